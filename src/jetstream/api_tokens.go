@@ -0,0 +1,477 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/apitokens"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/console_config"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+)
+
+const (
+	// API tokens start with a prefix, so that they can be told apart from other bearer tokens
+	apiTokenPrefix = "stratos_pat_"
+	// Echo context key for the API token that a request was authenticated with
+	apiTokenContextKey = "jetstream-api-token"
+
+	defaultAPITokenLifetimeDays    = 30
+	defaultAPITokenMaxLifetimeDays = 90
+
+	// How often the time that an API token was last used is recorded
+	apiTokenLastUsedInterval = time.Minute
+
+	// The kill switch for API tokens is kept in the config table, so that it applies to all Jetstream instances
+	apiTokensConfigGroup  = "api_tokens"
+	apiTokensDisabledName = "DISABLED"
+)
+
+type createAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int64    `json:"expires_in_days"`
+}
+
+// createAPITokenResponse is the only time that the value of an API token is returned
+type createAPITokenResponse struct {
+	interfaces.APIToken
+	Token string `json:"token"`
+}
+
+type apiTokensStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+// hashAPIToken returns the hash of an API token that is stored in place of the token
+// Tokens are random, so they do not need a salted or slow hash
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// getBearerAPIToken returns the API token in the Authorization header of the request, if there is one
+func getBearerAPIToken(req *http.Request) (string, bool) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return token, strings.HasPrefix(token, apiTokenPrefix)
+}
+
+// getRequestAPIToken returns the API token that the request was authenticated with, or nil for a session cookie
+func getRequestAPIToken(c echo.Context) *interfaces.APIToken {
+	token, _ := c.Get(apiTokenContextKey).(*interfaces.APIToken)
+	return token
+}
+
+// getAPITokenMaxLifetime returns the maximum number of days that an API token can be valid for
+func getAPITokenMaxLifetime(pc interfaces.PortalConfig) int64 {
+	if pc.APITokenMaxLifetimeInDays > 0 {
+		return pc.APITokenMaxLifetimeInDays
+	}
+	return defaultAPITokenMaxLifetimeDays
+}
+
+// apiTokensEnabled returns false if an admin has used the kill switch to stop API tokens from being used
+func (p *portalProxy) apiTokensEnabled() (bool, error) {
+	configRepo, err := console_config.NewPostgresConsoleConfigRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return false, fmt.Errorf(dbReferenceError, err)
+	}
+
+	disabled, ok, err := configRepo.GetValue(apiTokensConfigGroup, apiTokensDisabledName)
+	if err != nil {
+		return false, err
+	}
+	return !ok || disabled != "true", nil
+}
+
+// authenticateAPIToken sets up a request to run as the user that created the API token
+// The session of the request only exists for the request - it is never saved to the session store
+func (p *portalProxy) authenticateAPIToken(c echo.Context, value string) error {
+	enabled, err := p.apiTokensEnabled()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check API token",
+			"Unable to check if API tokens are enabled: %v", err)
+	}
+	if !enabled {
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"API tokens have been disabled",
+			"Rejected API token as API tokens have been disabled")
+	}
+
+	tokensRepo, err := apitokens.NewPgsqlAPITokensRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	token, err := tokensRepo.FindByHash(hashAPIToken(value))
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check API token",
+			"Unable to find API token: %v", err)
+	}
+
+	now := time.Now()
+	if token == nil || !now.Before(token.Expires) {
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Invalid or expired API token",
+			"Rejected unknown or expired API token")
+	}
+
+	if err := p.checkAPITokenRoute(c, token); err != nil {
+		return err
+	}
+
+	// Tokens only work for as long as their user could still log in
+	if err := p.StratosAuthService.VerifySession(c, token.UserGUID, token.Expires.Unix()); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Invalid or expired API token",
+			"Rejected API token %s as its user could not be verified: %v", token.GUID, err)
+	}
+
+	session := sessions.NewSession(nil, p.SessionCookieName)
+	session.Options = &sessions.Options{MaxAge: getSessionIdleTimeout(p.Config)}
+	session.Values["user_id"] = token.UserGUID
+	session.Values["exp"] = token.Expires.Unix()
+
+	c.Set(jetStreamSessionContextKey, session)
+	c.Set(apiTokenContextKey, token)
+	c.Set("user_id", token.UserGUID)
+
+	if err := p.setAPITokenLoginRequirements(session, token.UserGUID); err != nil {
+		return err
+	}
+	if err := p.checkPasswordChange(c, token.UserGUID); err != nil {
+		return err
+	}
+	if err := p.checkMFAEnrolment(c, token.UserGUID); err != nil {
+		return err
+	}
+
+	if token.LastUsed == nil || now.Sub(*token.LastUsed) > apiTokenLastUsedInterval {
+		if err := tokensRepo.UpdateLastUsed(token.GUID, now); err != nil {
+			log.Warnf("Unable to record use of API token: %v", err)
+		}
+	}
+	return nil
+}
+
+// setAPITokenLoginRequirements records whether a local user must change their password or enrol in MFA,
+// in the same way as a login does, so that the session checks apply to their API tokens too
+func (p *portalProxy) setAPITokenLoginRequirements(session *sessions.Session, userGUID string) error {
+	if p.Config.ConsoleConfig == nil || interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] != interfaces.Local {
+		return nil
+	}
+
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	user, err := localUsersRepo.FindUser(userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Invalid or expired API token",
+			"Unable to find local user %s of API token: %v", userGUID, err)
+	}
+
+	mfaRequired, err := p.isMFARequired(user)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check API token",
+			"Unable to check if MFA is required: %v", err)
+	}
+
+	session.Values[passwordChangeRequiredKey] = user.PasswordChangeRequired
+	session.Values[mfaEnrolmentRequiredKey] = mfaRequired
+	return nil
+}
+
+// allowAPITokens lets requests authenticated with an API token that has the scope use the routes
+// API tokens are refused for every other route, so routes must declare the scope that tokens need to use them
+func (p *portalProxy) allowAPITokens(scope string, routes ...*echo.Route) {
+	for _, route := range routes {
+		p.APITokenRoutes[route.Method+" "+route.Path] = scope
+	}
+}
+
+// checkAPITokenRoute only passes requests authenticated with an API token if the route allows API tokens
+// and the token has the scope that the route needs
+func (p *portalProxy) checkAPITokenRoute(c echo.Context, token *interfaces.APIToken) error {
+	scope, ok := p.APITokenRoutes[c.Request().Method+" "+c.Path()]
+	if !ok {
+		return interfaces.NewHTTPShadowError(
+			http.StatusForbidden,
+			"API tokens can not be used for this API",
+			"API token %s can not be used for %s %s", token.GUID, c.Request().Method, c.Path())
+	}
+	if !token.HasScope(scope) {
+		return interfaces.NewHTTPShadowError(
+			http.StatusForbidden,
+			"The API token does not have the scope needed for this API",
+			"API token %s does not have scope %s", token.GUID, scope)
+	}
+	return nil
+}
+
+// listAPITokens returns the API tokens of the current user
+func (p *portalProxy) listAPITokens(c echo.Context) error {
+	log.Debug("listAPITokens")
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	return p.listUserAPITokens(c, userGUID)
+}
+
+// listAPITokensOfUser returns the API tokens of any user
+func (p *portalProxy) listAPITokensOfUser(c echo.Context) error {
+	log.Debug("listAPITokensOfUser")
+	return p.listUserAPITokens(c, c.Param("user"))
+}
+
+func (p *portalProxy) listUserAPITokens(c echo.Context, userGUID string) error {
+	tokensRepo, err := apitokens.NewPgsqlAPITokensRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	list, err := tokensRepo.ListByUser(userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list API tokens",
+			"Unable to list API tokens: %v", err)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// createAPIToken creates an API token for the current user, with scopes that the user has been granted
+func (p *portalProxy) createAPIToken(c echo.Context) error {
+	log.Debug("createAPIToken")
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	// A leaked token must not be usable to create tokens that outlive it
+	if getRequestAPIToken(c) != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusForbidden,
+			"API tokens can only be created from a browser session",
+			"Rejected request to create an API token with an API token")
+	}
+
+	enabled, err := p.apiTokensEnabled()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to create API token",
+			"Unable to check if API tokens are enabled: %v", err)
+	}
+	if !enabled {
+		return interfaces.NewHTTPShadowError(
+			http.StatusForbidden,
+			"API tokens have been disabled",
+			"Rejected request to create an API token as API tokens have been disabled")
+	}
+
+	request := &createAPITokenRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid API token request",
+			"Unable to parse API token request: %v", err)
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if len(request.Name) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"API tokens need a name",
+			"API token request is missing a name")
+	}
+
+	if request.ExpiresInDays == 0 {
+		request.ExpiresInDays = defaultAPITokenLifetimeDays
+	}
+	if maxDays := getAPITokenMaxLifetime(p.Config); request.ExpiresInDays < 0 || request.ExpiresInDays > maxDays {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			fmt.Sprintf("API tokens must expire within %d days", maxDays),
+			"Invalid API token lifetime: %d days", request.ExpiresInDays)
+	}
+
+	if len(request.Scopes) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"API tokens need at least one scope",
+			"API token request has no scopes")
+	}
+	permissions, err := p.GetUserPermissions(userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to create API token",
+			"Unable to get permissions of user %s: %v", userGUID, err)
+	}
+	for _, scope := range request.Scopes {
+		if scope != interfaces.APITokenScopeProxy && !permissions.Has(scope) {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				fmt.Sprintf("Invalid API token scope: %s", scope),
+				"User %s can not create an API token with scope %s", userGUID, scope)
+		}
+	}
+
+	secret, err := crypto.GenerateRandomBytes(32)
+	if err != nil {
+		return fmt.Errorf("Unable to generate API token: %v", err)
+	}
+	value := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	now := time.Now().UTC()
+	token := interfaces.APIToken{
+		GUID:     uuid.NewV4().String(),
+		UserGUID: userGUID,
+		Name:     request.Name,
+		Scopes:   request.Scopes,
+		Created:  now,
+		Expires:  now.Add(time.Duration(request.ExpiresInDays) * 24 * time.Hour),
+	}
+	setAuditDetails(c, fmt.Sprintf("token=%s name=%s scopes=%s", token.GUID, token.Name, strings.Join(token.Scopes, ",")))
+
+	tokensRepo, err := apitokens.NewPgsqlAPITokensRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	if err := tokensRepo.Save(token, hashAPIToken(value)); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to create API token",
+			"Unable to save API token: %v", err)
+	}
+
+	return c.JSON(http.StatusCreated, &createAPITokenResponse{APIToken: token, Token: value})
+}
+
+// revokeAPIToken removes an API token of the current user
+func (p *portalProxy) revokeAPIToken(c echo.Context) error {
+	log.Debug("revokeAPIToken")
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	tokenGUID := c.Param("token")
+	setAuditDetails(c, fmt.Sprintf("token=%s", tokenGUID))
+
+	tokensRepo, err := apitokens.NewPgsqlAPITokensRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	deleted, err := tokensRepo.Delete(userGUID, tokenGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to revoke API token",
+			"Unable to revoke API token: %v", err)
+	}
+	if !deleted {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"API token not found",
+			"API token %s of user %s not found", tokenGUID, userGUID)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// revokeAPITokensOfUser removes all of the API tokens of any user
+func (p *portalProxy) revokeAPITokensOfUser(c echo.Context) error {
+	log.Debug("revokeAPITokensOfUser")
+	userGUID := c.Param("user")
+
+	tokensRepo, err := apitokens.NewPgsqlAPITokensRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	count, err := tokensRepo.DeleteByUser(userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to revoke API tokens",
+			"Unable to revoke API tokens: %v", err)
+	}
+	setAuditDetails(c, fmt.Sprintf("user=%s revoked=%d", userGUID, count))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getAPITokensStatus returns whether API tokens can be used
+func (p *portalProxy) getAPITokensStatus(c echo.Context) error {
+	log.Debug("getAPITokensStatus")
+	enabled, err := p.apiTokensEnabled()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check if API tokens are enabled",
+			"Unable to check if API tokens are enabled: %v", err)
+	}
+	return c.JSON(http.StatusOK, &apiTokensStatus{Enabled: enabled})
+}
+
+// setAPITokensStatus is the kill switch for API tokens - while disabled, no API token can be used or created
+func (p *portalProxy) setAPITokensStatus(c echo.Context) error {
+	log.Debug("setAPITokensStatus")
+	status := &apiTokensStatus{}
+	if err := json.NewDecoder(c.Request().Body).Decode(status); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid API token status",
+			"Unable to parse API token status: %v", err)
+	}
+	setAuditDetails(c, fmt.Sprintf("enabled=%t", status.Enabled))
+
+	configRepo, err := console_config.NewPostgresConsoleConfigRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	if status.Enabled {
+		err = configRepo.DeleteValue(apiTokensConfigGroup, apiTokensDisabledName)
+	} else {
+		err = configRepo.SetValue(apiTokensConfigGroup, apiTokensDisabledName, "true")
+	}
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update API token status",
+			"Unable to update API token status: %v", err)
+	}
+
+	if status.Enabled {
+		log.Info("API tokens have been enabled")
+	} else {
+		log.Warn("API tokens have been disabled")
+	}
+	return c.JSON(http.StatusOK, status)
+}
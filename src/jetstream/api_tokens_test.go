@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	mockAPIToken        = apiTokenPrefix + "mock-token"
+	mockAPITokenGUID    = "mock-api-token-guid"
+	selectAPITokensKill = `SELECT name, value, last_updated FROM config WHERE groupName = (.+) AND name = (.+)`
+	selectAPITokenHash  = `SELECT guid, user_guid, name, scopes, created, expires, last_used FROM api_tokens WHERE token_hash = (.+)`
+	updateAPITokenUsed  = `UPDATE api_tokens SET last_used = (.+) WHERE guid = (.+)`
+	insertAPIToken      = `INSERT INTO api_tokens`
+)
+
+var rowFieldsForAPITokens = []string{"guid", "user_guid", "name", "scopes", "created", "expires", "last_used"}
+
+// mockStratosAuth is a StratosAuth that knows a single user
+type mockStratosAuth struct {
+	user      *interfaces.ConnectedUser
+	verifyErr error
+}
+
+func (m *mockStratosAuth) Login(c echo.Context) error  { return nil }
+func (m *mockStratosAuth) Logout(c echo.Context) error { return nil }
+func (m *mockStratosAuth) GetUsername(userGUID string) (string, error) {
+	return m.user.Name, nil
+}
+func (m *mockStratosAuth) GetUser(userGUID string) (*interfaces.ConnectedUser, error) {
+	return m.user, nil
+}
+func (m *mockStratosAuth) VerifySession(c echo.Context, sessionUser string, sessionExpireTime int64) error {
+	return m.verifyErr
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given a request with an API token", t, func() {
+		req := setupMockReq("POST", "", nil)
+		req.Header.Set("Authorization", "Bearer "+mockAPIToken)
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		pp.StratosAuthService = &mockStratosAuth{user: &interfaces.ConnectedUser{GUID: mockUserGUID}}
+		pp.allowAPITokens(interfaces.PermissionEndpointsView, &echo.Route{Method: "POST", Path: "/pp/v1/endpoints"})
+		pp.allowAPITokens(interfaces.APITokenScopeProxy, &echo.Route{Method: "POST", Path: "/pp/v1/proxy/*"})
+		ctx.SetPath("/pp/v1/endpoints")
+
+		var handledUser interface{}
+		handler := pp.sessionMiddleware(pp.xsrfMiddleware(func(c echo.Context) error {
+			handledUser = c.Get("user_id")
+			return nil
+		}))
+
+		Convey("a valid token should run the request as its user without an XSRF token", func() {
+			mock.ExpectQuery(selectAPITokensKill).WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}))
+			mock.ExpectQuery(selectAPITokenHash).
+				WithArgs(hashAPIToken(mockAPIToken)).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens).
+					AddRow(mockAPITokenGUID, mockUserGUID, "ci", "endpoints.view", time.Now(), time.Now().Add(time.Hour), nil))
+			mock.ExpectExec(updateAPITokenUsed).
+				WithArgs(sqlmock.AnyArg(), mockAPITokenGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(handler(ctx), ShouldBeNil)
+			So(handledUser, ShouldEqual, mockUserGUID)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+			userID, err := pp.GetSessionStringValue(ctx, "user_id")
+			So(err, ShouldBeNil)
+			So(userID, ShouldEqual, mockUserGUID)
+
+			Convey("and only be allowed what the token has been scoped to", func() {
+				allowed := pp.RequirePermission(interfaces.PermissionEndpointsView)(func(c echo.Context) error { return nil })
+				So(allowed(ctx), ShouldBeNil)
+
+				pp.StratosAuthService = &mockStratosAuth{user: &interfaces.ConnectedUser{GUID: mockUserGUID, Admin: true}}
+				denied := pp.RequirePermission(interfaces.PermissionConsoleAdmin)(func(c echo.Context) error { return nil })
+				So(denied(ctx), ShouldNotBeNil)
+			})
+		})
+
+		Convey("a token should be refused for a route that needs a scope that it does not have", func() {
+			mock.ExpectQuery(selectAPITokensKill).WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}))
+			mock.ExpectQuery(selectAPITokenHash).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens).
+					AddRow(mockAPITokenGUID, mockUserGUID, "ci", "endpoints.view", time.Now(), time.Now().Add(time.Hour), nil))
+			ctx.SetPath("/pp/v1/proxy/*")
+
+			err := handler(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusForbidden)
+			So(handledUser, ShouldBeNil)
+		})
+
+		Convey("a token should be refused for a route that does not declare a token scope", func() {
+			mock.ExpectQuery(selectAPITokensKill).WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}))
+			mock.ExpectQuery(selectAPITokenHash).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens).
+					AddRow(mockAPITokenGUID, mockUserGUID, "ci", "endpoints.view,endpoints.proxy", time.Now(), time.Now().Add(time.Hour), nil))
+			ctx.SetPath("/pp/v1/mfa")
+
+			err := handler(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusForbidden)
+			So(handledUser, ShouldBeNil)
+		})
+
+		Convey("the token of a user that can no longer log in should be rejected", func() {
+			pp.StratosAuthService = &mockStratosAuth{user: &interfaces.ConnectedUser{GUID: mockUserGUID}, verifyErr: errLocalUserDisabled}
+			mock.ExpectQuery(selectAPITokensKill).WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}))
+			mock.ExpectQuery(selectAPITokenHash).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens).
+					AddRow(mockAPITokenGUID, mockUserGUID, "ci", "endpoints.view", time.Now(), time.Now().Add(time.Hour), nil))
+
+			err := handler(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+			So(handledUser, ShouldBeNil)
+		})
+
+		Convey("the token of a local user that must change their password should be refused", func() {
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			mock.ExpectQuery(selectAPITokensKill).WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}))
+			mock.ExpectQuery(selectAPITokenHash).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens).
+					AddRow(mockAPITokenGUID, mockUserGUID, "ci", "endpoints.view", time.Now(), time.Now().Add(time.Hour), nil))
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("localuser", "", "stratos.user", "", "", false, true, nil, 0, nil))
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("localuser", "", "stratos.user", "", "", false, true, nil, 0, nil))
+
+			err := handler(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusForbidden)
+			So(handledUser, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("an expired token should be rejected", func() {
+			mock.ExpectQuery(selectAPITokensKill).WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}))
+			mock.ExpectQuery(selectAPITokenHash).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens).
+					AddRow(mockAPITokenGUID, mockUserGUID, "ci", "endpoints.view", time.Now().Add(-time.Hour), time.Now().Add(-time.Minute), nil))
+
+			err := handler(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+			So(handledUser, ShouldBeNil)
+		})
+
+		Convey("tokens should be rejected once API tokens have been disabled", func() {
+			mock.ExpectQuery(selectAPITokensKill).
+				WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}).AddRow(apiTokensDisabledName, "true", ""))
+
+			So(handler(ctx), ShouldNotBeNil)
+			So(handledUser, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+func TestCreateAPIToken(t *testing.T) {
+	t.Parallel()
+
+	Convey("Creating an API token", t, func() {
+		create := func(body string) (*createAPITokenResponse, error, sqlmock.Sqlmock) {
+			req := setupMockReq("POST", "", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(body))
+			res, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+
+			pp.StratosAuthService = &mockStratosAuth{user: &interfaces.ConnectedUser{GUID: mockUserGUID}}
+			ctx.Set("user_id", mockUserGUID)
+			mock.ExpectQuery(selectAPITokensKill).WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}))
+			mock.ExpectExec(insertAPIToken).
+				WithArgs(sqlmock.AnyArg(), mockUserGUID, "ci", sqlmock.AnyArg(), "endpoints.view,endpoints.proxy", sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			if err := pp.createAPIToken(ctx); err != nil {
+				return nil, err, mock
+			}
+			response := &createAPITokenResponse{}
+			json.Unmarshal(res.Body.Bytes(), response)
+			return response, nil, mock
+		}
+
+		Convey("should return the token once and store its hash", func() {
+			response, err, mock := create(`{"name": "ci", "scopes": ["endpoints.view", "endpoints.proxy"], "expires_in_days": 7}`)
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(response.Token, ShouldStartWith, apiTokenPrefix)
+			So(response.Expires.Sub(response.Created), ShouldEqual, 7*24*time.Hour)
+		})
+
+		Convey("should fail for a scope that the user has not been granted", func() {
+			_, err, _ := create(`{"name": "ci", "scopes": ["console.admin"]}`)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail for a lifetime beyond the maximum", func() {
+			_, err, _ := create(`{"name": "ci", "scopes": ["endpoints.view"], "expires_in_days": 365}`)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail without a name", func() {
+			_, err, _ := create(`{"scopes": ["endpoints.view"]}`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
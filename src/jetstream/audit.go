@@ -0,0 +1,255 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/audit"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Echo context keys that handlers can use to add information to the audit event for the request
+const (
+	auditEndpointKey       = "audit_endpoint_guid"
+	auditDetailsKey        = "audit_details"
+	auditEndpointStatusKey = "audit_endpoint_status"
+)
+
+// How often expired audit events are removed
+const auditLogCleanupInterval = time.Hour
+
+// setAuditEndpoint records the endpoint that the request acted on, for when it is not a parameter of the request
+func setAuditEndpoint(c echo.Context, endpointGUID string) {
+	c.Set(auditEndpointKey, endpointGUID)
+}
+
+// setAuditDetails adds additional details to the audit event for the request
+func setAuditDetails(c echo.Context, details string) {
+	c.Set(auditDetailsKey, details)
+}
+
+// setAuditEndpointStatus records the status of the response from each endpoint that a proxy request was sent to
+// Endpoints without a response, e.g. because the request timed out, are recorded as having failed
+func setAuditEndpointStatus(c echo.Context, cnsiList []string, responses map[string]*interfaces.CNSIRequest) {
+	statusCodes := make(map[string]int)
+	for _, endpointGUID := range cnsiList {
+		endpointGUID = strings.TrimSpace(endpointGUID)
+		if len(endpointGUID) == 0 {
+			continue
+		}
+		statusCode := http.StatusInternalServerError
+		if res, ok := responses[endpointGUID]; ok && res.Error == nil && res.StatusCode > 0 {
+			statusCode = res.StatusCode
+		}
+		statusCodes[endpointGUID] = statusCode
+	}
+	c.Set(auditEndpointStatusKey, statusCodes)
+}
+
+// AuditMiddleware records an audit event for the given action once the request has been handled
+func (p *portalProxy) AuditMiddleware(action string) echo.MiddlewareFunc {
+	return func(h echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := h(c)
+			p.recordAuditEvent(c, action, getAuditEndpoint(c), err)
+			return err
+		}
+	}
+}
+
+// auditProxyMiddleware records an audit event for each endpoint targeted by a mutating proxy request
+// Requests sent to more than one endpoint are answered with a 200 even if some of the endpoints failed, so the
+// status of each endpoint's response is recorded when it is known
+func (p *portalProxy) auditProxyMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := h(c)
+		if !isMutatingMethod(c.Request().Method) {
+			return err
+		}
+
+		setAuditDetails(c, c.Request().Method+" "+c.Request().URL.Path)
+		statusCodes, _ := c.Get(auditEndpointStatusKey).(map[string]int)
+		for _, endpointGUID := range strings.Split(c.Request().Header.Get("x-cap-cnsi-list"), ",") {
+			endpointGUID = strings.TrimSpace(endpointGUID)
+			if statusCode, ok := statusCodes[endpointGUID]; ok && err == nil {
+				p.recordAuditEventStatus(c, interfaces.AuditProxyRequest, endpointGUID, statusCode)
+			} else {
+				p.recordAuditEvent(c, interfaces.AuditProxyRequest, endpointGUID, err)
+			}
+		}
+		return err
+	}
+}
+
+// getAuditEndpoint returns the endpoint that the request acted on
+// Form values are only checked if the handler has already parsed the form, so that we never consume the request body
+func getAuditEndpoint(c echo.Context) string {
+	if endpointGUID, ok := c.Get(auditEndpointKey).(string); ok {
+		return endpointGUID
+	}
+	if id := c.Param("id"); len(id) > 0 {
+		return id
+	}
+	if form := c.Request().Form; form != nil {
+		return form.Get("cnsi_guid")
+	}
+	return ""
+}
+
+// getAuditStatusCode returns the status code of the response that will be sent for the request
+func getAuditStatusCode(c echo.Context, err error) int {
+	switch e := err.(type) {
+	case nil:
+		if c.Response().Status == 0 {
+			return http.StatusOK
+		}
+		return c.Response().Status
+	case *echo.HTTPError:
+		return e.Code
+	case interfaces.ErrHTTPShadow:
+		return e.HTTPError.Code
+	}
+	return http.StatusInternalServerError
+}
+
+func (p *portalProxy) recordAuditEvent(c echo.Context, action, endpointGUID string, err error) {
+	p.recordAuditEventStatus(c, action, endpointGUID, getAuditStatusCode(c, err))
+}
+
+func (p *portalProxy) recordAuditEventStatus(c echo.Context, action, endpointGUID string, statusCode int) {
+	event := interfaces.AuditEvent{
+		GUID:         uuid.NewV4().String(),
+		Timestamp:    time.Now().UTC(),
+		Action:       action,
+		EndpointGUID: endpointGUID,
+		RemoteIP:     p.clientIP(c),
+		StatusCode:   statusCode,
+	}
+
+	event.Result = interfaces.AuditResultSuccess
+	if event.StatusCode >= http.StatusBadRequest {
+		event.Result = interfaces.AuditResultFailure
+	}
+
+	if details, ok := c.Get(auditDetailsKey).(string); ok {
+		event.Details = details
+	}
+
+	if userGUID, err := getPortalUserGUID(c); err == nil {
+		event.UserGUID = userGUID
+		if p.StratosAuthService != nil {
+			if user, err := p.StratosAuthService.GetUser(userGUID); err == nil {
+				event.UserName = user.Name
+			}
+		}
+	}
+
+	auditRepo, err := audit.NewPgsqlAuditRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return
+	}
+
+	// Failing to audit should not fail the request
+	if err = auditRepo.Save(event); err != nil {
+		log.Errorf("Unable to record audit event %s: %v", action, err)
+	}
+}
+
+// listAuditEvents returns the audit events, optionally filtered by time range and user
+func (p *portalProxy) listAuditEvents(c echo.Context) error {
+	log.Debug("listAuditEvents")
+
+	var filter audit.Filter
+	var err error
+
+	if from := c.QueryParam("from"); len(from) > 0 {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Invalid 'from' time - expected RFC3339 format",
+				"Invalid 'from' time: %v", err)
+		}
+	}
+
+	if to := c.QueryParam("to"); len(to) > 0 {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Invalid 'to' time - expected RFC3339 format",
+				"Invalid 'to' time: %v", err)
+		}
+	}
+
+	if limit := c.QueryParam("limit"); len(limit) > 0 {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Invalid 'limit' - expected a number",
+				"Invalid 'limit': %v", err)
+		}
+	}
+
+	filter.UserGUID = c.QueryParam("user")
+
+	auditRepo, err := audit.NewPgsqlAuditRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to retrieve audit events",
+			dbReferenceError, err)
+	}
+
+	events, err := auditRepo.List(filter)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to retrieve audit events",
+			"Failed to retrieve audit events: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+// startAuditLogCleanup periodically removes audit events older than the configured retention period
+func (p *portalProxy) startAuditLogCleanup() {
+	if p.Config.AuditLogRetentionDays <= 0 {
+		return
+	}
+
+	retention := time.Duration(p.Config.AuditLogRetentionDays) * 24 * time.Hour
+	log.Infof("Audit events will be kept for %d days", p.Config.AuditLogRetentionDays)
+
+	go func() {
+		for {
+			// Only one instance needs to remove the expired events
+			if p.IsLeader() {
+				p.cleanupAuditLog(retention)
+			}
+			time.Sleep(auditLogCleanupInterval)
+		}
+	}()
+}
+
+func (p *portalProxy) cleanupAuditLog(retention time.Duration) {
+	auditRepo, err := audit.NewPgsqlAuditRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return
+	}
+
+	count, err := auditRepo.DeleteOlderThan(time.Now().UTC().Add(-retention))
+	if err != nil {
+		log.Errorf("Unable to remove expired audit events: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Infof("Removed %d expired audit events", count)
+	}
+}
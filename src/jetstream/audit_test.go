@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const insertIntoAuditLog = `INSERT INTO audit_log`
+
+func TestAuditMiddleware(t *testing.T) {
+	t.Parallel()
+
+	Convey("Audit middleware should record the outcome of a request", t, func() {
+		req := setupMockReq("POST", "", map[string]string{
+			"cnsi_guid": mockCFGUID,
+		})
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		ctx.Set("user_id", mockUserGUID)
+
+		Convey("should record a successful request", func() {
+			mock.ExpectExec(insertIntoAuditLog).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), mockUserGUID, "", interfaces.AuditEndpointUnregister, mockCFGUID, sqlmock.AnyArg(), interfaces.AuditResultSuccess, http.StatusOK, "").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			handler := pp.AuditMiddleware(interfaces.AuditEndpointUnregister)(func(c echo.Context) error {
+				c.FormValue("cnsi_guid")
+				return nil
+			})
+			So(handler(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should record a failed request with the error status code", func() {
+			mock.ExpectExec(insertIntoAuditLog).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), mockUserGUID, "", interfaces.AuditEndpointConnect, mockCFGUID, sqlmock.AnyArg(), interfaces.AuditResultFailure, http.StatusBadRequest, "").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			handler := pp.AuditMiddleware(interfaces.AuditEndpointConnect)(func(c echo.Context) error {
+				c.FormValue("cnsi_guid")
+				return interfaces.NewHTTPShadowError(http.StatusBadRequest, "Could not connect to the endpoint", "")
+			})
+			So(handler(ctx), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should use the endpoint and details set by the handler", func() {
+			mock.ExpectExec(insertIntoAuditLog).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), mockUserGUID, "", interfaces.AuditEndpointRegister, mockCEGUID, sqlmock.AnyArg(), interfaces.AuditResultSuccess, http.StatusCreated, "name=test").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			handler := pp.AuditMiddleware(interfaces.AuditEndpointRegister)(func(c echo.Context) error {
+				setAuditDetails(c, "name=test")
+				setAuditEndpoint(c, mockCEGUID)
+				return c.NoContent(http.StatusCreated)
+			})
+			So(handler(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should record the connection address rather than a forwarded address from the client", func() {
+			req.RemoteAddr = "198.51.100.1:4321"
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			mock.ExpectExec(insertIntoAuditLog).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), mockUserGUID, "", interfaces.AuditEndpointUnregister, "", "198.51.100.1", interfaces.AuditResultSuccess, http.StatusOK, "").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			handler := pp.AuditMiddleware(interfaces.AuditEndpointUnregister)(func(c echo.Context) error {
+				return nil
+			})
+			So(handler(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+func TestAuditProxyMiddleware(t *testing.T) {
+	t.Parallel()
+
+	handler := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+
+	Convey("Proxy audit middleware should not record GET requests", t, func() {
+		req := setupMockReq("GET", "", nil)
+		req.Header.Set("x-cap-cnsi-list", mockCFGUID)
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		So(pp.auditProxyMiddleware(handler)(ctx), ShouldBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+
+	Convey("Proxy audit middleware should record a mutating request for each endpoint", t, func() {
+		req := setupMockReq("DELETE", "http://127.0.0.1/pp/v1/proxy/v2/apps/123", nil)
+		req.Header.Set("x-cap-cnsi-list", mockCFGUID+","+mockCEGUID)
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		for _, guid := range []string{mockCFGUID, mockCEGUID} {
+			mock.ExpectExec(insertIntoAuditLog).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "", "", interfaces.AuditProxyRequest, guid, sqlmock.AnyArg(), interfaces.AuditResultSuccess, http.StatusOK, "DELETE /pp/v1/proxy/v2/apps/123").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+
+		So(pp.auditProxyMiddleware(handler)(ctx), ShouldBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+
+	Convey("Proxy audit middleware should record the result of each endpoint", t, func() {
+		req := setupMockReq("DELETE", "http://127.0.0.1/pp/v1/proxy/v2/apps/123", nil)
+		req.Header.Set("x-cap-cnsi-list", mockCFGUID+","+mockCEGUID)
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		fanOutHandler := func(c echo.Context) error {
+			setAuditEndpointStatus(c, []string{mockCFGUID, mockCEGUID}, map[string]*interfaces.CNSIRequest{
+				mockCFGUID: {StatusCode: http.StatusNoContent},
+				mockCEGUID: {StatusCode: http.StatusForbidden},
+			})
+			return c.NoContent(http.StatusOK)
+		}
+
+		mock.ExpectExec(insertIntoAuditLog).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "", "", interfaces.AuditProxyRequest, mockCFGUID, sqlmock.AnyArg(), interfaces.AuditResultSuccess, http.StatusNoContent, "DELETE /pp/v1/proxy/v2/apps/123").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(insertIntoAuditLog).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "", "", interfaces.AuditProxyRequest, mockCEGUID, sqlmock.AnyArg(), interfaces.AuditResultFailure, http.StatusForbidden, "DELETE /pp/v1/proxy/v2/apps/123").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		So(pp.auditProxyMiddleware(fanOutHandler)(ctx), ShouldBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
+
+func TestListAuditEvents(t *testing.T) {
+	t.Parallel()
+
+	Convey("Listing audit events should reject an invalid time filter", t, func() {
+		req := setupMockReq("GET", "http://127.0.0.1/pp/v1/audit?from=yesterday", nil)
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		err := pp.listAuditEvents(ctx)
+		So(err, ShouldNotBeNil)
+		So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("Listing audit events should filter by user", t, func() {
+		req := setupMockReq("GET", "http://127.0.0.1/pp/v1/audit?user="+mockUserGUID+"&from=2019-11-01T00:00:00Z", nil)
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT (.+) FROM audit_log WHERE (.+) AND user_guid = (.+)`).
+			WillReturnRows(sqlmock.NewRows([]string{"guid", "event_time", "user_guid", "user_name", "action", "endpoint_guid", "remote_ip", "result", "status_code", "details"}))
+
+		So(pp.listAuditEvents(ctx), ShouldBeNil)
+		So(res.Code, ShouldEqual, http.StatusOK)
+		So(res.Body.String(), ShouldContainSubstring, "[]")
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
@@ -36,6 +36,20 @@ func (p *portalProxy) InitStratosAuthService(t interfaces.AuthEndpointType) erro
 			databaseConnectionPool: p.DatabaseConnectionPool,
 			p:                      p,
 		}
+	case interfaces.OIDC:
+		oidcAuth, err := newOIDCAuth(p)
+		if err != nil {
+			return err
+		}
+		// Users always log in at the provider, so the UI must offer single sign-on
+		p.Config.SSOLogin = true
+		auth = oidcAuth
+	case interfaces.LDAP:
+		ldapAuth, err := newLDAPAuth(p)
+		if err != nil {
+			return err
+		}
+		auth = ldapAuth
 	default:
 		err := fmt.Errorf("Invalid auth endpoint type: %v", t)
 		return err
@@ -108,12 +108,19 @@ func TestLocalLogin(t *testing.T) {
 		rows := sqlmock.NewRows([]string{"user_guid"}).AddRow(userGUID)
 		mock.ExpectQuery(findUserGUID).WithArgs(username).WillReturnRows(rows)
 
+		rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil)
+		mock.ExpectQuery(findUser).WithArgs(userGUID).WillReturnRows(rows)
+
 		rows = sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash)
 		mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(rows)
 
 		rows = sqlmock.NewRows([]string{"scope"}).AddRow(scope)
 		mock.ExpectQuery(findUserScope).WithArgs(userGUID).WillReturnRows(rows)
 
+		//The user has not enrolled in MFA and admins are not required to
+		mock.ExpectQuery(findMFA).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows(rowFieldsForMFA))
+		mock.ExpectQuery(findMFARequired).WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}))
+
 		//Expect exec to update local login time
 		mock.ExpectExec(updateLastLoginTime).WillReturnResult(sqlmock.NewResult(1, 1))
 
@@ -166,6 +173,9 @@ func TestLocalLoginWithBadCredentials(t *testing.T) {
 		rows := sqlmock.NewRows([]string{"user_guid"}).AddRow(userGUID)
 		mock.ExpectQuery(findUserGUID).WithArgs(username).WillReturnRows(rows)
 
+		rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil)
+		mock.ExpectQuery(findUser).WithArgs(userGUID).WillReturnRows(rows)
+
 		rows = sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash)
 		mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(rows)
 
@@ -181,6 +191,86 @@ func TestLocalLoginWithBadCredentials(t *testing.T) {
 	})
 }
 
+func TestLocalLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given local users are locked out after too many failed logins", t, func() {
+
+		username := "localuser"
+		scope := "stratos.admin"
+		userGUID := uuid.NewV4().String()
+		passwordHash, _ := crypto.HashPassword("localuserpass")
+
+		req := setupMockReq("POST", "", map[string]string{
+			"username": username,
+			"password": "wrong_password",
+		})
+
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+		pp.Config.LocalUserMaxFailedLogins = 3
+		err := pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType])
+		So(err, ShouldBeNil)
+
+		rows := sqlmock.NewRows([]string{"user_guid"}).AddRow(userGUID)
+		mock.ExpectQuery(findUserGUID).WithArgs(username).WillReturnRows(rows)
+
+		Convey("a failed login should be counted", func() {
+			rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil)
+			mock.ExpectQuery(findUser).WithArgs(userGUID).WillReturnRows(rows)
+			rows = sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash)
+			mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(rows)
+			mock.ExpectExec(incFailedLogins).WithArgs(userGUID).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(findFailedLogins).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(1))
+
+			So(pp.StratosAuthService.Login(ctx), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("the user should be locked out after the last failed login", func() {
+			rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 2, nil)
+			mock.ExpectQuery(findUser).WithArgs(userGUID).WillReturnRows(rows)
+			rows = sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash)
+			mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(rows)
+			mock.ExpectExec(incFailedLogins).WithArgs(userGUID).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(findFailedLogins).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(3))
+			mock.ExpectExec(updateFailedLogins).WithArgs(0, sqlmock.AnyArg(), userGUID).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(insertIntoAuditLog).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.StratosAuthService.Login(ctx), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("failed logins made at the same time should all count towards the lockout", func() {
+			// The user was read before other failed logins were counted
+			rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil)
+			mock.ExpectQuery(findUser).WithArgs(userGUID).WillReturnRows(rows)
+			rows = sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash)
+			mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(rows)
+			mock.ExpectExec(incFailedLogins).WithArgs(userGUID).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(findFailedLogins).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(3))
+			mock.ExpectExec(updateFailedLogins).WithArgs(0, sqlmock.AnyArg(), userGUID).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(insertIntoAuditLog).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.StratosAuthService.Login(ctx), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a locked out user should not be able to log in", func() {
+			lockedUntil := time.Now().Add(time.Minute)
+			rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, lockedUntil)
+			mock.ExpectQuery(findUser).WithArgs(userGUID).WillReturnRows(rows)
+
+			err := pp.StratosAuthService.Login(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Invalid username/password credentials")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
 func TestLocalLoginWithNoAdminScope(t *testing.T) {
 	t.Parallel()
 
@@ -208,6 +298,9 @@ func TestLocalLoginWithNoAdminScope(t *testing.T) {
 		rows := sqlmock.NewRows([]string{"user_guid"}).AddRow(userGUID)
 		mock.ExpectQuery(findUserGUID).WithArgs(username).WillReturnRows(rows)
 
+		rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", wrongScope, "", "", false, false, nil, 0, nil)
+		mock.ExpectQuery(findUser).WithArgs(userGUID).WillReturnRows(rows)
+
 		rows = sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash)
 		mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(rows)
 
@@ -386,6 +479,10 @@ func TestLoginToCNSI(t *testing.T) {
 			t.Error(errors.New("unable to mock/stub user in session object"))
 		}
 
+		mock.ExpectQuery(selectAnyFromEndpointVisibility).
+			WithArgs(mockCNSIGUID).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointVisibility))
+
 		mock.ExpectQuery(selectAnyFromTokens).
 			WithArgs(mockCNSIGUID, mockUserGUID).
 			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow("0"))
@@ -786,7 +883,7 @@ func TestVerifySession(t *testing.T) {
 
 		var expectedScopes = "\"scopes\":[\"openid\",\"scim.read\",\"cloud_controller.admin\",\"uaa.user\",\"cloud_controller.read\",\"password.write\",\"routing.router_groups.read\",\"cloud_controller.write\",\"doppler.firehose\",\"scim.write\"]"
 
-		var expectedBody = "{\"version\":{\"proxy_version\":\"dev\",\"database_version\":20161117141922},\"user\":{\"guid\":\"asd-gjfg-bob\",\"name\":\"admin\",\"admin\":false," + expectedScopes + "},\"endpoints\":{\"cf\":{}},\"plugins\":null,\"config\":{\"enableTechPreview\":false}}"
+		var expectedBody = "{\"version\":{\"proxy_version\":\"dev\",\"database_version\":20161117141922},\"user\":{\"guid\":\"asd-gjfg-bob\",\"name\":\"admin\",\"admin\":false," + expectedScopes + "},\"endpoints\":{\"cf\":{}},\"plugins\":null,\"roles\":[\"endpoint-viewer\"],\"permissions\":[\"endpoints.view\"],\"config\":{\"enableTechPreview\":false}}"
 
 		Convey("Should contain expected body", func() {
 			So(res, ShouldNotBeNil)
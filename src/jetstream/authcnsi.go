@@ -70,6 +70,7 @@ func (p *portalProxy) ssoLoginToCNSI(c echo.Context) error {
 
 	// Callback
 	_, err = p.DoLoginToCNSI(c, endpointGUID, false)
+	p.recordAuditEvent(c, interfaces.AuditEndpointConnect, endpointGUID, err)
 	status := "ok"
 	if err != nil {
 		status = "fail"
@@ -131,15 +132,22 @@ func (p *portalProxy) DoLoginToCNSI(c echo.Context, cnsiGUID string, systemShare
 		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Could not find correct session value")
 	}
 
+	if !p.IsEndpointVisible(userID, cnsiGUID) {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Requested endpoint not registered",
+			"Endpoint %s is not visible to user %s", cnsiGUID, userID)
+	}
+
 	// Register as a system endpoint?
 	if systemSharedToken {
-		// User needs to be an admin
-		user, err := p.StratosAuthService.GetUser(userID)
+		// User needs to be an endpoint admin
+		permissions, err := p.GetUserPermissions(userID)
 		if err != nil {
 			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Can not connect System Shared endpoint - could not check user")
 		}
 
-		if !user.Admin {
+		if !permissions.Has(interfaces.PermissionEndpointsAdmin) {
 			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Can not connect System Shared endpoint - user is not an administrator")
 		}
 
@@ -158,34 +166,9 @@ func (p *portalProxy) DoLoginToCNSI(c echo.Context, cnsiGUID string, systemShare
 
 		endpointType := endpointPlugin.GetType()
 		if cnsiRecord.CNSIType == endpointType {
-			tokenRecord, isAdmin, err := endpointPlugin.Connect(c, cnsiRecord, userID)
-			if err != nil {
-				if shadowError, ok := err.(interfaces.ErrHTTPShadow); ok {
-					return nil, shadowError
-				}
-				return nil, interfaces.NewHTTPShadowError(
-					http.StatusBadRequest,
-					"Could not connect to the endpoint",
-					"Could not connect to the endpoint: %s", err)
-			}
-
-			err = p.setCNSITokenRecord(cnsiGUID, userID, *tokenRecord)
+			tokenRecord, isAdmin, err := p.connectEndpoint(c, endpointPlugin, cnsiRecord, userID)
 			if err != nil {
-				return nil, interfaces.NewHTTPShadowError(
-					http.StatusBadRequest,
-					"Failed to save Token for endpoint",
-					"Error occurred: %s", err)
-			}
-
-			// Validate the connection - some endpoints may want to validate that the connected endpoint
-			err = endpointPlugin.Validate(userID, cnsiRecord, *tokenRecord)
-			if err != nil {
-				// Clear the token
-				p.ClearCNSIToken(cnsiRecord, userID)
-				return nil, interfaces.NewHTTPShadowError(
-					http.StatusBadRequest,
-					"Could not connect to the endpoint",
-					"Could not connect to the endpoint: %s", err)
+				return nil, err
 			}
 
 			resp := &interfaces.LoginRes{
@@ -220,6 +203,43 @@ func (p *portalProxy) DoLoginToCNSI(c echo.Context, cnsiGUID string, systemShare
 		"Endpoint connection not supported")
 }
 
+// connectEndpoint asks the plugin to connect the user to the endpoint with the credentials in the request,
+// then saves and validates the token - the token is removed again if it is not valid
+func (p *portalProxy) connectEndpoint(c echo.Context, endpointPlugin interfaces.EndpointPlugin, cnsiRecord interfaces.CNSIRecord, userID string) (*interfaces.TokenRecord, bool, error) {
+	tokenRecord, isAdmin, err := endpointPlugin.Connect(c, cnsiRecord, userID)
+	if err != nil {
+		if shadowError, ok := err.(interfaces.ErrHTTPShadow); ok {
+			return nil, false, shadowError
+		}
+		return nil, false, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Could not connect to the endpoint",
+			"Could not connect to the endpoint: %s", err)
+	}
+
+	err = p.setCNSITokenRecord(cnsiRecord.GUID, userID, *tokenRecord)
+	if err != nil {
+		return nil, false, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Failed to save Token for endpoint",
+			"Error occurred: %s", err)
+	}
+
+	// Validate the connection - some endpoints may want to validate that the connected endpoint
+	// Plugins may look up the token that has just been saved to do so
+	err = endpointPlugin.Validate(userID, cnsiRecord, *tokenRecord)
+	if err != nil {
+		// Clear the token
+		p.ClearCNSIToken(cnsiRecord, userID)
+		return nil, false, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Could not connect to the endpoint",
+			"Could not connect to the endpoint: %s", err)
+	}
+
+	return tokenRecord, isAdmin, nil
+}
+
 func (p *portalProxy) DoLoginToCNSIwithConsoleUAAtoken(c echo.Context, theCNSIrecord interfaces.CNSIRecord) error {
 	userID, err := p.GetSessionStringValue(c, "user_id")
 	if err != nil {
@@ -248,7 +268,7 @@ func (p *portalProxy) DoLoginToCNSIwithConsoleUAAtoken(c echo.Context, theCNSIre
 			err = p.setCNSITokenRecord(theCNSIrecord.GUID, u.UserGUID, uaaToken)
 
 			// Update the endpoint to indicate that SSO Login is okay
-			repo, dbErr := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+			repo, dbErr := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 			if dbErr == nil {
 				repo.Update(theCNSIrecord.GUID, true)
 			}
@@ -332,13 +352,13 @@ func (p *portalProxy) logoutOfCNSI(c echo.Context) error {
 	// Get the existing token to see if it is connected as a system shared endpoint
 	tr, ok := p.GetCNSITokenRecord(cnsiGUID, userGUID)
 	if ok && tr.SystemShared {
-		// User needs to be an admin
-		user, err := p.StratosAuthService.GetUser(userGUID)
+		// User needs to be an endpoint admin
+		permissions, err := p.GetUserPermissions(userGUID)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusUnauthorized, "Can not disconnect System Shared endpoint - could not check user")
 		}
 
-		if !user.Admin {
+		if !permissions.Has(interfaces.PermissionEndpointsAdmin) {
 			return echo.NewHTTPError(http.StatusUnauthorized, "Can not disconnect System Shared endpoint - user is not an administrator")
 		}
 		userGUID = tokens.SystemSharedUserGuid
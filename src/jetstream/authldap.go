@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/ldap"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/stringutils"
+)
+
+// ldapAuth provides Stratos login with the users of an LDAP directory or Active Directory
+// Users are looked up in the directory (through a cache) rather than kept in the database
+type ldapAuth struct {
+	databaseConnectionPool *sql.DB
+	p                      *portalProxy
+	directory              *ldap.Directory
+}
+
+func newLDAPAuth(p *portalProxy) (*ldapAuth, error) {
+	directory, err := ldap.NewDirectory(ldap.Config{
+		URL:                p.Config.LDAPURL,
+		StartTLS:           p.Config.LDAPStartTLS,
+		SkipSSLValidation:  p.Config.LDAPSkipSSLValidation,
+		CACertFile:         p.Config.LDAPCACertFile,
+		BindDN:             p.Config.LDAPBindDN,
+		BindPassword:       p.Config.LDAPBindPassword,
+		UserBaseDN:         p.Config.LDAPUserBaseDN,
+		UserFilter:         p.Config.LDAPUserFilter,
+		UserIDAttribute:    p.Config.LDAPUserIDAttribute,
+		UserNameAttribute:  p.Config.LDAPUserNameAttribute,
+		EmailAttribute:     p.Config.LDAPUserEmailAttribute,
+		GroupBaseDN:        p.Config.LDAPGroupBaseDN,
+		GroupFilter:        p.Config.LDAPGroupFilter,
+		GroupNameAttribute: p.Config.LDAPGroupNameAttribute,
+		PoolSize:           int(p.Config.LDAPPoolSize),
+		CacheTTL:           time.Duration(p.Config.LDAPCacheTTLInSecs) * time.Second,
+		Timeout:            time.Duration(p.Config.LDAPTimeoutInSecs) * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Users will log in with LDAP directory %s", p.Config.LDAPURL)
+	return &ldapAuth{
+		databaseConnectionPool: p.DatabaseConnectionPool,
+		p:                      p,
+		directory:              directory,
+	}, nil
+}
+
+//Login provides LDAP specific Stratos login
+func (a *ldapAuth) Login(c echo.Context) error {
+
+	//This check will remain in until auth is factored down into its own package
+	if interfaces.AuthEndpointTypes[a.p.Config.ConsoleConfig.AuthEndpointType] != interfaces.LDAP {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"LDAP Login is not enabled",
+			"LDAP Login is not enabled")
+	}
+
+	username := c.FormValue("username")
+	password := c.FormValue("password")
+
+	user, err := a.directory.Authenticate(username, password)
+	if err == ldap.ErrInvalidCredentials {
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied - Invalid username/password credentials",
+			"Login failed for %s: %v", username, err)
+	} else if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusServiceUnavailable,
+			"Unable to log in - the LDAP directory is not available",
+			"Login failed for %s: %v", username, err)
+	}
+
+	return a.generateLoginSuccessResponse(c, user)
+}
+
+//Logout provides LDAP specific Stratos logout
+func (a *ldapAuth) Logout(c echo.Context) error {
+	log.Debug("logout")
+
+	a.p.removeEmptyCookie(c)
+
+	// Remove the XSRF Token from the session
+	a.p.unsetSessionValue(c, XSRFTokenSessionName)
+
+	err := a.p.clearSession(c)
+	if err != nil {
+		log.Errorf("Unable to clear session: %v", err)
+	}
+
+	// Send JSON document
+	resp := &LogoutResponse{
+		IsSSO: a.p.Config.SSOLogin,
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+//GetUsername gets the user name of the user from the directory
+func (a *ldapAuth) GetUsername(userGUID string) (string, error) {
+	user, err := a.directory.FindUser(userGUID)
+	if err != nil {
+		log.Errorf("Error fetching username for LDAP user %s: %v", userGUID, err)
+		return "", err
+	}
+	return user.Username, nil
+}
+
+//GetUser gets the user from the directory - groups are used as scopes
+func (a *ldapAuth) GetUser(userGUID string) (*interfaces.ConnectedUser, error) {
+	log.Debug("GetUser")
+
+	user, err := a.directory.FindUser(userGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaces.ConnectedUser{
+		GUID:   userGUID,
+		Name:   user.Username,
+		Admin:  a.isAdmin(user),
+		Scopes: user.Groups,
+		Groups: user.Groups,
+	}, nil
+}
+
+//VerifySession verifies that the user is still in the directory
+//Sessions are kept if the directory can't be reached, so that an outage doesn't log everyone out
+func (a *ldapAuth) VerifySession(c echo.Context, sessionUser string, sessionExpireTime int64) error {
+	_, err := a.directory.FindUser(sessionUser)
+	if err == ldap.ErrUserNotFound {
+		return err
+	} else if err != nil {
+		log.Warnf("Unable to verify LDAP user %s: %v", sessionUser, err)
+	}
+	return nil
+}
+
+func (a *ldapAuth) isAdmin(user *ldap.User) bool {
+	return len(a.p.Config.LDAPAdminGroup) > 0 && stringutils.ArrayContainsString(user.Groups, a.p.Config.LDAPAdminGroup)
+}
+
+//generateLoginSuccessResponse
+func (a *ldapAuth) generateLoginSuccessResponse(c echo.Context, user *ldap.User) error {
+	log.Debug("generateLoginResponse")
+
+	var err error
+	var expiry int64
+	expiry = math.MaxInt64
+
+	sessionValues := a.p.newLoginSessionValues(c, user.ID, expiry)
+
+	// Ensure that login disregards cookies from the request
+	req := c.Request()
+	req.Header.Set("Cookie", "")
+	if err = a.p.setSessionValues(c, sessionValues); err != nil {
+		return err
+	}
+
+	if err = a.p.enforceSessionLimit(c, user.ID); err != nil {
+		return err
+	}
+
+	//Makes sure the client gets the right session expiry time
+	if err = a.p.handleSessionExpiryHeader(c); err != nil {
+		return err
+	}
+
+	if err = a.p.ExecuteLoginHooks(c); err != nil {
+		log.Warnf("Login hooks failed: %v", err)
+	}
+
+	resp := &interfaces.LoginRes{
+		Account:     user.Username,
+		TokenExpiry: expiry,
+		APIEndpoint: nil,
+		Admin:       a.isAdmin(user),
+	}
+
+	jsonString, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	// Add XSRF Token
+	a.p.ensureXSRFToken(c)
+	c.Response().Header().Set("Content-Type", "application/json")
+	c.Response().Write(jsonString)
+	return nil
+}
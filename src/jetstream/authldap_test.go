@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/ldap"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/ldap/ldaptest"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	mockLDAPUserID = "0b7c4d42-2f5e-4a3e-9a47-6c1f1d2b9e11"
+	mockLDAPUserDN = "uid=ldapuser,ou=people,dc=example,dc=com"
+)
+
+// setupLDAPTest uses an in-memory directory for LDAP authentication
+func setupLDAPTest(pp *portalProxy) *ldaptest.Server {
+	server := ldaptest.NewServer()
+	server.AddEntry("cn=stratos,dc=example,dc=com", "bind-secret", nil)
+	server.AddEntry(mockLDAPUserDN, "ldapuser-secret", map[string][]string{
+		"uid":       {"ldapuser"},
+		"entryUUID": {mockLDAPUserID},
+		"memberOf":  {"cn=developers,ou=groups,dc=example,dc=com", "cn=stratos-admins,ou=groups,dc=example,dc=com"},
+	})
+
+	pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.LDAP)
+	pp.Config.LDAPAdminGroup = "stratos-admins"
+	directory, err := ldap.NewDirectory(ldap.Config{
+		URL:          "ldap://ldap.example.com",
+		BindDN:       "cn=stratos,dc=example,dc=com",
+		BindPassword: "bind-secret",
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+		Dial:         server.Dial,
+	})
+	So(err, ShouldBeNil)
+	pp.StratosAuthService = &ldapAuth{
+		databaseConnectionPool: pp.DatabaseConnectionPool,
+		p:                      pp,
+		directory:              directory,
+	}
+	return server
+}
+
+func TestLDAPAuthService(t *testing.T) {
+	t.Parallel()
+
+	Convey("The LDAP auth service should only start with a valid configuration", t, func() {
+		_, _, _, pp, db, _ := setupHTTPTest(setupMockReq("GET", "", nil))
+		defer db.Close()
+
+		So(pp.InitStratosAuthService(interfaces.LDAP), ShouldNotBeNil)
+
+		pp.Config.LDAPURL = "ldap://ldap.example.com"
+		pp.Config.LDAPUserBaseDN = "ou=people,dc=example,dc=com"
+		So(pp.InitStratosAuthService(interfaces.LDAP), ShouldBeNil)
+		So(pp.StratosAuthService, ShouldHaveSameTypeAs, &ldapAuth{})
+	})
+}
+
+func TestLDAPLogin(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given the console logs users in with an LDAP directory", t, func() {
+
+		Convey("a user should be logged in with their directory password", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"username": "ldapuser",
+				"password": "ldapuser-secret",
+			})
+			res, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			setupLDAPTest(pp)
+
+			So(pp.StratosAuthService.Login(ctx), ShouldBeNil)
+			So(res.Body.String(), ShouldContainSubstring, `"account":"ldapuser"`)
+			So(res.Body.String(), ShouldContainSubstring, `"admin":true`)
+
+			userGUID, err := pp.GetSessionStringValue(ctx, "user_id")
+			So(err, ShouldBeNil)
+			So(userGUID, ShouldEqual, mockLDAPUserID)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a wrong password should be rejected", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"username": "ldapuser",
+				"password": "wrong-secret",
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+			setupLDAPTest(pp)
+
+			err := pp.StratosAuthService.Login(ctx)
+			So(err, ShouldNotBeNil)
+			shadowErr, ok := err.(interfaces.ErrHTTPShadow)
+			So(ok, ShouldBeTrue)
+			So(shadowErr.HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+
+			_, err = pp.GetSessionValue(ctx, "user_id")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("login should fail if the directory is not available", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"username": "ldapuser",
+				"password": "ldapuser-secret",
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+			server := setupLDAPTest(pp)
+			server.SetDown(true)
+
+			err := pp.StratosAuthService.Login(ctx)
+			So(err, ShouldNotBeNil)
+			shadowErr, ok := err.(interfaces.ErrHTTPShadow)
+			So(ok, ShouldBeTrue)
+			So(shadowErr.HTTPError.Code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+}
+
+func TestLDAPGetUser(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given the console logs users in with an LDAP directory", t, func() {
+		_, _, ctx, pp, db, _ := setupHTTPTest(setupMockReq("GET", "", nil))
+		defer db.Close()
+		server := setupLDAPTest(pp)
+
+		Convey("the user should be looked up in the directory", func() {
+			user, err := pp.StratosAuthService.GetUser(mockLDAPUserID)
+			So(err, ShouldBeNil)
+			So(user.Name, ShouldEqual, "ldapuser")
+			So(user.Admin, ShouldBeTrue)
+			So(user.Scopes, ShouldResemble, []string{"developers", "stratos-admins"})
+			So(user.Groups, ShouldResemble, []string{"developers", "stratos-admins"})
+
+			name, err := pp.StratosAuthService.GetUsername(mockLDAPUserID)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "ldapuser")
+		})
+
+		Convey("sessions should be kept while the directory is not available", func() {
+			server.SetDown(true)
+			So(pp.StratosAuthService.VerifySession(ctx, mockLDAPUserID, 0), ShouldBeNil)
+		})
+
+		Convey("sessions of users that are no longer in the directory should not be valid", func() {
+			server.RemoveEntry(mockLDAPUserDN)
+			So(pp.StratosAuthService.VerifySession(ctx, mockLDAPUserID, 0), ShouldEqual, ldap.ErrUserNotFound)
+		})
+	})
+}
@@ -17,6 +17,8 @@ import (
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/mfa"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/stringutils"
 )
 
 //More fields will be moved into here as global portalProxy struct is phased out
@@ -40,7 +42,7 @@ func (a *localAuth) Login(c echo.Context) error {
 	}
 
 	//Perform the login and fetch session values if successful
-	userGUID, username, err := a.localLogin(c)
+	user, err := a.localLogin(c)
 
 	if err != nil {
 		//Login failed, return response.
@@ -52,9 +54,113 @@ func (a *localAuth) Login(c echo.Context) error {
 		return err
 	}
 
-	err = a.generateLoginSuccessResponse(c, userGUID, username)
+	// Users that have enrolled in MFA must enter a code before they are logged in
+	mfaRepo, err := mfa.NewPgsqlMFARepository(a.databaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	enrolment, err := mfaRepo.Find(user.UserGUID, a.p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to log in",
+			"Unable to find MFA of user %s: %v", user.UserGUID, err)
+	}
+	if enrolment != nil && enrolment.Enabled {
+		return a.requestMFACode(c, user)
+	}
 
-	return err
+	mfaEnrolmentRequired, err := a.p.isMFARequired(*user)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to log in",
+			"Unable to check if MFA is required: %v", err)
+	}
+
+	return a.completeLogin(c, user, mfaEnrolmentRequired)
+}
+
+//VerifyMFA completes the login of a user that has entered their password with a code from their authenticator app or a recovery code
+func (a *localAuth) VerifyMFA(c echo.Context) error {
+	log.Debug("VerifyMFA")
+
+	userGUID, err := a.p.GetSessionStringValue(c, mfaPendingUserKey)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied - Log in with your username and password first",
+			"No login is waiting for an MFA code")
+	}
+	if expiry, err := a.p.GetSessionInt64Value(c, mfaPendingExpiryKey); err != nil || time.Now().Unix() > expiry {
+		a.clearPendingMFA(c)
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied - Login has expired, log in again",
+			"Login of user %s waiting for an MFA code has expired", userGUID)
+	}
+
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(a.databaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	mfaRepo, err := mfa.NewPgsqlMFARepository(a.databaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	user, err := localUsersRepo.FindUser(userGUID)
+	if err != nil || user.Disabled {
+		a.clearPendingMFA(c)
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied - Log in with your username and password first",
+			"Unable to find user %s waiting for an MFA code: %v", userGUID, err)
+	} else if isLocalUserLocked(user) {
+		a.clearPendingMFA(c)
+		return interfaces.NewHTTPShadowError(http.StatusUnauthorized, errLocalUserLocked.Error(), "User %s is locked out", userGUID)
+	}
+
+	enrolment, err := mfaRepo.Find(userGUID, a.p.Config.EncryptionKeyInBytes)
+	if err != nil || enrolment == nil || !enrolment.Enabled {
+		a.clearPendingMFA(c)
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied - Log in with your username and password first",
+			"Unable to find MFA of user %s: %v", userGUID, err)
+	}
+
+	ok, err := a.p.checkMFACode(mfaRepo, enrolment, c.FormValue("code"))
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check verification code",
+			"Unable to check MFA code of user %s: %v", userGUID, err)
+	} else if !ok {
+		// Wrong codes count towards a lockout, the same as wrong passwords
+		a.recordFailedLogin(c, localUsersRepo, user)
+
+		// Codes are short, so a login only gets a few guesses even if lockouts are turned off
+		failures, _ := a.p.GetSessionInt64Value(c, mfaPendingFailuresKey)
+		failures++
+		if failures >= mfaMaxPendingFailures {
+			a.clearPendingMFA(c)
+			return interfaces.NewHTTPShadowError(
+				http.StatusUnauthorized,
+				"Access Denied - Too many invalid verification codes, log in again",
+				"Too many invalid MFA codes for user %s", userGUID)
+		}
+		if err := a.p.setSessionValues(c, map[string]interface{}{mfaPendingFailuresKey: failures}); err != nil {
+			log.Warnf("Unable to update session: %v", err)
+		}
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied - Invalid verification code",
+			"Invalid MFA code for user %s", userGUID)
+	}
+
+	a.clearPendingMFA(c)
+	return a.completeLogin(c, &user, false)
 }
 
 //Logout provides Local-auth specific Stratos login
@@ -96,12 +202,9 @@ func (a *localAuth) GetUser(userGUID string) (*interfaces.ConnectedUser, error)
 		return nil, err
 	}
 
-	uaaAdmin := (user.Scope == a.p.Config.ConsoleConfig.ConsoleAdminScope)
+	uaaAdmin := a.p.isLocalUserAdmin(user)
 
-	var scopes []string
-	scopes = make([]string, 2)
-	scopes[0] = user.Scope
-	scopes[1] = "password.write"
+	scopes := append(getLocalUserScopes(user), "password.write")
 
 	connectdUser := &interfaces.ConnectedUser{
 		GUID:   userGUID,
@@ -113,7 +216,7 @@ func (a *localAuth) GetUser(userGUID string) (*interfaces.ConnectedUser, error)
 	return connectdUser, nil
 }
 
-//VerifySession verifies the session the specified local user, currently just verifies user exists
+//VerifySession verifies the session the specified local user, currently just verifies user exists and is not disabled
 func (a *localAuth) VerifySession(c echo.Context, sessionUser string, sessionExpireTime int64) error {
 	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(a.databaseConnectionPool)
 	if err != nil {
@@ -121,36 +224,51 @@ func (a *localAuth) VerifySession(c echo.Context, sessionUser string, sessionExp
 		return err
 	}
 
-	_, err = localUsersRepo.FindPasswordHash(sessionUser)
-	return err
+	user, err := localUsersRepo.FindUser(sessionUser)
+	if err != nil {
+		return err
+	}
+	if user.Disabled {
+		return errLocalUserDisabled
+	}
+	return nil
 }
 
 //localLogin verifies local user credentials against our DB
-func (a *localAuth) localLogin(c echo.Context) (string, string, error) {
+func (a *localAuth) localLogin(c echo.Context) (*interfaces.LocalUser, error) {
 	log.Debug("doLocalLogin")
 
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
 	if len(username) == 0 || len(password) == 0 {
-		return "", username, errors.New("Needs usernameand password")
+		return nil, errors.New("Needs usernameand password")
 	}
 
 	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(a.databaseConnectionPool)
 	if err != nil {
 		log.Errorf("Database error getting repo for Local users: %v", err)
-		return "", username, err
+		return nil, err
 	}
 
 	var scopeOK bool
 	var hash []byte
 	var authError error
 	var localUserScope string
+	var user interfaces.LocalUser
 
 	// Get the GUID for the specified user
 	guid, err := localUsersRepo.FindUserGUID(username)
 	if err != nil {
-		return guid, username, fmt.Errorf("Access Denied - Invalid username/password credentials")
+		return nil, fmt.Errorf("Access Denied - Invalid username/password credentials")
+	}
+
+	if user, err = localUsersRepo.FindUser(guid); err != nil {
+		return nil, fmt.Errorf("Access Denied - Invalid username/password credentials")
+	} else if isLocalUserLocked(user) {
+		// Locked out users get the same error as unknown users, so that lockouts don't reveal which usernames exist
+		log.Debugf("Local user %s is locked out", guid)
+		return nil, fmt.Errorf("Access Denied - Invalid username/password credentials")
 	}
 
 	//Attempt to find the password has for the given user
@@ -158,36 +276,136 @@ func (a *localAuth) localLogin(c echo.Context) (string, string, error) {
 		authError = fmt.Errorf("Access Denied - Invalid username/password credentials")
 		//Check the password hash
 	} else if authError = crypto.CheckPasswordHash(password, hash); authError != nil {
+		a.recordFailedLogin(c, localUsersRepo, user)
 		authError = fmt.Errorf("Access Denied - Invalid username/password credentials")
 	} else {
 		//Ensure the local user has some kind of admin role configured and we check for it here
 		localUserScope, authError = localUsersRepo.FindUserScope(guid)
-		scopeOK = strings.Contains(localUserScope, a.localUserScope)
+		scopeOK = len(a.localUserScope) == 0 || stringutils.ArrayContainsString(strings.Fields(localUserScope), a.localUserScope)
 		if (authError != nil) || (!scopeOK) {
 			authError = fmt.Errorf("Access Denied - User scope invalid")
-		} else {
-			//Update the last login time here if login was successful
-			loginTime := time.Now()
-			if updateLoginTimeErr := localUsersRepo.UpdateLastLoginTime(guid, loginTime); updateLoginTimeErr != nil {
-				log.Error(updateLoginTimeErr)
-				log.Errorf("Failed to update last login time for user: %s", guid)
-			}
+		} else if user.Disabled {
+			authError = fmt.Errorf("Access Denied - User is disabled")
 		}
 	}
-	return guid, username, authError
+	if authError != nil {
+		return nil, authError
+	}
+	return &user, nil
+}
+
+// requestMFACode starts a login that is waiting for the user to enter an MFA code
+// The session only records that the password has been checked - the user is not logged in until the code has been checked
+func (a *localAuth) requestMFACode(c echo.Context, user *interfaces.LocalUser) error {
+	// Ensure that login disregards cookies from the request
+	req := c.Request()
+	req.Header.Set("Cookie", "")
+	err := a.p.setSessionValues(c, map[string]interface{}{
+		mfaPendingUserKey:     user.UserGUID,
+		mfaPendingExpiryKey:   time.Now().Add(mfaPendingTimeout).Unix(),
+		mfaPendingFailuresKey: int64(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &interfaces.LoginRes{
+		Account:     user.Username,
+		MFARequired: true,
+	})
+}
+
+// clearPendingMFA removes a login that is waiting for an MFA code from the session
+func (a *localAuth) clearPendingMFA(c echo.Context) {
+	session, err := a.p.GetSession(c)
+	if err != nil {
+		return
+	}
+	delete(session.Values, mfaPendingUserKey)
+	delete(session.Values, mfaPendingExpiryKey)
+	delete(session.Values, mfaPendingFailuresKey)
+	if err = a.p.SaveSession(c, session); err != nil {
+		log.Warnf("Unable to update session: %v", err)
+	}
+}
+
+// completeLogin logs in a user that has entered their password, and MFA code if they have one
+func (a *localAuth) completeLogin(c echo.Context, user *interfaces.LocalUser, mfaEnrolmentRequired bool) error {
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(a.databaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	// Failed logins only count towards a lockout until the user logs in successfully
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := localUsersRepo.UpdateFailedLogins(user.UserGUID, 0, nil); err != nil {
+			log.Errorf("Failed to reset failed logins for user: %s: %v", user.UserGUID, err)
+		}
+	}
+
+	//Update the last login time here if login was successful
+	loginTime := time.Now()
+	if updateLoginTimeErr := localUsersRepo.UpdateLastLoginTime(user.UserGUID, loginTime); updateLoginTimeErr != nil {
+		log.Error(updateLoginTimeErr)
+		log.Errorf("Failed to update last login time for user: %s", user.UserGUID)
+	}
+
+	return a.generateLoginSuccessResponse(c, user, mfaEnrolmentRequired)
+}
+
+// recordFailedLogin counts a login with the wrong password - users are locked out for a while after too many of them
+func (a *localAuth) recordFailedLogin(c echo.Context, localUsersRepo localusers.Repository, user interfaces.LocalUser) {
+	maxFailedLogins := int(a.p.Config.LocalUserMaxFailedLogins)
+	if maxFailedLogins <= 0 {
+		return
+	}
+
+	// Count in the database rather than from the user that was read at the start of the login,
+	// so that guesses made at the same time can't overwrite each other's count
+	attempts, err := localUsersRepo.IncrementFailedLogins(user.UserGUID)
+	if err != nil {
+		log.Errorf("Failed to record failed login for user: %s: %v", user.UserGUID, err)
+		return
+	}
+	if attempts < maxFailedLogins {
+		return
+	}
+
+	lockout := a.p.Config.LocalUserLockoutInSecs
+	if lockout <= 0 {
+		lockout = defaultLocalUserLockout
+	}
+	lockedUntil := time.Now().Add(time.Duration(lockout) * time.Second)
+	if err := localUsersRepo.UpdateFailedLogins(user.UserGUID, 0, &lockedUntil); err != nil {
+		log.Errorf("Failed to lock out user: %s: %v", user.UserGUID, err)
+		return
+	}
+
+	log.Warnf("Local user %s has been locked out until %s after %d failed logins", user.Username, lockedUntil.Format(time.RFC3339), maxFailedLogins)
+	setAuditDetails(c, fmt.Sprintf("user=%s username=%s locked_until=%s", user.UserGUID, user.Username, lockedUntil.UTC().Format(time.RFC3339)))
+	a.p.recordAuditEvent(c, interfaces.AuditLocalUserLockout, "", echo.NewHTTPError(http.StatusUnauthorized, errLocalUserLocked.Error()))
 }
 
 //generateLoginSuccessResponse
-func (a *localAuth) generateLoginSuccessResponse(c echo.Context, userGUID string, username string) error {
+func (a *localAuth) generateLoginSuccessResponse(c echo.Context, user *interfaces.LocalUser, mfaEnrolmentRequired bool) error {
 	log.Debug("generateLoginResponse")
 
 	var err error
 	var expiry int64
 	expiry = math.MaxInt64
+	userGUID := user.UserGUID
+
+	sessionValues := a.p.newLoginSessionValues(c, userGUID, expiry)
 
-	sessionValues := make(map[string]interface{})
-	sessionValues["user_id"] = userGUID
-	sessionValues["exp"] = expiry
+	// Users that must change their password can only do that until they have changed it
+	if user.PasswordChangeRequired {
+		sessionValues[passwordChangeRequiredKey] = true
+	}
+
+	// Users that must use MFA, but haven't enrolled yet, can only enrol until they have done so
+	if mfaEnrolmentRequired {
+		sessionValues[mfaEnrolmentRequiredKey] = true
+	}
 
 	// Ensure that login disregards cookies from the request
 	req := c.Request()
@@ -196,16 +414,22 @@ func (a *localAuth) generateLoginSuccessResponse(c echo.Context, userGUID string
 		return err
 	}
 
+	if err = a.p.enforceSessionLimit(c, userGUID); err != nil {
+		return err
+	}
+
 	//Makes sure the client gets the right session expiry time
 	if err = a.p.handleSessionExpiryHeader(c); err != nil {
 		return err
 	}
 
 	resp := &interfaces.LoginRes{
-		Account:     username,
-		TokenExpiry: expiry,
-		APIEndpoint: nil,
-		Admin:       true,
+		Account:                user.Username,
+		TokenExpiry:            expiry,
+		APIEndpoint:            nil,
+		Admin:                  a.p.isLocalUserAdmin(*user),
+		PasswordChangeRequired: user.PasswordChangeRequired,
+		MFAEnrolmentRequired:   mfaEnrolmentRequired,
 	}
 
 	if jsonString, err := json.Marshal(resp); err == nil {
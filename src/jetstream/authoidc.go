@@ -0,0 +1,428 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/oidc"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/usersessions"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/stringutils"
+)
+
+const (
+	defaultOIDCScopes        = "openid profile email"
+	defaultOIDCUserIDClaim   = "sub"
+	defaultOIDCUserNameClaim = "preferred_username"
+	defaultOIDCGroupsClaim   = "groups"
+
+	// Session values that hold a login request until the provider redirects back to the console
+	oidcStateKey     = "oidc_state"
+	oidcNonceKey     = "oidc_nonce"
+	oidcVerifierKey  = "oidc_verifier"
+	oidcReturnURLKey = "oidc_return_url"
+
+	// Session value that holds the ID token of a user that has logged out, until they are logged out of the provider
+	oidcLogoutHintKey = "oidc_logout_hint"
+
+	// User IDs are stored in columns of this size - longer IDs are mapped to a UUID
+	maxUserGUIDLength = 36
+)
+
+// oidcAuth provides Stratos login with any OpenID Connect provider
+// The token record of a user holds their ID token, as the console only needs to know who they are
+type oidcAuth struct {
+	databaseConnectionPool *sql.DB
+	p                      *portalProxy
+	client                 *oidc.Client
+}
+
+func newOIDCAuth(p *portalProxy) (*oidcAuth, error) {
+	scopes := strings.Fields(strings.Replace(p.Config.OIDCScopes, ",", " ", -1))
+	if len(scopes) == 0 {
+		scopes = strings.Fields(defaultOIDCScopes)
+	}
+	if !stringutils.ArrayContainsString(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	httpClient := p.GetHttpClient(p.Config.OIDCSkipSSLValidation)
+	client, err := oidc.NewClient(oidc.Config{
+		IssuerURL:    p.Config.OIDCIssuerURL,
+		ClientID:     p.Config.OIDCClientID,
+		ClientSecret: p.Config.OIDCClientSecret,
+		Scopes:       scopes,
+		HTTPClient:   &httpClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Users will log in with OpenID Connect provider %s", client.Issuer())
+	return &oidcAuth{
+		databaseConnectionPool: p.DatabaseConnectionPool,
+		p:                      p,
+		client:                 client,
+	}, nil
+}
+
+//Login is not supported - users log in at the provider via the SSO routes
+func (a *oidcAuth) Login(c echo.Context) error {
+	return interfaces.NewHTTPShadowError(
+		http.StatusNotFound,
+		"Log in with single sign-on",
+		"Login with credentials is not supported with OpenID Connect")
+}
+
+//Logout ends the Stratos session of the user, keeping their ID token for logout at the provider
+func (a *oidcAuth) Logout(c echo.Context) error {
+	log.Debug("OIDC logout")
+
+	idToken := ""
+	if userGUID, err := a.p.GetSessionStringValue(c, "user_id"); err == nil {
+		if tr, err := a.p.GetUAATokenRecord(userGUID); err == nil {
+			idToken = tr.AuthToken
+		}
+	}
+
+	session, err := a.resetSession(c)
+	if err != nil {
+		log.Errorf("Unable to clear session: %v", err)
+	} else if len(idToken) > 0 {
+		session.Values[oidcLogoutHintKey] = idToken
+		a.p.SaveSession(c, session)
+	}
+
+	return c.JSON(http.StatusOK, &LogoutResponse{IsSSO: true})
+}
+
+//GetUsername gets the user name of the user from their ID token
+func (a *oidcAuth) GetUsername(userGUID string) (string, error) {
+	claims, err := a.getClaims(userGUID)
+	if err != nil {
+		return "", err
+	}
+	return a.getUserName(claims), nil
+}
+
+//GetUser gets the user from their ID token - groups are used as scopes
+func (a *oidcAuth) GetUser(userGUID string) (*interfaces.ConnectedUser, error) {
+	log.Debug("GetUser")
+	claims, err := a.getClaims(userGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := claims.Strings(getOIDCClaimName(a.p.Config.OIDCGroupsClaim, defaultOIDCGroupsClaim))
+	admin := len(a.p.Config.OIDCAdminGroup) > 0 && stringutils.ArrayContainsString(groups, a.p.Config.OIDCAdminGroup)
+	if groups == nil {
+		groups = make([]string, 0)
+	}
+
+	return &interfaces.ConnectedUser{
+		GUID:   userGUID,
+		Name:   a.getUserName(claims),
+		Admin:  admin,
+		Scopes: groups,
+		Groups: groups,
+	}, nil
+}
+
+//VerifySession verifies the session of the user and refreshes their tokens if they have expired
+func (a *oidcAuth) VerifySession(c echo.Context, sessionUser string, sessionExpireTime int64) error {
+	tr, err := a.p.GetUAATokenRecord(sessionUser)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to find OIDC token: %s", err)
+		log.Error(msg)
+		return echo.NewHTTPError(http.StatusForbidden, msg)
+	}
+
+	if time.Now().Before(time.Unix(sessionExpireTime, 0)) {
+		return nil
+	}
+
+	// Tokens have expired - refresh them, if that fails, fail the request
+	token, err := a.client.Refresh(tr.RefreshToken)
+	if err != nil {
+		log.Errorf("Could not refresh OIDC token: %v", err)
+		return echo.NewHTTPError(http.StatusForbidden, "Could not refresh OIDC token")
+	}
+
+	// Providers don't have to return a new ID token on refresh - if they do, it must be for the same user
+	idToken := tr.AuthToken
+	if len(token.IDToken) > 0 {
+		claims, err := a.client.VerifyIDToken(token.IDToken, "")
+		if err != nil {
+			log.Errorf("Refreshed ID token is not valid: %v", err)
+			return echo.NewHTTPError(http.StatusForbidden, "Could not refresh OIDC token")
+		}
+		if userGUID, err := a.getUserGUID(claims); err != nil || userGUID != sessionUser {
+			return echo.NewHTTPError(http.StatusForbidden, "Refreshed ID token is for a different user")
+		}
+		idToken = token.IDToken
+	}
+
+	claims, err := oidc.DecodeClaims(idToken)
+	if err != nil {
+		return err
+	}
+	expiry := getOIDCTokenExpiry(token, claims)
+	if err := a.saveToken(sessionUser, idToken, token.RefreshToken, tr.RefreshToken, expiry); err != nil {
+		return err
+	}
+
+	return a.p.setSessionValues(c, map[string]interface{}{"exp": expiry})
+}
+
+// redirectToLogin sends the user to the provider to log in, remembering the login request in their session
+func (a *oidcAuth) redirectToLogin(c echo.Context, returnURL string) error {
+	state, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+
+	authURL, err := a.client.AuthCodeURL(a.getRedirectURI(returnURL), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusServiceUnavailable,
+			"SSO Login: Unable to contact the identity provider",
+			"SSO Login: %v", err)
+	}
+
+	err = a.p.setSessionValues(c, map[string]interface{}{
+		oidcStateKey:     state,
+		oidcNonceKey:     nonce,
+		oidcVerifierKey:  verifier,
+		oidcReturnURLKey: returnURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+// loginCallback completes a login once the provider has redirected back to the console
+func (a *oidcAuth) loginCallback(c echo.Context) error {
+	returnURL, err := a.completeLogin(c)
+	if err != nil {
+		log.Warnf("SSO Login failed: %v", err)
+		msg := err.Error()
+		if httpError, ok := err.(interfaces.ErrHTTPShadow); ok {
+			msg = httpError.UserFacingError
+		}
+		return c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("%s/login?SSO_Message=%s", strings.TrimRight(returnURL, "/"), url.QueryEscape(msg)))
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, returnURL)
+}
+
+// completeLogin checks the response of the provider and starts a session for the user
+// The URL of the UI to return to is returned, even if login failed
+func (a *oidcAuth) completeLogin(c echo.Context) (string, error) {
+	session, err := a.p.GetSession(c)
+	if err != nil {
+		return "", err
+	}
+	state, _ := session.Values[oidcStateKey].(string)
+	nonce, _ := session.Values[oidcNonceKey].(string)
+	verifier, _ := session.Values[oidcVerifierKey].(string)
+	returnURL, _ := session.Values[oidcReturnURLKey].(string)
+
+	// A login request can only be used once, and the user gets a new session once logged in
+	if _, err := a.resetSession(c); err != nil {
+		return returnURL, err
+	}
+
+	if len(state) == 0 || c.QueryParam("state") != state {
+		return returnURL, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"SSO Login: Login request is not valid or has expired",
+			"SSO Login: State %q does not match the login request", c.QueryParam("state"))
+	}
+
+	if errorCode := c.QueryParam("error"); len(errorCode) > 0 {
+		msg := c.QueryParam("error_description")
+		if len(msg) == 0 {
+			msg = errorCode
+		}
+		return returnURL, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			msg,
+			"SSO Login: Identity provider returned %s: %s", errorCode, msg)
+	}
+
+	token, err := a.client.ExchangeCode(c.QueryParam("code"), verifier, a.getRedirectURI(returnURL))
+	if err != nil {
+		msg := "SSO Login: Unable to get tokens from the identity provider"
+		if tokenErr, ok := err.(*oidc.TokenError); ok && len(tokenErr.Description) > 0 {
+			msg = tokenErr.Description
+		}
+		return returnURL, interfaces.NewHTTPShadowError(http.StatusUnauthorized, msg, "SSO Login: %v", err)
+	}
+
+	claims, err := a.client.VerifyIDToken(token.IDToken, nonce)
+	if err != nil {
+		return returnURL, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"SSO Login: ID token is not valid",
+			"SSO Login: %v", err)
+	}
+
+	userGUID, err := a.getUserGUID(claims)
+	if err != nil {
+		return returnURL, interfaces.NewHTTPShadowError(http.StatusUnauthorized, "SSO Login: Unable to identify user", "SSO Login: %v", err)
+	}
+
+	expiry := getOIDCTokenExpiry(token, claims)
+	if err := a.saveToken(userGUID, token.IDToken, token.RefreshToken, "", expiry); err != nil {
+		return returnURL, err
+	}
+
+	if err := a.p.setSessionValues(c, a.p.newLoginSessionValues(c, userGUID, expiry)); err != nil {
+		return returnURL, err
+	}
+
+	if err := a.p.enforceSessionLimit(c, userGUID); err != nil {
+		return returnURL, err
+	}
+
+	if err := a.p.ExecuteLoginHooks(c); err != nil {
+		log.Warnf("Login hooks failed: %v", err)
+	}
+
+	return returnURL, nil
+}
+
+// logoutRedirect ends the session of the user at the provider, if it supports RP-initiated logout
+func (a *oidcAuth) logoutRedirect(c echo.Context, returnURL string) error {
+	idToken := ""
+	if hint, err := a.p.GetSessionStringValue(c, oidcLogoutHintKey); err == nil {
+		idToken = hint
+		if err := a.p.clearSession(c); err != nil {
+			log.Warnf("Unable to clear session: %v", err)
+		}
+	}
+
+	logoutURL, err := a.client.EndSessionURL(idToken, getSSORedirectURI(returnURL, "logout", ""))
+	if err != nil {
+		log.Warnf("Unable to log out of the identity provider: %v", err)
+	}
+	if len(logoutURL) == 0 {
+		logoutURL = "/login?SSO_Message=You+have+been+logged+out"
+	}
+	return c.Redirect(http.StatusTemporaryRedirect, logoutURL)
+}
+
+// resetSession removes the session of the request from the session store and gives the request a new, empty session
+func (a *oidcAuth) resetSession(c echo.Context) (*sessions.Session, error) {
+	session, err := a.p.GetSession(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(session.ID) > 0 {
+		sessionsRepo, err := usersessions.NewPgsqlSessionsRepository(a.databaseConnectionPool)
+		if err != nil {
+			return nil, fmt.Errorf(dbReferenceError, err)
+		}
+		if _, err := sessionsRepo.Delete(session.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	session.ID = ""
+	session.IsNew = true
+	session.Values = make(map[interface{}]interface{})
+	return session, nil
+}
+
+// getRedirectURI returns the URI the provider redirects back to after login
+func (a *oidcAuth) getRedirectURI(returnURL string) string {
+	if len(a.p.Config.OIDCRedirectURL) > 0 {
+		return a.p.Config.OIDCRedirectURL
+	}
+
+	baseURL, err := url.Parse(returnURL)
+	if err != nil {
+		return returnURL
+	}
+	baseURL.Path = ""
+	baseURL.RawQuery = ""
+	baseURL.Fragment = ""
+	return fmt.Sprintf("%s/pp/v1/auth/sso_login_callback", strings.TrimRight(baseURL.String(), "?"))
+}
+
+func (a *oidcAuth) saveToken(userGUID, idToken, refreshToken, previousRefreshToken string, expiry int64) error {
+	// Providers that don't rotate refresh tokens don't return a new one
+	if len(refreshToken) == 0 {
+		refreshToken = previousRefreshToken
+	}
+	return a.p.setUAATokenRecord(userGUID, interfaces.TokenRecord{
+		AuthToken:    idToken,
+		RefreshToken: refreshToken,
+		TokenExpiry:  expiry,
+		AuthType:     interfaces.AuthTypeOIDC,
+	})
+}
+
+func (a *oidcAuth) getClaims(userGUID string) (oidc.Claims, error) {
+	tr, err := a.p.GetUAATokenRecord(userGUID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve OIDC token record: %v", err)
+	}
+	return oidc.DecodeClaims(tr.AuthToken)
+}
+
+// getUserGUID returns the ID of the user in Stratos
+// IDs that are too long to store are mapped to a UUID derived from the issuer and the ID
+func (a *oidcAuth) getUserGUID(claims oidc.Claims) (string, error) {
+	claim := getOIDCClaimName(a.p.Config.OIDCUserIDClaim, defaultOIDCUserIDClaim)
+	id := claims.String(claim)
+	if len(id) == 0 {
+		return "", fmt.Errorf("ID token has no %s claim", claim)
+	}
+	if len(id) > maxUserGUIDLength {
+		return uuid.NewV5(uuid.NamespaceURL, a.client.Issuer()+"#"+id).String(), nil
+	}
+	return id, nil
+}
+
+func (a *oidcAuth) getUserName(claims oidc.Claims) string {
+	if name := claims.String(getOIDCClaimName(a.p.Config.OIDCUserNameClaim, defaultOIDCUserNameClaim)); len(name) > 0 {
+		return name
+	}
+	return claims.String("sub")
+}
+
+func getOIDCClaimName(configured string, defaultName string) string {
+	if len(configured) > 0 {
+		return configured
+	}
+	return defaultName
+}
+
+// getOIDCTokenExpiry returns when the tokens expire, preferring the lifetime of the access token
+func getOIDCTokenExpiry(token *oidc.TokenResponse, claims oidc.Claims) int64 {
+	if token.ExpiresIn > 0 {
+		return time.Now().Unix() + token.ExpiresIn
+	}
+	return claims.Time("exp").Unix()
+}
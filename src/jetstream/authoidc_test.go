@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/oidc/oidctest"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	mockOIDCClientID = "stratos"
+	mockOIDCSubject  = "5b9a0c3e-oidc-user"
+	mockConsoleURL   = "https://stratos.example.com"
+)
+
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func setupOIDCTest(idp *oidctest.IdP, pp *portalProxy) {
+	pp.Config.OIDCIssuerURL = idp.Issuer()
+	pp.Config.OIDCClientID = mockOIDCClientID
+	pp.Config.OIDCClientSecret = mockClientSecret
+	pp.Config.OIDCAdminGroup = "stratos-admins"
+	pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.OIDC)
+	pp.Config.EncryptionKeyInBytes = mockEncryptionKey
+	So(pp.InitStratosAuthService(interfaces.OIDC), ShouldBeNil)
+}
+
+func expectOIDCTokenRow(idToken string) sqlmock.Rows {
+	encryptedIDToken, _ := crypto.EncryptToken(mockEncryptionKey, idToken)
+	encryptedRefreshToken, _ := crypto.EncryptToken(mockEncryptionKey, "mock-refresh-token")
+	return sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "auth_type", "meta_data"}).
+		AddRow(mockTokenGUID, encryptedIDToken, encryptedRefreshToken, mockTokenExpiry, interfaces.AuthTypeOIDC, "")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given the console logs users in with an OpenID Connect provider", t, func() {
+		idp := oidctest.NewIdP(mockOIDCClientID, mockClientSecret, mockOIDCSubject)
+		idp.SetClaim("preferred_username", "oidc-user")
+		idp.SetClaim("groups", []string{"developers", "stratos-admins"})
+		defer idp.Close()
+
+		req := setupMockReq("GET", mockConsoleURL+"/pp/v1/auth/sso_login?state="+url.QueryEscape(mockConsoleURL+"/"), nil)
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		setupOIDCTest(idp, pp)
+
+		So(pp.Config.SSOLogin, ShouldBeTrue)
+
+		Convey("a user should be sent to the provider and logged in when it redirects back", func() {
+			So(pp.initSSOlogin(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusTemporaryRedirect)
+			authURL := res.Header().Get("Location")
+			So(authURL, ShouldStartWith, idp.URL+"/authorize?")
+			So(authURL, ShouldContainSubstring, "code_challenge_method=S256")
+			So(authURL, ShouldContainSubstring, "redirect_uri="+url.QueryEscape(mockConsoleURL+"/pp/v1/auth/sso_login_callback"))
+
+			idpRes, err := noRedirectClient.Get(authURL)
+			So(err, ShouldBeNil)
+			idpRes.Body.Close()
+			callbackURL := idpRes.Header.Get("Location")
+			So(callbackURL, ShouldStartWith, mockConsoleURL+"/pp/v1/auth/sso_login_callback?")
+
+			mock.ExpectQuery(selectAnyFromTokens).
+				WillReturnRows(expectNoRows())
+			mock.ExpectExec(insertIntoTokens).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			callbackRes := httptest.NewRecorder()
+			_, callbackCtx := setupEchoContext(callbackRes, setupMockReq("GET", callbackURL, nil))
+			So(pp.ssoLoginToUAA(callbackCtx), ShouldBeNil)
+			So(callbackRes.Code, ShouldEqual, http.StatusTemporaryRedirect)
+			So(callbackRes.Header().Get("Location"), ShouldEqual, mockConsoleURL+"/")
+
+			userGUID, err := pp.GetSessionStringValue(callbackCtx, "user_id")
+			So(err, ShouldBeNil)
+			So(userGUID, ShouldEqual, mockOIDCSubject)
+			_, err = pp.GetSessionValue(callbackCtx, oidcStateKey)
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+			Convey("the callback can not be used again", func() {
+				replayRes := httptest.NewRecorder()
+				_, replayCtx := setupEchoContext(replayRes, setupMockReq("GET", callbackURL, nil))
+				So(pp.ssoLoginToUAA(replayCtx), ShouldBeNil)
+				So(replayRes.Header().Get("Location"), ShouldStartWith, "/login?SSO_Message=")
+			})
+		})
+
+		Convey("a callback with the wrong state should be rejected", func() {
+			So(pp.initSSOlogin(ctx), ShouldBeNil)
+
+			callbackRes := httptest.NewRecorder()
+			_, callbackCtx := setupEchoContext(callbackRes, setupMockReq("GET", mockConsoleURL+"/pp/v1/auth/sso_login_callback?state=forged&code=forged", nil))
+			So(pp.ssoLoginToUAA(callbackCtx), ShouldBeNil)
+			So(callbackRes.Header().Get("Location"), ShouldStartWith, mockConsoleURL+"/login?SSO_Message=")
+
+			_, err := pp.GetSessionValue(callbackCtx, "user_id")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("the user should be mapped from the claims of their ID token", func() {
+			mock.ExpectQuery(selectAnyFromTokens).
+				WillReturnRows(expectOIDCTokenRow(idp.IDToken("")))
+
+			user, err := pp.StratosAuthService.GetUser(mockOIDCSubject)
+			So(err, ShouldBeNil)
+			So(user.Name, ShouldEqual, "oidc-user")
+			So(user.Admin, ShouldBeTrue)
+			So(user.Scopes, ShouldResemble, []string{"developers", "stratos-admins"})
+			So(user.Groups, ShouldResemble, []string{"developers", "stratos-admins"})
+		})
+
+		Convey("logging out should redirect to the provider with the ID token of the user", func() {
+			idToken := idp.IDToken("")
+			pp.setSessionValues(ctx, map[string]interface{}{"user_id": mockOIDCSubject})
+			mock.ExpectQuery(selectAnyFromTokens).
+				WillReturnRows(expectOIDCTokenRow(idToken))
+			So(pp.consoleLogout(ctx), ShouldBeNil)
+			So(res.Body.String(), ShouldContainSubstring, `"isSSO":true`)
+
+			logoutRes := httptest.NewRecorder()
+			_, logoutCtx := setupEchoContext(logoutRes, setupMockReq("GET", mockConsoleURL+"/pp/v1/auth/sso_logout?state="+url.QueryEscape(mockConsoleURL), nil))
+			So(pp.ssoLogoutOfUAA(logoutCtx), ShouldBeNil)
+
+			logoutURL := logoutRes.Header().Get("Location")
+			So(logoutURL, ShouldStartWith, idp.URL+"/logout?")
+			So(logoutURL, ShouldContainSubstring, "id_token_hint="+idToken)
+			So(logoutURL, ShouldContainSubstring, "post_logout_redirect_uri="+url.QueryEscape(mockConsoleURL+"/pp/v1/auth/sso_login_callback?state=logout"))
+		})
+	})
+}
+
+func TestOIDCUserGUID(t *testing.T) {
+	t.Parallel()
+
+	Convey("User IDs that are too long to store should be mapped to a UUID", t, func() {
+		idp := oidctest.NewIdP(mockOIDCClientID, mockClientSecret, mockOIDCSubject)
+		defer idp.Close()
+
+		_, _, _, pp, db, _ := setupHTTPTest(setupMockReq("GET", "", nil))
+		defer db.Close()
+		setupOIDCTest(idp, pp)
+		auth := pp.StratosAuthService.(*oidcAuth)
+
+		long := strings.Repeat("x", 64)
+		guid, err := auth.getUserGUID(map[string]interface{}{"sub": long})
+		So(err, ShouldBeNil)
+		So(len(guid), ShouldEqual, 36)
+
+		again, _ := auth.getUserGUID(map[string]interface{}{"sub": long})
+		So(again, ShouldEqual, guid)
+
+		_, err = auth.getUserGUID(map[string]interface{}{})
+		So(err, ShouldNotBeNil)
+	})
+}
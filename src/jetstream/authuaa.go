@@ -201,9 +201,7 @@ func (p *portalProxy) loginToUAA(c echo.Context) (*interfaces.LoginRes, error) {
 
 	} else { //Login succes
 
-		sessionValues := make(map[string]interface{})
-		sessionValues["user_id"] = u.UserGUID
-		sessionValues["exp"] = u.TokenExpiry
+		sessionValues := p.newLoginSessionValues(c, u.UserGUID, u.TokenExpiry)
 
 		// Ensure that login disregards cookies from the request
 		req := c.Request()
@@ -212,6 +210,10 @@ func (p *portalProxy) loginToUAA(c echo.Context) (*interfaces.LoginRes, error) {
 			return nil, err
 		}
 
+		if err = p.enforceSessionLimit(c, u.UserGUID); err != nil {
+			return nil, err
+		}
+
 		err = p.handleSessionExpiryHeader(c)
 		if err != nil {
 			return nil, err
@@ -268,7 +270,7 @@ func (p *portalProxy) saveAuthToken(u interfaces.JWTUserTokenInfo, authTok strin
 func (p *portalProxy) setUAATokenRecord(key string, t interfaces.TokenRecord) error {
 	log.Debug("setUAATokenRecord")
 
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return fmt.Errorf("Database error getting repo for UAA token: %v", err)
 	}
@@ -384,7 +386,7 @@ func (p *portalProxy) getUAAToken(body url.Values, skipSSLValidation bool, clien
 func (p *portalProxy) GetUAATokenRecord(userGUID string) (interfaces.TokenRecord, error) {
 	log.Debug("GetUAATokenRecord")
 
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		log.Errorf("Database error getting repo for UAA token: %v", err)
 		return interfaces.TokenRecord{}, err
@@ -453,6 +455,11 @@ func (p *portalProxy) ssoLoginToUAA(c echo.Context) error {
 	if state == "logout" {
 		return c.Redirect(http.StatusTemporaryRedirect, "/login?SSO_Message=You+have+been+logged+out")
 	}
+
+	if oidcAuth, ok := p.StratosAuthService.(*oidcAuth); ok {
+		return oidcAuth.loginCallback(c)
+	}
+
 	_, err := p.loginToUAA(c)
 	if err != nil {
 		// Send error as query string param
@@ -488,6 +495,10 @@ func (p *portalProxy) ssoLogoutOfUAA(c echo.Context) error {
 		return err
 	}
 
+	if oidcAuth, ok := p.StratosAuthService.(*oidcAuth); ok {
+		return oidcAuth.logoutRedirect(c, state)
+	}
+
 	// Redirect to the UAA to logout of the UAA session as well (if configured to do so), otherwise redirect back to the UI login page
 	var redirectURL string
 	if p.hasSSOOption("logout") {
@@ -535,6 +546,10 @@ func (p *portalProxy) initSSOlogin(c echo.Context) error {
 		return err
 	}
 
+	if oidcAuth, ok := p.StratosAuthService.(*oidcAuth); ok {
+		return oidcAuth.redirectToLogin(c, state)
+	}
+
 	redirectURL := fmt.Sprintf("%s/oauth/authorize?response_type=code&client_id=%s&redirect_uri=%s", p.Config.ConsoleConfig.AuthorizationEndpoint, p.Config.ConsoleConfig.ConsoleClient, url.QueryEscape(getSSORedirectURI(state, state, "")))
 	c.Redirect(http.StatusTemporaryRedirect, redirectURL)
 	return nil
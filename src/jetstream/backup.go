@@ -0,0 +1,622 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/userfavorites/userfavoritesstore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/console_config"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/mfa"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+const (
+	// Increment when the content of the archive changes in a way that older versions can't restore
+	backupArchiveVersion = 1
+
+	// Group of the console configuration that is backed up
+	backupConfigGroup = "env"
+
+	// The CLI reads the passphrase from this environment variable, so that it isn't visible in the process list
+	backupPassphraseEnvVar = "BACKUP_PASSPHRASE"
+)
+
+// Types of restored items, used in the restore report
+const (
+	backupEndpoints  = "endpoints"
+	backupTokens     = "tokens"
+	backupLocalUsers = "local_users"
+	backupMFA        = "mfa"
+	backupFavorites  = "favorites"
+	backupConfig     = "config"
+)
+
+var flagDryRun = flag.Bool("dry-run", false, "report what a restore would change without changing anything")
+
+// backupArchive is an encrypted backup of the Jetstream state
+type backupArchive struct {
+	Version         int       `json:"version"`
+	DatabaseVersion int64     `json:"database_version"`
+	Created         time.Time `json:"created"`
+	Salt            []byte    `json:"salt"`
+	Payload         []byte    `json:"payload"`
+}
+
+// backupContent is the decrypted payload of a backup archive
+// Secrets are decrypted, so that they can be encrypted with the encryption key of the instance they are restored to
+type backupContent struct {
+	Endpoints  []backupEndpoint       `json:"endpoints"`
+	Tokens     []backupToken          `json:"tokens"`
+	LocalUsers []interfaces.LocalUser `json:"local_users"`
+	MFA        []backupLocalUserMFA   `json:"mfa,omitempty"`
+	Favorites  []backupFavorite       `json:"favorites"`
+	Config     map[string]string      `json:"config"`
+}
+
+type backupEndpoint struct {
+	interfaces.CNSIRecord
+	ClientSecret string                          `json:"client_secret"`
+	Visibility   []interfaces.EndpointVisibility `json:"visibility,omitempty"`
+}
+
+type backupToken struct {
+	CNSIGUID string                 `json:"cnsi_guid"`
+	UserGUID string                 `json:"user_guid"`
+	Token    interfaces.TokenRecord `json:"token"`
+}
+
+// backupLocalUserMFA is the second factor of a local user, along with the hashes of their recovery codes
+type backupLocalUserMFA struct {
+	UserGUID      string    `json:"user_guid"`
+	Secret        string    `json:"secret"`
+	Enabled       bool      `json:"enabled"`
+	LastUsedStep  int64     `json:"last_used_step"`
+	Created       time.Time `json:"created"`
+	RecoveryCodes []string  `json:"recovery_codes"`
+}
+
+type backupFavorite struct {
+	userfavoritesstore.UserFavoriteRecord
+	UserGUID string `json:"user_guid"`
+}
+
+// backupRequest is the body of a backup request
+type backupRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// restoreRequest is the body of a restore request
+type restoreRequest struct {
+	Passphrase string         `json:"passphrase"`
+	DryRun     bool           `json:"dry_run"`
+	Archive    *backupArchive `json:"archive"`
+}
+
+// restoreReport lists what was restored and what was not because it conflicts with the current state
+type restoreReport struct {
+	DryRun    bool              `json:"dry_run"`
+	Restored  map[string]int    `json:"restored"`
+	Conflicts []restoreConflict `json:"conflicts"`
+
+	// Plugins are told about restored endpoints once the restore has been committed
+	endpoints []interfaces.CNSIRecord
+}
+
+type restoreConflict struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+func (r *restoreReport) conflict(itemType, id, reason string) {
+	r.Conflicts = append(r.Conflicts, restoreConflict{Type: itemType, ID: id, Reason: reason})
+}
+
+// createBackup exports endpoints, endpoint tokens, local users and their second factors, favorites and console configuration to an archive encrypted with the passphrase
+// Linked tokens and the tokens of console sessions are not included, users will need to log in again after a restore
+func (p *portalProxy) createBackup(passphrase string) (*backupArchive, error) {
+	content, err := p.readBackupContent()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to marshal backup: %v", err)
+	}
+
+	salt, ciphertext, err := crypto.EncryptWithPassphrase(passphrase, payload)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encrypt backup: %v", err)
+	}
+
+	archive := &backupArchive{
+		Version: backupArchiveVersion,
+		Created: time.Now().UTC(),
+		Salt:    salt,
+		Payload: ciphertext,
+	}
+	if versions, err := p.getVersionsData(); err == nil {
+		archive.DatabaseVersion = versions.DatabaseVersion
+	}
+
+	log.Infof("Backed up %d endpoint(s), %d token(s), %d local user(s), %d second factor(s) and %d favorite(s)",
+		len(content.Endpoints), len(content.Tokens), len(content.LocalUsers), len(content.MFA), len(content.Favorites))
+	return archive, nil
+}
+
+func (p *portalProxy) readBackupContent() (*backupContent, error) {
+	content := &backupContent{}
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+	cnsiList, err := cnsiRepo.List(p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list endpoints: %v", err)
+	}
+	visibility, err := cnsiRepo.ListVisibility()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list endpoint visibility: %v", err)
+	}
+	for _, cnsi := range cnsiList {
+		content.Endpoints = append(content.Endpoints, backupEndpoint{
+			CNSIRecord:   *cnsi,
+			ClientSecret: cnsi.ClientSecret,
+			Visibility:   visibility[cnsi.GUID],
+		})
+	}
+
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+	tokenList, err := tokenRepo.ListCNSITokens(p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokenList {
+		content.Tokens = append(content.Tokens, backupToken{CNSIGUID: token.CNSIGUID, UserGUID: token.UserGUID, Token: token.Record})
+	}
+
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+	if content.LocalUsers, err = localUsersRepo.ListLocalUsers(); err != nil {
+		return nil, err
+	}
+
+	mfaRepo, err := mfa.NewPgsqlMFARepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+	mfaList, err := mfaRepo.List(p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return nil, err
+	}
+	recoveryCodes, err := mfaRepo.ListRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, enrolment := range mfaList {
+		content.MFA = append(content.MFA, backupLocalUserMFA{
+			UserGUID:      enrolment.UserGUID,
+			Secret:        enrolment.Secret,
+			Enabled:       enrolment.Enabled,
+			LastUsedStep:  enrolment.LastUsedStep,
+			Created:       enrolment.Created,
+			RecoveryCodes: recoveryCodes[enrolment.UserGUID],
+		})
+	}
+
+	favoritesStore, err := userfavoritesstore.NewFavoritesDBStore(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+	favorites, err := favoritesStore.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, favorite := range favorites {
+		content.Favorites = append(content.Favorites, backupFavorite{UserFavoriteRecord: *favorite, UserGUID: favorite.UserGUID})
+	}
+
+	configRepo, err := console_config.NewPostgresConsoleConfigRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+	if content.Config, err = configRepo.GetValues(backupConfigGroup); err != nil {
+		return nil, fmt.Errorf("Unable to read console configuration: %v", err)
+	}
+
+	return content, nil
+}
+
+// restoreBackup merges the archive into the current state
+// Items that already exist are never overwritten, they are reported as conflicts instead
+// Everything is restored in one transaction, so that a failed restore doesn't leave part of the backup behind
+func (p *portalProxy) restoreBackup(archive *backupArchive, passphrase string, dryRun bool) (*restoreReport, error) {
+	if archive.Version != backupArchiveVersion {
+		return nil, fmt.Errorf("Unsupported backup version %d", archive.Version)
+	}
+	if versions, err := p.getVersionsData(); err == nil && archive.DatabaseVersion > versions.DatabaseVersion {
+		return nil, fmt.Errorf("Backup is from a newer database version (%d) than this instance (%d)", archive.DatabaseVersion, versions.DatabaseVersion)
+	}
+
+	payload, err := crypto.DecryptWithPassphrase(passphrase, archive.Salt, archive.Payload)
+	if err != nil {
+		return nil, err
+	}
+	content := &backupContent{}
+	if err := json.Unmarshal(payload, content); err != nil {
+		return nil, fmt.Errorf("Unable to parse backup: %v", err)
+	}
+
+	report := &restoreReport{
+		DryRun:    dryRun,
+		Restored:  make(map[string]int),
+		Conflicts: make([]restoreConflict, 0),
+	}
+
+	txn, err := p.DatabaseConnectionPool.Begin()
+	if err != nil {
+		return report, fmt.Errorf("Unable to start restore transaction: %v", err)
+	}
+
+	steps := []func(datastore.Connection, *backupContent, *restoreReport) error{
+		p.restoreConfig,
+		p.restoreLocalUsers,
+		p.restoreEndpoints,
+		p.restoreFavorites,
+	}
+	for _, step := range steps {
+		if err := step(txn, content, report); err != nil {
+			txn.Rollback()
+			// Nothing has been restored
+			report.Restored = make(map[string]int)
+			return report, err
+		}
+	}
+
+	if dryRun {
+		txn.Rollback()
+		return report, nil
+	}
+	if err := txn.Commit(); err != nil {
+		report.Restored = make(map[string]int)
+		return report, fmt.Errorf("Unable to commit restore: %v", err)
+	}
+
+	// Notify plugins if they support the notification interface
+	for i := range report.endpoints {
+		for _, plugin := range p.Plugins {
+			if notifier, ok := plugin.(interfaces.EndpointNotificationPlugin); ok {
+				notifier.OnEndpointNotification(interfaces.EndpointRegisterAction, &report.endpoints[i])
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (p *portalProxy) restoreConfig(txn datastore.Connection, content *backupContent, report *restoreReport) error {
+	configRepo, err := console_config.NewPostgresConsoleConfigRepository(txn)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	existing, err := configRepo.GetValues(backupConfigGroup)
+	if err != nil {
+		return fmt.Errorf("Unable to read console configuration: %v", err)
+	}
+
+	for name, value := range content.Config {
+		if current, ok := existing[name]; ok {
+			if current != value {
+				report.conflict(backupConfig, name, "Configuration value is already set to a different value")
+			}
+			continue
+		}
+		if !report.DryRun {
+			if err := configRepo.SetValue(backupConfigGroup, name, value); err != nil {
+				return fmt.Errorf("Unable to restore configuration value %s: %v", name, err)
+			}
+		}
+		report.Restored[backupConfig]++
+	}
+	return nil
+}
+
+// restoreLocalUsers restores local users and their second factors
+// Second factors are only restored for users that were restored, so that they can't be added to an existing user
+func (p *portalProxy) restoreLocalUsers(txn datastore.Connection, content *backupContent, report *restoreReport) error {
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(txn)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	existing, err := localUsersRepo.ListLocalUsers()
+	if err != nil {
+		return err
+	}
+	guids := make(map[string]bool)
+	usernames := make(map[string]bool)
+	for _, user := range existing {
+		guids[user.UserGUID] = true
+		usernames[user.Username] = true
+	}
+
+	restored := make(map[string]bool)
+	for _, user := range content.LocalUsers {
+		switch {
+		case guids[user.UserGUID]:
+			report.conflict(backupLocalUsers, user.Username, "A local user with the same GUID already exists")
+		case usernames[user.Username]:
+			report.conflict(backupLocalUsers, user.Username, "A local user with the same username already exists")
+		default:
+			if !report.DryRun {
+				if err := localUsersRepo.AddLocalUser(user); err != nil {
+					return fmt.Errorf("Unable to restore local user %s: %v", user.Username, err)
+				}
+			}
+			restored[user.UserGUID] = true
+			report.Restored[backupLocalUsers]++
+		}
+	}
+
+	mfaRepo, err := mfa.NewPgsqlMFARepository(txn)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	for _, enrolment := range content.MFA {
+		if !restored[enrolment.UserGUID] {
+			report.conflict(backupMFA, enrolment.UserGUID, "The local user of the second factor was not restored")
+			continue
+		}
+		if !report.DryRun {
+			userMFA := &interfaces.LocalUserMFA{
+				UserGUID:     enrolment.UserGUID,
+				Secret:       enrolment.Secret,
+				Enabled:      enrolment.Enabled,
+				LastUsedStep: enrolment.LastUsedStep,
+				Created:      enrolment.Created,
+			}
+			if err := mfaRepo.Restore(userMFA, enrolment.RecoveryCodes, p.Config.EncryptionKeyInBytes); err != nil {
+				return fmt.Errorf("Unable to restore second factor of local user %s: %v", enrolment.UserGUID, err)
+			}
+		}
+		report.Restored[backupMFA]++
+	}
+	return nil
+}
+
+// restoreEndpoints restores endpoints and their tokens
+// Tokens are only restored for endpoints that were restored, so that they aren't mixed with the tokens of an existing endpoint
+func (p *portalProxy) restoreEndpoints(txn datastore.Connection, content *backupContent, report *restoreReport) error {
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(txn, p.SecretStore)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	existing, err := cnsiRepo.List(p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return fmt.Errorf("Unable to list endpoints: %v", err)
+	}
+	guids := make(map[string]bool)
+	urls := make(map[string]bool)
+	for _, cnsi := range existing {
+		guids[cnsi.GUID] = true
+		urls[cnsi.APIEndpoint.String()] = true
+	}
+
+	restored := make(map[string]bool)
+	for _, endpoint := range content.Endpoints {
+		cnsi := endpoint.CNSIRecord
+		cnsi.ClientSecret = endpoint.ClientSecret
+		if cnsi.APIEndpoint == nil {
+			report.conflict(backupEndpoints, cnsi.Name, "Endpoint has no URL")
+			continue
+		}
+		switch {
+		case guids[cnsi.GUID]:
+			report.conflict(backupEndpoints, cnsi.Name, "An endpoint with the same GUID is already registered")
+			continue
+		case urls[cnsi.APIEndpoint.String()]:
+			report.conflict(backupEndpoints, cnsi.Name, "An endpoint with the same URL is already registered")
+			continue
+		}
+
+		if !report.DryRun {
+			if err := cnsiRepo.Save(cnsi.GUID, cnsi, p.Config.EncryptionKeyInBytes); err != nil {
+				return fmt.Errorf("Unable to restore endpoint %s: %v", cnsi.Name, err)
+			}
+			if len(endpoint.Visibility) > 0 {
+				if err := cnsiRepo.SetVisibility(cnsi.GUID, endpoint.Visibility); err != nil {
+					return fmt.Errorf("Unable to restore visibility of endpoint %s: %v", cnsi.Name, err)
+				}
+			}
+			report.endpoints = append(report.endpoints, cnsi)
+		}
+		restored[cnsi.GUID] = true
+		report.Restored[backupEndpoints]++
+	}
+
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(txn, p.SecretStore)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	for _, token := range content.Tokens {
+		if !restored[token.CNSIGUID] {
+			report.conflict(backupTokens, token.CNSIGUID+"/"+token.UserGUID, "The endpoint of the token was not restored")
+			continue
+		}
+		if !report.DryRun {
+			if err := tokenRepo.SaveCNSIToken(token.CNSIGUID, token.UserGUID, token.Token, p.Config.EncryptionKeyInBytes); err != nil {
+				return fmt.Errorf("Unable to restore token of endpoint %s: %v", token.CNSIGUID, err)
+			}
+		}
+		report.Restored[backupTokens]++
+	}
+
+	return nil
+}
+
+func (p *portalProxy) restoreFavorites(txn datastore.Connection, content *backupContent, report *restoreReport) error {
+	favoritesStore, err := userfavoritesstore.NewFavoritesDBStore(txn)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	existing, err := favoritesStore.ListAll()
+	if err != nil {
+		return err
+	}
+	guids := make(map[string]bool)
+	for _, favorite := range existing {
+		guids[favorite.GUID] = true
+	}
+
+	for _, favorite := range content.Favorites {
+		if guids[favorite.GUID] {
+			report.conflict(backupFavorites, favorite.GUID, "A favorite with the same GUID already exists")
+			continue
+		}
+		if !report.DryRun {
+			record := favorite.UserFavoriteRecord
+			record.UserGUID = favorite.UserGUID
+			if _, err := favoritesStore.Save(record); err != nil {
+				return fmt.Errorf("Unable to restore favorite %s: %v", favorite.GUID, err)
+			}
+		}
+		report.Restored[backupFavorites]++
+	}
+	return nil
+}
+
+// backupState returns an archive of the Jetstream state, encrypted with the passphrase in the request body
+func (p *portalProxy) backupState(c echo.Context) error {
+	request := &backupRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil || len(request.Passphrase) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"A passphrase is required to encrypt the backup",
+			"Invalid backup request: %v", err)
+	}
+
+	archive, err := p.createBackup(request.Passphrase)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to create backup",
+			"Unable to create backup: %v", err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=stratos-backup.json")
+	return c.JSON(http.StatusOK, archive)
+}
+
+// restoreState merges an archive into the Jetstream state and reports what was restored and what conflicted
+func (p *portalProxy) restoreState(c echo.Context) error {
+	request := &restoreRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil || request.Archive == nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid restore request",
+			"Invalid restore request: %v", err)
+	}
+
+	report, err := p.restoreBackup(request.Archive, request.Passphrase, request.DryRun)
+	if err != nil {
+		if report == nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Unable to restore backup: "+err.Error(),
+				"Unable to restore backup: %v", err)
+		}
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to restore backup",
+			"Unable to restore backup: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// isBackupCommand returns true if the command line asks for a backup or a restore
+func isBackupCommand(command string) bool {
+	return command == "backup" || command == "restore"
+}
+
+// runBackupCommand runs the backup or restore command from the command line, returning false if there is none
+// Usage: jetstream backup <file> or jetstream [-dry-run] restore <file>, with the passphrase in BACKUP_PASSPHRASE
+func runBackupCommand(p *portalProxy) bool {
+	args := flag.Args()
+	if len(args) < 1 || !isBackupCommand(args[0]) {
+		return false
+	}
+	if len(args) != 2 {
+		log.Fatalf("Usage: %s <file>", args[0])
+	}
+
+	passphrase := os.Getenv(backupPassphraseEnvVar)
+	if len(passphrase) == 0 {
+		log.Fatalf("Set %s to the passphrase of the backup", backupPassphraseEnvVar)
+	}
+
+	if err := runBackup(p, args[0], args[1], passphrase, *flagDryRun); err != nil {
+		log.Fatal(err)
+	}
+	return true
+}
+
+func runBackup(p *portalProxy, command, file, passphrase string, dryRun bool) error {
+	if command == "backup" {
+		archive, err := p.createBackup(passphrase)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(archive)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			return fmt.Errorf("Unable to write backup: %v", err)
+		}
+		log.Infof("Backup written to %s", file)
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("Unable to read backup: %v", err)
+	}
+	archive := &backupArchive{}
+	if err := json.Unmarshal(data, archive); err != nil {
+		return fmt.Errorf("Unable to parse backup: %v", err)
+	}
+
+	report, err := p.restoreBackup(archive, passphrase, dryRun)
+	if report != nil {
+		for itemType, count := range report.Restored {
+			log.Infof("Restored %d %s", count, itemType)
+		}
+		for _, conflict := range report.Conflicts {
+			log.Warnf("Not restored: %s %s: %s", conflict.Type, conflict.ID, conflict.Reason)
+		}
+		if report.DryRun {
+			log.Info("Dry run - nothing has been changed")
+		}
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+)
+
+const (
+	selectAllFromCNSIs           = `SELECT (.+) FROM cnsis`
+	selectAllEndpointVisibility  = `SELECT (.+) FROM endpoint_visibility`
+	selectAllCNSITokens          = `SELECT (.+) FROM tokens WHERE token_type = 'cnsi' AND linked_token IS NULL`
+	selectAllLocalUsers          = `SELECT (.+) FROM local_users`
+	selectAllLocalUsersMFA       = `SELECT (.+) FROM local_users_mfa`
+	selectAllRecoveryCodes       = `SELECT (.+) FROM local_users_recovery_codes`
+	insertIntoLocalUsersMFA      = `INSERT INTO local_users_mfa`
+	insertIntoRecoveryCodes      = `INSERT INTO local_users_recovery_codes`
+	deleteRecoveryCodes          = `DELETE FROM local_users_recovery_codes`
+	selectAllFavorites           = `SELECT (.+) FROM favorites`
+	selectConfigValues           = `SELECT name, value, last_updated FROM config WHERE groupName = (.+)`
+	insertIntoEndpointVisibility = `INSERT INTO endpoint_visibility`
+	insertIntoFavorites          = `INSERT INTO favorites`
+	insertIntoConfig             = `INSERT INTO config`
+	mockBackupPassphrase         = "backup passphrase"
+)
+
+var (
+	rowFieldsForLocalUsers   = []string{"user_guid", "password_hash", "user_name", "user_email", "user_scope", "given_name", "family_name", "disabled", "password_change_required", "last_login", "failed_login_attempts", "locked_until"}
+	rowFieldsForFavorites    = []string{"guid", "user_guid", "endpoint_type", "endpoint_id", "entity_type", "entity_id", "metadata"}
+	rowFieldsForConfigValues = []string{"name", "value", "last_updated"}
+	rowFieldsForCNSITokens   = []string{"token_guid", "cnsi_guid", "user_guid", "auth_token", "refresh_token", "token_expiry", "disconnected", "auth_type", "meta_data"}
+	rowFieldsForLocalUserMFA = []string{"user_guid", "secret", "enabled", "last_used_step", "created"}
+)
+
+func expectDatabaseVersion(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(getDbVersion).
+		WillReturnRows(sqlmock.NewRows([]string{"version_id"}).AddRow(20191129090000))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	t.Parallel()
+
+	Convey("Backing up the Jetstream state", t, func() {
+		req := setupMockReq("POST", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		encryptedToken, _ := crypto.EncryptToken(mockEncryptionKey, mockUAAToken)
+		mock.ExpectQuery(selectAllFromCNSIs).
+			WillReturnRows(expectCFRow())
+		mock.ExpectQuery(selectAllEndpointVisibility).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointVisibility).AddRow(mockCFGUID, "scope", "stratos.team-a"))
+		mock.ExpectQuery(selectAllCNSITokens).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForCNSITokens).
+				AddRow(mockTokenGUID, mockCFGUID, mockUserGUID, encryptedToken, encryptedToken, mockTokenExpiry, false, "oauth2", nil))
+		mock.ExpectQuery(selectAllLocalUsers).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUsers).
+				AddRow(mockUserGUID, []byte("hash"), "admin", "admin@example.com", "stratos.admin", nil, nil, false, false, nil, 0, nil))
+		encryptedMFASecret, _ := crypto.EncryptToken(mockEncryptionKey, "JBSWY3DPEHPK3PXP")
+		mock.ExpectQuery(selectAllLocalUsersMFA).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUserMFA).
+				AddRow(mockUserGUID, encryptedMFASecret, true, 42, time.Now()))
+		mock.ExpectQuery(selectAllRecoveryCodes).
+			WillReturnRows(sqlmock.NewRows([]string{"user_guid", "code_hash"}).AddRow(mockUserGUID, "code-hash"))
+		mock.ExpectQuery(selectAllFavorites).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForFavorites).
+				AddRow("favorite-1", mockUserGUID, "cf", mockCFGUID, "endpoint", mockCFGUID, `{"name":"cf"}`))
+		mock.ExpectQuery(selectConfigValues).
+			WithArgs(backupConfigGroup).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForConfigValues).AddRow("SSO_LOGIN", "true", time.Now()))
+		expectDatabaseVersion(mock)
+
+		archive, err := pp.createBackup(mockBackupPassphrase)
+		So(err, ShouldBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+		So(archive.Version, ShouldEqual, backupArchiveVersion)
+		So(archive.DatabaseVersion, ShouldEqual, 20191129090000)
+
+		Convey("should not restore with the wrong passphrase", func() {
+			expectDatabaseVersion(mock)
+			report, err := pp.restoreBackup(archive, "wrong", false)
+			So(err, ShouldNotBeNil)
+			So(report, ShouldBeNil)
+		})
+
+		Convey("should report conflicts without changing anything in a dry run", func() {
+			expectDatabaseVersion(mock)
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectConfigValues).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConfigValues).AddRow("SSO_LOGIN", "false", time.Now()))
+			mock.ExpectQuery(selectAllLocalUsers).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUsers))
+			mock.ExpectQuery(selectAllFromCNSIs).
+				WillReturnRows(expectCFRow())
+			mock.ExpectQuery(selectAllFavorites).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForFavorites))
+			mock.ExpectRollback()
+
+			report, err := pp.restoreBackup(archive, mockBackupPassphrase, true)
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(report.DryRun, ShouldBeTrue)
+			So(report.Restored[backupLocalUsers], ShouldEqual, 1)
+			So(report.Restored[backupMFA], ShouldEqual, 1)
+			So(report.Restored[backupFavorites], ShouldEqual, 1)
+			So(report.Restored[backupEndpoints], ShouldEqual, 0)
+			So(report.Conflicts, ShouldHaveLength, 3)
+			So(report.Conflicts[0].Type, ShouldEqual, backupConfig)
+			So(report.Conflicts[1].Type, ShouldEqual, backupEndpoints)
+			So(report.Conflicts[2].Type, ShouldEqual, backupTokens)
+		})
+
+		Convey("should not restore anything if part of the restore fails", func() {
+			expectDatabaseVersion(mock)
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectConfigValues).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConfigValues))
+			mock.ExpectQuery(selectConfigValues).
+				WithArgs(backupConfigGroup, "SSO_LOGIN").
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConfigValues))
+			mock.ExpectExec(insertIntoConfig).
+				WithArgs(backupConfigGroup, "SSO_LOGIN", "true").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(selectAllLocalUsers).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUsers))
+			mock.ExpectExec(addLocalUser).
+				WillReturnError(errors.New("Unknown Database Error"))
+			mock.ExpectRollback()
+
+			report, err := pp.restoreBackup(archive, mockBackupPassphrase, false)
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(report.Restored, ShouldBeEmpty)
+		})
+
+		Convey("should restore everything into an empty instance", func() {
+			expectDatabaseVersion(mock)
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectConfigValues).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConfigValues))
+			mock.ExpectQuery(selectConfigValues).
+				WithArgs(backupConfigGroup, "SSO_LOGIN").
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConfigValues))
+			mock.ExpectExec(insertIntoConfig).
+				WithArgs(backupConfigGroup, "SSO_LOGIN", "true").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(selectAllLocalUsers).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUsers))
+			mock.ExpectExec(addLocalUser).
+				WithArgs(mockUserGUID, []byte("hash"), "admin", "admin@example.com", "stratos.admin", "", "", false, false).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(insertIntoLocalUsersMFA).
+				WithArgs(mockUserGUID, sqlmock.AnyArg(), true, 42, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(deleteRecoveryCodes).
+				WithArgs(mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(insertIntoRecoveryCodes).
+				WithArgs(mockUserGUID, "code-hash").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(selectAllFromCNSIs).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForCNSI))
+			mock.ExpectExec(insertIntoCNSIs).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(`DELETE FROM endpoint_visibility`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(insertIntoEndpointVisibility).
+				WithArgs(mockCFGUID, "scope", "stratos.team-a").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(selectAnyFromTokens).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectExec(insertIntoTokens).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(selectAllFavorites).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForFavorites))
+			mock.ExpectExec(insertIntoFavorites).
+				WithArgs("favorite-1", mockUserGUID, "cf", mockCFGUID, "endpoint", mockCFGUID, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			report, err := pp.restoreBackup(archive, mockBackupPassphrase, false)
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(report.Conflicts, ShouldBeEmpty)
+			So(report.Restored[backupConfig], ShouldEqual, 1)
+			So(report.Restored[backupLocalUsers], ShouldEqual, 1)
+			So(report.Restored[backupMFA], ShouldEqual, 1)
+			So(report.Restored[backupEndpoints], ShouldEqual, 1)
+			So(report.Restored[backupTokens], ShouldEqual, 1)
+			So(report.Restored[backupFavorites], ShouldEqual, 1)
+		})
+	})
+}
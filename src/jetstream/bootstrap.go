@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+const (
+	// Only one instance reconciles the declared endpoints at a time
+	endpointBootstrapLockName     = "endpoint-bootstrap"
+	endpointBootstrapLockTTL      = 2 * time.Minute
+	endpointBootstrapLockAttempts = 60
+)
+
+// endpointBootstrap lists the endpoints that should be registered, read from ENDPOINTS_BOOTSTRAP or ENDPOINTS_BOOTSTRAP_FILE
+type endpointBootstrap struct {
+	Endpoints []bootstrapEndpoint `yaml:"endpoints" json:"endpoints"`
+}
+
+// bootstrapEndpoint is an endpoint declared in the bootstrap file
+// SystemSharedToken holds the form values used to connect the endpoint on behalf of all users, e.g. connect_type, username and password
+type bootstrapEndpoint struct {
+	Name              string            `yaml:"name" json:"name"`
+	Type              string            `yaml:"type" json:"type"`
+	URL               string            `yaml:"url" json:"url"`
+	SkipSSLValidation bool              `yaml:"skip_ssl_validation" json:"skip_ssl_validation"`
+	ClientID          string            `yaml:"client_id" json:"client_id"`
+	ClientSecret      string            `yaml:"client_secret" json:"client_secret"`
+	SSOAllowed        bool              `yaml:"sso_allowed" json:"sso_allowed"`
+	SubType           string            `yaml:"sub_type" json:"sub_type"`
+	Metadata          map[string]string `yaml:"metadata" json:"metadata"`
+	SystemSharedToken map[string]string `yaml:"system_shared_token" json:"system_shared_token"`
+}
+
+// readEndpointBootstrap returns the declared endpoints, or nil if none have been configured
+func readEndpointBootstrap(config interfaces.PortalConfig) (*endpointBootstrap, error) {
+	content := config.EndpointsBootstrap
+	if len(config.EndpointsBootstrapFile) > 0 {
+		data, err := ioutil.ReadFile(config.EndpointsBootstrapFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read endpoint bootstrap file: %v", err)
+		}
+		content = string(data)
+	}
+	if len(strings.TrimSpace(content)) == 0 {
+		return nil, nil
+	}
+	return parseEndpointBootstrap(content)
+}
+
+// parseEndpointBootstrap parses and validates declared endpoints in YAML or JSON
+func parseEndpointBootstrap(content string) (*endpointBootstrap, error) {
+	bootstrap := &endpointBootstrap{}
+
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(content), "{") {
+		err = json.Unmarshal([]byte(content), bootstrap)
+	} else {
+		err = yaml.Unmarshal([]byte(content), bootstrap)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse endpoint bootstrap: %v", err)
+	}
+
+	urls := make(map[string]bool)
+	for i := range bootstrap.Endpoints {
+		endpoint := &bootstrap.Endpoints[i]
+		endpoint.URL = strings.TrimRight(strings.TrimSpace(endpoint.URL), "/")
+		if len(endpoint.Name) == 0 || len(endpoint.Type) == 0 || len(endpoint.URL) == 0 {
+			return nil, fmt.Errorf("Endpoint %d of the endpoint bootstrap needs a name, type and url", i+1)
+		}
+		if _, err := url.Parse(endpoint.URL); err != nil {
+			return nil, fmt.Errorf("Endpoint %s has an invalid url: %v", endpoint.Name, err)
+		}
+		if urls[endpoint.URL] {
+			return nil, fmt.Errorf("Endpoint %s has the same url as another endpoint: %s", endpoint.Name, endpoint.URL)
+		}
+		urls[endpoint.URL] = true
+	}
+
+	return bootstrap, nil
+}
+
+// bootstrapEndpoints registers the declared endpoints, updates those that have changed and,
+// if ENDPOINTS_BOOTSTRAP_PRUNE is set, unregisters the endpoints that are no longer declared
+func (p *portalProxy) bootstrapEndpoints() error {
+	bootstrap, err := readEndpointBootstrap(p.Config)
+	if err != nil || bootstrap == nil {
+		return err
+	}
+
+	locked := false
+	for attempt := 0; attempt < endpointBootstrapLockAttempts && !locked; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Second)
+		}
+		if locked, err = p.AcquireLock(endpointBootstrapLockName, endpointBootstrapLockTTL); err != nil {
+			return err
+		}
+	}
+	if !locked {
+		return errors.New("Timed out waiting for another instance to bootstrap the endpoints")
+	}
+	defer p.ReleaseLock(endpointBootstrapLockName)
+
+	log.Infof("Bootstrapping %d endpoint(s)", len(bootstrap.Endpoints))
+
+	declared := make(map[string]bool)
+	failed := false
+	for _, endpoint := range bootstrap.Endpoints {
+		cnsiGUID, err := p.reconcileEndpoint(endpoint)
+		if len(cnsiGUID) > 0 {
+			declared[cnsiGUID] = true
+		}
+		if err != nil {
+			log.Errorf("Unable to bootstrap endpoint %s: %v", endpoint.Name, err)
+			failed = true
+		}
+	}
+
+	// A declared endpoint that could not be reconciled, e.g. because of a temporary error, must not be unregistered
+	if p.Config.EndpointsBootstrapPrune && failed {
+		log.Warn("Not unregistering endpoints that are no longer declared, as some declared endpoints could not be bootstrapped")
+	} else if p.Config.EndpointsBootstrapPrune {
+		cnsiList, err := p.ListEndpoints()
+		if err != nil {
+			return fmt.Errorf("Unable to list endpoints to prune: %v", err)
+		}
+		for _, cnsi := range cnsiList {
+			if !declared[cnsi.GUID] {
+				log.Infof("Unregistering endpoint %s as it is no longer declared", cnsi.Name)
+				p.doUnregisterEndpoint(cnsi.GUID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reconcileEndpoint registers or updates a declared endpoint, returning its GUID if it is registered
+func (p *portalProxy) reconcileEndpoint(endpoint bootstrapEndpoint) (string, error) {
+	endpointPlugin, err := p.GetEndpointTypeSpec(endpoint.Type)
+	if err != nil {
+		return "", fmt.Errorf("Unknown endpoint type %s", endpoint.Type)
+	}
+
+	existing, err := p.GetCNSIRecordByEndpoint(endpoint.URL)
+	if err != nil {
+		log.Infof("Registering endpoint %s (%s)", endpoint.Name, endpoint.URL)
+		existing, err = p.DoRegisterEndpoint(endpoint.Name, endpoint.URL, endpoint.SkipSSLValidation, endpoint.ClientID, endpoint.ClientSecret, endpoint.SSOAllowed, endpoint.SubType, endpointPlugin.Info)
+		if err != nil {
+			return "", err
+		}
+	} else if existing.CNSIType != endpoint.Type {
+		return existing.GUID, fmt.Errorf("Endpoint %s is already registered as type %s", endpoint.URL, existing.CNSIType)
+	}
+
+	if err := p.updateBootstrapEndpoint(existing, endpoint); err != nil {
+		return existing.GUID, err
+	}
+
+	if len(endpoint.SystemSharedToken) > 0 {
+		if err := p.connectBootstrapEndpoint(existing, endpoint, endpointPlugin); err != nil {
+			return existing.GUID, err
+		}
+	}
+
+	return existing.GUID, nil
+}
+
+// updateBootstrapEndpoint updates a registered endpoint whose declaration has changed
+// Metadata is only changed if it has been declared, so that metadata set by plugins is kept
+func (p *portalProxy) updateBootstrapEndpoint(existing interfaces.CNSIRecord, endpoint bootstrapEndpoint) error {
+	updated := existing
+	updated.Name = endpoint.Name
+	updated.SkipSSLValidation = endpoint.SkipSSLValidation
+	updated.ClientId = endpoint.ClientID
+	updated.ClientSecret = endpoint.ClientSecret
+	updated.SSOAllowed = endpoint.SSOAllowed
+	updated.SubType = endpoint.SubType
+	if len(endpoint.Metadata) > 0 {
+		metadata, err := json.Marshal(endpoint.Metadata)
+		if err != nil {
+			return fmt.Errorf("Unable to marshal metadata: %v", err)
+		}
+		updated.Metadata = string(metadata)
+	}
+
+	if reflect.DeepEqual(existing, updated) {
+		return nil
+	}
+
+	log.Infof("Updating endpoint %s (%s)", endpoint.Name, endpoint.URL)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	if err := cnsiRepo.Overwrite(existing.GUID, updated, p.Config.EncryptionKeyInBytes); err != nil {
+		return fmt.Errorf("Unable to update endpoint: %v", err)
+	}
+
+	if !canKeepEndpointTokens(existing, updated) {
+		log.Infof("Endpoint %s has changed how users authenticate - users will need to connect again", existing.GUID)
+		p.unsetCNSITokenRecords(existing.GUID)
+	}
+
+	// Notify plugins if they support the notification interface
+	for _, plugin := range p.Plugins {
+		if notifier, ok := plugin.(interfaces.EndpointNotificationPlugin); ok {
+			notifier.OnEndpointNotification(interfaces.EndpointUpdateAction, &updated)
+		}
+	}
+
+	return nil
+}
+
+// connectBootstrapEndpoint connects the endpoint with a system-shared token, unless it already has one
+func (p *portalProxy) connectBootstrapEndpoint(cnsiRecord interfaces.CNSIRecord, endpoint bootstrapEndpoint, endpointPlugin interfaces.EndpointPlugin) error {
+	if _, ok := p.GetCNSITokenRecord(cnsiRecord.GUID, tokens.SystemSharedUserGuid); ok {
+		return nil
+	}
+
+	log.Infof("Connecting endpoint %s with a system shared token", endpoint.Name)
+
+	// Plugins read the credentials from the form of a connect request
+	form := url.Values{}
+	for name, value := range endpoint.SystemSharedToken {
+		form.Set(name, value)
+	}
+	form.Set("cnsi_guid", cnsiRecord.GUID)
+	form.Set("system_shared", "true")
+	req, err := http.NewRequest(http.MethodPost, "/pp/v1/auth/login/cnsi", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("Unable to create connect request: %v", err)
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	ec := echo.New().NewContext(req, &discardResponseWriter{header: make(http.Header)})
+
+	if _, _, err := p.connectEndpoint(ec, endpointPlugin, cnsiRecord, tokens.SystemSharedUserGuid); err != nil {
+		return fmt.Errorf("Unable to connect with a system shared token: %v", err)
+	}
+	return nil
+}
+
+// discardResponseWriter is the response of connect requests made while bootstrapping - plugins read the credentials
+// from the request, but nothing is sent back to anyone
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(statusCode int)  {}
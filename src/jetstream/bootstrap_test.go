@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestParseEndpointBootstrap(t *testing.T) {
+	t.Parallel()
+
+	Convey("Parsing the endpoint bootstrap", t, func() {
+
+		Convey("should accept YAML", func() {
+			bootstrap, err := parseEndpointBootstrap(`
+endpoints:
+- name: Cloud Foundry
+  type: cf
+  url: https://api.example.com/
+  client_id: cf
+  sso_allowed: true
+  metadata:
+    team: a
+  system_shared_token:
+    username: admin
+    password: secret
+- name: Helm
+  type: helm
+  url: https://charts.example.com
+`)
+			So(err, ShouldBeNil)
+			So(bootstrap.Endpoints, ShouldHaveLength, 2)
+			So(bootstrap.Endpoints[0].URL, ShouldEqual, "https://api.example.com")
+			So(bootstrap.Endpoints[0].SSOAllowed, ShouldBeTrue)
+			So(bootstrap.Endpoints[0].Metadata["team"], ShouldEqual, "a")
+			So(bootstrap.Endpoints[0].SystemSharedToken["username"], ShouldEqual, "admin")
+			So(bootstrap.Endpoints[1].Type, ShouldEqual, "helm")
+		})
+
+		Convey("should accept JSON", func() {
+			bootstrap, err := parseEndpointBootstrap(`{
+	"endpoints": [{"name": "Cloud Foundry", "type": "cf", "url": "https://api.example.com", "skip_ssl_validation": true}]
+}`)
+			So(err, ShouldBeNil)
+			So(bootstrap.Endpoints, ShouldHaveLength, 1)
+			So(bootstrap.Endpoints[0].SkipSSLValidation, ShouldBeTrue)
+		})
+
+		Convey("should reject endpoints without a name, type or url", func() {
+			_, err := parseEndpointBootstrap("endpoints:\n- name: Cloud Foundry\n  url: https://api.example.com\n")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should reject endpoints with the same url", func() {
+			_, err := parseEndpointBootstrap(`
+endpoints:
+- name: One
+  type: cf
+  url: https://api.example.com
+- name: Two
+  type: cf
+  url: https://api.example.com/
+`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUpdateBootstrapEndpoint(t *testing.T) {
+	t.Parallel()
+
+	Convey("Updating a bootstrapped endpoint", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		apiEndpoint, _ := url.Parse(mockAPIEndpoint)
+		existing := interfaces.CNSIRecord{
+			GUID:        mockCFGUID,
+			Name:        "Cloud Foundry",
+			CNSIType:    "cf",
+			APIEndpoint: apiEndpoint,
+			ClientId:    mockClientId,
+			Metadata:    `{"set":"by plugin"}`,
+		}
+		endpoint := bootstrapEndpoint{
+			Name:     "Cloud Foundry",
+			Type:     "cf",
+			URL:      mockAPIEndpoint,
+			ClientID: mockClientId,
+		}
+
+		Convey("should not update an endpoint that matches its declaration", func() {
+			So(pp.updateBootstrapEndpoint(existing, endpoint), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should update an endpoint that has changed and keep its tokens", func() {
+			endpoint.Name = "Renamed"
+			mock.ExpectExec(updateCNSIs).
+				WithArgs("Renamed", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), false, mockClientId, sqlmock.AnyArg(), false, "", `{"set":"by plugin"}`, mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(pp.updateBootstrapEndpoint(existing, endpoint), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should replace metadata that has been declared", func() {
+			endpoint.Metadata = map[string]string{"team": "a"}
+			mock.ExpectExec(updateCNSIs).
+				WithArgs("Cloud Foundry", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), false, mockClientId, sqlmock.AnyArg(), false, "", `{"team":"a"}`, mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(pp.updateBootstrapEndpoint(existing, endpoint), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Default time a circuit stays open before a probe request is allowed through
+const defaultCircuitBreakerResetTimeout = 30
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+var errCircuitOpen = errors.New("Endpoint is unavailable - too many recent requests to it have failed")
+var errTooManyRequests = errors.New("Too many concurrent requests to the endpoint")
+
+// endpointCircuitBreaker tracks the health and in-flight requests of a single endpoint
+type endpointCircuitBreaker struct {
+	State               string
+	ConsecutiveFailures int
+	OpenedAt            time.Time
+	InFlight            int
+	Probing             bool
+}
+
+// circuitBreakers manages a circuit breaker and concurrency limit per registered endpoint
+type circuitBreakers struct {
+	sync.Mutex
+	failureThreshold int
+	resetTimeout     time.Duration
+	maxConcurrent    int
+	breakers         map[string]*endpointCircuitBreaker
+}
+
+// newCircuitBreakers creates the circuit breakers - returns nil if neither a failure threshold or concurrency limit are configured
+func newCircuitBreakers(failureThreshold, resetTimeoutInSecs, maxConcurrent int64) *circuitBreakers {
+	if failureThreshold <= 0 && maxConcurrent <= 0 {
+		return nil
+	}
+
+	if resetTimeoutInSecs <= 0 {
+		resetTimeoutInSecs = defaultCircuitBreakerResetTimeout
+	}
+
+	return &circuitBreakers{
+		failureThreshold: int(failureThreshold),
+		resetTimeout:     time.Duration(resetTimeoutInSecs) * time.Second,
+		maxConcurrent:    int(maxConcurrent),
+		breakers:         make(map[string]*endpointCircuitBreaker),
+	}
+}
+
+// Caller must hold the lock
+func (cb *circuitBreakers) get(endpointGUID string) *endpointCircuitBreaker {
+	breaker, ok := cb.breakers[endpointGUID]
+	if !ok {
+		breaker = &endpointCircuitBreaker{State: circuitClosed}
+		cb.breakers[endpointGUID] = breaker
+	}
+	return breaker
+}
+
+// acquire reserves a slot for a request to the endpoint, or returns an error if the request should fail fast
+func (cb *circuitBreakers) acquire(endpointGUID string) error {
+	cb.Lock()
+	defer cb.Unlock()
+
+	breaker := cb.get(endpointGUID)
+	switch breaker.State {
+	case circuitOpen:
+		if time.Since(breaker.OpenedAt) < cb.resetTimeout {
+			return errCircuitOpen
+		}
+		// Allow a single probe request through to see if the endpoint has recovered
+		breaker.State = circuitHalfOpen
+		breaker.Probing = true
+	case circuitHalfOpen:
+		if breaker.Probing {
+			return errCircuitOpen
+		}
+		breaker.Probing = true
+	}
+
+	if cb.maxConcurrent > 0 && breaker.InFlight >= cb.maxConcurrent {
+		if breaker.State == circuitHalfOpen {
+			breaker.Probing = false
+		}
+		return errTooManyRequests
+	}
+
+	breaker.InFlight++
+	return nil
+}
+
+// record updates the circuit state with the outcome of a request to the endpoint
+func (cb *circuitBreakers) record(endpointGUID string, success bool) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	breaker := cb.get(endpointGUID)
+	breaker.Probing = false
+	if success {
+		breaker.State = circuitClosed
+		breaker.ConsecutiveFailures = 0
+		return
+	}
+
+	breaker.ConsecutiveFailures++
+	if cb.failureThreshold <= 0 {
+		return
+	}
+	if breaker.State == circuitHalfOpen || breaker.ConsecutiveFailures >= cb.failureThreshold {
+		if breaker.State != circuitOpen {
+			log.Warnf("Opening circuit for endpoint %s after %d consecutive failures", endpointGUID, breaker.ConsecutiveFailures)
+		}
+		breaker.State = circuitOpen
+		breaker.OpenedAt = time.Now()
+	}
+}
+
+// release frees the slot reserved by acquire
+func (cb *circuitBreakers) release(endpointGUID string) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	breaker := cb.get(endpointGUID)
+	if breaker.InFlight > 0 {
+		breaker.InFlight--
+	}
+}
+
+// Status returns the state of the circuit breaker for each endpoint that has been used
+func (cb *circuitBreakers) Status() map[string]*interfaces.EndpointCircuitBreakerStatus {
+	cb.Lock()
+	defer cb.Unlock()
+
+	status := make(map[string]*interfaces.EndpointCircuitBreakerStatus)
+	for guid, breaker := range cb.breakers {
+		status[guid] = &interfaces.EndpointCircuitBreakerStatus{
+			State:               breaker.State,
+			ConsecutiveFailures: breaker.ConsecutiveFailures,
+			InFlight:            breaker.InFlight,
+		}
+		if breaker.State != circuitClosed {
+			status[guid].OpenedAt = breaker.OpenedAt.Unix()
+		}
+	}
+	return status
+}
+
+// isEndpointFailure indicates if the outcome of a request means that the endpoint itself is unhealthy
+func isEndpointFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// circuitBreakerBody releases the endpoint's concurrency slot once the response body has been closed
+type circuitBreakerBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *circuitBreakerBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func makeCircuitBreakerError(statusCode int, err error) []byte {
+	var errorStatus = &PassthroughErrorStatus{
+		StatusCode: statusCode,
+		Status:     err.Error(),
+	}
+	errorCode := "circuitOpen"
+	if err == errTooManyRequests {
+		errorCode = "tooManyRequests"
+	}
+	errorResponse, _ := json.Marshal(map[string]string{
+		"description": err.Error(),
+		"error_code":  errorCode,
+	})
+	passthroughError := &PassthroughError{}
+	passthroughError.Error = errorStatus
+	passthroughError.ErrorResponse = (*json.RawMessage)(&errorResponse)
+	res, e := json.Marshal(passthroughError)
+	if e != nil {
+		log.Errorf("makeCircuitBreakerError: could not marshal JSON: %+v", e)
+	}
+	return res
+}
+
+// doCircuitBreakerRequest makes the request via the endpoint's circuit breaker, failing fast if the endpoint is unavailable
+func (p *portalProxy) doCircuitBreakerRequest(cnsiRequest *interfaces.CNSIRequest, do func() (*http.Response, error)) (*http.Response, error) {
+	if p.CircuitBreakers == nil {
+		return do()
+	}
+
+	if err := p.CircuitBreakers.acquire(cnsiRequest.GUID); err != nil {
+		statusCode := http.StatusServiceUnavailable
+		if err == errTooManyRequests {
+			statusCode = http.StatusTooManyRequests
+		}
+		cnsiRequest.StatusCode = statusCode
+		cnsiRequest.Status = err.Error()
+		cnsiRequest.Response = makeCircuitBreakerError(statusCode, err)
+		cnsiRequest.Error = err
+		return nil, err
+	}
+
+	release := func() {
+		p.CircuitBreakers.release(cnsiRequest.GUID)
+	}
+
+	res, err := do()
+	p.CircuitBreakers.record(cnsiRequest.GUID, !isEndpointFailure(res, err))
+	if err != nil || res.Body == nil {
+		release()
+		return res, err
+	}
+
+	res.Body = &circuitBreakerBody{ReadCloser: res.Body, release: release}
+	return res, nil
+}
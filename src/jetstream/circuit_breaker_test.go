@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCircuitBreakerConfig(t *testing.T) {
+	t.Parallel()
+
+	Convey("Circuit breakers should be disabled when not configured", t, func() {
+		So(newCircuitBreakers(0, 0, 0), ShouldBeNil)
+	})
+
+	Convey("Circuit breakers should use the default reset timeout", t, func() {
+		cb := newCircuitBreakers(3, 0, 0)
+		So(cb, ShouldNotBeNil)
+		So(cb.resetTimeout, ShouldEqual, defaultCircuitBreakerResetTimeout*time.Second)
+	})
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	t.Parallel()
+
+	Convey("Circuit breaker state transitions", t, func() {
+		cb := newCircuitBreakers(2, 30, 0)
+
+		Convey("should open after consecutive failures", func() {
+			So(cb.acquire(mockCFGUID), ShouldBeNil)
+			cb.record(mockCFGUID, false)
+			cb.release(mockCFGUID)
+			So(cb.acquire(mockCFGUID), ShouldBeNil)
+			cb.record(mockCFGUID, false)
+			cb.release(mockCFGUID)
+
+			So(cb.acquire(mockCFGUID), ShouldEqual, errCircuitOpen)
+			So(cb.Status()[mockCFGUID].State, ShouldEqual, circuitOpen)
+
+			Convey("should only allow a single probe once the reset timeout has passed", func() {
+				cb.breakers[mockCFGUID].OpenedAt = time.Now().Add(-time.Minute)
+				So(cb.acquire(mockCFGUID), ShouldBeNil)
+				So(cb.Status()[mockCFGUID].State, ShouldEqual, circuitHalfOpen)
+				So(cb.acquire(mockCFGUID), ShouldEqual, errCircuitOpen)
+
+				Convey("should close when the probe succeeds", func() {
+					cb.record(mockCFGUID, true)
+					cb.release(mockCFGUID)
+					So(cb.Status()[mockCFGUID].State, ShouldEqual, circuitClosed)
+					So(cb.acquire(mockCFGUID), ShouldBeNil)
+				})
+
+				Convey("should re-open when the probe fails", func() {
+					cb.record(mockCFGUID, false)
+					cb.release(mockCFGUID)
+					So(cb.acquire(mockCFGUID), ShouldEqual, errCircuitOpen)
+				})
+			})
+		})
+
+		Convey("should reset the failure count after a success", func() {
+			cb.record(mockCFGUID, false)
+			cb.record(mockCFGUID, true)
+			cb.record(mockCFGUID, false)
+			So(cb.acquire(mockCFGUID), ShouldBeNil)
+		})
+	})
+}
+
+func TestCircuitBreakerConcurrency(t *testing.T) {
+	t.Parallel()
+
+	Convey("Circuit breaker should limit concurrent requests", t, func() {
+		cb := newCircuitBreakers(0, 0, 2)
+		So(cb.acquire(mockCFGUID), ShouldBeNil)
+		So(cb.acquire(mockCFGUID), ShouldBeNil)
+		So(cb.acquire(mockCFGUID), ShouldEqual, errTooManyRequests)
+		So(cb.acquire(mockCEGUID), ShouldBeNil)
+		So(cb.Status()[mockCFGUID].InFlight, ShouldEqual, 2)
+
+		cb.release(mockCFGUID)
+		So(cb.acquire(mockCFGUID), ShouldBeNil)
+	})
+
+	Convey("Failures should be detected from errors and gateway status codes", t, func() {
+		So(isEndpointFailure(nil, errors.New("timeout")), ShouldBeTrue)
+		So(isEndpointFailure(&http.Response{StatusCode: http.StatusGatewayTimeout}, nil), ShouldBeTrue)
+		So(isEndpointFailure(&http.Response{StatusCode: http.StatusNotFound}, nil), ShouldBeFalse)
+	})
+}
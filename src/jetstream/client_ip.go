@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+// trustedProxies are the networks of the reverse proxies in front of Jetstream
+// Only requests from these are believed when they say who the client is via X-Forwarded-For or X-Real-IP
+type trustedProxies []*net.IPNet
+
+// newTrustedProxies parses a list of IP addresses and CIDR ranges, e.g. 10.0.0.0/8,192.168.1.10
+func newTrustedProxies(config []string) trustedProxies {
+	var proxies trustedProxies
+	for _, item := range config {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item = item + "/32"
+			} else {
+				item = item + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			log.Warnf("Ignoring invalid trusted proxy: %s", item)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+func (tp trustedProxies) contains(ip net.IP) bool {
+	for _, network := range tp {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client that made the request
+// Unlike echo's RealIP, forwarding headers are ignored unless the connection comes from a trusted proxy,
+// so that clients can not choose the address that they are throttled and audited against
+func (p *portalProxy) clientIP(c echo.Context) string {
+	remoteAddr := c.Request().RemoteAddr
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	remote := net.ParseIP(host)
+	if remote == nil || !p.TrustedProxies.contains(remote) {
+		return host
+	}
+
+	// Each proxy appends the address that it received the request from, so walk back past our own proxies
+	forwarded := strings.Split(c.Request().Header.Get(echo.HeaderXForwardedFor), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		ip := net.ParseIP(address)
+		if ip == nil {
+			break
+		}
+		if !p.TrustedProxies.contains(ip) {
+			return address
+		}
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(c.Request().Header.Get(echo.HeaderXRealIP))); realIP != nil {
+		return realIP.String()
+	}
+	return host
+}
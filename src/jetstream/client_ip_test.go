@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given a request with forwarding headers", t, func() {
+		req := setupMockReq("GET", "", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+		req.Header.Set("X-Real-IP", "203.0.113.8")
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		Convey("the headers should be ignored when no proxies are trusted", func() {
+			req.RemoteAddr = "198.51.100.1:4321"
+			So(pp.clientIP(ctx), ShouldEqual, "198.51.100.1")
+		})
+
+		Convey("the headers should be ignored when the request is not from a trusted proxy", func() {
+			pp.TrustedProxies = newTrustedProxies([]string{"10.0.0.0/8"})
+			req.RemoteAddr = "198.51.100.1:4321"
+			So(pp.clientIP(ctx), ShouldEqual, "198.51.100.1")
+		})
+
+		Convey("the first untrusted forwarded address should be used when the request is from a trusted proxy", func() {
+			pp.TrustedProxies = newTrustedProxies([]string{"10.0.0.0/8"})
+			req.RemoteAddr = "10.0.0.1:4321"
+			So(pp.clientIP(ctx), ShouldEqual, "203.0.113.7")
+		})
+
+		Convey("X-Real-IP should be used when every forwarded address is a trusted proxy", func() {
+			pp.TrustedProxies = newTrustedProxies([]string{"10.0.0.0/8", "203.0.113.7"})
+			req.RemoteAddr = "10.0.0.1:4321"
+			So(pp.clientIP(ctx), ShouldEqual, "203.0.113.8")
+		})
+	})
+
+	Convey("Invalid trusted proxies should be ignored", t, func() {
+		So(newTrustedProxies([]string{"10.0.0.0/8", "not-an-ip", " ", "::1"}), ShouldHaveLength, 2)
+	})
+}
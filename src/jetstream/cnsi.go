@@ -64,10 +64,13 @@ func (p *portalProxy) RegisterEndpoint(c echo.Context, fetchInfo interfaces.Info
 		cnsiClientSecret = p.GetConfig().CFClientSecret
 	}
 
+	setAuditDetails(c, fmt.Sprintf("name=%s api_endpoint=%s", cnsiName, apiEndpoint))
+
 	newCNSI, err := p.DoRegisterEndpoint(cnsiName, apiEndpoint, skipSSLValidation, cnsiClientId, cnsiClientSecret, ssoAllowed, subType, fetchInfo)
 	if err != nil {
 		return err
 	}
+	setAuditEndpoint(c, newCNSI.GUID)
 
 	c.JSON(http.StatusCreated, newCNSI)
 	return nil
@@ -106,18 +109,7 @@ func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, sk
 
 	newCNSI, _, err := fetchInfo(apiEndpoint, skipSSLValidation)
 	if err != nil {
-		if ok, detail := isSSLRelatedError(err); ok {
-			return interfaces.CNSIRecord{}, interfaces.NewHTTPShadowError(
-				http.StatusForbidden,
-				"SSL error - "+detail,
-				"There is a problem with the server Certificate - %s",
-				detail)
-		}
-		return interfaces.CNSIRecord{}, interfaces.NewHTTPShadowError(
-			http.StatusBadRequest,
-			"Failed to validate endpoint",
-			"Failed to validate endpoint: %v",
-			err)
+		return interfaces.CNSIRecord{}, makeEndpointInfoError(err)
 	}
 
 	h := sha1.New()
@@ -158,6 +150,13 @@ func (p *portalProxy) unregisterCluster(c echo.Context) error {
 			"Missing target endpoint",
 			"Need CNSI GUID passed as form param")
 	}
+	p.doUnregisterEndpoint(cnsiGUID)
+
+	return nil
+}
+
+// doUnregisterEndpoint removes an endpoint along with the tokens and favorites of its users
+func (p *portalProxy) doUnregisterEndpoint(cnsiGUID string) {
 	// Should check for errors?
 	p.unsetCNSIRecord(cnsiGUID)
 
@@ -165,13 +164,172 @@ func (p *portalProxy) unregisterCluster(c echo.Context) error {
 
 	ufe := userfavoritesendpoints.Constructor(p, cnsiGUID)
 	ufe.RemoveFavorites()
+}
 
-	return nil
+// updateEndpoint changes the details of a registered endpoint
+// Unlike unregistering and registering the endpoint again, tokens are kept if they can still be used and favorites are always kept
+func (p *portalProxy) updateEndpoint(c echo.Context) error {
+	cnsiGUID := c.Param("id")
+	log.WithField("cnsiGUID", cnsiGUID).Debug("updateEndpoint")
+
+	existing, err := p.GetCNSIRecord(cnsiGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested endpoint not registered",
+			"No Endpoint registered with GUID %s: %s", cnsiGUID, err)
+	}
+
+	form, err := c.FormParams()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid endpoint update",
+			"Unable to parse endpoint update: %v", err)
+	}
+
+	// Only change the values that are in the request
+	updated := existing
+	if values, ok := form["cnsi_name"]; ok {
+		updated.Name = strings.TrimSpace(values[0])
+		if len(updated.Name) == 0 {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Needs CNSI Name",
+				"CNSI Name can not be empty")
+		}
+	}
+	if values, ok := form["api_endpoint"]; ok {
+		apiEndpoint := strings.TrimRight(strings.TrimSpace(values[0]), "/")
+		apiEndpointURL, err := url.Parse(apiEndpoint)
+		if err != nil || len(apiEndpoint) == 0 {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Failed to get API Endpoint",
+				"Failed to get API Endpoint: %v", err)
+		}
+		updated.APIEndpoint = apiEndpointURL
+	}
+	if values, ok := form["skip_ssl_validation"]; ok {
+		if updated.SkipSSLValidation, err = strconv.ParseBool(values[0]); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Invalid value for skip_ssl_validation",
+				"Failed to parse skip_ssl_validation value: %v", err)
+		}
+	}
+	if values, ok := form["sso_allowed"]; ok {
+		if updated.SSOAllowed, err = strconv.ParseBool(values[0]); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Invalid value for sso_allowed",
+				"Failed to parse sso_allowed value: %v", err)
+		}
+	}
+	if values, ok := form["cnsi_client_id"]; ok {
+		updated.ClientId = values[0]
+	}
+	if values, ok := form["cnsi_client_secret"]; ok {
+		updated.ClientSecret = values[0]
+	}
+
+	urlChanged := updated.APIEndpoint.String() != existing.APIEndpoint.String()
+	if urlChanged {
+		if other, err := p.GetCNSIRecordByEndpoint(updated.APIEndpoint.String()); err == nil && other.GUID != cnsiGUID {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Can not register same endpoint multiple times",
+				"Can not register same endpoint multiple times",
+			)
+		}
+	}
+
+	// Validate the endpoint again if it has moved, or if its certificate now needs to be checked
+	if urlChanged || (existing.SkipSSLValidation && !updated.SkipSSLValidation) {
+		endpointPlugin, err := p.GetEndpointTypeSpec(existing.CNSIType)
+		if err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Endpoint type not supported",
+				"Unable to find plugin for endpoint type %s: %v", existing.CNSIType, err)
+		}
+
+		info, _, err := endpointPlugin.Info(updated.APIEndpoint.String(), updated.SkipSSLValidation)
+		if err != nil {
+			return makeEndpointInfoError(err)
+		}
+		updated.AuthorizationEndpoint = info.AuthorizationEndpoint
+		updated.TokenEndpoint = info.TokenEndpoint
+		updated.DopplerLoggingEndpoint = info.DopplerLoggingEndpoint
+	}
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	if err := cnsiRepo.Overwrite(cnsiGUID, updated, p.Config.EncryptionKeyInBytes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update endpoint",
+			"Unable to update endpoint: %v", err)
+	}
+
+	if !canKeepEndpointTokens(existing, updated) {
+		log.Infof("Endpoint %s has changed how users authenticate - users will need to connect again", cnsiGUID)
+		p.unsetCNSITokenRecords(cnsiGUID)
+	}
+
+	// Cached responses may have come from the old URL
+	if p.ProxyCache != nil {
+		p.ProxyCache.invalidate(cnsiGUID)
+	}
+
+	// Notify plugins if they support the notification interface
+	for _, plugin := range p.Plugins {
+		if notifier, ok := plugin.(interfaces.EndpointNotificationPlugin); ok {
+			notifier.OnEndpointNotification(interfaces.EndpointUpdateAction, &updated)
+		}
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+// canKeepEndpointTokens returns true if the tokens of users connected to the endpoint can still be used after the update
+func canKeepEndpointTokens(existing, updated interfaces.CNSIRecord) bool {
+	// Tokens were issued by the old auth server or to the old client
+	if existing.ClientId != updated.ClientId || existing.TokenEndpoint != updated.TokenEndpoint || existing.AuthorizationEndpoint != updated.AuthorizationEndpoint {
+		return false
+	}
+
+	// Tokens must never be sent to a new host, which may not be run by whoever issued them
+	// This includes the shared system tokens, which are removed along with the tokens of each user
+	return existing.APIEndpoint.Host == updated.APIEndpoint.Host
+}
+
+// makeEndpointInfoError returns the error to send when an endpoint can not be validated
+func makeEndpointInfoError(err error) error {
+	if ok, detail := isSSLRelatedError(err); ok {
+		return interfaces.NewHTTPShadowError(
+			http.StatusForbidden,
+			"SSL error - "+detail,
+			"There is a problem with the server Certificate - %s",
+			detail)
+	}
+	return interfaces.NewHTTPShadowError(
+		http.StatusBadRequest,
+		"Failed to validate endpoint",
+		"Failed to validate endpoint: %v",
+		err)
 }
 
 func (p *portalProxy) buildCNSIList(c echo.Context) ([]*interfaces.CNSIRecord, error) {
 	log.Debug("buildCNSIList")
-	return p.ListEndpoints()
+	userGUID, err := p.GetSessionStringValue(c, "user_id")
+	if err != nil {
+		return nil, err
+	}
+	return p.listVisibleEndpoints(userGUID)
 }
 
 func (p *portalProxy) ListEndpoints() ([]*interfaces.CNSIRecord, error) {
@@ -179,7 +337,7 @@ func (p *portalProxy) ListEndpoints() ([]*interfaces.CNSIRecord, error) {
 	var cnsiList []*interfaces.CNSIRecord
 	var err error
 
-	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return cnsiList, fmt.Errorf("listRegisteredCNSIs: %s", err)
 	}
@@ -225,7 +383,7 @@ func (p *portalProxy) listRegisteredCNSIs(c echo.Context) error {
 	}
 	userGUID := userGUIDIntf.(string)
 
-	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return fmt.Errorf("listRegisteredCNSIs: %s", err)
 	}
@@ -242,6 +400,23 @@ func (p *portalProxy) listRegisteredCNSIs(c echo.Context) error {
 		)
 	}
 
+	// Leave out endpoints that have since been restricted to other users
+	visibility, err := p.getUserEndpointVisibility(userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Failed to retrieve list of clusters",
+			"Failed to retrieve endpoint visibility: %v", err,
+		)
+	}
+	visibleClusters := make([]*interfaces.ConnectedEndpoint, 0, len(clusterList))
+	for _, cluster := range clusterList {
+		if visibility.isVisible(cluster.GUID) {
+			visibleClusters = append(visibleClusters, cluster)
+		}
+	}
+	clusterList = visibleClusters
+
 	jsonString, err = marshalClusterList(clusterList)
 	if err != nil {
 		return err
@@ -281,7 +456,7 @@ func marshalClusterList(clusterList []*interfaces.ConnectedEndpoint) ([]byte, er
 func (p *portalProxy) UpdateEndointMetadata(guid string, metadata string) error {
 	log.Debug("UpdateEndointMetadata")
 
-	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		log.Errorf(dbReferenceError, err)
 		return fmt.Errorf(dbReferenceError, err)
@@ -299,7 +474,7 @@ func (p *portalProxy) UpdateEndointMetadata(guid string, metadata string) error
 
 func (p *portalProxy) GetCNSIRecord(guid string) (interfaces.CNSIRecord, error) {
 	log.Debug("GetCNSIRecord")
-	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return interfaces.CNSIRecord{}, err
 	}
@@ -319,7 +494,7 @@ func (p *portalProxy) GetCNSIRecordByEndpoint(endpoint string) (interfaces.CNSIR
 	log.Debug("GetCNSIRecordByEndpoint")
 	var rec interfaces.CNSIRecord
 
-	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return rec, err
 	}
@@ -344,7 +519,7 @@ func (p *portalProxy) cnsiRecordExists(endpoint string) bool {
 
 func (p *portalProxy) setCNSIRecord(guid string, c interfaces.CNSIRecord) error {
 	log.Debug("setCNSIRecord")
-	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		log.Errorf(dbReferenceError, err)
 		return fmt.Errorf(dbReferenceError, err)
@@ -362,7 +537,7 @@ func (p *portalProxy) setCNSIRecord(guid string, c interfaces.CNSIRecord) error
 
 func (p *portalProxy) unsetCNSIRecord(guid string) error {
 	log.Debug("unsetCNSIRecord")
-	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		log.Errorf(dbReferenceError, err)
 		return fmt.Errorf(dbReferenceError, err)
@@ -379,6 +554,10 @@ func (p *portalProxy) unsetCNSIRecord(guid string) error {
 		return fmt.Errorf(msg, err)
 	}
 
+	if err := cnsiRepo.SetVisibility(guid, nil); err != nil {
+		log.Warnf("Unable to delete visibility of endpoint %s: %v", guid, err)
+	}
+
 	if lookupErr == nil {
 		// Notify plugins if they support the notification interface
 		for _, plugin := range p.Plugins {
@@ -393,7 +572,7 @@ func (p *portalProxy) unsetCNSIRecord(guid string) error {
 
 func (p *portalProxy) SaveEndpointToken(cnsiGUID string, userGUID string, tokenRecord interfaces.TokenRecord) error {
 	log.Debug("SaveEndpointToken")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return err
 	}
@@ -403,7 +582,7 @@ func (p *portalProxy) SaveEndpointToken(cnsiGUID string, userGUID string, tokenR
 
 func (p *portalProxy) DeleteEndpointToken(cnsiGUID string, userGUID string) error {
 	log.Debug("DeleteEndpointToken")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return err
 	}
@@ -413,7 +592,7 @@ func (p *portalProxy) DeleteEndpointToken(cnsiGUID string, userGUID string) erro
 
 func (p *portalProxy) GetCNSITokenRecord(cnsiGUID string, userGUID string) (interfaces.TokenRecord, bool) {
 	log.Debug("GetCNSITokenRecord")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return interfaces.TokenRecord{}, false
 	}
@@ -428,7 +607,7 @@ func (p *portalProxy) GetCNSITokenRecord(cnsiGUID string, userGUID string) (inte
 
 func (p *portalProxy) GetCNSITokenRecordWithDisconnected(cnsiGUID string, userGUID string) (interfaces.TokenRecord, bool) {
 	log.Debug("GetCNSITokenRecordWithDisconnected")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		return interfaces.TokenRecord{}, false
 	}
@@ -443,7 +622,7 @@ func (p *portalProxy) GetCNSITokenRecordWithDisconnected(cnsiGUID string, userGU
 
 func (p *portalProxy) ListEndpointsByUser(userGUID string) ([]*interfaces.ConnectedEndpoint, error) {
 	log.Debug("ListCEndpointsByUser")
-	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		log.Errorf(dbReferenceError, err)
 		return nil, fmt.Errorf(dbReferenceError, err)
@@ -461,7 +640,7 @@ func (p *portalProxy) ListEndpointsByUser(userGUID string) ([]*interfaces.Connec
 // Uopdate the Access Token, Refresh Token and Token Expiry for a token
 func (p *portalProxy) updateTokenAuth(userGUID string, t interfaces.TokenRecord) error {
 	log.Debug("updateTokenAuth")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		log.Errorf(dbReferenceError, err)
 		return fmt.Errorf(dbReferenceError, err)
@@ -479,7 +658,7 @@ func (p *portalProxy) updateTokenAuth(userGUID string, t interfaces.TokenRecord)
 
 func (p *portalProxy) setCNSITokenRecord(cnsiGUID string, userGUID string, t interfaces.TokenRecord) error {
 	log.Debug("setCNSITokenRecord")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		log.Errorf(dbReferenceError, err)
 		return fmt.Errorf(dbReferenceError, err)
@@ -497,7 +676,7 @@ func (p *portalProxy) setCNSITokenRecord(cnsiGUID string, userGUID string, t int
 
 func (p *portalProxy) unsetCNSITokenRecord(cnsiGUID string, userGUID string) error {
 	log.Debug("unsetCNSITokenRecord")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		msg := "Unable to establish a database reference: '%v'"
 		log.Errorf(msg, err)
@@ -516,7 +695,7 @@ func (p *portalProxy) unsetCNSITokenRecord(cnsiGUID string, userGUID string) err
 
 func (p *portalProxy) unsetCNSITokenRecords(cnsiGUID string) error {
 	log.Debug("unsetCNSITokenRecord")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
 	if err != nil {
 		msg := "Unable to establish a database reference: '%v'"
 		log.Errorf(msg, err)
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"net/url"
 	"testing"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
@@ -10,6 +11,8 @@ import (
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
+const updateCNSIs = `UPDATE cnsis SET name = (.+) WHERE guid = (.+)`
+
 func TestRegisterCFCluster(t *testing.T) {
 	t.Parallel()
 
@@ -254,3 +257,126 @@ func TestGetCFv2InfoWithInvalidEndpoint(t *testing.T) {
 		t.Error("getCFv2Info should not return a valid response when the endpoint is invalid.")
 	}
 }
+
+func TestUpdateEndpointName(t *testing.T) {
+	t.Parallel()
+
+	req := setupMockReq("PATCH", "", map[string]string{
+		"cnsi_name": "Renamed CF Cluster",
+	})
+
+	_, _, ctx, pp, db, mock := setupHTTPTest(req)
+	defer db.Close()
+	ctx.SetParamNames("id")
+	ctx.SetParamValues(mockCNSIGUID)
+
+	cnsiRow := sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data"}).
+		AddRow(mockCNSIGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockAuthEndpoint, mockTokenEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "")
+	mock.ExpectQuery(selectAnyFromCNSIs).
+		WithArgs(mockCNSIGUID).
+		WillReturnRows(cnsiRow)
+
+	// The tokens of connected users should be kept
+	mock.ExpectExec(updateCNSIs).
+		WithArgs("Renamed CF Cluster", mockAPIEndpoint, mockAuthEndpoint, mockTokenEndpoint, mockDopplerEndpoint, true, mockClientId, sqlmock.AnyArg(), true, "", "", mockCNSIGUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := pp.updateEndpoint(ctx); err != nil {
+		t.Errorf("Failed to update endpoint: %v", err)
+	}
+
+	if dberr := mock.ExpectationsWereMet(); dberr != nil {
+		t.Errorf("There were unfulfilled expectations: %s", dberr)
+	}
+}
+
+func TestUpdateEndpointClientRemovesTokens(t *testing.T) {
+	t.Parallel()
+
+	req := setupMockReq("PATCH", "", map[string]string{
+		"cnsi_client_id": "another-client",
+	})
+
+	_, _, ctx, pp, db, mock := setupHTTPTest(req)
+	defer db.Close()
+	ctx.SetParamNames("id")
+	ctx.SetParamValues(mockCNSIGUID)
+
+	cnsiRow := sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data"}).
+		AddRow(mockCNSIGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockAuthEndpoint, mockTokenEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "")
+	mock.ExpectQuery(selectAnyFromCNSIs).
+		WithArgs(mockCNSIGUID).
+		WillReturnRows(cnsiRow)
+
+	mock.ExpectExec(updateCNSIs).
+		WithArgs("Some fancy CF Cluster", mockAPIEndpoint, mockAuthEndpoint, mockTokenEndpoint, mockDopplerEndpoint, true, "another-client", sqlmock.AnyArg(), true, "", "", mockCNSIGUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Tokens issued to the old client can't be refreshed
+	mock.ExpectExec(`DELETE FROM tokens`).
+		WithArgs(mockCNSIGUID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := pp.updateEndpoint(ctx); err != nil {
+		t.Errorf("Failed to update endpoint: %v", err)
+	}
+
+	if dberr := mock.ExpectationsWereMet(); dberr != nil {
+		t.Errorf("There were unfulfilled expectations: %s", dberr)
+	}
+}
+
+func TestUpdateEndpointWithEmptyName(t *testing.T) {
+	t.Parallel()
+
+	req := setupMockReq("PATCH", "", map[string]string{
+		"cnsi_name": "",
+	})
+
+	_, _, ctx, pp, db, mock := setupHTTPTest(req)
+	defer db.Close()
+	ctx.SetParamNames("id")
+	ctx.SetParamValues(mockCNSIGUID)
+
+	cnsiRow := sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data"}).
+		AddRow(mockCNSIGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockAuthEndpoint, mockTokenEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "")
+	mock.ExpectQuery(selectAnyFromCNSIs).
+		WithArgs(mockCNSIGUID).
+		WillReturnRows(cnsiRow)
+
+	if err := pp.updateEndpoint(ctx); err == nil {
+		t.Error("Should not be able to remove the name of an endpoint")
+	}
+}
+
+func TestCanKeepEndpointTokens(t *testing.T) {
+	t.Parallel()
+
+	apiURL, _ := url.Parse("https://api.example.com")
+	movedURL, _ := url.Parse("https://api.example.org")
+	existing := interfaces.CNSIRecord{APIEndpoint: apiURL, TokenEndpoint: mockTokenEndpoint, AuthorizationEndpoint: mockAuthEndpoint, ClientId: mockClientId}
+
+	updated := existing
+	updated.Name = "Renamed"
+	if !canKeepEndpointTokens(existing, updated) {
+		t.Error("Tokens should be kept when the endpoint is renamed")
+	}
+
+	updated.APIEndpoint = movedURL
+	if canKeepEndpointTokens(existing, updated) {
+		t.Error("Tokens should not be sent to a new host, even if they were issued by the same auth server")
+	}
+
+	updated = existing
+	updated.TokenEndpoint = "https://uaa.example.org"
+	if canKeepEndpointTokens(existing, updated) {
+		t.Error("Tokens issued by another auth server should not be kept")
+	}
+
+	existing.TokenEndpoint, existing.AuthorizationEndpoint = "", ""
+	updated = existing
+	updated.APIEndpoint = movedURL
+	if canKeepEndpointTokens(existing, updated) {
+		t.Error("Credentials for the old host should not be sent to a new host")
+	}
+}
@@ -48,7 +48,7 @@ func CheckPasswordHash(password string, hash []byte) error {
 // http://engineering.pivotal.io/post/ByteA_versus_TEXT_in_PostgreSQL/
 // I chose option 1.
 
-// EncryptToken - Encrypt a token being
+// EncryptToken - Encrypt a token being stored, prefixed with the ID of the key
 func EncryptToken(key []byte, t string) ([]byte, error) {
 	log.Debug("encryptToken")
 	var plaintextToken = []byte(t)
@@ -59,12 +59,19 @@ func EncryptToken(key []byte, t string) ([]byte, error) {
 		return nil, fmt.Errorf(msg, err)
 	}
 
-	return ciphertextToken, nil
+	return addKeyVersion(key, ciphertextToken), nil
 }
 
-// DecryptToken - Decrypt a token
+// DecryptToken - Decrypt a token with the current key or, if the token was encrypted with a previous key, that key
 func DecryptToken(key, t []byte) (string, error) {
 	log.Debug("decryptToken")
+	key, t, err := decryptionKey(key, t)
+	if err != nil {
+		msg := "Unable to decrypt token: %v"
+		log.Printf(msg, err)
+		return "", fmt.Errorf(msg, err)
+	}
+
 	plaintextToken, err := Decrypt(key, t)
 	if err != nil {
 		msg := "Unable to decrypt token: %v"
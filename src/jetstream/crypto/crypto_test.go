@@ -104,3 +104,96 @@ func writeFakeEncryptionKey() error {
 
 	return nil
 }
+
+func TestPassphraseEncryptDecrypt(t *testing.T) {
+
+	Convey("Given a passphrase and some data that requires encryption", t, func() {
+
+		var (
+			mockText       = []byte(`abcdefghijklmnopqrstuvwxyz0123456789`)
+			mockPassphrase = "correct horse battery staple"
+		)
+
+		salt, ciphertext, err := EncryptWithPassphrase(mockPassphrase, mockText)
+		So(err, ShouldBeNil)
+
+		Convey("decrypting with the same passphrase should succeed", func() {
+			plaintext, err := DecryptWithPassphrase(mockPassphrase, salt, ciphertext)
+			So(err, ShouldBeNil)
+			So(plaintext, ShouldResemble, mockText)
+		})
+
+		Convey("decrypting with another passphrase should fail", func() {
+			_, err := DecryptWithPassphrase("wrong", salt, ciphertext)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("decrypting modified data should fail", func() {
+			ciphertext[len(ciphertext)-1] ^= 0xff
+			_, err := DecryptWithPassphrase(mockPassphrase, salt, ciphertext)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Encrypting without a passphrase should fail", t, func() {
+		_, _, err := EncryptWithPassphrase("", []byte("data"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+
+	Convey("Given tokens encrypted with a previous encryption key", t, func() {
+
+		var (
+			oldKey = make([]byte, 32)
+			newKey = make([]byte, 32)
+		)
+		newKey[0] = 1
+
+		legacy, err := Encrypt(oldKey, []byte("legacy-token"))
+		So(err, ShouldBeNil)
+		versioned, err := EncryptToken(oldKey, "versioned-token")
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			SetDecryptionKeys()
+		})
+
+		Convey("tokens record the key that encrypted them", func() {
+			So(IsEncryptedWithKey(oldKey, versioned), ShouldBeTrue)
+			So(IsEncryptedWithKey(newKey, versioned), ShouldBeFalse)
+			So(IsEncryptedWithKey(oldKey, legacy), ShouldBeFalse)
+		})
+
+		Convey("decrypting with the new key should fail if the previous key is not registered", func() {
+			_, err := DecryptToken(newKey, versioned)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("decrypting with the new key should succeed once the previous key is registered", func() {
+			SetDecryptionKeys(oldKey)
+
+			token, err := DecryptToken(newKey, versioned)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "versioned-token")
+
+			token, err = DecryptToken(newKey, legacy)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "legacy-token")
+		})
+
+		Convey("re-encrypting should encrypt with the new key", func() {
+			SetDecryptionKeys(oldKey)
+
+			ciphertext, err := ReEncryptToken(newKey, versioned)
+			So(err, ShouldBeNil)
+			So(IsEncryptedWithKey(newKey, ciphertext), ShouldBeTrue)
+
+			SetDecryptionKeys()
+			token, err := DecryptToken(newKey, ciphertext)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "versioned-token")
+		})
+	})
+}
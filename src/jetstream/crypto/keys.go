@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Ciphertexts of tokens and secrets start with this prefix followed by the ID of the key that encrypted them
+// Ciphertexts written before keys were versioned have no prefix
+var keyVersionPrefix = []byte{0x00, 'S', 'K', '1'}
+
+const keyIDLength = 4
+
+var (
+	decryptionKeysLock sync.RWMutex
+	decryptionKeys     = make(map[string][]byte)
+	legacyKey          []byte
+)
+
+// KeyID returns the ID that identifies ciphertexts encrypted with the key
+func KeyID(key []byte) string {
+	return hex.EncodeToString(keyID(key))
+}
+
+func keyID(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:keyIDLength]
+}
+
+// SetDecryptionKeys registers the previous encryption keys, oldest first
+// These are no longer used to encrypt, but ciphertexts encrypted with them can still be decrypted.
+// Ciphertexts written before keys were versioned are decrypted with the oldest key.
+func SetDecryptionKeys(keys ...[]byte) {
+	decryptionKeysLock.Lock()
+	defer decryptionKeysLock.Unlock()
+
+	decryptionKeys = make(map[string][]byte)
+	legacyKey = nil
+	for _, key := range keys {
+		decryptionKeys[KeyID(key)] = key
+	}
+	if len(keys) > 0 {
+		legacyKey = keys[0]
+	}
+}
+
+// IsEncryptedWithKey returns true if the ciphertext was encrypted with the key
+func IsEncryptedWithKey(key, ciphertext []byte) bool {
+	id, _, ok := splitKeyVersion(ciphertext)
+	return ok && bytes.Equal(id, keyID(key))
+}
+
+// ReEncryptToken decrypts a token with whichever key encrypted it and encrypts it again with the key
+// Unlike DecryptToken, the ciphertext is left unchanged
+func ReEncryptToken(key, ciphertext []byte) ([]byte, error) {
+	plaintext, err := DecryptToken(key, append([]byte(nil), ciphertext...))
+	if err != nil {
+		return nil, err
+	}
+	return EncryptToken(key, plaintext)
+}
+
+// addKeyVersion prefixes the ciphertext with the ID of the key that encrypted it
+func addKeyVersion(key, ciphertext []byte) []byte {
+	versioned := make([]byte, 0, len(keyVersionPrefix)+keyIDLength+len(ciphertext))
+	versioned = append(versioned, keyVersionPrefix...)
+	versioned = append(versioned, keyID(key)...)
+	return append(versioned, ciphertext...)
+}
+
+// decryptionKey returns the key that encrypted the ciphertext, given the current key, and the ciphertext without its key ID
+func decryptionKey(key, ciphertext []byte) ([]byte, []byte, error) {
+	id, unversioned, ok := splitKeyVersion(ciphertext)
+	if !ok {
+		decryptionKeysLock.RLock()
+		defer decryptionKeysLock.RUnlock()
+		if legacyKey != nil {
+			return legacyKey, ciphertext, nil
+		}
+		return key, ciphertext, nil
+	}
+
+	if bytes.Equal(id, keyID(key)) {
+		return key, unversioned, nil
+	}
+
+	decryptionKeysLock.RLock()
+	defer decryptionKeysLock.RUnlock()
+	if previous, ok := decryptionKeys[hex.EncodeToString(id)]; ok {
+		return previous, unversioned, nil
+	}
+	return nil, nil, fmt.Errorf("encrypted with unknown key %s", hex.EncodeToString(id))
+}
+
+func splitKeyVersion(ciphertext []byte) ([]byte, []byte, bool) {
+	if len(ciphertext) < len(keyVersionPrefix)+keyIDLength || !bytes.HasPrefix(ciphertext, keyVersionPrefix) {
+		return nil, nil, false
+	}
+	rest := ciphertext[len(keyVersionPrefix):]
+	return rest[:keyIDLength], rest[keyIDLength:], true
+}
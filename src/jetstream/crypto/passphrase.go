@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Parameters used to derive an encryption key from a passphrase
+const (
+	passphraseSaltLength = 16
+	passphraseKeyLength  = 32
+	scryptN              = 32768
+	scryptR              = 8
+	scryptP              = 1
+)
+
+// EncryptWithPassphrase encrypts and authenticates the plaintext with a key derived from the passphrase
+// The returned salt is needed to decrypt the ciphertext
+func EncryptWithPassphrase(passphrase string, plaintext []byte) (salt []byte, ciphertext []byte, err error) {
+	if len(passphrase) == 0 {
+		return nil, nil, errors.New("A passphrase is required")
+	}
+
+	if salt, err = GenerateRandomBytes(passphraseSaltLength); err != nil {
+		return nil, nil, err
+	}
+
+	gcm, err := newPassphraseCipher(passphrase, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return salt, gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// DecryptWithPassphrase decrypts ciphertext from EncryptWithPassphrase, failing if the passphrase is wrong or the ciphertext has been modified
+func DecryptWithPassphrase(passphrase string, salt []byte, ciphertext []byte) ([]byte, error) {
+	gcm, err := newPassphraseCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce := ciphertext[:gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("Unable to decrypt - the passphrase is incorrect or the data has been modified")
+	}
+	return plaintext, nil
+}
+
+func newPassphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, passphraseKeyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
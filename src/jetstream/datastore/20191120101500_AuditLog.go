@@ -0,0 +1,44 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191120101500, "AuditLog", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		createAuditLogTable := "CREATE TABLE IF NOT EXISTS audit_log ("
+		createAuditLogTable += "guid                      VARCHAR(36)   NOT NULL,"
+		createAuditLogTable += "event_time                TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,"
+		createAuditLogTable += "user_guid                 VARCHAR(36),"
+		createAuditLogTable += "user_name                 VARCHAR(255),"
+		createAuditLogTable += "action                    VARCHAR(64)   NOT NULL,"
+		createAuditLogTable += "endpoint_guid             VARCHAR(255),"
+		createAuditLogTable += "remote_ip                 VARCHAR(64),"
+		createAuditLogTable += "result                    VARCHAR(16)   NOT NULL,"
+		createAuditLogTable += "status_code               INTEGER,"
+		createAuditLogTable += "details                   TEXT,"
+		createAuditLogTable += "PRIMARY KEY (guid) );"
+
+		_, err := txn.Exec(createAuditLogTable)
+		if err != nil {
+			return err
+		}
+
+		createIndex := "CREATE INDEX audit_log_event_time ON audit_log (event_time);"
+		_, err = txn.Exec(createIndex)
+		if err != nil {
+			return err
+		}
+
+		createIndex = "CREATE INDEX audit_log_user_guid ON audit_log (user_guid);"
+		_, err = txn.Exec(createIndex)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,32 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191125120000, "HelmCharts", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		createHelmChartsTable := "CREATE TABLE IF NOT EXISTS helm_charts ("
+		createHelmChartsTable += "endpoint_guid             VARCHAR(255)  NOT NULL,"
+		createHelmChartsTable += "name                      VARCHAR(255)  NOT NULL,"
+		createHelmChartsTable += "version                   VARCHAR(255)  NOT NULL,"
+		createHelmChartsTable += "app_version               VARCHAR(255),"
+		createHelmChartsTable += "description               TEXT,"
+		createHelmChartsTable += "icon                      TEXT,"
+		createHelmChartsTable += "created                   VARCHAR(64),"
+		createHelmChartsTable += "digest                    VARCHAR(255),"
+		createHelmChartsTable += "urls                      TEXT,"
+		createHelmChartsTable += "last_synced               TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,"
+		createHelmChartsTable += "PRIMARY KEY (endpoint_guid, name, version) );"
+
+		_, err := txn.Exec(createHelmChartsTable)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
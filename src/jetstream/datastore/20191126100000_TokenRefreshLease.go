@@ -0,0 +1,21 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191126100000, "TokenRefreshLease", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		// Time until which a Jetstream instance has claimed a token for a background refresh
+		addRefreshLease := "ALTER TABLE tokens ADD refresh_lease BIGINT"
+		_, err := txn.Exec(addRefreshLease)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
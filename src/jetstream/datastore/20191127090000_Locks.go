@@ -0,0 +1,25 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191127090000, "Locks", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		createLocksTable := "CREATE TABLE IF NOT EXISTS locks ("
+		createLocksTable += "name                      VARCHAR(255)  NOT NULL,"
+		createLocksTable += "holder                    VARCHAR(255)  NOT NULL,"
+		createLocksTable += "expires                   BIGINT        NOT NULL,"
+		createLocksTable += "PRIMARY KEY (name) );"
+
+		_, err := txn.Exec(createLocksTable)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,25 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191128100000, "EndpointVisibility", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		createVisibilityTable := "CREATE TABLE IF NOT EXISTS endpoint_visibility ("
+		createVisibilityTable += "endpoint_guid             VARCHAR(255)  NOT NULL,"
+		createVisibilityTable += "principal_type            VARCHAR(16)   NOT NULL,"
+		createVisibilityTable += "principal                 VARCHAR(255)  NOT NULL,"
+		createVisibilityTable += "PRIMARY KEY (endpoint_guid, principal_type, principal) );"
+
+		_, err := txn.Exec(createVisibilityTable)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
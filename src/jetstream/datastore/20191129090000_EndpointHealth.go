@@ -0,0 +1,33 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191129090000, "EndpointHealth", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		createEndpointHealthTable := "CREATE TABLE IF NOT EXISTS endpoint_health ("
+		createEndpointHealthTable += "endpoint_guid             VARCHAR(255)  NOT NULL,"
+		createEndpointHealthTable += "checked                   TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,"
+		createEndpointHealthTable += "healthy                   BOOLEAN       NOT NULL,"
+		createEndpointHealthTable += "latency_ms                BIGINT        NOT NULL,"
+		createEndpointHealthTable += "error                     TEXT,"
+		createEndpointHealthTable += "PRIMARY KEY (endpoint_guid, checked) );"
+
+		_, err := txn.Exec(createEndpointHealthTable)
+		if err != nil {
+			return err
+		}
+
+		createIndex := "CREATE INDEX endpoint_health_checked ON endpoint_health (checked);"
+		_, err = txn.Exec(createIndex)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
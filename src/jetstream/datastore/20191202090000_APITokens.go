@@ -0,0 +1,42 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191202090000, "APITokens", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		createAPITokensTable := "CREATE TABLE IF NOT EXISTS api_tokens ("
+		createAPITokensTable += "guid                      VARCHAR(36)   NOT NULL,"
+		createAPITokensTable += "user_guid                 VARCHAR(36)   NOT NULL,"
+		createAPITokensTable += "name                      VARCHAR(255)  NOT NULL,"
+		createAPITokensTable += "token_hash                VARCHAR(64)   NOT NULL,"
+		createAPITokensTable += "scopes                    TEXT          NOT NULL,"
+		createAPITokensTable += "created                   TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,"
+		createAPITokensTable += "expires                   TIMESTAMP     NOT NULL,"
+		createAPITokensTable += "last_used                 TIMESTAMP     NULL,"
+		createAPITokensTable += "PRIMARY KEY (guid) );"
+
+		_, err := txn.Exec(createAPITokensTable)
+		if err != nil {
+			return err
+		}
+
+		createIndex := "CREATE UNIQUE INDEX api_tokens_token_hash ON api_tokens (token_hash);"
+		_, err = txn.Exec(createIndex)
+		if err != nil {
+			return err
+		}
+
+		createIndex = "CREATE INDEX api_tokens_user_guid ON api_tokens (user_guid);"
+		_, err = txn.Exec(createIndex)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
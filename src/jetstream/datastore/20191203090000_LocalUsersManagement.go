@@ -0,0 +1,42 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191203090000, "LocalUsersManagement", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		addDisabledColumn := "ALTER TABLE local_users ADD disabled BOOLEAN NOT NULL DEFAULT FALSE;"
+		_, err := txn.Exec(addDisabledColumn)
+		if err != nil {
+			return err
+		}
+
+		addPasswordChangeColumn := "ALTER TABLE local_users ADD password_change_required BOOLEAN NOT NULL DEFAULT FALSE;"
+		_, err = txn.Exec(addPasswordChangeColumn)
+		if err != nil {
+			return err
+		}
+
+		// Users can have several scopes, so make room for them - SQLite does not enforce the length of columns
+		var widenScopeColumn string
+		if strings.Contains(conf.Driver.Name, "postgres") {
+			widenScopeColumn = "ALTER TABLE local_users ALTER COLUMN user_scope TYPE VARCHAR(255);"
+		} else if strings.Contains(conf.Driver.Name, "mysql") {
+			widenScopeColumn = "ALTER TABLE local_users MODIFY user_scope VARCHAR(255);"
+		}
+
+		if len(widenScopeColumn) > 0 {
+			_, err = txn.Exec(widenScopeColumn)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,48 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191204090000, "LocalUsersPasswordPolicy", func(txn *sql.Tx, conf *goose.DBConf) error {
+		binaryDataType := "BYTEA"
+		if strings.Contains(conf.Driver.Name, "mysql") {
+			binaryDataType = "BLOB"
+		}
+
+		addFailedLoginsColumn := "ALTER TABLE local_users ADD failed_login_attempts INTEGER NOT NULL DEFAULT 0;"
+		_, err := txn.Exec(addFailedLoginsColumn)
+		if err != nil {
+			return err
+		}
+
+		addLockedUntilColumn := "ALTER TABLE local_users ADD locked_until TIMESTAMP NULL;"
+		_, err = txn.Exec(addLockedUntilColumn)
+		if err != nil {
+			return err
+		}
+
+		// Previous password hashes of local users, so that passwords are not reused
+		createPasswordHistoryTable := "CREATE TABLE IF NOT EXISTS local_users_password_history ("
+		createPasswordHistoryTable += "user_guid                 VARCHAR(36)   NOT NULL,"
+		createPasswordHistoryTable += "password_hash             " + binaryDataType + " NOT NULL,"
+		createPasswordHistoryTable += "created                   TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP );"
+
+		_, err = txn.Exec(createPasswordHistoryTable)
+		if err != nil {
+			return err
+		}
+
+		createIndex := "CREATE INDEX local_users_password_history_user_guid ON local_users_password_history (user_guid);"
+		_, err = txn.Exec(createIndex)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
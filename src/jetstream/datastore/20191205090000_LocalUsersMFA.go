@@ -0,0 +1,44 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191205090000, "LocalUsersMFA", func(txn *sql.Tx, conf *goose.DBConf) error {
+		binaryDataType := "BYTEA"
+		if strings.Contains(conf.Driver.Name, "mysql") {
+			binaryDataType = "BLOB"
+		}
+
+		// TOTP secrets of local users - secrets are encrypted, and only used once enrolment has been verified
+		createMFATable := "CREATE TABLE IF NOT EXISTS local_users_mfa ("
+		createMFATable += "user_guid                 VARCHAR(36)   NOT NULL,"
+		createMFATable += "secret                    " + binaryDataType + " NOT NULL,"
+		createMFATable += "enabled                   BOOLEAN       NOT NULL DEFAULT FALSE,"
+		createMFATable += "last_used_step            BIGINT        NOT NULL DEFAULT 0,"
+		createMFATable += "created                   TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,"
+		createMFATable += "PRIMARY KEY (user_guid) );"
+
+		_, err := txn.Exec(createMFATable)
+		if err != nil {
+			return err
+		}
+
+		// Recovery codes are only stored as hashes and can each be used once
+		createRecoveryCodesTable := "CREATE TABLE IF NOT EXISTS local_users_recovery_codes ("
+		createRecoveryCodesTable += "user_guid                 VARCHAR(36)   NOT NULL,"
+		createRecoveryCodesTable += "code_hash                 VARCHAR(64)   NOT NULL,"
+		createRecoveryCodesTable += "PRIMARY KEY (user_guid, code_hash) );"
+
+		_, err = txn.Exec(createRecoveryCodesTable)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,37 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191206090000, "SessionUsers", func(txn *sql.Tx, conf *goose.DBConf) error {
+		// The Postgres session store keys sessions by a binary key, the other session stores by an integer ID
+		sessionIDType := "VARCHAR(128)"
+		if strings.Contains(conf.Driver.Name, "postgres") {
+			sessionIDType = "BYTEA"
+		}
+
+		// The user of each session, so that the sessions of a user can be found without decoding every session
+		createSessionUsersTable := "CREATE TABLE IF NOT EXISTS session_users ("
+		createSessionUsersTable += "session_id                " + sessionIDType + " NOT NULL,"
+		createSessionUsersTable += "user_guid                 VARCHAR(36)   NOT NULL,"
+		createSessionUsersTable += "PRIMARY KEY (session_id) );"
+
+		_, err := txn.Exec(createSessionUsersTable)
+		if err != nil {
+			return err
+		}
+
+		createIndex := "CREATE INDEX session_users_user_guid ON session_users (user_guid);"
+		_, err = txn.Exec(createIndex)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -255,6 +255,35 @@ func buildConnectionStringForMysql(dc DatabaseConfig) string {
 	return connStr
 }
 
+// Connection is implemented by both the database connection pool and transactions,
+// so that repositories can be used within a transaction
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// RunInTransaction runs the function in a new transaction, or in the transaction that the connection already is
+func RunInTransaction(conn Connection, fn func(txn Connection) error) error {
+	db, ok := conn.(*sql.DB)
+	if !ok {
+		return fn(conn)
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to start transaction: %v", err)
+	}
+	if err := fn(txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("Unable to commit transaction: %v", err)
+	}
+	return nil
+}
+
 // Ping - ping the database to ensure the connection/pool works.
 func Ping(db *sql.DB) error {
 	log.Debug("Ping database")
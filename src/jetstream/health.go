@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/health"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	defaultEndpointHealthCheckInterval = 5 * time.Minute
+	defaultEndpointHealthHistory       = 24 * time.Hour
+
+	// Maximum number of endpoints that are checked at the same time
+	endpointHealthCheckConcurrency = 5
+)
+
+// startEndpointHealthCheck periodically checks that the registered endpoints are responding
+func (p *portalProxy) startEndpointHealthCheck() {
+	if p.Config.EndpointHealthCheckIntervalInSecs < 0 {
+		log.Info("Endpoint health checks are disabled")
+		return
+	}
+
+	interval := defaultEndpointHealthCheckInterval
+	if p.Config.EndpointHealthCheckIntervalInSecs > 0 {
+		interval = time.Duration(p.Config.EndpointHealthCheckIntervalInSecs) * time.Second
+	}
+
+	log.Infof("The health of endpoints will be checked every %s", interval)
+
+	go func() {
+		for {
+			// Only one instance needs to check the endpoints
+			if p.IsLeader() {
+				p.checkEndpointsHealth()
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// getEndpointHealthHistory returns how long the results of health checks are kept
+func (p *portalProxy) getEndpointHealthHistory() time.Duration {
+	if p.Config.EndpointHealthHistoryInHours > 0 {
+		return time.Duration(p.Config.EndpointHealthHistoryInHours) * time.Hour
+	}
+	return defaultEndpointHealthHistory
+}
+
+// checkEndpointsHealth checks the health of all registered endpoints, records the results and
+// notifies plugins of endpoints that have gone down or come back up since the previous check
+func (p *portalProxy) checkEndpointsHealth() {
+	healthRepo, err := health.NewPgsqlHealthRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return
+	}
+
+	cnsiList, err := p.ListEndpoints()
+	if err != nil {
+		log.Errorf("Unable to list endpoints to check their health: %v", err)
+		return
+	}
+
+	// The previous results are read from the database, so that a new leader carries on where the old one stopped
+	previous, err := p.getEndpointsCurrentHealth()
+	if err != nil {
+		log.Warnf("Unable to retrieve previous endpoint health: %v", err)
+		previous = make(map[string]*interfaces.EndpointHealth)
+	}
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, endpointHealthCheckConcurrency)
+	for _, cnsi := range cnsiList {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(cnsi *interfaces.CNSIRecord) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+
+			check := p.checkEndpointHealth(*cnsi)
+			if err := healthRepo.Save(check); err != nil {
+				log.Errorf("Unable to save health of endpoint %s: %v", cnsi.Name, err)
+			}
+
+			wasHealthy := true
+			if last, ok := previous[cnsi.GUID]; ok {
+				wasHealthy = last.Healthy
+			}
+			switch {
+			case wasHealthy && !check.Healthy:
+				log.Warnf("Endpoint %s is down: %s", cnsi.Name, check.Error)
+				p.notifyEndpointHealth(interfaces.EndpointDownAction, cnsi)
+			case !wasHealthy && check.Healthy:
+				log.Infof("Endpoint %s is up again", cnsi.Name)
+				p.notifyEndpointHealth(interfaces.EndpointUpAction, cnsi)
+			}
+		}(cnsi)
+	}
+	wg.Wait()
+
+	if _, err := healthRepo.DeleteOlderThan(time.Now().UTC().Add(-p.getEndpointHealthHistory())); err != nil {
+		log.Errorf("Unable to remove expired endpoint health checks: %v", err)
+	}
+}
+
+// checkEndpointHealth checks the health of an endpoint with its plugin's HealthCheck, or by fetching its Info
+func (p *portalProxy) checkEndpointHealth(cnsi interfaces.CNSIRecord) interfaces.EndpointHealthCheck {
+	check := interfaces.EndpointHealthCheck{
+		EndpointGUID: cnsi.GUID,
+		Timestamp:    time.Now().UTC(),
+	}
+
+	err := p.healthCheckEndpoint(cnsi)
+	check.LatencyMs = int64(time.Since(check.Timestamp) / time.Millisecond)
+	check.Healthy = err == nil
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
+
+func (p *portalProxy) healthCheckEndpoint(cnsi interfaces.CNSIRecord) error {
+	endpointPlugin, err := p.GetEndpointTypeSpec(cnsi.CNSIType)
+	if err != nil {
+		return fmt.Errorf("Unable to find plugin for endpoint type %s: %v", cnsi.CNSIType, err)
+	}
+
+	if checker, ok := endpointPlugin.(interfaces.EndpointHealthCheckPlugin); ok {
+		return checker.HealthCheck(cnsi)
+	}
+
+	_, _, err = endpointPlugin.Info(cnsi.APIEndpoint.String(), cnsi.SkipSSLValidation)
+	return err
+}
+
+func (p *portalProxy) notifyEndpointHealth(action interfaces.EndpointAction, cnsi *interfaces.CNSIRecord) {
+	// Notify plugins if they support the notification interface
+	for _, plugin := range p.Plugins {
+		if notifier, ok := plugin.(interfaces.EndpointNotificationPlugin); ok {
+			notifier.OnEndpointNotification(action, cnsi)
+		}
+	}
+}
+
+// getEndpointsHealth returns the current health and recent history of every endpoint that has been checked
+func (p *portalProxy) getEndpointsHealth() (map[string]*interfaces.EndpointHealth, error) {
+	healthRepo, err := health.NewPgsqlHealthRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+
+	checks, err := healthRepo.ListSince(time.Now().UTC().Add(-p.getEndpointHealthHistory()))
+	if err != nil {
+		return nil, err
+	}
+
+	// Checks are listed most recent first, so the first check of each endpoint is its current health
+	endpoints := make(map[string]*interfaces.EndpointHealth)
+	for _, check := range checks {
+		endpointHealth, ok := endpoints[check.EndpointGUID]
+		if !ok {
+			endpointHealth = &interfaces.EndpointHealth{
+				Healthy:     check.Healthy,
+				LastChecked: check.Timestamp,
+				LatencyMs:   check.LatencyMs,
+				Error:       check.Error,
+				History:     make([]*interfaces.EndpointHealthCheck, 0),
+			}
+			endpoints[check.EndpointGUID] = endpointHealth
+		}
+		endpointHealth.History = append(endpointHealth.History, check)
+	}
+	return endpoints, nil
+}
+
+// getEndpointsCurrentHealth returns the health of each endpoint from its most recent check, without the history of checks
+func (p *portalProxy) getEndpointsCurrentHealth() (map[string]*interfaces.EndpointHealth, error) {
+	healthRepo, err := health.NewPgsqlHealthRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+
+	checks, err := healthRepo.ListLatest()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]*interfaces.EndpointHealth)
+	for _, check := range checks {
+		// Endpoints checked twice at the same time are listed twice - either check will do
+		if _, ok := endpoints[check.EndpointGUID]; !ok {
+			endpoints[check.EndpointGUID] = &interfaces.EndpointHealth{
+				Healthy:     check.Healthy,
+				LastChecked: check.Timestamp,
+				LatencyMs:   check.LatencyMs,
+				Error:       check.Error,
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// listEndpointsHealth returns the health of the endpoints that the user can see
+func (p *portalProxy) listEndpointsHealth(c echo.Context) error {
+	userGUID, err := p.GetSessionStringValue(c, "user_id")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not find session user_id")
+	}
+
+	cnsiList, err := p.listVisibleEndpoints(userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to retrieve list of endpoints",
+			"Unable to retrieve list of endpoints: %v", err)
+	}
+
+	endpointsHealth, err := p.getEndpointsHealth()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to retrieve endpoint health",
+			"Unable to retrieve endpoint health: %v", err)
+	}
+
+	visible := make(map[string]*interfaces.EndpointHealth)
+	for _, cnsi := range cnsiList {
+		if endpointHealth, ok := endpointsHealth[cnsi.GUID]; ok {
+			visible[cnsi.GUID] = endpointHealth
+		}
+	}
+
+	return c.JSON(http.StatusOK, visible)
+}
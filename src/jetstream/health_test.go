@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	selectAnyFromAllCNSIs          = `SELECT (.+) FROM cnsis`
+	selectAnyFromAllVisibility     = `SELECT (.+) FROM endpoint_visibility`
+	selectAnyFromEndpointHealth    = `SELECT (.+) FROM endpoint_health WHERE checked >= (.+)`
+	mockUnregisteredEndpointGUID   = "unregistered-endpoint-guid"
+	mockEndpointHealthCheckFailure = "connection refused"
+)
+
+var rowFieldsForEndpointHealth = []string{"endpoint_guid", "checked", "healthy", "latency_ms", "error"}
+
+func TestListEndpointsHealth(t *testing.T) {
+	t.Parallel()
+
+	Convey("Listing the health of endpoints", t, func() {
+		req := setupMockReq("GET", "", nil)
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.setSessionValues(ctx, map[string]interface{}{"user_id": mockUserGUID})
+
+		now := time.Now().UTC()
+		mock.ExpectQuery(selectAnyFromAllCNSIs).
+			WillReturnRows(expectCFAndCERows())
+		mock.ExpectQuery(selectAnyFromAllVisibility).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointVisibility))
+		mock.ExpectQuery(selectAnyFromEndpointHealth).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointHealth).
+				AddRow(mockCFGUID, now, false, 3000, mockEndpointHealthCheckFailure).
+				AddRow(mockCFGUID, now.Add(-5*time.Minute), true, 120, nil).
+				AddRow(mockUnregisteredEndpointGUID, now, true, 50, nil))
+
+		err := pp.listEndpointsHealth(ctx)
+		So(err, ShouldBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+		So(res.Code, ShouldEqual, http.StatusOK)
+
+		var endpointsHealth map[string]*interfaces.EndpointHealth
+		So(json.Unmarshal(res.Body.Bytes(), &endpointsHealth), ShouldBeNil)
+
+		Convey("should report the most recent check as the current health", func() {
+			cfHealth, ok := endpointsHealth[mockCFGUID]
+			So(ok, ShouldBeTrue)
+			So(cfHealth.Healthy, ShouldBeFalse)
+			So(cfHealth.LatencyMs, ShouldEqual, 3000)
+			So(cfHealth.Error, ShouldEqual, mockEndpointHealthCheckFailure)
+			So(cfHealth.History, ShouldHaveLength, 2)
+			So(cfHealth.History[1].Healthy, ShouldBeTrue)
+		})
+
+		Convey("should only include endpoints that have been checked and are registered", func() {
+			So(endpointsHealth, ShouldHaveLength, 1)
+			So(endpointsHealth, ShouldNotContainKey, mockCEGUID)
+			So(endpointsHealth, ShouldNotContainKey, mockUnregisteredEndpointGUID)
+		})
+	})
+}
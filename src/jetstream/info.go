@@ -8,6 +8,7 @@ import (
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
 )
 
 // Endpoint - This represents the CNSI endpoint
@@ -58,9 +59,20 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 
 	s.Configuration.TechPreview = p.Config.EnableTechPreview
 
+	// Report the permissions of the user, so that the UI can hide what they can't use
+	permissions := p.RoleMappings.getPermissions(uaaUser)
+	s.Roles = permissions.Roles
+	s.Permissions = permissions.Permissions
+
 	// Only add diagnostics information if the user is an admin
-	if uaaUser.Admin {
+	if permissions.Has(interfaces.PermissionConsoleAdmin) {
 		s.Diagnostics = p.Diagnostics
+		if p.ProxyCache != nil {
+			s.ProxyCache = p.ProxyCache.Stats()
+		}
+		if p.CircuitBreakers != nil {
+			s.CircuitBreakers = p.CircuitBreakers.Status()
+		}
 	}
 
 	// initialize the Endpoints maps
@@ -78,6 +90,13 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 
 	// get the CNSI Endpoints
 	cnsiList, _ := p.buildCNSIList(c)
+	endpointsHealth := make(map[string]*interfaces.EndpointHealth)
+	if len(cnsiList) > 0 {
+		// The history of health checks is left to the endpoint health route, since the info is polled
+		if endpointsHealth, err = p.getEndpointsCurrentHealth(); err != nil {
+			log.Warnf("Unable to retrieve endpoint health: %v", err)
+		}
+	}
 	for _, cnsi := range cnsiList {
 		// Extend the CNSI record
 		endpoint := &interfaces.EndpointDetail{
@@ -85,6 +104,7 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 			EndpointMetadata:  marshalEndpointMetadata(cnsi.Metadata),
 			Metadata:          make(map[string]string),
 			SystemSharedToken: false,
+			Health:            endpointsHealth[cnsi.GUID],
 		}
 		// try to get the user info for this cnsi for the user
 		cnsiUser, token, ok := p.GetCNSIUserAndToken(cnsi.GUID, userGUID)
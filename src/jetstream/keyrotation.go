@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/mfa"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/secrets"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+const (
+	// Only one instance re-encrypts the stored tokens and secrets at a time - the lock is renewed after each batch
+	encryptionKeyRotationLockName = "encryption-key-rotation"
+	encryptionKeyRotationLockTTL  = 2 * time.Minute
+
+	// Number of rows that are read and re-encrypted at a time
+	encryptionKeyRotationBatchSize = 100
+)
+
+// States of the re-encryption job
+const (
+	keyRotationIdle      = "idle"
+	keyRotationRunning   = "running"
+	keyRotationCompleted = "completed"
+	keyRotationFailed    = "failed"
+)
+
+// keyRotationProgress counts the rows of a table that have been re-encrypted
+type keyRotationProgress struct {
+	Total       int `json:"total"`
+	Processed   int `json:"processed"`
+	ReEncrypted int `json:"reEncrypted"`
+	Failed      int `json:"failed"`
+}
+
+// keyRotationStatus reports the progress of re-encrypting the stored tokens, client secrets and MFA secrets with the current encryption key
+type keyRotationStatus struct {
+	State     string              `json:"state"`
+	KeyID     string              `json:"keyId"`
+	Started   *time.Time          `json:"started,omitempty"`
+	Finished  *time.Time          `json:"finished,omitempty"`
+	Tokens    keyRotationProgress `json:"tokens"`
+	Endpoints keyRotationProgress `json:"endpoints"`
+	MFA       keyRotationProgress `json:"mfa"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// keyRotation holds the status of the last re-encryption job run by this instance
+type keyRotation struct {
+	sync.Mutex
+	status keyRotationStatus
+}
+
+func newKeyRotation() *keyRotation {
+	return &keyRotation{status: keyRotationStatus{State: keyRotationIdle}}
+}
+
+func (k *keyRotation) get() keyRotationStatus {
+	k.Lock()
+	defer k.Unlock()
+	return k.status
+}
+
+func (k *keyRotation) update(fn func(status *keyRotationStatus)) {
+	k.Lock()
+	defer k.Unlock()
+	fn(&k.status)
+}
+
+// getPreviousEncryptionKeys returns the keys in ENCRYPTION_KEY_PREVIOUS, oldest first
+func getPreviousEncryptionKeys(pc interfaces.PortalConfig) ([][]byte, error) {
+	keys := make([][]byte, 0)
+	for _, value := range strings.Split(pc.EncryptionKeyPrevious, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode previous encryption key: %v", err)
+		}
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return nil, fmt.Errorf("Previous encryption key %d has an invalid length of %d bytes", len(keys)+1, len(key))
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// startKeyRotation starts re-encrypting the stored tokens and client secrets with the current encryption key
+func (p *portalProxy) startKeyRotation(c echo.Context) error {
+	if p.KeyRotation.get().State == keyRotationRunning {
+		return interfaces.NewHTTPShadowError(
+			http.StatusConflict,
+			"Tokens and secrets are already being re-encrypted",
+			"Re-encryption is already running on this instance")
+	}
+
+	locked, err := p.AcquireLock(encryptionKeyRotationLockName, encryptionKeyRotationLockTTL)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to start re-encrypting tokens and secrets",
+			"Unable to acquire the encryption key rotation lock: %v", err)
+	}
+	if !locked {
+		return interfaces.NewHTTPShadowError(
+			http.StatusConflict,
+			"Tokens and secrets are already being re-encrypted",
+			"Re-encryption is already running on another instance")
+	}
+
+	started := time.Now().UTC()
+	p.KeyRotation.update(func(status *keyRotationStatus) {
+		*status = keyRotationStatus{
+			State:   keyRotationRunning,
+			KeyID:   crypto.KeyID(p.Config.EncryptionKeyInBytes),
+			Started: &started,
+		}
+	})
+
+	go func() {
+		defer p.ReleaseLock(encryptionKeyRotationLockName)
+		p.rotateEncryptionKey()
+	}()
+
+	return c.JSON(http.StatusAccepted, p.KeyRotation.get())
+}
+
+// getKeyRotationStatus returns the progress of the re-encryption job run by this instance
+func (p *portalProxy) getKeyRotationStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, p.KeyRotation.get())
+}
+
+// rotateEncryptionKey re-encrypts the tokens, client secrets and MFA secrets that were encrypted with a previous key
+func (p *portalProxy) rotateEncryptionKey() {
+	log.Infof("Re-encrypting tokens and secrets with encryption key %s", crypto.KeyID(p.Config.EncryptionKeyInBytes))
+
+	err := p.reEncryptTokens()
+	if err == nil {
+		err = p.reEncryptClientSecrets()
+	}
+	if err == nil {
+		err = p.reEncryptMFASecrets()
+	}
+
+	finished := time.Now().UTC()
+	p.KeyRotation.update(func(status *keyRotationStatus) {
+		status.Finished = &finished
+		status.State = keyRotationCompleted
+		if err != nil {
+			status.State = keyRotationFailed
+			status.Error = err.Error()
+		}
+	})
+
+	status := p.KeyRotation.get()
+	if err != nil {
+		log.Errorf("Unable to re-encrypt tokens and secrets: %v", err)
+	} else {
+		log.Infof("Re-encrypted %d token(s), %d client secret(s) and %d MFA secret(s) - %d failed", status.Tokens.ReEncrypted, status.Endpoints.ReEncrypted, status.MFA.ReEncrypted, status.Tokens.Failed+status.Endpoints.Failed+status.MFA.Failed)
+	}
+}
+
+// renewKeyRotationLock keeps hold of the lock between batches
+func (p *portalProxy) renewKeyRotationLock() error {
+	locked, err := p.AcquireLock(encryptionKeyRotationLockName, encryptionKeyRotationLockTTL)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("Lost the %s lock to another instance", encryptionKeyRotationLockName)
+	}
+	return nil
+}
+
+// needsReEncryption returns true if the ciphertext was not encrypted with the key
+// References to secrets in an external secret store are not encrypted
+func needsReEncryption(key, ciphertext []byte) bool {
+	return len(ciphertext) > 0 && !secrets.IsReference(ciphertext) && !crypto.IsEncryptedWithKey(key, ciphertext)
+}
+
+func (p *portalProxy) reEncryptTokens() error {
+	key := p.Config.EncryptionKeyInBytes
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	total, err := tokenRepo.CountTokens()
+	if err != nil {
+		return err
+	}
+	p.KeyRotation.update(func(status *keyRotationStatus) { status.Tokens.Total = total })
+
+	var after tokens.EncryptedToken
+	for {
+		if err := p.renewKeyRotationLock(); err != nil {
+			return err
+		}
+
+		batch, err := tokenRepo.ListEncryptedTokens(after, encryptionKeyRotationBatchSize)
+		if err != nil {
+			return err
+		}
+
+		var progress keyRotationProgress
+		for _, token := range batch {
+			progress.Processed++
+			if !needsReEncryption(key, token.AuthToken) && !needsReEncryption(key, token.RefreshToken) {
+				continue
+			}
+
+			previousAuthToken := token.AuthToken
+			updated := token
+			if updated.AuthToken, err = reEncrypt(key, token.AuthToken); err == nil {
+				updated.RefreshToken, err = reEncrypt(key, token.RefreshToken)
+			}
+			if err != nil {
+				log.Errorf("Unable to re-encrypt token %s of user %s: %v", token.TokenGUID, token.UserGUID, err)
+				progress.Failed++
+				continue
+			}
+
+			// A token that has changed since it was listed has already been encrypted with the current key
+			ok, err := tokenRepo.UpdateEncryptedToken(updated, previousAuthToken)
+			if err != nil {
+				return err
+			}
+			if ok {
+				progress.ReEncrypted++
+			}
+		}
+
+		p.KeyRotation.update(func(status *keyRotationStatus) {
+			status.Tokens.Processed += progress.Processed
+			status.Tokens.ReEncrypted += progress.ReEncrypted
+			status.Tokens.Failed += progress.Failed
+		})
+
+		if len(batch) < encryptionKeyRotationBatchSize {
+			return nil
+		}
+		last := batch[len(batch)-1]
+		after = tokens.EncryptedToken{UserGUID: last.UserGUID, CNSIGUID: last.CNSIGUID, TokenGUID: last.TokenGUID}
+	}
+}
+
+func (p *portalProxy) reEncryptClientSecrets() error {
+	key := p.Config.EncryptionKeyInBytes
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	total, err := cnsiRepo.CountClientSecrets()
+	if err != nil {
+		return err
+	}
+	p.KeyRotation.update(func(status *keyRotationStatus) { status.Endpoints.Total = total })
+
+	after := ""
+	for {
+		if err := p.renewKeyRotationLock(); err != nil {
+			return err
+		}
+
+		batch, err := cnsiRepo.ListClientSecrets(after, encryptionKeyRotationBatchSize)
+		if err != nil {
+			return err
+		}
+
+		var progress keyRotationProgress
+		for _, secret := range batch {
+			progress.Processed++
+			if !needsReEncryption(key, secret.ClientSecret) {
+				continue
+			}
+
+			clientSecret, err := reEncrypt(key, secret.ClientSecret)
+			if err != nil {
+				log.Errorf("Unable to re-encrypt client secret of endpoint %s: %v", secret.GUID, err)
+				progress.Failed++
+				continue
+			}
+
+			ok, err := cnsiRepo.UpdateClientSecret(secret.GUID, clientSecret, secret.ClientSecret)
+			if err != nil {
+				return err
+			}
+			if ok {
+				progress.ReEncrypted++
+			}
+		}
+
+		p.KeyRotation.update(func(status *keyRotationStatus) {
+			status.Endpoints.Processed += progress.Processed
+			status.Endpoints.ReEncrypted += progress.ReEncrypted
+			status.Endpoints.Failed += progress.Failed
+		})
+
+		if len(batch) < encryptionKeyRotationBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1].GUID
+	}
+}
+
+func (p *portalProxy) reEncryptMFASecrets() error {
+	key := p.Config.EncryptionKeyInBytes
+	mfaRepo, err := mfa.NewPgsqlMFARepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	total, err := mfaRepo.CountSecrets()
+	if err != nil {
+		return err
+	}
+	p.KeyRotation.update(func(status *keyRotationStatus) { status.MFA.Total = total })
+
+	after := ""
+	for {
+		if err := p.renewKeyRotationLock(); err != nil {
+			return err
+		}
+
+		batch, err := mfaRepo.ListSecrets(after, encryptionKeyRotationBatchSize)
+		if err != nil {
+			return err
+		}
+
+		var progress keyRotationProgress
+		for _, secret := range batch {
+			progress.Processed++
+			if !needsReEncryption(key, secret.Secret) {
+				continue
+			}
+
+			ciphertext, err := reEncrypt(key, secret.Secret)
+			if err != nil {
+				log.Errorf("Unable to re-encrypt MFA secret of user %s: %v", secret.UserGUID, err)
+				progress.Failed++
+				continue
+			}
+
+			ok, err := mfaRepo.UpdateSecret(secret.UserGUID, ciphertext, secret.Secret)
+			if err != nil {
+				return err
+			}
+			if ok {
+				progress.ReEncrypted++
+			}
+		}
+
+		p.KeyRotation.update(func(status *keyRotationStatus) {
+			status.MFA.Processed += progress.Processed
+			status.MFA.ReEncrypted += progress.ReEncrypted
+			status.MFA.Failed += progress.Failed
+		})
+
+		if len(batch) < encryptionKeyRotationBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1].UserGUID
+	}
+}
+
+// reEncrypt returns the ciphertext encrypted with the key, leaving ciphertexts that are empty or already encrypted with the key as they are
+func reEncrypt(key, ciphertext []byte) ([]byte, error) {
+	if !needsReEncryption(key, ciphertext) {
+		return ciphertext, nil
+	}
+	return crypto.ReEncryptToken(key, ciphertext)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	mockPreviousEncryptionKey = "0101010101010101010101010101010101010101010101010101010101010101"
+	updateLocks               = `UPDATE locks`
+	countAnyFromTokens        = `SELECT COUNT\(\*\) FROM tokens`
+	countAnyFromCNSIs         = `SELECT COUNT\(\*\) FROM cnsis`
+	countAnyFromMFA           = `SELECT COUNT\(\*\) FROM local_users_mfa`
+	selectEncryptedTokens     = `SELECT user_guid, cnsi_guid, token_guid, auth_token, refresh_token FROM tokens`
+	selectClientSecrets       = `SELECT guid, client_secret FROM cnsis`
+	selectMFASecrets          = `SELECT user_guid, secret FROM local_users_mfa`
+	updateEncryptedTokens     = `UPDATE tokens SET auth_token = (.+), refresh_token = (.+) WHERE`
+	updateClientSecrets       = `UPDATE cnsis SET client_secret = (.+) WHERE`
+	updateMFASecrets          = `UPDATE local_users_mfa SET secret = (.+) WHERE`
+)
+
+func TestGetPreviousEncryptionKeys(t *testing.T) {
+	t.Parallel()
+
+	Convey("Reading the previous encryption keys", t, func() {
+
+		Convey("should return the keys in order", func() {
+			keys, err := getPreviousEncryptionKeys(interfaces.PortalConfig{EncryptionKeyPrevious: mockPreviousEncryptionKey + ", " + hex.EncodeToString(mockEncryptionKey)})
+			So(err, ShouldBeNil)
+			So(keys, ShouldHaveLength, 2)
+			So(keys[0][0], ShouldEqual, 1)
+			So(keys[1], ShouldResemble, mockEncryptionKey)
+		})
+
+		Convey("should return no keys if none are configured", func() {
+			keys, err := getPreviousEncryptionKeys(interfaces.PortalConfig{})
+			So(err, ShouldBeNil)
+			So(keys, ShouldBeEmpty)
+		})
+
+		Convey("should fail for a key that is not hex", func() {
+			_, err := getPreviousEncryptionKeys(interfaces.PortalConfig{EncryptionKeyPrevious: "not-a-key"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail for a key of the wrong length", func() {
+			_, err := getPreviousEncryptionKeys(interfaces.PortalConfig{EncryptionKeyPrevious: "0101"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// The previous keys are shared by the crypto package, so this test does not run in parallel with tests that decrypt
+func TestRotateEncryptionKey(t *testing.T) {
+
+	Convey("Re-encrypting tokens and secrets with the current encryption key", t, func() {
+		req := setupMockReq("POST", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		previousKeys, _ := getPreviousEncryptionKeys(interfaces.PortalConfig{EncryptionKeyPrevious: mockPreviousEncryptionKey})
+		crypto.SetDecryptionKeys(previousKeys...)
+		defer crypto.SetDecryptionKeys()
+
+		oldToken, _ := crypto.EncryptToken(previousKeys[0], mockUAAToken)
+		currentToken, _ := crypto.EncryptToken(mockEncryptionKey, mockUAAToken)
+		oldSecret, _ := crypto.EncryptToken(previousKeys[0], mockClientSecret)
+		oldMFASecret, _ := crypto.EncryptToken(previousKeys[0], "JBSWY3DPEHPK3PXP")
+
+		mock.ExpectQuery(countAnyFromTokens).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2))
+		mock.ExpectExec(updateLocks).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(selectEncryptedTokens).
+			WillReturnRows(sqlmock.NewRows([]string{"user_guid", "cnsi_guid", "token_guid", "auth_token", "refresh_token"}).
+				AddRow(mockUserGUID, mockCFGUID, mockTokenGUID, oldToken, oldToken).
+				AddRow(mockUserGUID, mockCEGUID, mockTokenGUID, currentToken, nil))
+		mock.ExpectExec(updateEncryptedTokens).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), mockUserGUID, mockCFGUID, mockTokenGUID, oldToken).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(countAnyFromCNSIs).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+		mock.ExpectExec(updateLocks).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(selectClientSecrets).
+			WillReturnRows(sqlmock.NewRows([]string{"guid", "client_secret"}).
+				AddRow(mockCFGUID, oldSecret))
+		mock.ExpectExec(updateClientSecrets).
+			WithArgs(sqlmock.AnyArg(), mockCFGUID, oldSecret).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(countAnyFromMFA).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+		mock.ExpectExec(updateLocks).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(selectMFASecrets).
+			WillReturnRows(sqlmock.NewRows([]string{"user_guid", "secret"}).
+				AddRow(mockUserGUID, oldMFASecret))
+		mock.ExpectExec(updateMFASecrets).
+			WithArgs(sqlmock.AnyArg(), mockUserGUID, oldMFASecret).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		pp.rotateEncryptionKey()
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+		status := pp.KeyRotation.get()
+		So(status.State, ShouldEqual, keyRotationCompleted)
+		So(status.Tokens, ShouldResemble, keyRotationProgress{Total: 2, Processed: 2, ReEncrypted: 1})
+		So(status.Endpoints, ShouldResemble, keyRotationProgress{Total: 1, Processed: 1, ReEncrypted: 1})
+		So(status.MFA, ShouldResemble, keyRotationProgress{Total: 1, Processed: 1, ReEncrypted: 1})
+	})
+}
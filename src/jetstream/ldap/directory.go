@@ -0,0 +1,474 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	defaultUserFilter         = "(uid={username})"
+	defaultUserIDAttribute    = "entryUUID"
+	defaultUserNameAttribute  = "uid"
+	defaultEmailAttribute     = "mail"
+	defaultGroupFilter        = "(|(member={dn})(uniqueMember={dn}))"
+	defaultGroupNameAttribute = "cn"
+	defaultPoolSize           = 5
+	defaultCacheTTL           = 5 * time.Minute
+	defaultTimeout            = 10 * time.Second
+
+	// Active Directory keeps the ID of an entry as a binary GUID
+	adObjectGUIDAttribute = "objectGUID"
+
+	// User IDs are stored in columns of this size - longer IDs are mapped to a UUID
+	maxUserIDLength = 36
+)
+
+var (
+	// ErrInvalidCredentials is returned when the username or password of a user is not valid
+	ErrInvalidCredentials = errors.New("Invalid username/password credentials")
+
+	// ErrUserNotFound is returned when a user can not be found in the directory
+	ErrUserNotFound = errors.New("User not found")
+)
+
+// Config describes how to connect to the directory and where users and groups are kept
+// In filters, {username} is replaced with the name a user logs in with and {dn} with the DN of the user
+// A negative cache TTL turns off caching of users
+type Config struct {
+	URL                string
+	StartTLS           bool
+	SkipSSLValidation  bool
+	CACertFile         string
+	BindDN             string
+	BindPassword       string
+	UserBaseDN         string
+	UserFilter         string
+	UserIDAttribute    string
+	UserNameAttribute  string
+	EmailAttribute     string
+	GroupBaseDN        string
+	GroupFilter        string
+	GroupNameAttribute string
+	PoolSize           int
+	CacheTTL           time.Duration
+	Timeout            time.Duration
+
+	// Dial is used to connect to the directory instead of the URL if it is set, for tests
+	Dial func() (ldapv3.Client, error)
+}
+
+// User is a user found in the directory
+type User struct {
+	ID       string
+	DN       string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+type cachedUser struct {
+	user    *User
+	expires time.Time
+}
+
+// Directory authenticates and looks up users in an LDAP directory or Active Directory
+type Directory struct {
+	config Config
+	pool   *pool
+
+	lock  sync.Mutex
+	cache map[string]cachedUser
+	dns   map[string]string
+}
+
+// NewDirectory returns a directory that connects to the server at the URL, which must be an ldap:// or ldaps:// URL
+// Connections are made when they are first needed
+func NewDirectory(config Config) (*Directory, error) {
+	if len(config.URL) == 0 || len(config.UserBaseDN) == 0 {
+		return nil, errors.New("An LDAP URL and user base DN are needed for LDAP authentication")
+	}
+	serverURL, err := url.Parse(config.URL)
+	if err != nil || (serverURL.Scheme != "ldap" && serverURL.Scheme != "ldaps") {
+		return nil, fmt.Errorf("LDAP URL %s is not valid - it must start with ldap:// or ldaps://", config.URL)
+	}
+	if config.StartTLS && serverURL.Scheme == "ldaps" {
+		return nil, errors.New("StartTLS can not be used with an ldaps:// URL")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverURL.Hostname(),
+		InsecureSkipVerify: config.SkipSSLValidation,
+	}
+	if len(config.CACertFile) > 0 {
+		pem, err := ioutil.ReadFile(config.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read LDAP CA certificate: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", config.CACertFile)
+		}
+	}
+
+	setDefaults(&config)
+	if config.Dial == nil {
+		config.Dial = func() (ldapv3.Client, error) {
+			return dialServer(serverURL, tlsConfig, config.StartTLS, config.Timeout)
+		}
+	}
+	return &Directory{
+		config: config,
+		pool:   newPool(config.PoolSize, config.Timeout, config.Dial),
+		cache:  make(map[string]cachedUser),
+		dns:    make(map[string]string),
+	}, nil
+}
+
+func setDefaults(config *Config) {
+	if len(config.UserFilter) == 0 {
+		config.UserFilter = defaultUserFilter
+	}
+	if len(config.UserIDAttribute) == 0 {
+		config.UserIDAttribute = defaultUserIDAttribute
+	}
+	if len(config.UserNameAttribute) == 0 {
+		config.UserNameAttribute = defaultUserNameAttribute
+	}
+	if len(config.EmailAttribute) == 0 {
+		config.EmailAttribute = defaultEmailAttribute
+	}
+	if len(config.GroupFilter) == 0 {
+		config.GroupFilter = defaultGroupFilter
+	}
+	if len(config.GroupNameAttribute) == 0 {
+		config.GroupNameAttribute = defaultGroupNameAttribute
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = defaultPoolSize
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = defaultCacheTTL
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+}
+
+func dialServer(serverURL *url.URL, tlsConfig *tls.Config, startTLS bool, timeout time.Duration) (ldapv3.Client, error) {
+	host := serverURL.Host
+	if len(serverURL.Port()) == 0 {
+		if serverURL.Scheme == "ldaps" {
+			host = net.JoinHostPort(serverURL.Hostname(), ldapv3.DefaultLdapsPort)
+		} else {
+			host = net.JoinHostPort(serverURL.Hostname(), ldapv3.DefaultLdapPort)
+		}
+	}
+
+	// Dial ourselves rather than with ldap.DialURL, which only trusts the system CAs and uses a global timeout
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn *ldapv3.Conn
+	if serverURL.Scheme == "ldaps" {
+		tcpConn, err := tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		conn = ldapv3.NewConn(tcpConn, true)
+	} else {
+		tcpConn, err := dialer.Dial("tcp", host)
+		if err != nil {
+			return nil, err
+		}
+		conn = ldapv3.NewConn(tcpConn, false)
+	}
+	conn.Start()
+	conn.SetTimeout(timeout)
+
+	if startTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Unable to start TLS: %v", err)
+		}
+	}
+	return conn, nil
+}
+
+// Close closes the connections to the directory
+func (d *Directory) Close() {
+	d.pool.close()
+}
+
+// Authenticate finds the user with the username and checks their password by binding as them
+func (d *Directory) Authenticate(username string, password string) (*User, error) {
+	// An empty password would be an unauthenticated bind, which servers accept for any DN
+	if len(username) == 0 || len(password) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	var user *User
+	err := d.withConnection(func(conn ldapv3.Client) error {
+		filter := strings.Replace(d.config.UserFilter, "{username}", ldapv3.EscapeFilter(username), -1)
+		entry, err := d.searchUser(conn, d.config.UserBaseDN, ldapv3.ScopeWholeSubtree, filter)
+		if err == ErrUserNotFound {
+			return ErrInvalidCredentials
+		} else if err != nil {
+			return err
+		}
+
+		if err := conn.Bind(entry.DN, password); err != nil {
+			if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultInvalidCredentials) {
+				return ErrInvalidCredentials
+			}
+			return err
+		}
+
+		// Look up the groups as the service account, which may see more than the user
+		if err := d.bind(conn); err != nil {
+			return err
+		}
+		user, err = d.newUser(conn, entry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.cacheUser(user)
+	return user, nil
+}
+
+// FindUser returns the user with the ID - users are cached, so changes in the directory are seen once they expire
+func (d *Directory) FindUser(id string) (*User, error) {
+	d.lock.Lock()
+	cached, ok := d.cache[id]
+	dn := d.dns[id]
+	d.lock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.user, nil
+	}
+
+	var user *User
+	err := d.withConnection(func(conn ldapv3.Client) error {
+		anyUser := strings.Replace(d.config.UserFilter, "{username}", "*", -1)
+		var entry *ldapv3.Entry
+		var err error
+		if len(dn) > 0 {
+			// Read users we've seen before by their DN, as IDs that were mapped to a UUID can't be searched for
+			entry, err = d.searchUser(conn, dn, ldapv3.ScopeBaseObject, anyUser)
+			if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultNoSuchObject) {
+				err = ErrUserNotFound
+			}
+		} else {
+			filter := fmt.Sprintf("(&%s(%s=%s))", anyUser, d.config.UserIDAttribute, d.idFilterValue(id))
+			entry, err = d.searchUser(conn, d.config.UserBaseDN, ldapv3.ScopeWholeSubtree, filter)
+		}
+		if err != nil {
+			return err
+		}
+		user, err = d.newUser(conn, entry)
+		return err
+	})
+	if err != nil {
+		if err == ErrUserNotFound {
+			d.lock.Lock()
+			delete(d.cache, id)
+			delete(d.dns, id)
+			d.lock.Unlock()
+		}
+		return nil, err
+	}
+
+	// The entry at a DN may have been replaced by a different user
+	if user.ID != id {
+		return nil, ErrUserNotFound
+	}
+
+	d.cacheUser(user)
+	return user, nil
+}
+
+func (d *Directory) cacheUser(user *User) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Drop expired entries, so the cache only holds users that have been active recently
+	now := time.Now()
+	for id, cached := range d.cache {
+		if now.After(cached.expires) {
+			delete(d.cache, id)
+		}
+	}
+	d.cache[user.ID] = cachedUser{user: user, expires: now.Add(d.config.CacheTTL)}
+	d.dns[user.ID] = user.DN
+}
+
+// withConnection binds a pooled connection as the service account and runs the function with it
+func (d *Directory) withConnection(fn func(conn ldapv3.Client) error) error {
+	conn, err := d.pool.get()
+	if err != nil {
+		return fmt.Errorf("Unable to connect to LDAP server: %v", err)
+	}
+
+	err = d.bind(conn)
+	if err == nil {
+		err = fn(conn)
+	}
+
+	// Connections that failed other than with an LDAP result are not reused
+	broken := err != nil && err != ErrInvalidCredentials && err != ErrUserNotFound && !isLDAPResult(err)
+	d.pool.put(conn, broken)
+	return err
+}
+
+func isLDAPResult(err error) bool {
+	if ldapErr, ok := err.(*ldapv3.Error); ok {
+		return ldapErr.ResultCode < ldapv3.ErrorNetwork
+	}
+	return false
+}
+
+func (d *Directory) bind(conn ldapv3.Client) error {
+	if len(d.config.BindDN) == 0 {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(d.config.BindDN, d.config.BindPassword)
+}
+
+// searchUser returns the single user that matches the filter
+func (d *Directory) searchUser(conn ldapv3.Client, baseDN string, scope int, filter string) (*ldapv3.Entry, error) {
+	attributes := []string{d.config.UserIDAttribute, d.config.UserNameAttribute, d.config.EmailAttribute}
+	if len(d.config.GroupBaseDN) == 0 {
+		attributes = append(attributes, "memberOf")
+	}
+
+	result, err := conn.Search(ldapv3.NewSearchRequest(baseDN, scope, ldapv3.NeverDerefAliases,
+		2, int(d.config.Timeout.Seconds()), false, filter, attributes, nil))
+	if err != nil {
+		if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultSizeLimitExceeded) {
+			return nil, errors.New("More than one user matches the user filter")
+		}
+		return nil, err
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, errors.New("More than one user matches the user filter")
+	}
+}
+
+func (d *Directory) newUser(conn ldapv3.Client, entry *ldapv3.Entry) (*User, error) {
+	id, err := d.userID(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := d.groups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{
+		ID:       id,
+		DN:       entry.DN,
+		Username: entry.GetAttributeValue(d.config.UserNameAttribute),
+		Email:    entry.GetAttributeValue(d.config.EmailAttribute),
+		Groups:   groups,
+	}, nil
+}
+
+// groups returns the names of the groups of the user, found by searching for groups if a group base DN is
+// configured and from the memberOf attribute of the user otherwise
+func (d *Directory) groups(conn ldapv3.Client, entry *ldapv3.Entry) ([]string, error) {
+	groups := make([]string, 0)
+	if len(d.config.GroupBaseDN) == 0 {
+		for _, groupDN := range entry.GetAttributeValues("memberOf") {
+			if dn, err := ldapv3.ParseDN(groupDN); err == nil && len(dn.RDNs) > 0 && len(dn.RDNs[0].Attributes) > 0 {
+				groups = append(groups, dn.RDNs[0].Attributes[0].Value)
+			}
+		}
+		return groups, nil
+	}
+
+	filter := strings.Replace(d.config.GroupFilter, "{dn}", ldapv3.EscapeFilter(entry.DN), -1)
+	result, err := conn.Search(ldapv3.NewSearchRequest(d.config.GroupBaseDN, ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+		0, int(d.config.Timeout.Seconds()), false, filter, []string{d.config.GroupNameAttribute}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find groups of user: %v", err)
+	}
+	for _, group := range result.Entries {
+		if name := group.GetAttributeValue(d.config.GroupNameAttribute); len(name) > 0 {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+// userID returns the ID of the user in Stratos
+// Active Directory GUIDs are formatted as UUIDs, and IDs that are too long to store are mapped to a UUID
+func (d *Directory) userID(entry *ldapv3.Entry) (string, error) {
+	if strings.EqualFold(d.config.UserIDAttribute, adObjectGUIDAttribute) {
+		raw := entry.GetRawAttributeValue(d.config.UserIDAttribute)
+		if len(raw) != 16 {
+			return "", fmt.Errorf("User %s has no valid %s", entry.DN, d.config.UserIDAttribute)
+		}
+		return formatADGUID(raw), nil
+	}
+
+	id := entry.GetAttributeValue(d.config.UserIDAttribute)
+	if len(id) == 0 {
+		return "", fmt.Errorf("User %s has no %s", entry.DN, d.config.UserIDAttribute)
+	}
+	if len(id) > maxUserIDLength {
+		return uuid.NewV5(uuid.NamespaceURL, d.config.URL+"#"+id).String(), nil
+	}
+	return id, nil
+}
+
+// idFilterValue returns the ID of a user as it must appear in a search filter
+func (d *Directory) idFilterValue(id string) string {
+	if strings.EqualFold(d.config.UserIDAttribute, adObjectGUIDAttribute) {
+		if guid, err := uuid.FromString(id); err == nil {
+			var escaped strings.Builder
+			for _, b := range parseADGUID(guid) {
+				fmt.Fprintf(&escaped, "\\%02x", b)
+			}
+			return escaped.String()
+		}
+	}
+	return ldapv3.EscapeFilter(id)
+}
+
+// Active Directory GUIDs keep the first three fields of the UUID in little-endian byte order
+var adGUIDByteOrder = []int{3, 2, 1, 0, 5, 4, 7, 6, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func formatADGUID(raw []byte) string {
+	ordered := make([]byte, 16)
+	for i, j := range adGUIDByteOrder {
+		ordered[i] = raw[j]
+	}
+	s := hex.EncodeToString(ordered)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", s[0:8], s[8:12], s[12:16], s[16:20], s[20:32])
+}
+
+func parseADGUID(guid uuid.UUID) []byte {
+	raw := make([]byte, 16)
+	for i, j := range adGUIDByteOrder {
+		raw[j] = guid[i]
+	}
+	return raw
+}
@@ -0,0 +1,305 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/ldap/ldaptest"
+)
+
+const (
+	mockUserBaseDN  = "ou=people,dc=example,dc=com"
+	mockGroupBaseDN = "ou=groups,dc=example,dc=com"
+	mockBindDN      = "cn=stratos,dc=example,dc=com"
+	mockUserDN      = "uid=alice,ou=people,dc=example,dc=com"
+	mockUserID      = "0b7c4d42-2f5e-4a3e-9a47-6c1f1d2b9e11"
+)
+
+func newMockServer() *ldaptest.Server {
+	server := ldaptest.NewServer()
+	server.AddEntry(mockBindDN, "bind-secret", nil)
+	server.AddEntry(mockUserDN, "alice-secret", map[string][]string{
+		"uid":       {"alice"},
+		"entryUUID": {mockUserID},
+		"mail":      {"alice@example.com"},
+		"memberOf":  {"cn=developers,ou=groups,dc=example,dc=com", "cn=stratos-admins,ou=groups,dc=example,dc=com"},
+	})
+	server.AddEntry("uid=bob,ou=people,dc=example,dc=com", "bob-secret", map[string][]string{
+		"uid":       {"bob"},
+		"entryUUID": {"9d1e6a1c-6a8b-4a52-8d0f-3f4f0c6a7b21"},
+	})
+	server.AddEntry("cn=developers,ou=groups,dc=example,dc=com", "", map[string][]string{
+		"cn":     {"developers"},
+		"member": {mockUserDN, "uid=bob,ou=people,dc=example,dc=com"},
+	})
+	server.AddEntry("cn=auditors,ou=groups,dc=example,dc=com", "", map[string][]string{
+		"cn":           {"auditors"},
+		"uniqueMember": {mockUserDN},
+	})
+	return server
+}
+
+func newMockDirectory(server *ldaptest.Server, groupBaseDN string) *Directory {
+	directory, err := NewDirectory(Config{
+		URL:          "ldap://ldap.example.com",
+		BindDN:       mockBindDN,
+		BindPassword: "bind-secret",
+		UserBaseDN:   mockUserBaseDN,
+		GroupBaseDN:  groupBaseDN,
+		PoolSize:     2,
+		Timeout:      time.Second,
+		Dial:         server.Dial,
+	})
+	So(err, ShouldBeNil)
+	return directory
+}
+
+func TestNewDirectory(t *testing.T) {
+
+	Convey("A directory should only be created with a valid configuration", t, func() {
+		_, err := NewDirectory(Config{UserBaseDN: mockUserBaseDN})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewDirectory(Config{URL: "https://ldap.example.com", UserBaseDN: mockUserBaseDN})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewDirectory(Config{URL: "ldaps://ldap.example.com", UserBaseDN: mockUserBaseDN, StartTLS: true})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewDirectory(Config{URL: "ldap://ldap.example.com", UserBaseDN: mockUserBaseDN, CACertFile: "/does/not/exist"})
+		So(err, ShouldNotBeNil)
+
+		directory, err := NewDirectory(Config{URL: "ldap://ldap.example.com", UserBaseDN: mockUserBaseDN})
+		So(err, ShouldBeNil)
+		So(directory.config.UserFilter, ShouldEqual, defaultUserFilter)
+		So(directory.config.PoolSize, ShouldEqual, defaultPoolSize)
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+
+	Convey("Given a directory of users", t, func() {
+		server := newMockServer()
+		directory := newMockDirectory(server, "")
+		Reset(func() {
+			directory.Close()
+		})
+
+		Convey("a user should be logged in with their password", func() {
+			user, err := directory.Authenticate("alice", "alice-secret")
+			So(err, ShouldBeNil)
+			So(user.ID, ShouldEqual, mockUserID)
+			So(user.DN, ShouldEqual, mockUserDN)
+			So(user.Username, ShouldEqual, "alice")
+			So(user.Email, ShouldEqual, "alice@example.com")
+			So(user.Groups, ShouldResemble, []string{"developers", "stratos-admins"})
+		})
+
+		Convey("a wrong password should be rejected", func() {
+			_, err := directory.Authenticate("alice", "bob-secret")
+			So(err, ShouldEqual, ErrInvalidCredentials)
+		})
+
+		Convey("an empty password should be rejected without binding", func() {
+			_, err := directory.Authenticate("alice", "")
+			So(err, ShouldEqual, ErrInvalidCredentials)
+			So(server.Dials(), ShouldEqual, 0)
+		})
+
+		Convey("an unknown user should be rejected", func() {
+			_, err := directory.Authenticate("carol", "alice-secret")
+			So(err, ShouldEqual, ErrInvalidCredentials)
+		})
+
+		Convey("a username should not be able to change the filter", func() {
+			_, err := directory.Authenticate("*", "alice-secret")
+			So(err, ShouldEqual, ErrInvalidCredentials)
+
+			_, err = directory.Authenticate("alice)(uid=*", "alice-secret")
+			So(err, ShouldEqual, ErrInvalidCredentials)
+		})
+
+		Convey("connections should be reused", func() {
+			for i := 0; i < 5; i++ {
+				_, err := directory.Authenticate("alice", "alice-secret")
+				So(err, ShouldBeNil)
+			}
+			So(server.Dials(), ShouldEqual, 1)
+
+			directory.Close()
+			So(server.Open(), ShouldEqual, 0)
+		})
+
+		Convey("broken connections should not be reused", func() {
+			_, err := directory.Authenticate("alice", "alice-secret")
+			So(err, ShouldBeNil)
+
+			server.SetDown(true)
+			_, err = directory.Authenticate("alice", "alice-secret")
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotEqual, ErrInvalidCredentials)
+			So(server.Open(), ShouldEqual, 0)
+
+			server.SetDown(false)
+			_, err = directory.Authenticate("alice", "alice-secret")
+			So(err, ShouldBeNil)
+			So(server.Dials(), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a directory with a group base DN", t, func() {
+		server := newMockServer()
+		directory := newMockDirectory(server, mockGroupBaseDN)
+		defer directory.Close()
+
+		Convey("groups should be found by searching for the user's DN", func() {
+			user, err := directory.Authenticate("alice", "alice-secret")
+			So(err, ShouldBeNil)
+			So(user.Groups, ShouldResemble, []string{"developers", "auditors"})
+		})
+	})
+}
+
+func TestFindUser(t *testing.T) {
+
+	Convey("Given a directory of users", t, func() {
+		server := newMockServer()
+		directory := newMockDirectory(server, "")
+		Reset(func() {
+			directory.Close()
+		})
+
+		Convey("a user should be found by their ID", func() {
+			user, err := directory.FindUser(mockUserID)
+			So(err, ShouldBeNil)
+			So(user.Username, ShouldEqual, "alice")
+		})
+
+		Convey("an unknown user should not be found", func() {
+			_, err := directory.FindUser("f6c3a1a0-0000-4000-8000-000000000000")
+			So(err, ShouldEqual, ErrUserNotFound)
+		})
+
+		Convey("users should be cached until they expire", func() {
+			_, err := directory.FindUser(mockUserID)
+			So(err, ShouldBeNil)
+
+			server.SetDown(true)
+			user, err := directory.FindUser(mockUserID)
+			So(err, ShouldBeNil)
+			So(user.Username, ShouldEqual, "alice")
+
+			server.SetDown(false)
+			server.RemoveEntry(mockUserDN)
+			directory.cache[mockUserID] = cachedUser{user: user, expires: time.Now().Add(-time.Second)}
+			_, err = directory.FindUser(mockUserID)
+			So(err, ShouldEqual, ErrUserNotFound)
+		})
+	})
+
+	Convey("User IDs that are too long to store should be mapped to a UUID", t, func() {
+		server := newMockServer()
+		longID := "S-1-5-21-3623811015-3361044348-30300820-1013"
+		server.AddEntry("uid=carol,ou=people,dc=example,dc=com", "carol-secret", map[string][]string{
+			"uid":       {"carol"},
+			"entryUUID": {longID},
+		})
+		directory := newMockDirectory(server, "")
+		defer directory.Close()
+
+		user, err := directory.Authenticate("carol", "carol-secret")
+		So(err, ShouldBeNil)
+		So(len(user.ID), ShouldEqual, 36)
+		So(user.ID, ShouldNotEqual, longID)
+
+		// Mapped users are found by their DN once they have logged in
+		directory.cache = make(map[string]cachedUser)
+		found, err := directory.FindUser(user.ID)
+		So(err, ShouldBeNil)
+		So(found.Username, ShouldEqual, "carol")
+	})
+
+	Convey("Active Directory GUIDs should be formatted as UUIDs", t, func() {
+		raw := []byte{0x42, 0x4d, 0x7c, 0x0b, 0x5e, 0x2f, 0x3e, 0x4a, 0x9a, 0x47, 0x6c, 0x1f, 0x1d, 0x2b, 0x9e, 0x11}
+		So(formatADGUID(raw), ShouldEqual, mockUserID)
+
+		server := ldaptest.NewServer()
+		server.AddEntry(mockBindDN, "bind-secret", nil)
+		server.AddEntry(mockUserDN, "alice-secret", map[string][]string{
+			"sAMAccountName": {"alice"},
+			"objectGUID":     {string(raw)},
+		})
+		directory, err := NewDirectory(Config{
+			URL:               "ldap://ad.example.com",
+			BindDN:            mockBindDN,
+			BindPassword:      "bind-secret",
+			UserBaseDN:        mockUserBaseDN,
+			UserFilter:        "(sAMAccountName={username})",
+			UserIDAttribute:   "objectGUID",
+			UserNameAttribute: "sAMAccountName",
+			Dial:              server.Dial,
+		})
+		So(err, ShouldBeNil)
+		defer directory.Close()
+
+		user, err := directory.Authenticate("alice", "alice-secret")
+		So(err, ShouldBeNil)
+		So(user.ID, ShouldEqual, mockUserID)
+
+		// A new directory has not seen the user, so has to search for the GUID
+		directory.cache = make(map[string]cachedUser)
+		directory.dns = make(map[string]string)
+		found, err := directory.FindUser(mockUserID)
+		So(err, ShouldBeNil)
+		So(found.Username, ShouldEqual, "alice")
+	})
+}
+
+func TestPool(t *testing.T) {
+
+	Convey("Given a pool of connections", t, func() {
+		server := ldaptest.NewServer()
+		p := newPool(1, 50*time.Millisecond, server.Dial)
+
+		Convey("callers should wait for a connection once the pool is full", func() {
+			conn, err := p.get()
+			So(err, ShouldBeNil)
+
+			_, err = p.get()
+			So(err, ShouldNotBeNil)
+
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				p.put(conn, false)
+			}()
+			again, err := p.get()
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, conn)
+			So(server.Dials(), ShouldEqual, 1)
+		})
+
+		Convey("broken connections should be closed and replaced", func() {
+			conn, err := p.get()
+			So(err, ShouldBeNil)
+			p.put(conn, true)
+			So(server.Open(), ShouldEqual, 0)
+
+			_, err = p.get()
+			So(err, ShouldBeNil)
+			So(server.Dials(), ShouldEqual, 2)
+		})
+
+		Convey("a closed pool should not hand out connections", func() {
+			conn, err := p.get()
+			So(err, ShouldBeNil)
+			p.close()
+			p.put(conn, false)
+			So(server.Open(), ShouldEqual, 0)
+
+			_, err = p.get()
+			So(err, ShouldEqual, errPoolClosed)
+		})
+	})
+}
@@ -0,0 +1,225 @@
+// Package ldaptest provides an in-memory LDAP directory for testing LDAP authentication
+package ldaptest
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+type entry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// Server is an in-memory directory of users and groups
+// Only the filters used for logins and lookups are supported: and, or, not, equality and presence
+type Server struct {
+	lock    sync.Mutex
+	entries []*entry
+	dials   int
+	open    int
+	down    bool
+}
+
+// NewServer returns an empty directory
+func NewServer() *Server {
+	return &Server{}
+}
+
+// AddEntry adds an entry - entries with a password can be bound to
+func (s *Server) AddEntry(dn string, password string, attributes map[string][]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = append(s.entries, &entry{dn: dn, password: password, attributes: attributes})
+}
+
+// RemoveEntry removes the entry with the DN
+func (s *Server) RemoveEntry(dn string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, e := range s.entries {
+		if strings.EqualFold(e.dn, dn) {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetDown makes the connections to the directory fail with network errors, as if the server went away
+func (s *Server) SetDown(down bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.down = down
+}
+
+// Dials returns the number of connections that have been made to the directory
+func (s *Server) Dials() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dials
+}
+
+// Open returns the number of connections that have not been closed
+func (s *Server) Open() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.open
+}
+
+// Dial opens a connection to the directory
+func (s *Server) Dial() (ldapv3.Client, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.down {
+		return nil, ldapv3.NewError(ldapv3.ErrorNetwork, errors.New("connection refused"))
+	}
+	s.dials++
+	s.open++
+	return &conn{server: s}, nil
+}
+
+// conn is a connection to the in-memory directory
+// Operations that aren't needed for authentication panic through the nil embedded client
+type conn struct {
+	ldapv3.Client
+	server *Server
+	closed bool
+}
+
+func (c *conn) Start()                     {}
+func (c *conn) StartTLS(*tls.Config) error { return nil }
+func (c *conn) SetTimeout(time.Duration)   {}
+
+func (c *conn) Close() {
+	c.server.lock.Lock()
+	defer c.server.lock.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.server.open--
+	}
+}
+
+func (c *conn) check() error {
+	if c.closed {
+		return ldapv3.NewError(ldapv3.ErrorNetwork, errors.New("connection closed"))
+	}
+	if c.server.down {
+		return ldapv3.NewError(ldapv3.ErrorNetwork, errors.New("connection reset"))
+	}
+	return nil
+}
+
+func (c *conn) Bind(username, password string) error {
+	c.server.lock.Lock()
+	defer c.server.lock.Unlock()
+	if err := c.check(); err != nil {
+		return err
+	}
+	for _, e := range c.server.entries {
+		if strings.EqualFold(e.dn, username) && len(e.password) > 0 && e.password == password {
+			return nil
+		}
+	}
+	return ldapv3.NewError(ldapv3.LDAPResultInvalidCredentials, errors.New("Invalid Credentials"))
+}
+
+func (c *conn) UnauthenticatedBind(username string) error {
+	c.server.lock.Lock()
+	defer c.server.lock.Unlock()
+	return c.check()
+}
+
+func (c *conn) Search(req *ldapv3.SearchRequest) (*ldapv3.SearchResult, error) {
+	c.server.lock.Lock()
+	defer c.server.lock.Unlock()
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	filter, err := ldapv3.CompileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	baseFound := false
+	result := &ldapv3.SearchResult{}
+	for _, e := range c.server.entries {
+		if strings.EqualFold(e.dn, req.BaseDN) {
+			baseFound = true
+		}
+		if !inScope(e.dn, req.BaseDN, req.Scope) || !matches(filter, e) {
+			continue
+		}
+		if req.SizeLimit > 0 && len(result.Entries) == req.SizeLimit {
+			return result, ldapv3.NewError(ldapv3.LDAPResultSizeLimitExceeded, errors.New("Size Limit Exceeded"))
+		}
+		attributes := make(map[string][]string)
+		for _, name := range req.Attributes {
+			if values, ok := e.attributes[name]; ok {
+				attributes[name] = values
+			}
+		}
+		result.Entries = append(result.Entries, ldapv3.NewEntry(e.dn, attributes))
+	}
+
+	if req.Scope == ldapv3.ScopeBaseObject && !baseFound {
+		return nil, ldapv3.NewError(ldapv3.LDAPResultNoSuchObject, errors.New("No Such Object"))
+	}
+	return result, nil
+}
+
+func inScope(dn string, baseDN string, scope int) bool {
+	dn = strings.ToLower(dn)
+	baseDN = strings.ToLower(baseDN)
+	if scope == ldapv3.ScopeBaseObject {
+		return dn == baseDN
+	}
+	return dn == baseDN || strings.HasSuffix(dn, ","+baseDN)
+}
+
+func matches(filter *ber.Packet, e *entry) bool {
+	switch int(filter.Tag) {
+	case ldapv3.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, e) {
+				return false
+			}
+		}
+		return true
+	case ldapv3.FilterOr:
+		for _, child := range filter.Children {
+			if matches(child, e) {
+				return true
+			}
+		}
+		return false
+	case ldapv3.FilterNot:
+		return !matches(filter.Children[0], e)
+	case ldapv3.FilterPresent:
+		return len(attributeValues(e, filter.Data.String())) > 0
+	case ldapv3.FilterEqualityMatch:
+		value := filter.Children[1].Data.String()
+		for _, v := range attributeValues(e, filter.Children[0].Data.String()) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func attributeValues(e *entry, name string) []string {
+	for attribute, values := range e.attributes {
+		if strings.EqualFold(attribute, name) {
+			return values
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package ldap
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+var errPoolClosed = errors.New("LDAP connection pool is closed")
+
+// pool keeps open connections to the directory, so that logins and lookups don't need to connect each time
+// At most size connections are open at once - callers wait for a connection to be returned once this is reached
+type pool struct {
+	dial    func() (ldapv3.Client, error)
+	timeout time.Duration
+
+	lock   sync.Mutex
+	idle   []ldapv3.Client
+	open   int
+	size   int
+	closed bool
+	ready  chan struct{}
+}
+
+func newPool(size int, timeout time.Duration, dial func() (ldapv3.Client, error)) *pool {
+	return &pool{
+		dial:    dial,
+		timeout: timeout,
+		size:    size,
+		ready:   make(chan struct{}, size),
+	}
+}
+
+// get returns an idle connection, or a new one if fewer than the pool size are open
+func (p *pool) get() (ldapv3.Client, error) {
+	deadline := time.After(p.timeout)
+	for {
+		p.lock.Lock()
+		if p.closed {
+			p.lock.Unlock()
+			return nil, errPoolClosed
+		}
+		if n := len(p.idle); n > 0 {
+			conn := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.lock.Unlock()
+			return conn, nil
+		}
+		if p.open < p.size {
+			p.open++
+			p.lock.Unlock()
+
+			conn, err := p.dial()
+			if err != nil {
+				p.release()
+				return nil, err
+			}
+			return conn, nil
+		}
+		p.lock.Unlock()
+
+		select {
+		case <-p.ready:
+		case <-deadline:
+			return nil, errors.New("Timed out waiting for an LDAP connection")
+		}
+	}
+}
+
+// put returns a connection to the pool - broken connections are closed instead
+func (p *pool) put(conn ldapv3.Client, broken bool) {
+	p.lock.Lock()
+	if broken || p.closed {
+		p.lock.Unlock()
+		conn.Close()
+		p.release()
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.lock.Unlock()
+	p.notify()
+}
+
+// release gives up the slot of a connection that is no longer open
+func (p *pool) release() {
+	p.lock.Lock()
+	p.open--
+	p.lock.Unlock()
+	p.notify()
+}
+
+// notify wakes up a caller waiting for a connection, if there is one
+func (p *pool) notify() {
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+// close closes the idle connections - connections in use are closed when they are returned
+func (p *pool) close() {
+	p.lock.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.open -= len(idle)
+	p.lock.Unlock()
+
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
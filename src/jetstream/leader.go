@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/locks"
+)
+
+const (
+	leaderLockName      = "jetstream-leader"
+	leaderLeaseDuration = 30 * time.Second
+	leaderRenewInterval = 10 * time.Second
+
+	sessionStoreCleanupInterval = 3 * time.Minute
+)
+
+// leaderElection tracks whether this Jetstream instance is the leader of all instances sharing the database
+type leaderElection struct {
+	sync.Mutex
+	leader    bool
+	onElected []func()
+	onDemoted []func()
+}
+
+// newInstanceID returns an ID that identifies this Jetstream instance as the holder of locks
+func newInstanceID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s", hostname, uuid.NewV4().String())
+}
+
+// AcquireLock takes or renews a named lock, shared by all Jetstream instances, until the ttl expires
+// Returns false if another instance holds the lock
+func (p *portalProxy) AcquireLock(name string, ttl time.Duration) (bool, error) {
+	lockRepo, err := locks.NewPgsqlLockRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return false, fmt.Errorf(dbReferenceError, err)
+	}
+	return lockRepo.Acquire(name, p.InstanceID, time.Now().Add(ttl))
+}
+
+// ReleaseLock gives up a named lock held by this instance
+func (p *portalProxy) ReleaseLock(name string) error {
+	lockRepo, err := locks.NewPgsqlLockRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	return lockRepo.Release(name, p.InstanceID)
+}
+
+// IsLeader returns true if this instance is the leader - background jobs that should only run once use this
+func (p *portalProxy) IsLeader() bool {
+	if p.Leader == nil {
+		return false
+	}
+	p.Leader.Lock()
+	defer p.Leader.Unlock()
+	return p.Leader.leader
+}
+
+// onLeaderChange registers functions to call when this instance is elected leader and when it stops being the leader
+func (p *portalProxy) onLeaderChange(elected func(), demoted func()) {
+	p.Leader.Lock()
+	p.Leader.onElected = append(p.Leader.onElected, elected)
+	p.Leader.onDemoted = append(p.Leader.onDemoted, demoted)
+	isLeader := p.Leader.leader
+	p.Leader.Unlock()
+
+	if isLeader {
+		elected()
+	}
+}
+
+// startLeaderElection takes part in the election of the leader, renewing the leader lease while this instance is the leader
+func (p *portalProxy) startLeaderElection() {
+	p.electLeader()
+	go func() {
+		for {
+			time.Sleep(leaderRenewInterval)
+			p.electLeader()
+		}
+	}()
+}
+
+func (p *portalProxy) electLeader() {
+	leader, err := p.AcquireLock(leaderLockName, leaderLeaseDuration)
+	if err != nil {
+		// We can't tell if the lease is still ours, so assume that it isn't
+		log.Warnf("Unable to renew leader lease: %v", err)
+		leader = false
+	}
+
+	p.Leader.Lock()
+	if p.Leader.leader == leader {
+		p.Leader.Unlock()
+		return
+	}
+	p.Leader.leader = leader
+	callbacks := p.Leader.onDemoted
+	if leader {
+		callbacks = p.Leader.onElected
+	}
+	p.Leader.Unlock()
+
+	if leader {
+		log.Infof("This Jetstream instance (%s) is now the leader", p.InstanceID)
+	} else {
+		log.Infof("This Jetstream instance (%s) is no longer the leader", p.InstanceID)
+	}
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+// startSessionStoreCleanup deletes expired sessions from the session store while this instance is the leader
+// Returns a function that stops the cleanup
+func (p *portalProxy) startSessionStoreCleanup(sessionStore HttpSessionStore) func() {
+	var mutex sync.Mutex
+	var quit chan<- struct{}
+	var done <-chan struct{}
+
+	start := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if quit == nil {
+			quit, done = sessionStore.Cleanup(sessionStoreCleanupInterval)
+		}
+	}
+	stop := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if quit != nil {
+			sessionStore.StopCleanup(quit, done)
+			quit, done = nil, nil
+		}
+	}
+
+	p.onLeaderChange(start, stop)
+	return stop
+}
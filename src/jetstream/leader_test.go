@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const renewLeaderLockSQL = `UPDATE locks SET holder = (.+)`
+
+func TestLeaderElection(t *testing.T) {
+	t.Parallel()
+
+	Convey("Leader election", t, func() {
+
+		db, mock, dberr := sqlmock.New()
+		if dberr != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", dberr)
+		}
+		defer db.Close()
+
+		pp := setupPortalProxy(db)
+		pp.DatabaseConnectionPool = db
+		pp.InstanceID = "instance-1"
+		pp.Leader = &leaderElection{}
+
+		elected, demoted := 0, 0
+		pp.onLeaderChange(func() { elected++ }, func() { demoted++ })
+
+		mock.ExpectExec(renewLeaderLockSQL).
+			WithArgs("instance-1", sqlmock.AnyArg(), leaderLockName, "instance-1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		pp.electLeader()
+
+		So(pp.IsLeader(), ShouldBeTrue)
+		So(elected, ShouldEqual, 1)
+
+		Convey("should stay leader while the lease is renewed", func() {
+			mock.ExpectExec(renewLeaderLockSQL).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			pp.electLeader()
+
+			So(pp.IsLeader(), ShouldBeTrue)
+			So(elected, ShouldEqual, 1)
+			So(demoted, ShouldEqual, 0)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should stop being leader if the lease can not be renewed", func() {
+			mock.ExpectExec(renewLeaderLockSQL).
+				WillReturnError(errors.New("Unknown Database Error"))
+			pp.electLeader()
+
+			So(pp.IsLeader(), ShouldBeFalse)
+			So(demoted, ShouldEqual, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should call the elected function when registered after being elected", func() {
+			late := 0
+			pp.onLeaderChange(func() { late++ }, func() {})
+			So(late, ShouldEqual, 1)
+		})
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cfappssh"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cloudfoundry"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cloudfoundryhosting"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/helm"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/kubernetes"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/metrics"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/userfavorites"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/userinfo"
@@ -28,6 +30,8 @@ func (pp *portalProxy) loadPlugins() {
 		{"cfappssh", cfappssh.Init},
 		{"cloudfoundry", cloudfoundry.Init},
 		{"cloudfoundryhosting", cloudfoundryhosting.Init},
+		{"helm", helm.Init},
+		{"kubernetes", kubernetes.Init},
 		{"metrics", metrics.Init},
 		{"userinfo", userinfo.Init},
 		// userinvite depends on cloudfoundry & cloudfoundryhosting
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/apitokens"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/mfa"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/stringutils"
+)
+
+const (
+	// Session value set at login for local users that must change their password before they can do anything else
+	passwordChangeRequiredKey = "password_change_required"
+
+	// Scopes of a local user are stored together in a column of this size
+	maxLocalUserScopeLength = 255
+
+	// Default number of seconds that users are locked out for after too many failed logins
+	defaultLocalUserLockout = 900
+
+	// Routes that users who must change their password can still use
+	sessionVerifyRoute = "/pp/v1/auth/session/verify"
+	infoRoute          = "/pp/v1/info"
+	userRoute          = "/pp/v1/users/:id"
+	userPasswordRoute  = "/pp/v1/users/:id/password"
+)
+
+var errLocalUserDisabled = errors.New("Local user is disabled")
+var errLocalUserLocked = errors.New("Access Denied - Too many failed logins, try again later")
+
+// localUserResponse describes a local user - the password hash is never returned
+type localUserResponse struct {
+	GUID                   string     `json:"id"`
+	Username               string     `json:"username"`
+	Email                  string     `json:"email"`
+	GivenName              string     `json:"given_name"`
+	FamilyName             string     `json:"family_name"`
+	Scopes                 []string   `json:"scopes"`
+	Disabled               bool       `json:"disabled"`
+	PasswordChangeRequired bool       `json:"password_change_required"`
+	LastLogin              *time.Time `json:"last_login,omitempty"`
+	LockedUntil            *time.Time `json:"locked_until,omitempty"`
+}
+
+type createLocalUserRequest struct {
+	Username               string   `json:"username"`
+	Password               string   `json:"password"`
+	Email                  string   `json:"email"`
+	GivenName              string   `json:"given_name"`
+	FamilyName             string   `json:"family_name"`
+	Scopes                 []string `json:"scopes"`
+	PasswordChangeRequired bool     `json:"password_change_required"`
+}
+
+// updateLocalUserRequest only changes the fields that are set - Unlock lets a user that has been locked out log in again
+type updateLocalUserRequest struct {
+	Email                  *string   `json:"email"`
+	GivenName              *string   `json:"given_name"`
+	FamilyName             *string   `json:"family_name"`
+	Scopes                 *[]string `json:"scopes"`
+	Disabled               *bool     `json:"disabled"`
+	PasswordChangeRequired *bool     `json:"password_change_required"`
+	Unlock                 bool      `json:"unlock"`
+}
+
+// resetLocalUserPasswordRequest sets a new password - users must change it at their next login unless told otherwise
+type resetLocalUserPasswordRequest struct {
+	Password               string `json:"password"`
+	PasswordChangeRequired *bool  `json:"password_change_required"`
+}
+
+// getLocalUserScopes returns the scopes of a local user
+func getLocalUserScopes(user interfaces.LocalUser) []string {
+	return strings.Fields(user.Scope)
+}
+
+func newLocalUserResponse(user interfaces.LocalUser) *localUserResponse {
+	return &localUserResponse{
+		GUID:                   user.UserGUID,
+		Username:               user.Username,
+		Email:                  user.Email,
+		GivenName:              user.GivenName,
+		FamilyName:             user.FamilyName,
+		Scopes:                 getLocalUserScopes(user),
+		Disabled:               user.Disabled,
+		PasswordChangeRequired: user.PasswordChangeRequired,
+		LastLogin:              user.LastLogin,
+		LockedUntil:            user.LockedUntil,
+	}
+}
+
+// isLocalUserLocked returns true if the user has been locked out after too many failed logins
+func isLocalUserLocked(user interfaces.LocalUser) bool {
+	return user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
+}
+
+// joinLocalUserScopes checks the scopes of a local user and returns them in the form they are stored in
+func joinLocalUserScopes(scopes []string) (string, error) {
+	if len(scopes) == 0 {
+		return "", errors.New("Local users need at least one scope")
+	}
+
+	unique := make([]string, 0, len(scopes))
+	seen := make(map[string]bool)
+	for _, scope := range scopes {
+		if len(scope) == 0 || strings.ContainsAny(scope, " \t\r\n") {
+			return "", fmt.Errorf("Invalid scope: '%s'", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+
+	joined := strings.Join(unique, " ")
+	if len(joined) > maxLocalUserScopeLength {
+		return "", fmt.Errorf("Scopes must be no longer than %d characters in total", maxLocalUserScopeLength)
+	}
+	return joined, nil
+}
+
+// getLocalUsersRepository returns the local users repository, as long as the console uses local users
+func (p *portalProxy) getLocalUsersRepository() (localusers.Repository, error) {
+	if p.Config.ConsoleConfig == nil || interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] != interfaces.Local {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Local users are not enabled",
+			"Local users are not enabled")
+	}
+
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+	return localUsersRepo, nil
+}
+
+// findLocalUserDetails returns the local user without the password hash
+func findLocalUserDetails(localUsersRepo localusers.Repository, userGUID string) (interfaces.LocalUser, error) {
+	user, err := localUsersRepo.FindUser(userGUID)
+	if err == localusers.ErrUserNotFound {
+		return user, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Local user not found",
+			"Local user %s not found", userGUID)
+	} else if err != nil {
+		return user, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to find local user",
+			"Unable to find local user %s: %v", userGUID, err)
+	}
+	return user, nil
+}
+
+// findLocalUser returns the local user with the password hash, which is needed to update the user
+func findLocalUser(localUsersRepo localusers.Repository, userGUID string) (interfaces.LocalUser, error) {
+	user, err := findLocalUserDetails(localUsersRepo, userGUID)
+	if err != nil {
+		return user, err
+	}
+
+	if user.PasswordHash, err = localUsersRepo.FindPasswordHash(userGUID); err != nil {
+		return user, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to find local user",
+			"Unable to find password hash of local user %s: %v", userGUID, err)
+	}
+	return user, nil
+}
+
+// checkLocalUserPassword checks that a new password meets the password policy - the user GUID is empty for new users
+func (p *portalProxy) checkLocalUserPassword(localUsersRepo localusers.Repository, userGUID, password string) error {
+	err := localusers.NewPasswordPolicy(&p.Config).Check(localUsersRepo, userGUID, password)
+	if _, ok := err.(localusers.PasswordPolicyError); ok {
+		return interfaces.NewHTTPShadowError(http.StatusBadRequest, err.Error(), "Password does not meet the password policy: %v", err)
+	} else if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check password",
+			"Unable to check password against the password policy: %v", err)
+	}
+	return nil
+}
+
+// revokeUserAccess ends the sessions of a user, other than the session of the request, and optionally removes their API tokens
+func (p *portalProxy) revokeUserAccess(c echo.Context, userGUID string, tokens bool) error {
+	list, err := p.listUserSessions(c, userGUID)
+	if err != nil {
+		return fmt.Errorf("Unable to list sessions: %v", err)
+	}
+
+	others := make([]*userSession, 0, len(list))
+	for _, session := range list {
+		if !session.Current {
+			others = append(others, session)
+		}
+	}
+	if err := p.deleteUserSessions(others); err != nil {
+		return fmt.Errorf("Unable to revoke sessions: %v", err)
+	}
+
+	if tokens {
+		tokensRepo, err := apitokens.NewPgsqlAPITokensRepository(p.DatabaseConnectionPool)
+		if err != nil {
+			return fmt.Errorf(dbReferenceError, err)
+		}
+		if _, err := tokensRepo.DeleteByUser(userGUID); err != nil {
+			return fmt.Errorf("Unable to revoke API tokens: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkPasswordChange only lets users that must change their password do so
+// The session value is cleared once the password has been changed
+func (p *portalProxy) checkPasswordChange(c echo.Context, userGUID string) error {
+	if required, err := p.GetSessionValue(c, passwordChangeRequiredKey); err != nil || required != true {
+		return nil
+	}
+
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	if user, err := localUsersRepo.FindUser(userGUID); err == nil && !user.PasswordChangeRequired {
+		if err := p.unsetSessionValue(c, passwordChangeRequiredKey); err != nil {
+			log.Warnf("Unable to update session: %v", err)
+		}
+		return nil
+	}
+
+	if isPathAllowed(c, sessionVerifyRoute, infoRoute) || isOwnUserPathAllowed(c, userGUID) {
+		return nil
+	}
+
+	return interfaces.NewHTTPShadowError(
+		http.StatusForbidden,
+		"You must change your password before you can continue",
+		"User %s must change their password", userGUID)
+}
+
+// listLocalUsers returns all of the local users
+func (p *portalProxy) listLocalUsers(c echo.Context) error {
+	log.Debug("listLocalUsers")
+	localUsersRepo, err := p.getLocalUsersRepository()
+	if err != nil {
+		return err
+	}
+
+	users, err := localUsersRepo.ListLocalUsers()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list local users",
+			"Unable to list local users: %v", err)
+	}
+
+	list := make([]*localUserResponse, 0, len(users))
+	for _, user := range users {
+		list = append(list, newLocalUserResponse(user))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+	return c.JSON(http.StatusOK, list)
+}
+
+// getLocalUser returns a local user
+func (p *portalProxy) getLocalUser(c echo.Context) error {
+	log.Debug("getLocalUser")
+	localUsersRepo, err := p.getLocalUsersRepository()
+	if err != nil {
+		return err
+	}
+
+	user, err := findLocalUser(localUsersRepo, c.Param("user"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newLocalUserResponse(user))
+}
+
+// createLocalUser adds a local user - users get the local user scope if no scopes are given
+func (p *portalProxy) createLocalUser(c echo.Context) error {
+	log.Debug("createLocalUser")
+	localUsersRepo, err := p.getLocalUsersRepository()
+	if err != nil {
+		return err
+	}
+
+	request := &createLocalUserRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid local user request",
+			"Unable to parse local user request: %v", err)
+	}
+
+	request.Username = strings.TrimSpace(request.Username)
+	if len(request.Username) == 0 || len(request.Password) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Local users need a username and password",
+			"Local user request is missing a username or password")
+	}
+
+	if len(request.Scopes) == 0 {
+		request.Scopes = []string{p.Config.ConsoleConfig.LocalUserScope}
+	}
+	scope, err := joinLocalUserScopes(request.Scopes)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(http.StatusBadRequest, err.Error(), "Invalid local user request: %v", err)
+	}
+
+	if err := p.checkLocalUserPassword(localUsersRepo, "", request.Password); err != nil {
+		return err
+	}
+
+	if _, err := localUsersRepo.FindUserGUID(request.Username); err == nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusConflict,
+			"A local user with this username already exists",
+			"Local user %s already exists", request.Username)
+	}
+
+	passwordHash, err := crypto.HashPassword(request.Password)
+	if err != nil {
+		return fmt.Errorf("Unable to hash password: %v", err)
+	}
+
+	user := interfaces.LocalUser{
+		UserGUID:               uuid.NewV4().String(),
+		PasswordHash:           passwordHash,
+		Username:               request.Username,
+		Email:                  request.Email,
+		Scope:                  scope,
+		GivenName:              request.GivenName,
+		FamilyName:             request.FamilyName,
+		PasswordChangeRequired: request.PasswordChangeRequired,
+	}
+	setAuditDetails(c, fmt.Sprintf("user=%s username=%s scopes=%s", user.UserGUID, user.Username, strings.Join(getLocalUserScopes(user), ",")))
+
+	if err := p.checkLocalUserPermissionsChange(c, user.UserGUID, user); err != nil {
+		return err
+	}
+
+	if err := localUsersRepo.AddLocalUser(user); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to create local user",
+			"Unable to add local user: %v", err)
+	}
+
+	if err := localusers.NewPasswordPolicy(&p.Config).Remember(localUsersRepo, user.UserGUID, user.PasswordHash); err != nil {
+		log.Warnf("Unable to add password history of local user %s: %v", user.UserGUID, err)
+	}
+
+	return c.JSON(http.StatusCreated, newLocalUserResponse(user))
+}
+
+// updateLocalUser changes the details, scopes and status of a local user
+// Disabled users are logged out and their API tokens are removed
+func (p *portalProxy) updateLocalUser(c echo.Context) error {
+	log.Debug("updateLocalUser")
+	localUsersRepo, err := p.getLocalUsersRepository()
+	if err != nil {
+		return err
+	}
+
+	request := &updateLocalUserRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid local user request",
+			"Unable to parse local user request: %v", err)
+	}
+
+	userGUID := c.Param("user")
+	user, err := findLocalUser(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+
+	// Users can only be changed by users that hold all of their permissions, both before and after the change
+	previous := user
+
+	changes := make([]string, 0)
+	if request.Email != nil {
+		user.Email = *request.Email
+	}
+	if request.GivenName != nil {
+		user.GivenName = *request.GivenName
+	}
+	if request.FamilyName != nil {
+		user.FamilyName = *request.FamilyName
+	}
+	if request.Scopes != nil {
+		scope, err := joinLocalUserScopes(*request.Scopes)
+		if err != nil {
+			return interfaces.NewHTTPShadowError(http.StatusBadRequest, err.Error(), "Invalid local user request: %v", err)
+		}
+		if scope != user.Scope && isCurrentUser(c, userGUID) {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"You can not change your own scopes",
+				"User %s tried to change their own scopes", userGUID)
+		}
+		user.Scope = scope
+		changes = append(changes, "scopes="+strings.Join(getLocalUserScopes(user), ","))
+	}
+
+	disabling := false
+	if request.Disabled != nil {
+		if *request.Disabled && isCurrentUser(c, userGUID) {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"You can not disable your own user",
+				"User %s tried to disable themselves", userGUID)
+		}
+		disabling = *request.Disabled && !user.Disabled
+		user.Disabled = *request.Disabled
+		changes = append(changes, fmt.Sprintf("disabled=%t", user.Disabled))
+	}
+	if request.PasswordChangeRequired != nil {
+		user.PasswordChangeRequired = *request.PasswordChangeRequired
+		changes = append(changes, fmt.Sprintf("password_change_required=%t", user.PasswordChangeRequired))
+	}
+	if request.Unlock {
+		changes = append(changes, "unlock=true")
+	}
+	setAuditDetails(c, strings.TrimSpace(fmt.Sprintf("user=%s %s", userGUID, strings.Join(changes, " "))))
+
+	if err := p.checkLocalUserPermissionsChange(c, userGUID, previous, user); err != nil {
+		return err
+	}
+
+	if err := localUsersRepo.UpdateLocalUser(user); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update local user",
+			"Unable to update local user: %v", err)
+	}
+
+	if request.Unlock {
+		if err := localUsersRepo.UpdateFailedLogins(userGUID, 0, nil); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusInternalServerError,
+				"Unable to unlock local user",
+				"Unable to reset failed logins of user %s: %v", userGUID, err)
+		}
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = nil
+	}
+
+	if disabling {
+		if err := p.revokeUserAccess(c, userGUID, true); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusInternalServerError,
+				"Local user has been disabled, but could not be logged out",
+				"Unable to revoke access of disabled user %s: %v", userGUID, err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, newLocalUserResponse(user))
+}
+
+// resetLocalUserPassword sets a new password for a local user and logs out their other sessions
+func (p *portalProxy) resetLocalUserPassword(c echo.Context) error {
+	log.Debug("resetLocalUserPassword")
+	localUsersRepo, err := p.getLocalUsersRepository()
+	if err != nil {
+		return err
+	}
+
+	request := &resetLocalUserPasswordRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid password reset request",
+			"Unable to parse password reset request: %v", err)
+	}
+	if len(request.Password) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"A new password is needed",
+			"Password reset request is missing a password")
+	}
+
+	userGUID := c.Param("user")
+	user, err := findLocalUser(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+
+	if err := p.checkLocalUserPermissionsChange(c, userGUID, user); err != nil {
+		return err
+	}
+
+	if err := p.checkLocalUserPassword(localUsersRepo, userGUID, request.Password); err != nil {
+		return err
+	}
+
+	if user.PasswordHash, err = crypto.HashPassword(request.Password); err != nil {
+		return fmt.Errorf("Unable to hash password: %v", err)
+	}
+	user.PasswordChangeRequired = request.PasswordChangeRequired == nil || *request.PasswordChangeRequired
+	setAuditDetails(c, fmt.Sprintf("user=%s password_change_required=%t", userGUID, user.PasswordChangeRequired))
+
+	if err := localUsersRepo.UpdateLocalUser(user); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to reset password",
+			"Unable to update local user: %v", err)
+	}
+
+	if err := localusers.NewPasswordPolicy(&p.Config).Remember(localUsersRepo, userGUID, user.PasswordHash); err != nil {
+		log.Warnf("Unable to add password history of local user %s: %v", userGUID, err)
+	}
+
+	// A new password also lets users back in that have been locked out
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := localUsersRepo.UpdateFailedLogins(userGUID, 0, nil); err != nil {
+			log.Warnf("Unable to reset failed logins of local user %s: %v", userGUID, err)
+		}
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = nil
+	}
+
+	if err := p.revokeUserAccess(c, userGUID, false); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Password has been reset, but the user could not be logged out",
+			"Unable to revoke sessions of user %s: %v", userGUID, err)
+	}
+
+	return c.JSON(http.StatusOK, newLocalUserResponse(user))
+}
+
+// deleteLocalUser removes a local user, their second factor, sessions and API tokens
+func (p *portalProxy) deleteLocalUser(c echo.Context) error {
+	log.Debug("deleteLocalUser")
+	localUsersRepo, err := p.getLocalUsersRepository()
+	if err != nil {
+		return err
+	}
+
+	userGUID := c.Param("user")
+	setAuditDetails(c, fmt.Sprintf("user=%s", userGUID))
+	if isCurrentUser(c, userGUID) {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"You can not delete your own user",
+			"User %s tried to delete themselves", userGUID)
+	}
+
+	user, err := findLocalUserDetails(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkLocalUserPermissionsChange(c, userGUID, user); err != nil {
+		return err
+	}
+
+	err = localUsersRepo.DeleteLocalUser(userGUID)
+	if err == localusers.ErrUserNotFound {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Local user not found",
+			"Local user %s not found", userGUID)
+	} else if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to delete local user",
+			"Unable to delete local user: %v", err)
+	}
+
+	mfaRepo, err := mfa.NewPgsqlMFARepository(p.DatabaseConnectionPool)
+	if err == nil {
+		err = mfaRepo.Delete(userGUID)
+	}
+	if err != nil {
+		log.Warnf("Unable to delete MFA of deleted user %s: %v", userGUID, err)
+	}
+
+	if err := p.revokeUserAccess(c, userGUID, true); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Local user has been deleted, but could not be logged out",
+			"Unable to revoke access of deleted user %s: %v", userGUID, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// isPathAllowed returns true if the request was routed to one of the allowed routes
+// The registered route is compared rather than the path of the request, so that other routes that share a suffix
+// (e.g. /pp/v1/proxy/v2/info) are not allowed
+func isPathAllowed(c echo.Context, allowed ...string) bool {
+	path := c.Path()
+	for _, route := range allowed {
+		if path == route {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnUserPathAllowed returns true if the request is for the user profile or password of the given user
+func isOwnUserPathAllowed(c echo.Context, userGUID string) bool {
+	return isPathAllowed(c, userRoute, userPasswordRoute) && c.Param("id") == userGUID
+}
+
+// isLocalUserAdmin returns true if the local user has the console admin scope
+func (p *portalProxy) isLocalUserAdmin(user interfaces.LocalUser) bool {
+	return p.Config.ConsoleConfig != nil && stringutils.ArrayContainsString(getLocalUserScopes(user), p.Config.ConsoleConfig.ConsoleAdminScope)
+}
+
+// getLocalUserPermissions returns the permissions that the scopes of the local user grant them,
+// either through the console admin scope or a role mapping
+func (p *portalProxy) getLocalUserPermissions(user interfaces.LocalUser) *interfaces.UserPermissions {
+	connectedUser := &interfaces.ConnectedUser{
+		Admin:  p.isLocalUserAdmin(user),
+		Scopes: getLocalUserScopes(user),
+	}
+	return p.RoleMappings.getPermissions(connectedUser)
+}
+
+// isLocalUserConsoleAdmin returns true if the scopes of the local user grant them console admin permission
+func (p *portalProxy) isLocalUserConsoleAdmin(user interfaces.LocalUser) bool {
+	return p.getLocalUserPermissions(user).Has(interfaces.PermissionConsoleAdmin)
+}
+
+// checkLocalUserPermissionsChange only lets users make, change or remove local users whose permissions they hold
+// themselves, and that the API token of the request has been scoped to, so that users that can manage local users
+// can not give themselves or others more than they have
+// Each state of the user is checked, e.g. both before and after a change of scopes
+func (p *portalProxy) checkLocalUserPermissionsChange(c echo.Context, userGUID string, users ...interfaces.LocalUser) error {
+	currentGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(http.StatusForbidden, "Unable to find the current user", "Unable to find the current user: %v", err)
+	}
+	current, err := p.GetUserPermissions(currentGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check permissions",
+			"Unable to get permissions of user %s: %v", currentGUID, err)
+	}
+
+	token := getRequestAPIToken(c)
+	for _, user := range users {
+		for _, permission := range p.getLocalUserPermissions(user).Permissions {
+			if !current.Has(permission) || (token != nil && !token.HasScope(permission)) {
+				return interfaces.NewHTTPShadowError(
+					http.StatusForbidden,
+					"You can only manage users that have permissions that you have",
+					"User %s is not allowed to manage user %s with permission %s", currentGUID, userGUID, permission)
+			}
+		}
+	}
+	return nil
+}
+
+func isCurrentUser(c echo.Context, userGUID string) bool {
+	current, err := getPortalUserGUID(c)
+	return err == nil && current == userGUID
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestLocalUserManagement(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given an admin that manages local users", t, func() {
+
+		Convey("local users can only be managed when the console uses local users", func() {
+			_, _, ctx, pp, db, _ := setupHTTPTest(setupMockReq("GET", "", nil))
+			defer db.Close()
+
+			err := pp.listLocalUsers(ctx)
+			So(err, ShouldNotBeNil)
+			shadowErr, ok := err.(interfaces.ErrHTTPShadow)
+			So(ok, ShouldBeTrue)
+			So(shadowErr.HTTPError.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("a local user should be created with the given scopes", func() {
+			req := setupMockReq("POST", "", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(`{"username":"newuser","password":"changeme","scopes":["stratos.admin","stratos.user"],"password_change_required":true}`))
+			res, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+			ctx.Set("user_id", mockUserGUID)
+
+			mock.ExpectQuery(findUserGUID).WithArgs("newuser").WillReturnRows(sqlmock.NewRows([]string{"user_guid"}))
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", "stratos.admin", "", "", false, false, nil, 0, nil))
+			mock.ExpectExec(addLocalUser).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "newuser", "", "stratos.admin stratos.user", "", "", false, true).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.createLocalUser(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusCreated)
+			So(res.Body.String(), ShouldContainSubstring, `"username":"newuser"`)
+			So(res.Body.String(), ShouldNotContainSubstring, "password_hash")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("users that are not console admins should not be able to create console admins", func() {
+			req := setupMockReq("POST", "", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(`{"username":"newuser","password":"changeme","scopes":["stratos.admin"]}`))
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+			ctx.Set("user_id", mockUserGUID)
+
+			mock.ExpectQuery(findUserGUID).WithArgs("newuser").WillReturnRows(sqlmock.NewRows([]string{"user_guid"}))
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("manager", "", "stratos.users", "", "", false, false, nil, 0, nil))
+
+			err := pp.createLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusForbidden)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("users that are not console admins should not be able to reset the password of a console admin", func() {
+			req := setupMockReq("POST", "", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(`{"password":"changeme"}`))
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+			ctx.Set("user_id", mockUserGUID)
+			ctx.SetParamNames("user")
+			ctx.SetParamValues("admin-guid")
+
+			mock.ExpectQuery(findUser).WithArgs("admin-guid").
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", "stratos.admin", "", "", false, false, nil, 0, nil))
+			mock.ExpectQuery(findPasswordHash).WithArgs("admin-guid").WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow("hash"))
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("manager", "", "stratos.users", "", "", false, false, nil, 0, nil))
+
+			err := pp.resetLocalUserPassword(ctx)
+			So(err, ShouldNotBeNil)
+			So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusForbidden)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("user managers should not be able to grant permissions that they do not have", func() {
+			req := setupMockReq("POST", "", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(`{"username":"newuser","password":"changeme","scopes":["stratos.endpoints"]}`))
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+			pp.RoleMappings = newRoleMappings("user-manager=stratos.users;endpoint-admin=stratos.endpoints", "")
+			ctx.Set("user_id", mockUserGUID)
+
+			mock.ExpectQuery(findUserGUID).WithArgs("newuser").WillReturnRows(sqlmock.NewRows([]string{"user_guid"}))
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("manager", "", "stratos.users", "", "", false, false, nil, 0, nil))
+
+			err := pp.createLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusForbidden)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("user managers should not be able to reset the password of an endpoint admin", func() {
+			req := setupMockReq("POST", "", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(`{"password":"changeme"}`))
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+			pp.RoleMappings = newRoleMappings("user-manager=stratos.users;endpoint-admin=stratos.endpoints", "")
+			ctx.Set("user_id", mockUserGUID)
+			ctx.SetParamNames("user")
+			ctx.SetParamValues("endpoint-admin-guid")
+
+			mock.ExpectQuery(findUser).WithArgs("endpoint-admin-guid").
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("endpointadmin", "", "stratos.endpoints", "", "", false, false, nil, 0, nil))
+			mock.ExpectQuery(findPasswordHash).WithArgs("endpoint-admin-guid").WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow("hash"))
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("manager", "", "stratos.users", "", "", false, false, nil, 0, nil))
+
+			err := pp.resetLocalUserPassword(ctx)
+			So(err, ShouldNotBeNil)
+			So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusForbidden)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("users should not be able to change their own scopes", func() {
+			req := setupMockReq("PUT", "", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(`{"scopes":["stratos.admin"]}`))
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			ctx.Set("user_id", mockUserGUID)
+			ctx.SetParamNames("user")
+			ctx.SetParamValues(mockUserGUID)
+
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("manager", "", "stratos.users", "", "", false, false, nil, 0, nil))
+			mock.ExpectQuery(findPasswordHash).WithArgs(mockUserGUID).WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow("hash"))
+
+			err := pp.updateLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusBadRequest)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("users should not be able to delete themselves", func() {
+			_, _, ctx, pp, db, mock := setupHTTPTest(setupMockReq("DELETE", "", nil))
+			defer db.Close()
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			ctx.Set("user_id", mockUserGUID)
+			ctx.SetParamNames("user")
+			ctx.SetParamValues(mockUserGUID)
+
+			err := pp.deleteLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			shadowErr, ok := err.(interfaces.ErrHTTPShadow)
+			So(ok, ShouldBeTrue)
+			So(shadowErr.HTTPError.Code, ShouldEqual, http.StatusBadRequest)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
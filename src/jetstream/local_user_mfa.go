@@ -0,0 +1,545 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/console_config"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/mfa"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/totp"
+)
+
+const (
+	// Session values of a login where the password has been checked, but the MFA code has not been checked yet
+	mfaPendingUserKey     = "mfa_pending_user"
+	mfaPendingExpiryKey   = "mfa_pending_expiry"
+	mfaPendingFailuresKey = "mfa_pending_failures"
+	// How long users have to enter their MFA code after entering their password
+	mfaPendingTimeout = 5 * time.Minute
+	// Number of wrong codes after which users must enter their password again, whatever the lockout config
+	mfaMaxPendingFailures = 5
+
+	// Session value set at login for users that must enrol in MFA before they can do anything else
+	mfaEnrolmentRequiredKey = "mfa_enrolment_required"
+	// Routes used to enrol, which users that must enrol in MFA can still use
+	mfaRoute       = "/pp/v1/mfa"
+	mfaEnrolRoute  = "/pp/v1/mfa/enrol"
+	mfaVerifyRoute = "/pp/v1/mfa/verify"
+
+	// Name that authenticator apps show for Stratos codes
+	mfaIssuer = "Stratos"
+
+	// Number of recovery codes that users get - each can be used once in place of a code from their authenticator app
+	mfaRecoveryCodeCount = 10
+	// Recovery codes are made of these characters, without ones that are easily mistaken for each other
+	mfaRecoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	// Whether admins must use MFA is kept in the config table, so that it applies to all Jetstream instances
+	mfaConfigGroup           = "mfa"
+	mfaRequiredForAdminsName = "REQUIRED_FOR_ADMINS"
+)
+
+// mfaStatus describes the MFA of the current user
+type mfaStatus struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// mfaEnrolment is the secret that users add to their authenticator app - the URI is usually shown as a QR code
+type mfaEnrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// mfaRecoveryCodes is the only time that recovery codes are returned
+type mfaRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type mfaSettings struct {
+	RequiredForAdmins bool `json:"required_for_admins"`
+}
+
+// hashRecoveryCode returns the hash of a recovery code that is stored in place of the code
+// Codes are random, so like API tokens they do not need a salted or slow hash
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	return hashAPIToken(code)
+}
+
+// generateRecoveryCodes returns new recovery codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, mfaRecoveryCodeCount)
+	hashes := make([]string, mfaRecoveryCodeCount)
+	for i := range codes {
+		random := make([]byte, 10)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, fmt.Errorf("Unable to generate recovery codes: %v", err)
+		}
+
+		code := make([]byte, len(random))
+		for j, b := range random {
+			code[j] = mfaRecoveryCodeAlphabet[int(b)%len(mfaRecoveryCodeAlphabet)]
+		}
+		codes[i] = string(code[:5]) + "-" + string(code[5:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// mfaRequiredForAdmins returns true if an admin has required console admins to use MFA
+func (p *portalProxy) mfaRequiredForAdmins() (bool, error) {
+	configRepo, err := console_config.NewPostgresConsoleConfigRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return false, fmt.Errorf(dbReferenceError, err)
+	}
+
+	required, ok, err := configRepo.GetValue(mfaConfigGroup, mfaRequiredForAdminsName)
+	if err != nil {
+		return false, err
+	}
+	return ok && required == "true", nil
+}
+
+// isMFARequired returns true if the local user must use MFA
+// Users that are console admins through a role mapping must use MFA, as well as those with the console admin scope
+func (p *portalProxy) isMFARequired(user interfaces.LocalUser) (bool, error) {
+	if !p.isLocalUserConsoleAdmin(user) {
+		return false, nil
+	}
+	return p.mfaRequiredForAdmins()
+}
+
+// getMFARepositories returns the local users and MFA repositories, as long as the console uses local users
+func (p *portalProxy) getMFARepositories() (localusers.Repository, mfa.Repository, error) {
+	localUsersRepo, err := p.getLocalUsersRepository()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mfaRepo, err := mfa.NewPgsqlMFARepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, nil, fmt.Errorf(dbReferenceError, err)
+	}
+	return localUsersRepo, mfaRepo, nil
+}
+
+// checkMFACode checks a code from the user's authenticator app, or one of their recovery codes
+// Codes can only be used once
+func (p *portalProxy) checkMFACode(mfaRepo mfa.Repository, enrolment *interfaces.LocalUserMFA, code string) (bool, error) {
+	if step, ok := totp.Validate(enrolment.Secret, code, time.Now()); ok {
+		return mfaRepo.UpdateLastUsedStep(enrolment.UserGUID, step)
+	}
+
+	if !enrolment.Enabled || len(strings.TrimSpace(code)) == 0 {
+		return false, nil
+	}
+	used, err := mfaRepo.UseRecoveryCode(enrolment.UserGUID, hashRecoveryCode(code))
+	if used {
+		log.Infof("User %s has logged in with a recovery code", enrolment.UserGUID)
+	}
+	return used, err
+}
+
+// checkMFAEnrolment only lets users that must enrol in MFA do so
+// The session value is cleared once the user has enrolled
+func (p *portalProxy) checkMFAEnrolment(c echo.Context, userGUID string) error {
+	if required, err := p.GetSessionValue(c, mfaEnrolmentRequiredKey); err != nil || required != true {
+		return nil
+	}
+
+	mfaRepo, err := mfa.NewPgsqlMFARepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+	if enrolment, err := mfaRepo.Find(userGUID, p.Config.EncryptionKeyInBytes); err == nil && enrolment != nil && enrolment.Enabled {
+		if err := p.unsetSessionValue(c, mfaEnrolmentRequiredKey); err != nil {
+			log.Warnf("Unable to update session: %v", err)
+		}
+		return nil
+	}
+
+	// Users may also have to change their password first
+	if isPathAllowed(c, sessionVerifyRoute, infoRoute, mfaRoute, mfaEnrolRoute, mfaVerifyRoute) || isOwnUserPathAllowed(c, userGUID) {
+		return nil
+	}
+
+	return interfaces.NewHTTPShadowError(
+		http.StatusForbidden,
+		"You must set up two-factor authentication before you can continue",
+		"User %s must enrol in MFA", userGUID)
+}
+
+// readMFACode reads the code from the body of a request
+func readMFACode(c echo.Context) (string, error) {
+	request := &mfaCodeRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil || len(strings.TrimSpace(request.Code)) == 0 {
+		return "", interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"A verification code is needed",
+			"Unable to read MFA code from request: %v", err)
+	}
+	return request.Code, nil
+}
+
+// findEnabledMFA returns the second factor of the current user, who must have enrolled
+func (p *portalProxy) findEnabledMFA(mfaRepo mfa.Repository, userGUID string) (*interfaces.LocalUserMFA, error) {
+	enrolment, err := mfaRepo.Find(userGUID, p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to find two-factor authentication settings",
+			"Unable to find MFA of user %s: %v", userGUID, err)
+	} else if enrolment == nil || !enrolment.Enabled {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Two-factor authentication is not enabled",
+			"MFA is not enabled for user %s", userGUID)
+	}
+	return enrolment, nil
+}
+
+// checkMFARequestCode checks the code in the body of a request by the current user
+func (p *portalProxy) checkMFARequestCode(c echo.Context, mfaRepo mfa.Repository, enrolment *interfaces.LocalUserMFA) error {
+	code, err := readMFACode(c)
+	if err != nil {
+		return err
+	}
+
+	ok, err := p.checkMFACode(mfaRepo, enrolment, code)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check verification code",
+			"Unable to check MFA code of user %s: %v", enrolment.UserGUID, err)
+	} else if !ok {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid verification code",
+			"Invalid MFA code for user %s", enrolment.UserGUID)
+	}
+	return nil
+}
+
+// loginMFA is the second step of a login for local users that have enrolled in MFA
+func (p *portalProxy) loginMFA(c echo.Context) error {
+	auth, ok := p.StratosAuthService.(*localAuth)
+	if !ok {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Two-factor authentication is only available for local users",
+			"MFA login requested when auth is not local")
+	}
+	return auth.VerifyMFA(c)
+}
+
+// getMFAStatus returns whether the current user has enrolled in MFA
+func (p *portalProxy) getMFAStatus(c echo.Context) error {
+	log.Debug("getMFAStatus")
+	localUsersRepo, mfaRepo, err := p.getMFARepositories()
+	if err != nil {
+		return err
+	}
+
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	user, err := findLocalUserDetails(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+
+	status := &mfaStatus{}
+	if status.Required, err = p.isMFARequired(user); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check if two-factor authentication is required",
+			"Unable to check if MFA is required: %v", err)
+	}
+
+	enrolment, err := mfaRepo.Find(userGUID, p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to find two-factor authentication settings",
+			"Unable to find MFA of user %s: %v", userGUID, err)
+	}
+	if enrolment != nil && enrolment.Enabled {
+		status.Enabled = true
+		if status.RecoveryCodesRemaining, err = mfaRepo.CountRecoveryCodes(userGUID); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusInternalServerError,
+				"Unable to count recovery codes",
+				"Unable to count recovery codes of user %s: %v", userGUID, err)
+		}
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// enrolMFA starts enrolment of the current user with a new secret for their authenticator app
+func (p *portalProxy) enrolMFA(c echo.Context) error {
+	log.Debug("enrolMFA")
+	localUsersRepo, mfaRepo, err := p.getMFARepositories()
+	if err != nil {
+		return err
+	}
+
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	user, err := findLocalUserDetails(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+
+	enrolment, err := mfaRepo.Find(userGUID, p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to find two-factor authentication settings",
+			"Unable to find MFA of user %s: %v", userGUID, err)
+	} else if enrolment != nil && enrolment.Enabled {
+		return interfaces.NewHTTPShadowError(
+			http.StatusConflict,
+			"Two-factor authentication is already enabled",
+			"MFA is already enabled for user %s", userGUID)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return err
+	}
+	if err := mfaRepo.Save(userGUID, secret, p.Config.EncryptionKeyInBytes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to set up two-factor authentication",
+			"Unable to save MFA of user %s: %v", userGUID, err)
+	}
+
+	return c.JSON(http.StatusOK, &mfaEnrolment{
+		Secret: secret,
+		URI:    totp.ProvisioningURI(mfaIssuer, user.Username, secret),
+	})
+}
+
+// verifyMFAEnrolment completes enrolment once the user has entered a code from their authenticator app
+// Returns the recovery codes of the user
+func (p *portalProxy) verifyMFAEnrolment(c echo.Context) error {
+	log.Debug("verifyMFAEnrolment")
+	_, mfaRepo, err := p.getMFARepositories()
+	if err != nil {
+		return err
+	}
+
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	setAuditDetails(c, fmt.Sprintf("user=%s", userGUID))
+
+	enrolment, err := mfaRepo.Find(userGUID, p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to find two-factor authentication settings",
+			"Unable to find MFA of user %s: %v", userGUID, err)
+	} else if enrolment == nil || enrolment.Enabled {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Two-factor authentication set up has not been started",
+			"MFA enrolment of user %s has not been started", userGUID)
+	}
+
+	if err := p.checkMFARequestCode(c, mfaRepo, enrolment); err != nil {
+		return err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := mfaRepo.Enable(userGUID, hashes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to enable two-factor authentication",
+			"Unable to enable MFA of user %s: %v", userGUID, err)
+	}
+
+	if err := p.unsetSessionValue(c, mfaEnrolmentRequiredKey); err != nil {
+		log.Warnf("Unable to update session: %v", err)
+	}
+	return c.JSON(http.StatusOK, &mfaRecoveryCodes{RecoveryCodes: codes})
+}
+
+// regenerateMFARecoveryCodes replaces the recovery codes of the current user
+func (p *portalProxy) regenerateMFARecoveryCodes(c echo.Context) error {
+	log.Debug("regenerateMFARecoveryCodes")
+	_, mfaRepo, err := p.getMFARepositories()
+	if err != nil {
+		return err
+	}
+
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	setAuditDetails(c, fmt.Sprintf("user=%s", userGUID))
+
+	enrolment, err := p.findEnabledMFA(mfaRepo, userGUID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkMFARequestCode(c, mfaRepo, enrolment); err != nil {
+		return err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := mfaRepo.SetRecoveryCodes(userGUID, hashes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to create recovery codes",
+			"Unable to save recovery codes of user %s: %v", userGUID, err)
+	}
+	return c.JSON(http.StatusOK, &mfaRecoveryCodes{RecoveryCodes: codes})
+}
+
+// disableMFA removes the second factor of the current user, as long as they don't have to use one
+func (p *portalProxy) disableMFA(c echo.Context) error {
+	log.Debug("disableMFA")
+	localUsersRepo, mfaRepo, err := p.getMFARepositories()
+	if err != nil {
+		return err
+	}
+
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	setAuditDetails(c, fmt.Sprintf("user=%s", userGUID))
+
+	user, err := findLocalUserDetails(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+	if required, err := p.isMFARequired(user); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check if two-factor authentication is required",
+			"Unable to check if MFA is required: %v", err)
+	} else if required {
+		return interfaces.NewHTTPShadowError(
+			http.StatusForbidden,
+			"Two-factor authentication is required for admins and can not be turned off",
+			"User %s tried to disable required MFA", userGUID)
+	}
+
+	enrolment, err := p.findEnabledMFA(mfaRepo, userGUID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkMFARequestCode(c, mfaRepo, enrolment); err != nil {
+		return err
+	}
+
+	if err := mfaRepo.Delete(userGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to turn off two-factor authentication",
+			"Unable to delete MFA of user %s: %v", userGUID, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// resetLocalUserMFA removes the second factor of any local user, for users that have lost their authenticator and recovery codes
+func (p *portalProxy) resetLocalUserMFA(c echo.Context) error {
+	log.Debug("resetLocalUserMFA")
+	localUsersRepo, mfaRepo, err := p.getMFARepositories()
+	if err != nil {
+		return err
+	}
+
+	userGUID := c.Param("user")
+	setAuditDetails(c, fmt.Sprintf("user=%s", userGUID))
+	user, err := findLocalUserDetails(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+
+	// Otherwise user managers could remove the second factor of console admins
+	if err := p.checkLocalUserPermissionsChange(c, userGUID, user); err != nil {
+		return err
+	}
+
+	if err := mfaRepo.Delete(userGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to reset two-factor authentication",
+			"Unable to delete MFA of user %s: %v", userGUID, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getMFASettings returns whether console admins must use MFA
+func (p *portalProxy) getMFASettings(c echo.Context) error {
+	log.Debug("getMFASettings")
+	required, err := p.mfaRequiredForAdmins()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check if two-factor authentication is required",
+			"Unable to check if MFA is required: %v", err)
+	}
+	return c.JSON(http.StatusOK, &mfaSettings{RequiredForAdmins: required})
+}
+
+// setMFASettings sets whether console admins must use MFA - admins without it must enrol when they next log in
+func (p *portalProxy) setMFASettings(c echo.Context) error {
+	log.Debug("setMFASettings")
+	settings := &mfaSettings{}
+	if err := json.NewDecoder(c.Request().Body).Decode(settings); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid two-factor authentication settings",
+			"Unable to parse MFA settings: %v", err)
+	}
+	setAuditDetails(c, fmt.Sprintf("required_for_admins=%t", settings.RequiredForAdmins))
+
+	configRepo, err := console_config.NewPostgresConsoleConfigRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	if settings.RequiredForAdmins {
+		err = configRepo.SetValue(mfaConfigGroup, mfaRequiredForAdminsName, "true")
+	} else {
+		err = configRepo.DeleteValue(mfaConfigGroup, mfaRequiredForAdminsName)
+	}
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update two-factor authentication settings",
+			"Unable to update MFA settings: %v", err)
+	}
+	return c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/totp"
+)
+
+const (
+	updateMFALastUsedStep = `UPDATE local_users_mfa SET last_used_step = (.+)`
+	deleteRecoveryCode    = `DELETE FROM local_users_recovery_codes WHERE user_guid = (.+) AND code_hash = (.+)`
+)
+
+func TestLocalLoginWithMFA(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given a local user that has enrolled in MFA", t, func() {
+
+		username := "localuser"
+		password := "localuserpass"
+		scope := "stratos.admin"
+		secret := "JBSWY3DPEHPK3PXP"
+		userGUID := uuid.NewV4().String()
+		passwordHash, _ := crypto.HashPassword(password)
+		encryptedSecret, _ := crypto.EncryptToken(mockEncryptionKey, secret)
+
+		Convey("logging in with their password should wait for a code", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"username": username,
+				"password": password,
+			})
+			res, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+
+			mock.ExpectQuery(findUserGUID).WithArgs(username).WillReturnRows(sqlmock.NewRows([]string{"user_guid"}).AddRow(userGUID))
+			mock.ExpectQuery(findUser).WithArgs(userGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil))
+			mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash))
+			mock.ExpectQuery(findUserScope).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows([]string{"scope"}).AddRow(scope))
+			mock.ExpectQuery(findMFA).WithArgs(userGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForMFA).AddRow(userGUID, encryptedSecret, true, 0, time.Now()))
+
+			So(pp.StratosAuthService.Login(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(res.Body.String(), ShouldContainSubstring, `"mfa_required":true`)
+
+			_, err := pp.GetSessionValue(ctx, "user_id")
+			So(err, ShouldNotBeNil)
+			pendingGUID, err := pp.GetSessionStringValue(ctx, mfaPendingUserKey)
+			So(err, ShouldBeNil)
+			So(pendingGUID, ShouldEqual, userGUID)
+		})
+
+		Convey("entering a code", func() {
+			code, _ := totp.Code(secret, totp.Step(time.Now()))
+			req := setupMockReq("POST", "", map[string]string{
+				"code": code,
+			})
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+
+			Convey("should fail if the password has not been entered", func() {
+				err := pp.loginMFA(ctx)
+				So(err, ShouldNotBeNil)
+				So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusUnauthorized)
+			})
+
+			Convey("should log in the user if the code is valid", func() {
+				So(pp.setSessionValues(ctx, map[string]interface{}{
+					mfaPendingUserKey:   userGUID,
+					mfaPendingExpiryKey: time.Now().Add(time.Minute).Unix(),
+				}), ShouldBeNil)
+
+				mock.ExpectQuery(findUser).WithArgs(userGUID).
+					WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil))
+				mock.ExpectQuery(findMFA).WithArgs(userGUID).
+					WillReturnRows(sqlmock.NewRows(rowFieldsForMFA).AddRow(userGUID, encryptedSecret, true, 0, time.Now()))
+				mock.ExpectExec(updateMFALastUsedStep).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(updateLastLoginTime).WillReturnResult(sqlmock.NewResult(1, 1))
+
+				So(pp.loginMFA(ctx), ShouldBeNil)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+				userID, err := pp.GetSessionStringValue(ctx, "user_id")
+				So(err, ShouldBeNil)
+				So(userID, ShouldEqual, userGUID)
+				_, err = pp.GetSessionValue(ctx, mfaPendingUserKey)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("should not accept a code that has already been used", func() {
+				So(pp.setSessionValues(ctx, map[string]interface{}{
+					mfaPendingUserKey:   userGUID,
+					mfaPendingExpiryKey: time.Now().Add(time.Minute).Unix(),
+				}), ShouldBeNil)
+
+				mock.ExpectQuery(findUser).WithArgs(userGUID).
+					WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil))
+				mock.ExpectQuery(findMFA).WithArgs(userGUID).
+					WillReturnRows(sqlmock.NewRows(rowFieldsForMFA).AddRow(userGUID, encryptedSecret, true, totp.Step(time.Now())+1, time.Now()))
+				mock.ExpectExec(updateMFALastUsedStep).WillReturnResult(sqlmock.NewResult(0, 0))
+
+				err := pp.loginMFA(ctx)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Invalid verification code")
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+
+			Convey("should make the user log in again after too many invalid codes", func() {
+				So(pp.setSessionValues(ctx, map[string]interface{}{
+					mfaPendingUserKey:     userGUID,
+					mfaPendingExpiryKey:   time.Now().Add(time.Minute).Unix(),
+					mfaPendingFailuresKey: int64(mfaMaxPendingFailures - 1),
+				}), ShouldBeNil)
+
+				mock.ExpectQuery(findUser).WithArgs(userGUID).
+					WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil))
+				mock.ExpectQuery(findMFA).WithArgs(userGUID).
+					WillReturnRows(sqlmock.NewRows(rowFieldsForMFA).AddRow(userGUID, encryptedSecret, true, totp.Step(time.Now())+1, time.Now()))
+				mock.ExpectExec(updateMFALastUsedStep).WillReturnResult(sqlmock.NewResult(0, 0))
+
+				err := pp.loginMFA(ctx)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Too many invalid verification codes")
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+				_, err = pp.GetSessionValue(ctx, mfaPendingUserKey)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("should fail if the login has expired", func() {
+				So(pp.setSessionValues(ctx, map[string]interface{}{
+					mfaPendingUserKey:   userGUID,
+					mfaPendingExpiryKey: time.Now().Add(-time.Minute).Unix(),
+				}), ShouldBeNil)
+
+				err := pp.loginMFA(ctx)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Login has expired")
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("entering a recovery code should log in the user", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"code": "abcde-fghjk",
+			})
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+			So(pp.setSessionValues(ctx, map[string]interface{}{
+				mfaPendingUserKey:   userGUID,
+				mfaPendingExpiryKey: time.Now().Add(time.Minute).Unix(),
+			}), ShouldBeNil)
+
+			mock.ExpectQuery(findUser).WithArgs(userGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil))
+			mock.ExpectQuery(findMFA).WithArgs(userGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForMFA).AddRow(userGUID, encryptedSecret, true, 0, time.Now()))
+			mock.ExpectExec(deleteRecoveryCode).WithArgs(userGUID, hashRecoveryCode("abcde-fghjk")).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(updateLastLoginTime).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.loginMFA(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+func TestLocalLoginWithRequiredMFA(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given admins must use MFA", t, func() {
+
+		username := "localuser"
+		password := "localuserpass"
+		scope := "stratos.admin"
+		userGUID := uuid.NewV4().String()
+		passwordHash, _ := crypto.HashPassword(password)
+
+		req := setupMockReq("POST", "", map[string]string{
+			"username": username,
+			"password": password,
+		})
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+		So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+
+		Convey("an admin that has not enrolled should log in, but only be able to enrol", func() {
+			mock.ExpectQuery(findUserGUID).WithArgs(username).WillReturnRows(sqlmock.NewRows([]string{"user_guid"}).AddRow(userGUID))
+			mock.ExpectQuery(findUser).WithArgs(userGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false, false, nil, 0, nil))
+			mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash))
+			mock.ExpectQuery(findUserScope).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows([]string{"scope"}).AddRow(scope))
+			mock.ExpectQuery(findMFA).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows(rowFieldsForMFA))
+			mock.ExpectQuery(findMFARequired).
+				WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}).AddRow(mfaRequiredForAdminsName, "true", time.Now()))
+			mock.ExpectExec(updateLastLoginTime).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.StratosAuthService.Login(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(res.Body.String(), ShouldContainSubstring, `"mfa_enrolment_required":true`)
+
+			required, err := pp.GetSessionValue(ctx, mfaEnrolmentRequiredKey)
+			So(err, ShouldBeNil)
+			So(required, ShouldEqual, true)
+
+			Convey("and other requests should be refused until they enrol", func() {
+				mock.ExpectQuery(findMFA).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows(rowFieldsForMFA))
+				ctx.SetPath("/pp/v1/cnsis")
+
+				err := pp.checkMFAEnrolment(ctx, userGUID)
+				So(err, ShouldNotBeNil)
+				So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusForbidden)
+			})
+
+			Convey("and other routes that end with an allowed path should be refused", func() {
+				mock.ExpectQuery(findMFA).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows(rowFieldsForMFA))
+				ctx.SetPath("/pp/v1/proxy/*")
+				ctx.Request().URL.Path = "/pp/v1/proxy/v2/info"
+
+				err := pp.checkMFAEnrolment(ctx, userGUID)
+				So(err, ShouldNotBeNil)
+				So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusForbidden)
+			})
+
+			Convey("but they should be able to enrol and change their own password", func() {
+				mock.ExpectQuery(findMFA).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows(rowFieldsForMFA))
+				ctx.SetPath("/pp/v1/mfa/enrol")
+				So(pp.checkMFAEnrolment(ctx, userGUID), ShouldBeNil)
+
+				mock.ExpectQuery(findMFA).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows(rowFieldsForMFA))
+				ctx.SetPath("/pp/v1/users/:id/password")
+				ctx.SetParamNames("id")
+				ctx.SetParamValues(userGUID)
+				So(pp.checkMFAEnrolment(ctx, userGUID), ShouldBeNil)
+
+				mock.ExpectQuery(findMFA).WithArgs(userGUID).WillReturnRows(sqlmock.NewRows(rowFieldsForMFA))
+				ctx.SetParamValues("another-user")
+				So(pp.checkMFAEnrolment(ctx, userGUID), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestMFARequiredThroughRoleMapping(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given admins must use MFA", t, func() {
+		_, _, _, pp, db, mock := setupHTTPTest(setupMockReq("GET", "", nil))
+		defer db.Close()
+
+		Convey("users that are console admins through a role mapping must use MFA", func() {
+			pp.RoleMappings = newRoleMappings(interfaces.RoleAdmin+"=stratos.ops", noRBACRoles)
+			mock.ExpectQuery(findMFARequired).
+				WillReturnRows(sqlmock.NewRows([]string{"name", "value", "last_updated"}).AddRow(mfaRequiredForAdminsName, "true", time.Now()))
+
+			required, err := pp.isMFARequired(interfaces.LocalUser{Scope: "stratos.ops"})
+			So(err, ShouldBeNil)
+			So(required, ShouldBeTrue)
+		})
+
+		Convey("other users do not have to use MFA", func() {
+			required, err := pp.isMFARequired(interfaces.LocalUser{Scope: "stratos.ops"})
+			So(err, ShouldBeNil)
+			So(required, ShouldBeFalse)
+		})
+	})
+}
+
+func TestResetLocalUserMFA(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given a user manager that is not a console admin", t, func() {
+		_, _, ctx, pp, db, mock := setupHTTPTest(setupMockReq("DELETE", "", nil))
+		defer db.Close()
+		pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+		So(pp.InitStratosAuthService(interfaces.AuthEndpointTypes[pp.Config.ConsoleConfig.AuthEndpointType]), ShouldBeNil)
+		pp.RoleMappings = newRoleMappings("user-manager=stratos.users", "")
+		ctx.Set("user_id", mockUserGUID)
+		ctx.SetParamNames("user")
+		ctx.SetParamValues("admin-guid")
+
+		Convey("they should not be able to remove the MFA of a console admin", func() {
+			mock.ExpectQuery(findUser).WithArgs("admin-guid").
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", "stratos.admin", "", "", false, false, nil, 0, nil))
+			mock.ExpectQuery(findUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("manager", "", "stratos.users", "", "", false, false, nil, 0, nil))
+
+			err := pp.resetLocalUserMFA(ctx)
+			So(err, ShouldNotBeNil)
+			So(getAuditStatusCode(ctx, err), ShouldEqual, http.StatusForbidden)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Default number of seconds that failed logins are counted over
+const defaultLoginThrottleWindow = 60
+
+// loginFailures counts the failed logins from an IP address or for a username in the current window
+type loginFailures struct {
+	Count       int
+	WindowStart time.Time
+}
+
+// loginThrottle slows down password guessing by refusing logins from IP addresses and for usernames
+// with too many recent failed logins
+type loginThrottle struct {
+	sync.Mutex
+	maxPerIP   int
+	maxPerUser int
+	window     time.Duration
+	failures   map[string]*loginFailures
+	lastSweep  time.Time
+}
+
+// newLoginThrottle creates the login throttle - returns nil if neither limit is configured
+func newLoginThrottle(maxPerIP, maxPerUser, windowInSecs int64) *loginThrottle {
+	if maxPerIP <= 0 && maxPerUser <= 0 {
+		return nil
+	}
+
+	if windowInSecs <= 0 {
+		windowInSecs = defaultLoginThrottleWindow
+	}
+
+	return &loginThrottle{
+		maxPerIP:   int(maxPerIP),
+		maxPerUser: int(maxPerUser),
+		window:     time.Duration(windowInSecs) * time.Second,
+		failures:   make(map[string]*loginFailures),
+		lastSweep:  time.Now(),
+	}
+}
+
+// keys returns the keys that failed logins of the request are counted against, along with their limits
+func (lt *loginThrottle) keys(ip, username string) map[string]int {
+	keys := make(map[string]int)
+	if lt.maxPerIP > 0 && len(ip) > 0 {
+		keys["ip:"+ip] = lt.maxPerIP
+	}
+	if lt.maxPerUser > 0 && len(username) > 0 {
+		keys["user:"+strings.ToLower(username)] = lt.maxPerUser
+	}
+	return keys
+}
+
+// Caller must hold the lock
+func (lt *loginThrottle) get(key string, now time.Time) *loginFailures {
+	failures, ok := lt.failures[key]
+	if !ok || now.Sub(failures.WindowStart) >= lt.window {
+		return nil
+	}
+	return failures
+}
+
+// loginReservation is a login attempt that has been counted as a failure until it is known not to have failed
+type loginReservation struct {
+	username string
+	// The windows that the attempt was counted in, by key
+	windows map[string]time.Time
+	// The keys that reached their limit with this attempt
+	throttled []string
+}
+
+// reserve counts a login attempt for the username from the IP address as a failure, unless it would exceed a limit,
+// in which case it returns how long until a login is allowed
+// Checking and counting under the same lock stops logins made at the same time from getting past the limit
+func (lt *loginThrottle) reserve(ip, username string) (*loginReservation, time.Duration) {
+	lt.Lock()
+	defer lt.Unlock()
+
+	now := time.Now()
+	lt.sweep(now)
+
+	keys := lt.keys(ip, username)
+	var wait time.Duration
+	for key, limit := range keys {
+		if failures := lt.get(key, now); failures != nil && failures.Count >= limit {
+			if remaining := failures.WindowStart.Add(lt.window).Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	if wait > 0 {
+		return nil, wait
+	}
+
+	reservation := &loginReservation{
+		username:  username,
+		windows:   make(map[string]time.Time),
+		throttled: make([]string, 0),
+	}
+	for key, limit := range keys {
+		failures := lt.get(key, now)
+		if failures == nil {
+			failures = &loginFailures{WindowStart: now}
+			lt.failures[key] = failures
+		}
+		failures.Count++
+		reservation.windows[key] = failures.WindowStart
+		if failures.Count == limit {
+			reservation.throttled = append(reservation.throttled, key)
+		}
+	}
+	return reservation, 0
+}
+
+// release uncounts a login attempt that did not fail
+func (lt *loginThrottle) release(reservation *loginReservation) {
+	lt.Lock()
+	defer lt.Unlock()
+	lt.uncount(reservation)
+}
+
+// succeeded forgets the failed logins for the username, so that users that remember their password aren't held up
+func (lt *loginThrottle) succeeded(reservation *loginReservation) {
+	lt.Lock()
+	defer lt.Unlock()
+	lt.uncount(reservation)
+	delete(lt.failures, "user:"+strings.ToLower(reservation.username))
+}
+
+// uncount removes the attempt from the windows it was counted in, unless they have since passed - caller must hold the lock
+func (lt *loginThrottle) uncount(reservation *loginReservation) {
+	for key, windowStart := range reservation.windows {
+		if failures, ok := lt.failures[key]; ok && failures.WindowStart.Equal(windowStart) && failures.Count > 0 {
+			failures.Count--
+		}
+	}
+}
+
+// sweep removes failures from previous windows - caller must hold the lock
+func (lt *loginThrottle) sweep(now time.Time) {
+	if now.Sub(lt.lastSweep) < lt.window {
+		return
+	}
+	for key, failures := range lt.failures {
+		if now.Sub(failures.WindowStart) >= lt.window {
+			delete(lt.failures, key)
+		}
+	}
+	lt.lastSweep = now
+}
+
+// loginThrottleMiddleware refuses logins from IP addresses and for usernames with too many recent failed logins
+func (p *portalProxy) loginThrottleMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if p.LoginThrottle == nil {
+			return h(c)
+		}
+
+		ip := p.clientIP(c)
+		username := c.FormValue("username")
+		reservation, wait := p.LoginThrottle.reserve(ip, username)
+		if reservation == nil {
+			c.Response().Header().Set("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(wait.Seconds()))))
+			return interfaces.NewHTTPShadowError(
+				http.StatusTooManyRequests,
+				"Too many failed logins, try again later",
+				"Login for %s from %s has been throttled", username, ip)
+		}
+
+		err := h(c)
+		status := getAuditStatusCode(c, err)
+		switch {
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			for _, key := range reservation.throttled {
+				log.Warnf("Logins for %s have been throttled after too many failures", key)
+				setAuditDetails(c, fmt.Sprintf("throttled=%s username=%s", key, username))
+				p.recordAuditEvent(c, interfaces.AuditLoginThrottle, "", err)
+			}
+		case err == nil:
+			p.LoginThrottle.succeeded(reservation)
+		default:
+			p.LoginThrottle.release(reservation)
+		}
+		return err
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestLoginThrottleConfig(t *testing.T) {
+	t.Parallel()
+
+	Convey("The login throttle should be disabled when not configured", t, func() {
+		So(newLoginThrottle(0, 0, 0), ShouldBeNil)
+	})
+
+	Convey("The login throttle should use the default window", t, func() {
+		lt := newLoginThrottle(10, 0, 0)
+		So(lt, ShouldNotBeNil)
+		So(lt.window, ShouldEqual, defaultLoginThrottleWindow*time.Second)
+	})
+}
+
+func TestLoginThrottle(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given logins are throttled per IP address and username", t, func() {
+		lt := newLoginThrottle(3, 2, 60)
+
+		reserve := func(ip, username string) time.Duration {
+			_, wait := lt.reserve(ip, username)
+			return wait
+		}
+
+		Convey("logins for a username should be refused after too many failures", func() {
+			reservation, wait := lt.reserve("10.0.0.1", "admin")
+			So(wait, ShouldEqual, 0)
+			So(reservation.throttled, ShouldBeEmpty)
+			reservation, wait = lt.reserve("10.0.0.2", "Admin")
+			So(wait, ShouldEqual, 0)
+			So(reservation.throttled, ShouldResemble, []string{"user:admin"})
+			So(reserve("10.0.0.3", "admin"), ShouldBeGreaterThan, 0)
+			So(reserve("10.0.0.3", "other"), ShouldEqual, 0)
+
+			Convey("and allowed again once the window has passed", func() {
+				lt.failures["user:admin"].WindowStart = time.Now().Add(-2 * time.Minute)
+				So(reserve("10.0.0.3", "admin"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("logins from an IP address should be refused after too many failures", func() {
+			reserve("10.0.0.1", "one")
+			reserve("10.0.0.1", "two")
+			reservation, _ := lt.reserve("10.0.0.1", "three")
+			So(reservation.throttled, ShouldResemble, []string{"ip:10.0.0.1"})
+			So(reserve("10.0.0.1", "four"), ShouldBeGreaterThan, 0)
+			So(reserve("10.0.0.2", "four"), ShouldEqual, 0)
+		})
+
+		Convey("logins made at the same time should not get past the limit", func() {
+			allowed := make(chan bool, 10)
+			for i := 0; i < 10; i++ {
+				go func() {
+					_, wait := lt.reserve("10.0.0.1", "admin")
+					allowed <- wait == 0
+				}()
+			}
+			count := 0
+			for i := 0; i < 10; i++ {
+				if <-allowed {
+					count++
+				}
+			}
+			So(count, ShouldEqual, 2)
+		})
+
+		Convey("logins that did not fail should not count", func() {
+			reservation, _ := lt.reserve("10.0.0.1", "admin")
+			lt.release(reservation)
+			reserve("10.0.0.1", "admin")
+			So(reserve("10.0.0.1", "admin"), ShouldEqual, 0)
+		})
+
+		Convey("a successful login should reset the failures of the username", func() {
+			reserve("10.0.0.1", "admin")
+			reservation, _ := lt.reserve("10.0.0.1", "admin")
+			lt.succeeded(reservation)
+			reservation, wait := lt.reserve("10.0.0.1", "admin")
+			So(wait, ShouldEqual, 0)
+			So(reservation.throttled, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestLoginThrottleMiddleware(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given the login throttle middleware", t, func() {
+		req := setupMockReq("POST", "", map[string]string{"username": "admin", "password": "wrong"})
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.LoginThrottle = newLoginThrottle(0, 1, 60)
+
+		failedLogin := pp.loginThrottleMiddleware(func(c echo.Context) error {
+			return interfaces.NewHTTPShadowError(http.StatusUnauthorized, "Access Denied", "Access Denied")
+		})
+
+		Convey("logins should be refused once the limit has been reached", func() {
+			mock.ExpectExec(insertIntoAuditLog).WillReturnResult(sqlmock.NewResult(1, 1))
+			So(failedLogin(ctx), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+			err := failedLogin(ctx)
+			shadowErr, ok := err.(interfaces.ErrHTTPShadow)
+			So(ok, ShouldBeTrue)
+			So(shadowErr.HTTPError.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(res.Header().Get("Retry-After"), ShouldNotBeEmpty)
+		})
+	})
+}
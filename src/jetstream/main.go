@@ -35,19 +35,26 @@ import (
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/userfavorites/userfavoritesstore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/apitokens"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/audit"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/console_config"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/health"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces/config"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/locks"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/mfa"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/secrets"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/usersessions"
 )
 
 // TimeoutBoundary represents the amount of time we'll wait for the database
 // server to come online before we bail out.
 const (
 	TimeoutBoundary      = 10
-	SessionExpiry        = 20 * 60 // Session cookies expire after 20 minutes
 	UpgradeVolume        = "UPGRADE_VOLUME"
 	UpgradeLockFileName  = "UPGRADE_LOCK_FILENAME"
 	LogToJSON            = "LOG_TO_JSON"
@@ -176,6 +183,16 @@ func main() {
 	}
 	log.Info("Encryption key set.")
 
+	// Previous keys are still needed to decrypt the tokens and secrets that have not been re-encrypted with the current key
+	previousEncryptionKeys, err := getPreviousEncryptionKeys(portalConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	crypto.SetDecryptionKeys(previousEncryptionKeys...)
+	if len(previousEncryptionKeys) > 0 {
+		log.Infof("%d previous encryption key(s) set.", len(previousEncryptionKeys))
+	}
+
 	// Load database configuration
 	var dc datastore.DatabaseConfig
 	dc, err = loadDatabaseConfig(dc, envLookup)
@@ -190,6 +207,21 @@ func main() {
 	tokens.InitRepositoryProvider(dc.DatabaseProvider)
 	console_config.InitRepositoryProvider(dc.DatabaseProvider)
 	localusers.InitRepositoryProvider(dc.DatabaseProvider)
+	audit.InitRepositoryProvider(dc.DatabaseProvider)
+	locks.InitRepositoryProvider(dc.DatabaseProvider)
+	health.InitRepositoryProvider(dc.DatabaseProvider)
+	usersessions.InitRepositoryProvider(dc.DatabaseProvider)
+	apitokens.InitRepositoryProvider(dc.DatabaseProvider)
+	mfa.InitRepositoryProvider(dc.DatabaseProvider)
+
+	// Keep endpoint client secrets and tokens in an external secret store, if one has been configured
+	secretStore, err := getSecretStore(portalConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if secretStore != nil {
+		log.Infof("Secrets will be kept in the %s secret store.", portalConfig.SecretsBackend)
+	}
 
 	// Establish a Postgresql connection pool
 	var databaseConnectionPool *sql.DB
@@ -208,6 +240,12 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Run a backup or restore from the command line instead of the server
+	userfavoritesstore.InitRepositoryProvider(dc.DatabaseProvider)
+	if runBackupCommand(&portalProxy{Config: portalConfig, DatabaseConnectionPool: databaseConnectionPool, SecretStore: secretStore}) {
+		return
+	}
+
 	// Before any changes it, log that we detected a non-default session store secret, so we can tell it has been set from the log
 	if portalConfig.SessionStoreSecret != defaultSessionSecret {
 		log.Info("Session Store Secret detected okay")
@@ -226,7 +264,7 @@ func main() {
 	}
 
 	// Initialize session store for Gorilla sessions
-	sessionStore, sessionStoreOptions, err := initSessionStore(databaseConnectionPool, dc.DatabaseProvider, portalConfig, SessionExpiry, envLookup)
+	sessionStore, sessionStoreOptions, err := initSessionStore(databaseConnectionPool, dc.DatabaseProvider, portalConfig, getSessionIdleTimeout(portalConfig), envLookup)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -236,16 +274,24 @@ func main() {
 		sessionStore.Close()
 	}()
 
-	// Ensure the cleanup tick starts now (this will delete expired sessions from the DB)
-	quitCleanup, doneCleanup := sessionStore.Cleanup(time.Minute * 3)
-	defer func() {
-		log.Info(`... Cleaning up session store`)
-		sessionStore.StopCleanup(quitCleanup, doneCleanup)
-	}()
 	log.Info("Session store initialized.")
 
+	// Expose database and session store metrics
+	initTelemetry(databaseConnectionPool, dc.DatabaseProvider)
+
 	// Setup the global interface for the proxy
 	portalProxy := newPortalProxy(portalConfig, databaseConnectionPool, sessionStore, sessionStoreOptions, envLookup)
+	portalProxy.SecretStore = secretStore
+
+	// Elect a leader to run the background jobs that should only run on one instance
+	portalProxy.startLeaderElection()
+
+	// The leader deletes expired sessions from the DB
+	stopSessionCleanup := portalProxy.startSessionStoreCleanup(sessionStore)
+	defer func() {
+		log.Info(`... Cleaning up session store`)
+		stopSessionCleanup()
+	}()
 	log.Info("Initialization complete.")
 
 	c := make(chan os.Signal, 2)
@@ -293,6 +339,11 @@ func main() {
 	portalProxy.Plugins = initedPlugins
 	log.Info("Plugins initialized")
 
+	// Register the endpoints declared in the bootstrap file
+	if err := portalProxy.bootstrapEndpoints(); err != nil {
+		log.Fatalf("Unable to bootstrap endpoints: %v", err)
+	}
+
 	var needSetupMiddleware bool
 
 	// At this stage, all plugins have had a chance to modify configurtion based on hosting environment
@@ -309,6 +360,15 @@ func main() {
 	// Get Diagnostics and store them once - ensure this is done after plugins are loaded
 	portalProxy.StoreDiagnostics()
 
+	// Periodically remove audit events that are older than the retention period
+	portalProxy.startAuditLogCleanup()
+
+	// Refresh endpoint tokens in the background before they expire
+	portalProxy.startTokenRefresh()
+
+	// Check the health of the registered endpoints and record their status
+	portalProxy.startEndpointHealthCheck()
+
 	// Start the back-end
 	if err := start(portalProxy.Config, portalProxy, needSetupMiddleware, false); err != nil {
 		log.Fatalf("Unable to start: %v", err)
@@ -373,7 +433,7 @@ func showStratosConfig(config *interfaces.ConsoleConfig) {
 		if val == interfaces.Local {
 			log.Infof("... Local User              : %s", config.LocalUser)
 			log.Infof("... Local User Scope        : %s", config.LocalUserScope)
-		} else { //Auth type is set to remote
+		} else if val == interfaces.Remote {
 			log.Infof("... UAA Endpoint            : %s", config.UAAEndpoint)
 			log.Infof("... Authorization Endpoint  : %s", config.AuthorizationEndpoint)
 			log.Infof("... Console Client          : %s", config.ConsoleClient)
@@ -421,6 +481,24 @@ func getEncryptionKey(pc interfaces.PortalConfig) ([]byte, error) {
 	return key, nil
 }
 
+// getSecretStore returns the external secret store configured with SECRETS_BACKEND, or nil if secrets are kept in the database
+func getSecretStore(pc interfaces.PortalConfig) (secrets.Store, error) {
+	switch pc.SecretsBackend {
+	case "", "database":
+		return nil, nil
+	case "file":
+		return secrets.NewFileStore(pc.SecretsFileDir)
+	case "vault":
+		client := &httpClient
+		if pc.SecretsVaultSkipSSLValidation {
+			client = &httpClientSkipSSL
+		}
+		return secrets.NewVaultStore(client, pc.SecretsVaultAddress, pc.SecretsVaultToken, pc.SecretsVaultMount, pc.SecretsVaultPrefix)
+	default:
+		return nil, fmt.Errorf("Unknown secret store: %s", pc.SecretsBackend)
+	}
+}
+
 func initConnPool(dc datastore.DatabaseConfig, env *env.VarSet) (*sql.DB, error) {
 	log.Debug("initConnPool")
 
@@ -533,7 +611,7 @@ func loadPortalConfig(pc interfaces.PortalConfig, env *env.VarSet) (interfaces.P
 		if endpointTypeSupported {
 			pc.AuthEndpointType = string(val)
 		} else {
-			return pc, fmt.Errorf("AUTH_ENDPOINT_TYPE: %v is not valid. Must be set to local or remote (defaults to remote)", val)
+			return pc, fmt.Errorf("AUTH_ENDPOINT_TYPE: %v is not valid. Must be set to local, remote, oidc or ldap (defaults to remote)", val)
 		}
 	}
 
@@ -622,9 +700,23 @@ func newPortalProxy(pc interfaces.PortalConfig, dcp *sql.DB, ss HttpSessionStore
 		SessionStore:           ss,
 		SessionStoreOptions:    sessionStoreOptions,
 		SessionCookieName:      cookieName,
+		SessionCodecs:          newSessionCodecs(pc.SessionStoreSecret),
 		EmptyCookieMatcher:     regexp.MustCompile(cookieName + "=(?:;[ ]*|$)"),
 		AuthProviders:          make(map[string]interfaces.AuthProvider),
 		env:                    env,
+		ProxyCache:             newProxyCache(pc.ProxyCacheTTLs, pc.ProxyCacheMaxEntries),
+		CircuitBreakers:        newCircuitBreakers(pc.CircuitBreakerFailureThreshold, pc.CircuitBreakerResetTimeoutInSecs, pc.EndpointMaxConcurrentRequests),
+		InstanceID:             newInstanceID(),
+		Leader:                 &leaderElection{},
+		RoleMappings:           newRoleMappings(pc.RBACRoleMappings, pc.RBACDefaultRoles),
+		KeyRotation:            newKeyRotation(),
+		LoginThrottle:          newLoginThrottle(pc.LoginThrottleMaxPerIP, pc.LoginThrottleMaxPerUser, pc.LoginThrottleWindowInSecs),
+		TrustedProxies:         newTrustedProxies(pc.TrustedProxies),
+		APITokenRoutes:         make(map[string]string),
+	}
+
+	if pp.ProxyCache != nil {
+		log.Infof("Proxy response cache enabled: %s", strings.Join(pc.ProxyCacheTTLs, ","))
 	}
 
 	// Initialize built-in auth providers
@@ -813,6 +905,9 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 
 	staticDir, staticDirErr := getStaticFiles(p.Env().String("UI_PATH", "./ui"))
 
+	// Jetstream metrics in the Prometheus exposition format
+	e.GET("/metrics", p.metrics)
+
 	// Always serve the backend API from /pp
 	pp := e.Group("/pp")
 
@@ -822,11 +917,12 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	if needSetupMiddleware {
 		e.Use(p.SetupMiddleware())
 		pp.POST("/v1/setup/check", p.setupGetAvailableScopes)
-		pp.POST("/v1/setup/save", p.setupSaveConfig)
+		pp.POST("/v1/setup/save", p.setupSaveConfig, p.AuditMiddleware(interfaces.AuditSetupSave))
 	}
 
 	loginAuthGroup := pp.Group("/v1/auth")
-	loginAuthGroup.POST("/login/uaa", p.consoleLogin)
+	loginAuthGroup.POST("/login/uaa", p.consoleLogin, p.loginThrottleMiddleware)
+	loginAuthGroup.POST("/login/mfa", p.loginMFA, p.loginThrottleMiddleware)
 	loginAuthGroup.POST("/logout", p.consoleLogout)
 
 	// SSO Routes will only respond if SSO is enabled
@@ -843,6 +939,7 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	sessionGroup := pp.Group("/v1")
 	sessionGroup.Use(p.sessionMiddleware)
 	sessionGroup.Use(p.xsrfMiddleware)
+	sessionGroup.Use(p.endpointVisibilityMiddleware)
 
 	for _, plugin := range p.Plugins {
 		middlewarePlugin, err := plugin.GetMiddlewarePlugin()
@@ -856,20 +953,84 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	sessionAuthGroup := sessionGroup.Group("/auth")
 
 	// Connect to endpoint
-	sessionAuthGroup.POST("/login/cnsi", p.loginToCNSI)
+	sessionAuthGroup.POST("/login/cnsi", p.loginToCNSI, p.AuditMiddleware(interfaces.AuditEndpointConnect))
 
 	// Connect to Enpoint (SSO)
 	sessionAuthGroup.GET("/login/cnsi", p.ssoLoginToCNSI)
 
 	// Disconnect endpoint
-	sessionAuthGroup.POST("/logout/cnsi", p.logoutOfCNSI)
+	sessionAuthGroup.POST("/logout/cnsi", p.logoutOfCNSI, p.AuditMiddleware(interfaces.AuditEndpointDisconnect))
 
 	// Verify Session
 	sessionAuthGroup.GET("/session/verify", p.verifySession)
 
 	// CNSI operations
-	sessionGroup.GET("/cnsis", p.listCNSIs)
-	sessionGroup.GET("/cnsis/registered", p.listRegisteredCNSIs)
+	p.allowAPITokens(interfaces.PermissionEndpointsView, sessionGroup.GET("/cnsis", p.listCNSIs, p.RequirePermission(interfaces.PermissionEndpointsView)))
+	p.allowAPITokens(interfaces.PermissionEndpointsView, sessionGroup.GET("/cnsis/registered", p.listRegisteredCNSIs, p.RequirePermission(interfaces.PermissionEndpointsView)))
+
+	for _, plugin := range p.Plugins {
+		endpointPlugin, err := plugin.GetEndpointPlugin()
+		if err == nil {
+			// Plugin supports endpoint plugin
+			endpointType := endpointPlugin.GetType()
+			p.allowAPITokens(interfaces.PermissionEndpointsAdmin, sessionGroup.POST("/register/"+endpointType, endpointPlugin.Register, p.RequirePermission(interfaces.PermissionEndpointsAdmin), p.AuditMiddleware(interfaces.AuditEndpointRegister)))
+		}
+	}
+
+	p.allowAPITokens(interfaces.PermissionEndpointsAdmin, sessionGroup.POST("/unregister", p.unregisterCluster, p.RequirePermission(interfaces.PermissionEndpointsAdmin), p.AuditMiddleware(interfaces.AuditEndpointUnregister)))
+
+	p.allowAPITokens(interfaces.PermissionEndpointsAdmin, sessionGroup.PATCH("/endpoint/:id", p.updateEndpoint, p.RequirePermission(interfaces.PermissionEndpointsAdmin), p.AuditMiddleware(interfaces.AuditEndpointUpdate)))
+
+	// Endpoint health
+	p.allowAPITokens(interfaces.PermissionEndpointsView, sessionGroup.GET("/endpoints/health", p.listEndpointsHealth, p.RequirePermission(interfaces.PermissionEndpointsView)))
+
+	// Endpoint visibility
+	p.allowAPITokens(interfaces.PermissionEndpointsAdmin, sessionGroup.GET("/endpoints/:id/visibility", p.listEndpointVisibility, p.RequirePermission(interfaces.PermissionEndpointsAdmin)))
+	p.allowAPITokens(interfaces.PermissionEndpointsAdmin, sessionGroup.PUT("/endpoints/:id/visibility", p.setEndpointVisibility, p.RequirePermission(interfaces.PermissionEndpointsAdmin), p.AuditMiddleware(interfaces.AuditEndpointVisibility)))
+
+	// Backup and restore
+	p.allowAPITokens(interfaces.PermissionConsoleAdmin, sessionGroup.POST("/backup", p.backupState, p.RequirePermission(interfaces.PermissionConsoleAdmin), p.AuditMiddleware(interfaces.AuditBackupCreate)))
+	p.allowAPITokens(interfaces.PermissionConsoleAdmin, sessionGroup.POST("/restore", p.restoreState, p.RequirePermission(interfaces.PermissionConsoleAdmin), p.AuditMiddleware(interfaces.AuditBackupRestore)))
+
+	// Re-encrypt stored tokens and secrets with the current encryption key
+	p.allowAPITokens(interfaces.PermissionConsoleAdmin, sessionGroup.GET("/encryption/rotate", p.getKeyRotationStatus, p.RequirePermission(interfaces.PermissionConsoleAdmin)))
+	p.allowAPITokens(interfaces.PermissionConsoleAdmin, sessionGroup.POST("/encryption/rotate", p.startKeyRotation, p.RequirePermission(interfaces.PermissionConsoleAdmin), p.AuditMiddleware(interfaces.AuditEncryptionKeyRotate)))
+
+	// Sessions of the current user, and of any user for user managers
+	sessionGroup.GET("/sessions", p.listSessions)
+	sessionGroup.DELETE("/sessions/:session", p.revokeSession, p.AuditMiddleware(interfaces.AuditSessionRevoke))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.GET("/users/:user/sessions", p.listSessionsOfUser, p.RequirePermission(interfaces.PermissionUsersManage)))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.DELETE("/users/:user/sessions/:session", p.revokeSessionOfUser, p.RequirePermission(interfaces.PermissionUsersManage), p.AuditMiddleware(interfaces.AuditSessionRevoke)))
+
+	// Personal API tokens, and the kill switch for all API tokens
+	sessionGroup.GET("/api-tokens", p.listAPITokens)
+	sessionGroup.POST("/api-tokens", p.createAPIToken, p.AuditMiddleware(interfaces.AuditAPITokenCreate))
+	p.allowAPITokens(interfaces.PermissionConsoleAdmin, sessionGroup.GET("/api-tokens/status", p.getAPITokensStatus, p.RequirePermission(interfaces.PermissionConsoleAdmin)))
+	p.allowAPITokens(interfaces.PermissionConsoleAdmin, sessionGroup.PUT("/api-tokens/status", p.setAPITokensStatus, p.RequirePermission(interfaces.PermissionConsoleAdmin), p.AuditMiddleware(interfaces.AuditAPITokenStatus)))
+	sessionGroup.DELETE("/api-tokens/:token", p.revokeAPIToken, p.AuditMiddleware(interfaces.AuditAPITokenRevoke))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.GET("/users/:user/api-tokens", p.listAPITokensOfUser, p.RequirePermission(interfaces.PermissionUsersManage)))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.DELETE("/users/:user/api-tokens", p.revokeAPITokensOfUser, p.RequirePermission(interfaces.PermissionUsersManage), p.AuditMiddleware(interfaces.AuditAPITokenRevoke)))
+
+	// Local users - only available when the console uses local users
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.GET("/local-users", p.listLocalUsers, p.RequirePermission(interfaces.PermissionUsersManage)))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.POST("/local-users", p.createLocalUser, p.RequirePermission(interfaces.PermissionUsersManage), p.AuditMiddleware(interfaces.AuditLocalUserCreate)))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.GET("/local-users/:user", p.getLocalUser, p.RequirePermission(interfaces.PermissionUsersManage)))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.PATCH("/local-users/:user", p.updateLocalUser, p.RequirePermission(interfaces.PermissionUsersManage), p.AuditMiddleware(interfaces.AuditLocalUserUpdate)))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.PUT("/local-users/:user/password", p.resetLocalUserPassword, p.RequirePermission(interfaces.PermissionUsersManage), p.AuditMiddleware(interfaces.AuditLocalUserPassword)))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.DELETE("/local-users/:user", p.deleteLocalUser, p.RequirePermission(interfaces.PermissionUsersManage), p.AuditMiddleware(interfaces.AuditLocalUserDelete)))
+	p.allowAPITokens(interfaces.PermissionUsersManage, sessionGroup.DELETE("/local-users/:user/mfa", p.resetLocalUserMFA, p.RequirePermission(interfaces.PermissionUsersManage), p.AuditMiddleware(interfaces.AuditMFAReset)))
+
+	// Two-factor authentication for local users
+	sessionGroup.GET("/mfa", p.getMFAStatus)
+	sessionGroup.POST("/mfa/enrol", p.enrolMFA)
+	sessionGroup.POST("/mfa/verify", p.verifyMFAEnrolment, p.AuditMiddleware(interfaces.AuditMFAEnable))
+	sessionGroup.POST("/mfa/recovery-codes", p.regenerateMFARecoveryCodes, p.AuditMiddleware(interfaces.AuditMFARecoveryCodes))
+	sessionGroup.DELETE("/mfa", p.disableMFA, p.AuditMiddleware(interfaces.AuditMFADisable))
+	p.allowAPITokens(interfaces.PermissionConsoleAdmin, sessionGroup.GET("/mfa/settings", p.getMFASettings, p.RequirePermission(interfaces.PermissionConsoleAdmin)))
+	p.allowAPITokens(interfaces.PermissionConsoleAdmin, sessionGroup.PUT("/mfa/settings", p.setMFASettings, p.RequirePermission(interfaces.PermissionConsoleAdmin), p.AuditMiddleware(interfaces.AuditMFASettings)))
+
+	// Audit log
+	p.allowAPITokens(interfaces.PermissionAuditRead, sessionGroup.GET("/audit", p.listAuditEvents, p.RequirePermission(interfaces.PermissionAuditRead)))
 
 	// Info
 	sessionGroup.GET("/info", p.info)
@@ -883,9 +1044,7 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 		routePlugin.AddSessionGroupRoutes(sessionGroup)
 	}
 
-	// This is used for passthru of requests
-	group := sessionGroup.Group("/proxy")
-	group.Any("/*", p.proxy)
+	p.addProxyRoutes(sessionGroup)
 
 	// The admin-only routes need to be last as the admin middleware will be
 	// applied to any routes below it's instantiation
@@ -893,20 +1052,12 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	adminGroup.Use(p.adminMiddleware)
 
 	for _, plugin := range p.Plugins {
-		endpointPlugin, err := plugin.GetEndpointPlugin()
-		if err == nil {
-			// Plugin supports endpoint plugin
-			endpointType := endpointPlugin.GetType()
-			adminGroup.POST("/register/"+endpointType, endpointPlugin.Register)
-		}
-
 		routePlugin, err := plugin.GetRoutePlugin()
 		if err == nil {
 			routePlugin.AddAdminGroupRoutes(adminGroup)
 		}
 	}
 
-	adminGroup.POST("/unregister", p.unregisterCluster)
 	// sessionGroup.DELETE("/cnsis", p.removeCluster)
 
 	// Serve up static resources
@@ -923,6 +1074,18 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	}
 }
 
+// addProxyRoutes adds the routes that pass requests through to endpoints
+// Only users that can see endpoints can make requests to them
+func (p *portalProxy) addProxyRoutes(sessionGroup *echo.Group) {
+	// This is used for passthru of requests
+	group := sessionGroup.Group("/proxy")
+	group.Use(p.auditProxyMiddleware)
+	p.allowAPITokens(interfaces.APITokenScopeProxy, group.Any("/*", p.proxy, p.RequirePermission(interfaces.PermissionEndpointsView))...)
+
+	// Passthru of CF list requests, fetching all pages
+	p.allowAPITokens(interfaces.APITokenScopeProxy, sessionGroup.GET("/proxy-all/*", p.proxyAll, p.RequirePermission(interfaces.PermissionEndpointsView)))
+}
+
 func (p *portalProxy) AddLoginHook(priority int, function interfaces.LoginHookFunc) error {
 	p.GetConfig().LoginHooks = append(p.GetConfig().LoginHooks, interfaces.LoginHook{
 		Priority: priority,
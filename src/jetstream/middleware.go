@@ -63,12 +63,30 @@ func (p *portalProxy) sessionMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		log.Debug("sessionMiddleware")
 
+		// Requests from scripts can use an API token in place of a session cookie
+		if token, ok := getBearerAPIToken(c.Request()); ok {
+			if err := p.authenticateAPIToken(c, token); err != nil {
+				return err
+			}
+			return h(c)
+		}
+
 		p.removeEmptyCookie(c)
 
 		userID, err := p.GetSessionValue(c, "user_id")
 		if err == nil {
-			c.Set("user_id", userID)
-			return h(c)
+			if err = p.checkSessionLifetime(c); err == nil {
+				c.Set("user_id", userID)
+				if userGUID, ok := userID.(string); ok {
+					if err := p.checkPasswordChange(c, userGUID); err != nil {
+						return err
+					}
+					if err := p.checkMFAEnrolment(c, userGUID); err != nil {
+						return err
+					}
+				}
+				return h(c)
+			}
 		}
 
 		// Don't log an error if we are verifying the session, as a failure is not an error
@@ -103,6 +121,10 @@ func (p *portalProxy) xsrfMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 		if c.Request().Method == "GET" || c.Request().Method == "HEAD" {
 			return h(c)
 		}
+		// Requests authenticated with an API token do not use cookies, so are not open to XSRF
+		if getRequestAPIToken(c) != nil {
+			return h(c)
+		}
 		errMsg := "Failed to get stored XSRF token from user session"
 		token, err := p.GetSessionStringValue(c, XSRFTokenSessionName)
 		if err == nil {
@@ -183,13 +205,13 @@ func (p *portalProxy) adminMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 		// get the user guid
 		userID, err := p.GetSessionValue(c, "user_id")
 		if err == nil {
-			// check their admin status in UAA
-			u, err := p.StratosAuthService.GetUser(userID.(string))
+			// check their admin status in UAA and their roles
+			permissions, err := p.GetUserPermissions(userID.(string))
 			if err != nil {
 				return c.NoContent(http.StatusUnauthorized)
 			}
 
-			if u.Admin == true {
+			if permissions.Has(interfaces.PermissionConsoleAdmin) {
 				return h(c)
 			}
 		}
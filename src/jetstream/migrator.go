@@ -53,6 +53,11 @@ func migrateDatabase(env *env.VarSet) bool {
 		return true
 	}
 
+	// Backups are made by the server, once the database is ready
+	if isBackupCommand(args[0]) {
+		return false
+	}
+
 	if !parseCloudFoundry(env) {
 		return false
 	}
@@ -281,11 +281,21 @@ const (
 	findUserScope       = `SELECT user_scope FROM local_users WHERE (.+)`
 	updateLastLoginTime = `UPDATE local_users (.+)`
 	findLastLoginTime   = `SELECT last_login FROM local_users WHERE (.+)`
+	findUser            = `SELECT user_name, (.+) FROM local_users WHERE (.+)`
+	updateFailedLogins  = `UPDATE local_users SET failed_login_attempts=(.+)`
+	incFailedLogins     = `UPDATE local_users SET failed_login_attempts = failed_login_attempts \+ 1`
+	findFailedLogins    = `SELECT failed_login_attempts FROM local_users WHERE (.+)`
+	findMFA             = `SELECT (.+) FROM local_users_mfa WHERE (.+)`
+	findMFARequired     = `SELECT name, value, last_updated FROM config WHERE groupName = (.+) AND name = (.+)`
 	getDbVersion        = `SELECT version_id FROM goose_db_version WHERE is_applied = '1' ORDER BY id DESC LIMIT 1`
 )
 
 var rowFieldsForCNSI = []string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data"}
 
+var rowFieldsForMFA = []string{"user_guid", "secret", "enabled", "last_used_step", "created"}
+
+var rowFieldsForLocalUser = []string{"user_name", "user_email", "user_scope", "given_name", "family_name", "disabled", "password_change_required", "last_login", "failed_login_attempts", "locked_until"}
+
 var mockEncryptionKey = make([]byte, 32)
 
 var cipherClientSecret, _ = crypto.EncryptToken(mockEncryptionKey, mockClientSecret)
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/telemetry"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -72,6 +73,10 @@ func (p *portalProxy) getCNSIRequestRecords(r *interfaces.CNSIRequest) (t interf
 
 func (p *portalProxy) RefreshOAuthToken(skipSSLValidation bool, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint string) (t interfaces.TokenRecord, err error) {
 	log.Debug("refreshToken")
+	defer func() {
+		telemetry.ObserveTokenRefresh(telemetry.TokenRefreshOAuth, err)
+	}()
+
 	userToken, ok := p.GetCNSITokenRecordWithDisconnected(cnsiGUID, userGUID)
 	if !ok {
 		return t, fmt.Errorf("Info could not be found for user with GUID %s", userGUID)
@@ -81,7 +86,7 @@ func (p *portalProxy) RefreshOAuthToken(skipSSLValidation bool, cnsiGUID, userGU
 
 	uaaRes, err := p.getUAATokenWithRefreshToken(skipSSLValidation, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, "")
 	if err != nil {
-		return t, fmt.Errorf("Token refresh request failed: %v", err)
+		return t, interfaces.NewTokenRefreshError(err)
 	}
 
 	u, err := p.GetUserTokenInfo(uaaRes.AccessToken)
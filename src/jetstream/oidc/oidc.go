@@ -0,0 +1,265 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// Config describes the OpenID Connect provider and the client registered with it
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	HTTPClient   *http.Client
+}
+
+// Provider is the metadata published by an OpenID Connect provider at its discovery endpoint
+type Provider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserInfoEndpoint      string   `json:"userinfo_endpoint"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// TokenResponse is the response of the token endpoint to a code exchange or refresh
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+}
+
+// TokenError is returned when the token endpoint rejects a request
+type TokenError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *TokenError) Error() string {
+	if len(e.Description) > 0 {
+		return fmt.Sprintf("Token request failed with %d: %s: %s", e.StatusCode, e.Code, e.Description)
+	}
+	return fmt.Sprintf("Token request failed with %d: %s", e.StatusCode, e.Code)
+}
+
+// Client performs the authorization code flow against an OpenID Connect provider
+// The provider metadata and signing keys are fetched when first needed and then cached
+type Client struct {
+	config Config
+
+	lock     sync.Mutex
+	provider *Provider
+	keys     *keySet
+}
+
+// NewClient returns a client for the provider with the issuer URL
+func NewClient(config Config) (*Client, error) {
+	if len(config.IssuerURL) == 0 || len(config.ClientID) == 0 {
+		return nil, fmt.Errorf("An issuer URL and a client ID are needed for OpenID Connect")
+	}
+	config.IssuerURL = strings.TrimRight(config.IssuerURL, "/")
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid"}
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &Client{config: config}, nil
+}
+
+// Issuer returns the issuer URL of the provider
+func (c *Client) Issuer() string {
+	return c.config.IssuerURL
+}
+
+// Provider returns the metadata of the provider, fetching it from the discovery endpoint if needed
+func (c *Client) Provider() (*Provider, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.provider != nil {
+		return c.provider, nil
+	}
+
+	provider := &Provider{}
+	if err := c.getJSON(c.config.IssuerURL+discoveryPath, provider); err != nil {
+		return nil, fmt.Errorf("Unable to discover OpenID Connect provider: %v", err)
+	}
+
+	// The issuer must match exactly, otherwise tokens from another provider could be accepted
+	if provider.Issuer != c.config.IssuerURL {
+		return nil, fmt.Errorf("Issuer %s of the provider does not match the configured issuer %s", provider.Issuer, c.config.IssuerURL)
+	}
+	if len(provider.AuthorizationEndpoint) == 0 || len(provider.TokenEndpoint) == 0 || len(provider.JWKSURI) == 0 {
+		return nil, fmt.Errorf("OpenID Connect provider metadata is incomplete")
+	}
+
+	c.provider = provider
+	c.keys = newKeySet(c, provider.JWKSURI)
+	return provider, nil
+}
+
+// AuthCodeURL returns the URL of the provider to send the user to for login
+func (c *Client) AuthCodeURL(redirectURI, state, nonce, codeChallenge string) (string, error) {
+	provider, err := c.Provider()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", c.config.ClientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", strings.Join(c.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	return appendQuery(provider.AuthorizationEndpoint, params), nil
+}
+
+// ExchangeCode exchanges the authorization code returned to the redirect URI for tokens
+func (c *Client) ExchangeCode(code, codeVerifier, redirectURI string) (*TokenResponse, error) {
+	body := url.Values{}
+	body.Set("grant_type", "authorization_code")
+	body.Set("code", code)
+	body.Set("code_verifier", codeVerifier)
+	body.Set("redirect_uri", redirectURI)
+	return c.requestToken(body)
+}
+
+// Refresh uses a refresh token to get new tokens
+func (c *Client) Refresh(refreshToken string) (*TokenResponse, error) {
+	body := url.Values{}
+	body.Set("grant_type", "refresh_token")
+	body.Set("refresh_token", refreshToken)
+	return c.requestToken(body)
+}
+
+// EndSessionURL returns the URL of the provider that ends the session of the user, or an empty string if the
+// provider does not support RP-initiated logout
+func (c *Client) EndSessionURL(idTokenHint, postLogoutRedirectURI string) (string, error) {
+	provider, err := c.Provider()
+	if err != nil {
+		return "", err
+	}
+	if len(provider.EndSessionEndpoint) == 0 {
+		return "", nil
+	}
+
+	params := url.Values{}
+	params.Set("client_id", c.config.ClientID)
+	if len(idTokenHint) > 0 {
+		params.Set("id_token_hint", idTokenHint)
+	}
+	if len(postLogoutRedirectURI) > 0 {
+		params.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	}
+	return appendQuery(provider.EndSessionEndpoint, params), nil
+}
+
+func (c *Client) requestToken(body url.Values) (*TokenResponse, error) {
+	provider, err := c.Provider()
+	if err != nil {
+		return nil, err
+	}
+
+	// Public clients identify themselves in the body, confidential clients use basic auth
+	if len(c.config.ClientSecret) == 0 {
+		body.Set("client_id", c.config.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(body.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(c.config.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	res, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Token request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read token response: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		tokenErr := &TokenError{StatusCode: res.StatusCode}
+		json.Unmarshal(data, tokenErr)
+		return nil, tokenErr
+	}
+
+	token := &TokenResponse{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, fmt.Errorf("Unable to parse token response: %v", err)
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, fmt.Errorf("Token response did not include an access token")
+	}
+	return token, nil
+}
+
+func (c *Client) getJSON(url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func appendQuery(endpoint string, params url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + params.Encode()
+	}
+	return endpoint + "?" + params.Encode()
+}
+
+// RandomString returns a random URL-safe string, suitable for a state, nonce or PKCE code verifier
+func RandomString() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of the code verifier
+func CodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,189 @@
+package oidc
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/oidc/oidctest"
+)
+
+const (
+	mockClientID     = "stratos"
+	mockClientSecret = "stratos-secret"
+	mockSubject      = "mock-user"
+	mockRedirectURI  = "https://stratos.example.com/pp/v1/auth/sso_login_callback"
+)
+
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// login follows the authorization URL to the mock provider and returns the code and state it redirects back with
+func login(authURL string) (string, string) {
+	res, err := noRedirectClient.Get(authURL)
+	So(err, ShouldBeNil)
+	res.Body.Close()
+	So(res.StatusCode, ShouldEqual, http.StatusFound)
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	So(err, ShouldBeNil)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+
+	Convey("Given a client of an OpenID Connect provider", t, func() {
+		idp := oidctest.NewIdP(mockClientID, mockClientSecret, mockSubject)
+		idp.SetClaim("groups", []string{"developers", "admins"})
+		client, err := NewClient(Config{
+			IssuerURL:    idp.Issuer() + "/",
+			ClientID:     mockClientID,
+			ClientSecret: mockClientSecret,
+			Scopes:       []string{"openid", "profile"},
+		})
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			idp.Close()
+		})
+
+		Convey("the provider should be discovered", func() {
+			provider, err := client.Provider()
+			So(err, ShouldBeNil)
+			So(provider.TokenEndpoint, ShouldEqual, idp.URL+"/token")
+			So(provider.EndSessionEndpoint, ShouldEqual, idp.URL+"/logout")
+		})
+
+		Convey("a user can log in with PKCE and the ID token should be verified", func() {
+			verifier, _ := RandomString()
+			authURL, err := client.AuthCodeURL(mockRedirectURI, "mock-state", "mock-nonce", CodeChallenge(verifier))
+			So(err, ShouldBeNil)
+
+			code, state := login(authURL)
+			So(state, ShouldEqual, "mock-state")
+
+			token, err := client.ExchangeCode(code, verifier, mockRedirectURI)
+			So(err, ShouldBeNil)
+			So(token.RefreshToken, ShouldNotBeEmpty)
+
+			claims, err := client.VerifyIDToken(token.IDToken, "mock-nonce")
+			So(err, ShouldBeNil)
+			So(claims.String("sub"), ShouldEqual, mockSubject)
+			So(claims.Strings("groups"), ShouldResemble, []string{"developers", "admins"})
+			So(claims.Time("exp").After(time.Now()), ShouldBeTrue)
+
+			Convey("the tokens can be refreshed", func() {
+				refreshed, err := client.Refresh(token.RefreshToken)
+				So(err, ShouldBeNil)
+				So(refreshed.AccessToken, ShouldNotEqual, token.AccessToken)
+
+				_, err = client.Refresh(token.RefreshToken)
+				So(err, ShouldHaveSameTypeAs, &TokenError{})
+			})
+		})
+
+		Convey("a code exchange with the wrong code verifier should be rejected", func() {
+			verifier, _ := RandomString()
+			authURL, _ := client.AuthCodeURL(mockRedirectURI, "mock-state", "mock-nonce", CodeChallenge(verifier))
+			code, _ := login(authURL)
+
+			_, err := client.ExchangeCode(code, "wrong-verifier", mockRedirectURI)
+			So(err, ShouldNotBeNil)
+			So(err.(*TokenError).Code, ShouldEqual, "invalid_grant")
+		})
+
+		Convey("the logout URL should include the ID token hint and redirect", func() {
+			logoutURL, err := client.EndSessionURL("mock-id-token", mockRedirectURI)
+			So(err, ShouldBeNil)
+			So(logoutURL, ShouldStartWith, idp.URL+"/logout?")
+			So(logoutURL, ShouldContainSubstring, "id_token_hint=mock-id-token")
+			So(logoutURL, ShouldContainSubstring, "post_logout_redirect_uri="+url.QueryEscape(mockRedirectURI))
+		})
+	})
+
+	Convey("A client should not trust a provider that reports a different issuer", t, func() {
+		idp := oidctest.NewIdP(mockClientID, mockClientSecret, mockSubject)
+		defer idp.Close()
+
+		client, _ := NewClient(Config{IssuerURL: idp.Issuer() + "/other", ClientID: mockClientID})
+		_, err := client.Provider()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestVerifyIDToken(t *testing.T) {
+
+	Convey("Given ID tokens signed by the provider", t, func() {
+		idp := oidctest.NewIdP(mockClientID, mockClientSecret, mockSubject)
+		client, _ := NewClient(Config{IssuerURL: idp.Issuer(), ClientID: mockClientID})
+
+		Reset(func() {
+			idp.Close()
+		})
+
+		claims := func() map[string]interface{} {
+			return map[string]interface{}{
+				"iss": idp.Issuer(),
+				"sub": mockSubject,
+				"aud": mockClientID,
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}
+		}
+
+		Convey("a valid token should be accepted", func() {
+			_, err := client.VerifyIDToken(idp.Sign(claims()), "")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("an expired token should be rejected", func() {
+			c := claims()
+			c["exp"] = time.Now().Add(-time.Hour).Unix()
+			_, err := client.VerifyIDToken(idp.Sign(c), "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a token for another client should be rejected", func() {
+			c := claims()
+			c["aud"] = []string{"other-client", mockClientID}
+			c["azp"] = "other-client"
+			_, err := client.VerifyIDToken(idp.Sign(c), "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a token from another issuer should be rejected", func() {
+			c := claims()
+			c["iss"] = "https://other.example.com"
+			_, err := client.VerifyIDToken(idp.Sign(c), "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a token with the wrong nonce should be rejected", func() {
+			c := claims()
+			c["nonce"] = "other-nonce"
+			_, err := client.VerifyIDToken(idp.Sign(c), "mock-nonce")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a token signed by another key should be rejected", func() {
+			other := oidctest.NewIdP(mockClientID, mockClientSecret, mockSubject)
+			defer other.Close()
+			_, err := client.VerifyIDToken(other.Sign(claims()), "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("an unsigned token should be rejected", func() {
+			parts := strings.Split(idp.Sign(claims()), ".")
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+			_, err := client.VerifyIDToken(header+"."+parts[1]+".", "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,257 @@
+// Package oidctest provides a mock OpenID Connect provider for tests
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// KeyID is the ID of the key that signs ID tokens
+	KeyID = "mock-key"
+
+	tokenLifetime = time.Hour
+)
+
+type authRequest struct {
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+// IdP is a mock OpenID Connect provider, which logs in a single user without prompting
+type IdP struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	lock          sync.Mutex
+	key           *rsa.PrivateKey
+	claims        map[string]interface{}
+	codes         map[string]authRequest
+	refreshTokens map[string]bool
+}
+
+// NewIdP starts a mock provider for the client, which logs in a user with the subject
+func NewIdP(clientID string, clientSecret string, subject string) *IdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	idp := &IdP{
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		key:           key,
+		claims:        map[string]interface{}{"sub": subject},
+		codes:         make(map[string]authRequest),
+		refreshTokens: make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/logout", idp.logout)
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// Issuer returns the issuer URL of the provider
+func (idp *IdP) Issuer() string {
+	return idp.URL
+}
+
+// SetClaim sets a claim of the ID tokens issued for the user
+func (idp *IdP) SetClaim(name string, value interface{}) {
+	idp.lock.Lock()
+	defer idp.lock.Unlock()
+	idp.claims[name] = value
+}
+
+// Sign returns a token with the claims, signed with the key of the provider
+func (idp *IdP) Sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": KeyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// IDToken returns an ID token for the user with the nonce
+func (idp *IdP) IDToken(nonce string) string {
+	idp.lock.Lock()
+	defer idp.lock.Unlock()
+	return idp.idToken(nonce)
+}
+
+func (idp *IdP) idToken(nonce string) string {
+	claims := make(map[string]interface{})
+	for name, value := range idp.claims {
+		claims[name] = value
+	}
+
+	now := time.Now()
+	claims["iss"] = idp.Issuer()
+	claims["aud"] = idp.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(tokenLifetime).Unix()
+	if len(nonce) > 0 {
+		claims["nonce"] = nonce
+	}
+	return idp.Sign(claims)
+}
+
+func (idp *IdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                idp.Issuer(),
+		"authorization_endpoint":                idp.URL + "/authorize",
+		"token_endpoint":                        idp.URL + "/token",
+		"jwks_uri":                              idp.URL + "/jwks",
+		"end_session_endpoint":                  idp.URL + "/logout",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (idp *IdP) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize logs the user in straight away and redirects back to the client with a code
+func (idp *IdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != idp.ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge_method") != "S256" || len(query.Get("code_challenge")) == 0 {
+		http.Error(w, "PKCE is required", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	idp.lock.Lock()
+	idp.codes[code] = authRequest{
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		redirectURI:   query.Get("redirect_uri"),
+	}
+	idp.lock.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *IdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID != idp.ClientID || secret != idp.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.lock.Lock()
+	defer idp.lock.Unlock()
+
+	nonce := ""
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		request, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		if !ok || request.redirectURI != r.PostForm.Get("redirect_uri") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "Unknown code"})
+			return
+		}
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(challenge[:]) != request.codeChallenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+			return
+		}
+		nonce = request.nonce
+	case "refresh_token":
+		if !idp.refreshTokens[r.PostForm.Get("refresh_token")] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "Unknown refresh token"})
+			return
+		}
+		delete(idp.refreshTokens, r.PostForm.Get("refresh_token"))
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	refreshToken := randomString()
+	idp.refreshTokens[refreshToken] = true
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  randomString(),
+		"token_type":    "Bearer",
+		"refresh_token": refreshToken,
+		"id_token":      idp.idToken(nonce),
+		"expires_in":    int64(tokenLifetime.Seconds()),
+	})
+}
+
+// logout ends the session of the user and redirects back to the client
+func (idp *IdP) logout(w http.ResponseWriter, r *http.Request) {
+	redirect := r.URL.Query().Get("post_logout_redirect_uri")
+	if len(redirect) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return fmt.Sprintf("%x", data)
+}
@@ -0,0 +1,316 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Allowed difference between our clock and the clock of the provider
+	clockLeeway = 2 * time.Minute
+
+	// Keys are fetched again for an unknown key ID at most this often, as the provider rotates its keys
+	keyRefreshInterval = time.Minute
+)
+
+// Only asymmetric algorithms are accepted - in particular "none" and HMAC signed tokens are rejected
+var signingAlgs = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+}
+
+// Claims are the claims of a verified ID token
+type Claims map[string]interface{}
+
+// String returns the value of a string claim, or an empty string if it is missing
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns the values of a claim that is either a list of strings or a single string
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Time returns the value of a claim holding seconds since the epoch, or the zero time if it is missing
+func (c Claims) Time(name string) time.Time {
+	if value, ok := c[name].(float64); ok {
+		return time.Unix(int64(value), 0)
+	}
+	return time.Time{}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+// keySet caches the signing keys of the provider
+type keySet struct {
+	client  *Client
+	url     string
+	lock    sync.Mutex
+	keys    []publicKey
+	fetched time.Time
+}
+
+func newKeySet(client *Client, url string) *keySet {
+	return &keySet{client: client, url: url}
+}
+
+// find returns the keys that may have signed a token, fetching the keys again if the key ID is not known
+func (k *keySet) find(kid string) ([]crypto.PublicKey, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	matching := k.match(kid)
+	if len(matching) > 0 || time.Since(k.fetched) < keyRefreshInterval {
+		return matching, nil
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := k.client.getJSON(k.url, &jwks); err != nil {
+		return nil, fmt.Errorf("Unable to fetch signing keys: %v", err)
+	}
+
+	keys := make([]publicKey, 0, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we don't understand, rather than failing on all of them
+			continue
+		}
+		keys = append(keys, publicKey{kid: jwk.Kid, key: key})
+	}
+	k.keys = keys
+	k.fetched = time.Now()
+	return k.match(kid), nil
+}
+
+func (k *keySet) match(kid string) []crypto.PublicKey {
+	matching := make([]crypto.PublicKey, 0, 1)
+	for _, key := range k.keys {
+		if len(kid) == 0 || key.kid == kid {
+			matching = append(matching, key.key)
+		}
+	}
+	return matching
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("Unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("Invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type: %s", jwk.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// verifySignature checks the signature of the token with the key
+func verifySignature(key crypto.PublicKey, alg string, signed string, signature []byte) bool {
+	hash := signingAlgs[alg]
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token and returns its claims
+func (c *Client) VerifyIDToken(rawIDToken string, nonce string) (Claims, error) {
+	provider, err := c.Provider()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("ID token is malformed")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("Unable to decode ID token header: %v", err)
+	}
+	if _, ok := signingAlgs[header.Alg]; !ok {
+		return nil, fmt.Errorf("ID token signing algorithm %s is not supported", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode ID token signature: %v", err)
+	}
+
+	keys, err := c.keys.find(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	verified := false
+	for _, key := range keys {
+		if verifySignature(key, header.Alg, parts[0]+"."+parts[1], signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("ID token signature is not valid")
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("Unable to decode ID token claims: %v", err)
+	}
+
+	if claims.String("iss") != provider.Issuer {
+		return nil, fmt.Errorf("ID token was issued by %s, not %s", claims.String("iss"), provider.Issuer)
+	}
+
+	audience := claims.Strings("aud")
+	found := false
+	for _, aud := range audience {
+		if aud == c.config.ClientID {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("ID token was not issued for client %s", c.config.ClientID)
+	}
+	if len(audience) > 1 && claims.String("azp") != c.config.ClientID {
+		return nil, fmt.Errorf("ID token was not issued to client %s", c.config.ClientID)
+	}
+
+	expiry := claims.Time("exp")
+	if expiry.IsZero() || time.Now().After(expiry.Add(clockLeeway)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+
+	if len(nonce) > 0 && claims.String("nonce") != nonce {
+		return nil, fmt.Errorf("ID token nonce does not match")
+	}
+
+	if len(claims.String("sub")) == 0 {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// DecodeClaims returns the claims of a token without verifying it
+// This must only be used for tokens that were verified when they were received
+func DecodeClaims(rawToken string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Token is malformed")
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("Unable to decode token claims: %v", err)
+	}
+	return claims, nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/telemetry"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,6 +19,10 @@ func (p *portalProxy) doOidcFlowRequest(cnsiRequest *interfaces.CNSIRequest, req
 
 func (p *portalProxy) RefreshOidcToken(skipSSLValidation bool, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint string) (t interfaces.TokenRecord, err error) {
 	log.Debug("refreshToken")
+	defer func() {
+		telemetry.ObserveTokenRefresh(telemetry.TokenRefreshOidc, err)
+	}()
+
 	userToken, ok := p.GetCNSITokenRecordWithDisconnected(cnsiGUID, userGUID)
 	if !ok {
 		return t, fmt.Errorf("Info could not be found for user with GUID %s", userGUID)
@@ -28,6 +33,7 @@ func (p *portalProxy) RefreshOidcToken(skipSSLValidation bool, cnsiGUID, userGUI
 	// Parse out token metadata is there is some, and override some of theser parameters
 
 	var scopes string
+	useIDToken := false
 
 	log.Info(userToken.Metadata)
 	if len(userToken.Metadata) > 0 {
@@ -47,12 +53,13 @@ func (p *portalProxy) RefreshOidcToken(skipSSLValidation bool, cnsiGUID, userGUI
 				tokenEndpoint = metadata.IssuerURL
 				tokenEndpointWithPath = fmt.Sprintf("%s/token", tokenEndpoint)
 			}
+			useIDToken = metadata.UseIDToken
 		}
 	}
 
 	uaaRes, err := p.getUAATokenWithRefreshToken(skipSSLValidation, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, scopes)
 	if err != nil {
-		return t, fmt.Errorf("Token refresh request failed: %v", err)
+		return t, interfaces.NewTokenRefreshError(err)
 	}
 
 	u, err := p.GetUserTokenInfo(uaaRes.IDToken)
@@ -62,7 +69,12 @@ func (p *portalProxy) RefreshOidcToken(skipSSLValidation bool, cnsiGUID, userGUI
 
 	u.UserGUID = userGUID
 
-	tokenRecord := p.InitEndpointTokenRecord(u.TokenExpiry, uaaRes.AccessToken, uaaRes.RefreshToken, userToken.Disconnected)
+	authToken := uaaRes.AccessToken
+	if useIDToken {
+		authToken = uaaRes.IDToken
+	}
+
+	tokenRecord := p.InitEndpointTokenRecord(u.TokenExpiry, authToken, uaaRes.RefreshToken, userToken.Disconnected)
 	tokenRecord.AuthType = interfaces.AuthTypeOIDC
 	// Copy across the metadata from the original token
 	tokenRecord.Metadata = userToken.Metadata
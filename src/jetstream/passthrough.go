@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/telemetry"
 )
 
 // API Host Prefix to replace if the custom header is supplied
@@ -210,6 +212,15 @@ func fwdCNSIStandardHeaders(cnsiRequest *interfaces.CNSIRequest, req *http.Reque
 
 func (p *portalProxy) proxy(c echo.Context) error {
 	log.Debug("proxy")
+	shouldPassthrough := "true" == c.Request().Header.Get("x-cap-passthrough")
+	longRunning := "true" == c.Request().Header.Get(longRunningTimeoutHeader)
+
+	// Single endpoint passthrough requests are streamed rather than buffered
+	// Long running requests are not, since they rely on the response channel timeout
+	if shouldPassthrough && !longRunning {
+		return p.ProxyStreamingRequest(c, makeRequestURI(c))
+	}
+
 	responses, err := p.ProxyRequest(c, makeRequestURI(c))
 	if err != nil {
 		return err
@@ -218,6 +229,112 @@ func (p *portalProxy) proxy(c echo.Context) error {
 	return p.SendProxiedResponse(c, responses)
 }
 
+// ProxyStreamingRequest proxies a request to a single endpoint, streaming the endpoint's response
+// directly to the client rather than reading the whole response into memory first
+func (p *portalProxy) ProxyStreamingRequest(c echo.Context, uri *url.URL) error {
+	log.Debug("ProxyStreamingRequest")
+	cnsiList := strings.Split(c.Request().Header.Get("x-cap-cnsi-list"), ",")
+	if len(cnsiList) > 1 {
+		err := errors.New("Requested passthrough to multiple CNSIs. Only single CNSI passthroughs are supported")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := p.validateCNSIList(cnsiList); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	header := getEchoHeaders(c)
+	header.Del("Cookie")
+
+	portalUserGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	req, body, err := getRequestParts(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	cnsiRequest, err := p.buildCNSIRequest(cnsiList[0], portalUserGUID, req.Method, uri, body, header)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	cnsiRequest.PassThrough = true
+	overrideAPIHost(c, &cnsiRequest)
+
+	res, err := p.doUpstreamRequest(&cnsiRequest)
+	p.invalidateProxyCache(&cnsiRequest)
+	if err != nil {
+		if cnsiRequest.StatusCode <= 0 {
+			cnsiRequest.StatusCode = http.StatusInternalServerError
+		}
+		logPassthroughWarning(&cnsiRequest, nil)
+		c.Response().WriteHeader(cnsiRequest.StatusCode)
+		if _, err := c.Response().Write(cnsiRequest.Response); err != nil {
+			log.Errorf("Failed to write passthrough response %v", err)
+		}
+		return nil
+	}
+	defer res.Body.Close()
+
+	cnsiRequest.StatusCode = res.StatusCode
+	cnsiRequest.Status = res.Status
+	if cnsiRequest.StatusCode >= 400 {
+		logPassthroughWarning(&cnsiRequest, res)
+	}
+
+	return streamPassthroughResponse(c, res)
+}
+
+// Headers from the endpoint's response that are copied to the client in streaming passthrough mode
+var streamedResponseHeaders = []string{"Content-Type", "Content-Disposition"}
+
+func streamPassthroughResponse(c echo.Context, res *http.Response) error {
+	for _, name := range streamedResponseHeaders {
+		if value := res.Header.Get(name); len(value) > 0 {
+			c.Response().Header().Set(name, value)
+		}
+	}
+
+	// Content Length is only known if the transport did not have to decompress the response
+	if res.ContentLength >= 0 {
+		c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(res.ContentLength, 10))
+	}
+
+	// in passthrough mode, set the status code to that of the single response
+	c.Response().WriteHeader(res.StatusCode)
+
+	// Copying chunk by chunk means we only read from the endpoint as fast as the client reads from us
+	if _, err := io.Copy(c.Response(), res.Body); err != nil {
+		log.Errorf("Failed to stream passthrough response %v", err)
+	}
+
+	return nil
+}
+
+// overrideAPIHost allows the host part of the API URL to be overridden via the x-cap-api-host header
+func overrideAPIHost(c echo.Context, cnsiRequest *interfaces.CNSIRequest) {
+	setAPIHost(c.Request().Header.Get("x-cap-api-host"), cnsiRequest)
+}
+
+// setAPIHost replaces the 'api' prefix of the host of the API URL with the given name
+func setAPIHost(apiHost string, cnsiRequest *interfaces.CNSIRequest) {
+	// Don't allow any '.' chars in the api name
+	if apiHost != "" && !strings.ContainsAny(apiHost, ".") {
+		// Add trailing . for when we replace
+		apiHost = apiHost + "."
+		// Override the API URL if needed
+		if strings.HasPrefix(cnsiRequest.URL.Host, apiPrefix) {
+			// Replace 'api.' prefix with supplied prefix
+			cnsiRequest.URL.Host = strings.Replace(cnsiRequest.URL.Host, apiPrefix, apiHost, 1)
+		} else {
+			// Add supplied prefix to the domain
+			cnsiRequest.URL.Host = apiHost + cnsiRequest.URL.Host
+		}
+	}
+}
+
 func (p *portalProxy) ProxyRequest(c echo.Context, uri *url.URL) (map[string]*interfaces.CNSIRequest, error) {
 	log.Debug("proxy")
 	cnsiList := strings.Split(c.Request().Header.Get("x-cap-cnsi-list"), ",")
@@ -264,21 +381,7 @@ func (p *portalProxy) ProxyRequest(c echo.Context, uri *url.URL) (map[string]*in
 			return nil, echo.NewHTTPError(http.StatusBadRequest, buildErr.Error())
 		}
 		cnsiRequest.LongRunning = longRunning
-		// Allow the host part of the API URL to be overridden
-		apiHost := c.Request().Header.Get("x-cap-api-host")
-		// Don't allow any '.' chars in the api name
-		if apiHost != "" && !strings.ContainsAny(apiHost, ".") {
-			// Add trailing . for when we replace
-			apiHost = apiHost + "."
-			// Override the API URL if needed
-			if strings.HasPrefix(cnsiRequest.URL.Host, apiPrefix) {
-				// Replace 'api.' prefix with supplied prefix
-				cnsiRequest.URL.Host = strings.Replace(cnsiRequest.URL.Host, apiPrefix, apiHost, 1)
-			} else {
-				// Add supplied prefix to the domain
-				cnsiRequest.URL.Host = apiHost + cnsiRequest.URL.Host
-			}
-		}
+		overrideAPIHost(c, &cnsiRequest)
 		go p.doRequest(&cnsiRequest, done)
 	}
 
@@ -350,6 +453,7 @@ func (p *portalProxy) DoProxyRequest(requests []interfaces.ProxyRequestInfo) (ma
 		if buildErr != nil {
 			return nil, echo.NewHTTPError(http.StatusBadRequest, buildErr.Error())
 		}
+		setAPIHost(requestInfo.APIHost, &cnsiRequest)
 		go p.doRequest(&cnsiRequest, done)
 	}
 
@@ -404,6 +508,9 @@ func (p *portalProxy) SendProxiedResponse(c echo.Context, responses map[string]*
 		cnsiList = append(cnsiList, k)
 	}
 
+	// Endpoints that did not respond in time have no response, so audit against the requested endpoints
+	setAuditEndpointStatus(c, strings.Split(c.Request().Header.Get("x-cap-cnsi-list"), ","), responses)
+
 	if shouldPassthrough {
 		cnsiGUID := cnsiList[0]
 		res, ok := responses[cnsiGUID]
@@ -434,6 +541,42 @@ func (p *portalProxy) SendProxiedResponse(c echo.Context, responses map[string]*
 
 func (p *portalProxy) doRequest(cnsiRequest *interfaces.CNSIRequest, done chan<- *interfaces.CNSIRequest) {
 	log.Debug("doRequest")
+
+	if cacheKey, ttl := p.getProxyCacheKey(cnsiRequest); len(cacheKey) > 0 {
+		p.doCachedRequest(cnsiRequest, cacheKey, ttl)
+	} else {
+		p.readUpstreamResponse(cnsiRequest)
+		p.invalidateProxyCache(cnsiRequest)
+	}
+
+	if done != nil {
+		done <- cnsiRequest
+	}
+}
+
+// readUpstreamResponse makes the request to the endpoint and reads the whole response into the CNSI request
+// The returned response (if any) has already had its body read and closed
+func (p *portalProxy) readUpstreamResponse(cnsiRequest *interfaces.CNSIRequest) *http.Response {
+	res, err := p.doUpstreamRequest(cnsiRequest)
+	if err == nil && res.Body != nil {
+		cnsiRequest.StatusCode = res.StatusCode
+		cnsiRequest.Status = res.Status
+		cnsiRequest.Response, cnsiRequest.Error = ioutil.ReadAll(res.Body)
+		defer res.Body.Close()
+	}
+
+	// If Status Code >=400, log this as a warning
+	if cnsiRequest.StatusCode >= 400 {
+		logPassthroughWarning(cnsiRequest, res)
+		log.Warn(string(cnsiRequest.Response))
+	}
+
+	return res
+}
+
+// doUpstreamRequest makes the request to the endpoint and returns the response without reading the body
+// The caller is responsible for closing the body. On error, the status and error of the CNSI request are updated
+func (p *portalProxy) doUpstreamRequest(cnsiRequest *interfaces.CNSIRequest) (*http.Response, error) {
 	var body io.Reader
 	var res *http.Response
 	var req *http.Request
@@ -445,22 +588,16 @@ func (p *portalProxy) doRequest(cnsiRequest *interfaces.CNSIRequest, done chan<-
 	req, err = http.NewRequest(cnsiRequest.Method, cnsiRequest.URL.String(), body)
 	if err != nil {
 		cnsiRequest.Error = err
-		if done != nil {
-			done <- cnsiRequest
-		}
-		return
+		return nil, err
 	}
 
 	// get a cnsi token record and a cnsi record
-	tokenRec, _, err := p.getCNSIRequestRecords(cnsiRequest)
+	tokenRec, cnsi, err := p.getCNSIRequestRecords(cnsiRequest)
 	if err != nil {
 		cnsiRequest.Error = err
-		if done != nil {
-			cnsiRequest.StatusCode = 400
-			cnsiRequest.Status = "Unable to retrieve CNSI token record"
-			done <- cnsiRequest
-		}
-		return
+		cnsiRequest.StatusCode = 400
+		cnsiRequest.Status = "Unable to retrieve CNSI token record"
+		return nil, err
 	}
 
 	// Copy original headers through, except custom portal-proxy Headers
@@ -473,38 +610,40 @@ func (p *portalProxy) doRequest(cnsiRequest *interfaces.CNSIRequest, done chan<-
 
 	// Find the auth provider for the auth type - default ot oauthflow
 	authHandler := p.GetAuthProvider(tokenRec.AuthType)
-	if authHandler.Handler != nil {
-		res, err = authHandler.Handler(cnsiRequest, req)
-	} else {
-		res, err = p.doOauthFlowRequest(cnsiRequest, req)
+	start := time.Now()
+	res, err = p.doCircuitBreakerRequest(cnsiRequest, func() (*http.Response, error) {
+		if authHandler.Handler != nil {
+			return authHandler.Handler(cnsiRequest, req)
+		}
+		return p.doOauthFlowRequest(cnsiRequest, req)
+	})
+
+	statusCode := cnsiRequest.StatusCode
+	if res != nil {
+		statusCode = res.StatusCode
 	}
+	telemetry.ObserveProxyRequest(cnsi.CNSIType, statusCode, time.Since(start))
 
-	if err != nil {
+	if err == errCircuitOpen || err == errTooManyRequests {
+		return nil, err
+	} else if err != nil {
 		cnsiRequest.StatusCode = 500
 		cnsiRequest.Status = "Error proxing request"
 		cnsiRequest.Response = []byte(err.Error())
 		cnsiRequest.Error = err
-	} else if res.Body != nil {
-		cnsiRequest.StatusCode = res.StatusCode
-		cnsiRequest.Status = res.Status
-		cnsiRequest.Response, cnsiRequest.Error = ioutil.ReadAll(res.Body)
-		defer res.Body.Close()
+		return nil, err
 	}
 
-	// If Status Code >=400, log this as a warning
-	if cnsiRequest.StatusCode >= 400 {
-		var contentType = "Unknown"
-		var contentLength int64 = -1
-		if res != nil {
-			contentType = res.Header.Get("Content-Type")
-			contentLength = res.ContentLength
-		}
-		log.Warnf("Passthrough response: URL: %s, Status Code: %d, Status: %s, Content Type: %s, Length: %d",
-			cnsiRequest.URL.String(), cnsiRequest.StatusCode, cnsiRequest.Status, contentType, contentLength)
-		log.Warn(string(cnsiRequest.Response))
-	}
+	return res, nil
+}
 
-	if done != nil {
-		done <- cnsiRequest
+func logPassthroughWarning(cnsiRequest *interfaces.CNSIRequest, res *http.Response) {
+	var contentType = "Unknown"
+	var contentLength int64 = -1
+	if res != nil {
+		contentType = res.Header.Get("Content-Type")
+		contentLength = res.ContentLength
 	}
+	log.Warnf("Passthrough response: URL: %s, Status Code: %d, Status: %s, Content Type: %s, Length: %d",
+		cnsiRequest.URL.String(), cnsiRequest.StatusCode, cnsiRequest.Status, contentType, contentLength)
 }
@@ -3,7 +3,10 @@ package main
 import (
 	"errors"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -108,6 +111,79 @@ func TestPassthroughDoRequest(t *testing.T) {
 	})
 }
 
+func TestPassthroughStreamingRequest(t *testing.T) {
+	t.Parallel()
+
+	Convey("Streaming passthrough request tests", t, func() {
+		mockDropletBody := strings.Repeat("droplet-bits", 1024)
+		mockCFServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v3/droplets/mock-droplet/download" {
+				t.Errorf("Wanted path '/v3/droplets/mock-droplet/download', got path '%s'", r.URL.Path)
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", "attachment; filename=droplet.tgz")
+			w.Header().Set("Content-Length", strconv.Itoa(len(mockDropletBody)))
+			w.Header().Set("X-Not-Forwarded", "true")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(mockDropletBody))
+		}))
+		defer mockCFServer.Close()
+
+		req := setupMockReq("GET", "http://localhost/pp/v1/proxy/v3/droplets/mock-droplet/download", nil)
+		req.Header.Set("x-cap-cnsi-list", mockCFGUID)
+		req.Header.Set("x-cap-passthrough", "true")
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		ctx.SetPath("/pp/v1/proxy/*")
+		ctx.Set("user_id", mockUserGUID)
+
+		expectMockServerRow := func() sqlmock.Rows {
+			return sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockCFServer.URL, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "")
+		}
+
+		// validateCNSIList and buildCNSIRequest
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCFGUID).
+			WillReturnRows(expectMockServerRow())
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCFGUID).
+			WillReturnRows(expectMockServerRow())
+
+		// getCNSIRequestRecords for the request and then for the auth flow
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCFGUID, mockUserGUID, mockAdminGUID).
+				WillReturnRows(expectEncryptedTokenRow(pp.Config.EncryptionKeyInBytes))
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WithArgs(mockCFGUID).
+				WillReturnRows(expectMockServerRow())
+		}
+
+		err := pp.proxy(ctx)
+
+		Convey("should not return an error", func() {
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should have all expectations met", func() {
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should stream the status and body of the endpoint response", func() {
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(res.Body.String(), ShouldEqual, mockDropletBody)
+		})
+
+		Convey("should only copy the selected headers", func() {
+			So(res.Header().Get("Content-Type"), ShouldEqual, "application/octet-stream")
+			So(res.Header().Get("Content-Disposition"), ShouldEqual, "attachment; filename=droplet.tgz")
+			So(res.Header().Get("Content-Length"), ShouldEqual, strconv.Itoa(len(mockDropletBody)))
+			So(res.Header().Get("X-Not-Forwarded"), ShouldBeEmpty)
+		})
+	})
+}
+
 func B2S(bs []byte) string {
 	return string(bs[:])
 }
@@ -150,6 +226,35 @@ func TestPassthroughGetEchoHeaders(t *testing.T) {
 	})
 }
 
+func TestPassthroughSetAPIHost(t *testing.T) {
+	t.Parallel()
+
+	Convey("Overriding the API host", t, func() {
+		request := func(host string) *interfaces.CNSIRequest {
+			return &interfaces.CNSIRequest{URL: &url.URL{Scheme: "https", Host: host, Path: "/v2/apps"}}
+		}
+
+		Convey("should replace the api prefix", func() {
+			cnsiRequest := request("api.example.com")
+			setAPIHost("api2", cnsiRequest)
+			So(cnsiRequest.URL.Host, ShouldEqual, "api2.example.com")
+		})
+
+		Convey("should add a prefix to a host without one", func() {
+			cnsiRequest := request("example.com")
+			setAPIHost("api2", cnsiRequest)
+			So(cnsiRequest.URL.Host, ShouldEqual, "api2.example.com")
+		})
+
+		Convey("should ignore an empty or invalid host", func() {
+			cnsiRequest := request("api.example.com")
+			setAPIHost("", cnsiRequest)
+			setAPIHost("evil.com", cnsiRequest)
+			So(cnsiRequest.URL.Host, ShouldEqual, "api.example.com")
+		})
+	})
+}
+
 func TestPassthroughMakeRequestURI(t *testing.T) {
 	t.Parallel()
 
@@ -12,6 +12,7 @@ import (
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cfapppush/pushapp"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/telemetry"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo"
 	log "github.com/sirupsen/logrus"
@@ -92,6 +93,7 @@ func (cfAppPush *CFAppPush) deploy(echoContext echo.Context) error {
 	}
 	defer clientWebSocket.Close()
 	defer pingTicker.Stop()
+	defer telemetry.TrackWebSocketSession(telemetry.WebSocketAppPush)()
 
 	// We use a simple protocol to get the source to use for cf push and any cf push cli overrides
 
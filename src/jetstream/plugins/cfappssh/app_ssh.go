@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/telemetry"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo"
 	log "github.com/sirupsen/logrus"
@@ -129,6 +130,7 @@ func (cfAppSsh *CFAppSSH) appSSH(c echo.Context) error {
 	}
 	defer ws.Close()
 	defer pingTicker.Stop()
+	defer telemetry.TrackWebSocketSession(telemetry.WebSocketAppSSH)()
 
 	modes := ssh.TerminalModes{
 		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
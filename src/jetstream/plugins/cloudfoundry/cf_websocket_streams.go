@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/telemetry"
 	"github.com/cloudfoundry/noaa"
 	"github.com/cloudfoundry/noaa/consumer"
 	noaa_errors "github.com/cloudfoundry/noaa/errors"
@@ -32,18 +33,18 @@ var upgrader = websocket.Upgrader{
 }
 
 func (c CloudFoundrySpecification) appStream(echoContext echo.Context) error {
-	return c.commonStreamHandler(echoContext, appStreamHandler)
+	return c.commonStreamHandler(echoContext, telemetry.WebSocketAppStream, appStreamHandler)
 }
 
 func (c CloudFoundrySpecification) firehose(echoContext echo.Context) error {
-	return c.commonStreamHandler(echoContext, firehoseStreamHandler)
+	return c.commonStreamHandler(echoContext, telemetry.WebSocketFirehose, firehoseStreamHandler)
 }
 
 func (c CloudFoundrySpecification) appFirehose(echoContext echo.Context) error {
-	return c.commonStreamHandler(echoContext, appFirehoseStreamHandler)
+	return c.commonStreamHandler(echoContext, telemetry.WebSocketFirehose, appFirehoseStreamHandler)
 }
 
-func (c CloudFoundrySpecification) commonStreamHandler(echoContext echo.Context, bespokeStreamHandler func(echo.Context, *AuthorizedConsumer, *websocket.Conn) error) error {
+func (c CloudFoundrySpecification) commonStreamHandler(echoContext echo.Context, sessionType string, bespokeStreamHandler func(echo.Context, *AuthorizedConsumer, *websocket.Conn) error) error {
 	ac, err := c.openNoaaConsumer(echoContext)
 	if err != nil {
 		return err
@@ -56,6 +57,7 @@ func (c CloudFoundrySpecification) commonStreamHandler(echoContext echo.Context,
 	}
 	defer clientWebSocket.Close()
 	defer pingTicker.Stop()
+	defer telemetry.TrackWebSocketSession(sessionType)()
 
 	if err := bespokeStreamHandler(echoContext, ac, clientWebSocket); err != nil {
 		return err
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/url"
 	"strings"
+	"time"
 
 	"errors"
 
@@ -24,6 +25,11 @@ type CloudFoundrySpecification struct {
 const (
 	EndpointType  = "cf"
 	CLIENT_ID_KEY = "CF_CLIENT"
+
+	// Only one Jetstream instance should auto-register the Cloud Foundry endpoint
+	autoRegisterLockName     = "cf-auto-register"
+	autoRegisterLockTTL      = 30 * time.Second
+	autoRegisterLockAttempts = 10
 )
 
 // Init creates a new CloudFoundrySpecification
@@ -100,18 +106,7 @@ func (c *CloudFoundrySpecification) cfLoginHook(context echo.Context) error {
 
 	// CF auto reg cnsi entry missing, attempt to register
 	if cfCnsi.CNSIType == "" {
-		cfEndpointSpec, _ := c.portalProxy.GetEndpointTypeSpec("cf")
-
-		// Allow the auto-registration name to be configured
-		autoRegName := c.portalProxy.GetConfig().AutoRegisterCFName
-		if len(autoRegName) == 0 {
-			autoRegName = "Cloud Foundry"
-		}
-
-		log.Infof("Auto-registering cloud foundry endpoint %s as \"%s\"", cfAPI, autoRegName)
-
-		// Auto-register the Cloud Foundry
-		cfCnsi, err = c.portalProxy.DoRegisterEndpoint(autoRegName, cfAPI, true, c.portalProxy.GetConfig().CFClient, c.portalProxy.GetConfig().CFClientSecret, false, "", cfEndpointSpec.Info)
+		cfCnsi, err = c.autoRegisterEndpoint(cfAPI)
 		if err != nil {
 			log.Errorf("Could not auto-register Cloud Foundry endpoint: %v", err)
 			return nil
@@ -159,6 +154,43 @@ func (c *CloudFoundrySpecification) cfLoginHook(context echo.Context) error {
 	return nil
 }
 
+// autoRegisterEndpoint registers the auto-register Cloud Foundry endpoint, unless another Jetstream instance already has
+func (c *CloudFoundrySpecification) autoRegisterEndpoint(cfAPI string) (interfaces.CNSIRecord, error) {
+	locked := false
+	for attempt := 0; attempt < autoRegisterLockAttempts && !locked; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Second)
+		}
+		var err error
+		if locked, err = c.portalProxy.AcquireLock(autoRegisterLockName, autoRegisterLockTTL); err != nil {
+			return interfaces.CNSIRecord{}, err
+		}
+	}
+	if !locked {
+		return interfaces.CNSIRecord{}, errors.New("Timed out waiting for another instance to auto-register the endpoint")
+	}
+	defer c.portalProxy.ReleaseLock(autoRegisterLockName)
+
+	// Another instance may have registered the endpoint while we waited for the lock
+	if _, cfCnsi, _ := c.fetchAutoRegisterEndpoint(); cfCnsi.CNSIType != "" {
+		log.Infof("Cloud Foundry endpoint %s has been auto-registered by another instance", cfAPI)
+		return cfCnsi, nil
+	}
+
+	cfEndpointSpec, _ := c.portalProxy.GetEndpointTypeSpec("cf")
+
+	// Allow the auto-registration name to be configured
+	autoRegName := c.portalProxy.GetConfig().AutoRegisterCFName
+	if len(autoRegName) == 0 {
+		autoRegName = "Cloud Foundry"
+	}
+
+	log.Infof("Auto-registering cloud foundry endpoint %s as \"%s\"", cfAPI, autoRegName)
+
+	// Auto-register the Cloud Foundry
+	return c.portalProxy.DoRegisterEndpoint(autoRegName, cfAPI, true, c.portalProxy.GetConfig().CFClient, c.portalProxy.GetConfig().CFClientSecret, false, "", cfEndpointSpec.Info)
+}
+
 func (c *CloudFoundrySpecification) fetchAutoRegisterEndpoint() (string, interfaces.CNSIRecord, error) {
 	cfAPI := c.portalProxy.GetConfig().AutoRegisterCFUrl
 	cfAPI = strings.TrimRight(cfAPI, "/")
@@ -0,0 +1,183 @@
+package helm
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/helm/helmstore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// ChartSummary is the latest version of a chart in a Helm repository
+type ChartSummary struct {
+	*helmstore.HelmChartRecord
+	Versions int `json:"versions"`
+}
+
+func (h *Helm) getHelmEndpoint(c echo.Context) (interfaces.CNSIRecord, error) {
+	guid := c.Param("guid")
+	userGUID, err := h.portalProxy.GetSessionStringValue(c, "user_id")
+	if err != nil {
+		return interfaces.CNSIRecord{}, echo.NewHTTPError(http.StatusUnauthorized, "Could not find session user_id")
+	}
+	endpoint, err := h.portalProxy.GetCNSIRecord(guid)
+	if err != nil || endpoint.CNSIType != EndpointType || !h.portalProxy.IsEndpointVisible(userGUID, guid) {
+		return endpoint, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Helm repository not found",
+			"Helm repository not found: %s", guid)
+	}
+	return endpoint, nil
+}
+
+func (h *Helm) getStore() (helmstore.HelmStore, error) {
+	store, err := helmstore.NewHelmDBStore(h.portalProxy.GetDatabaseConnection())
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to get Helm chart store",
+			"Unable to get Helm chart store: %v", err)
+	}
+	return store, nil
+}
+
+// listCharts lists the latest version of each chart in a Helm repository
+func (h *Helm) listCharts(c echo.Context) error {
+	endpoint, err := h.getHelmEndpoint(c)
+	if err != nil {
+		return err
+	}
+
+	store, err := h.getStore()
+	if err != nil {
+		return err
+	}
+
+	charts, err := store.List(endpoint.GUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list Helm charts",
+			"Unable to list Helm charts: %v", err)
+	}
+
+	summaries := make([]*ChartSummary, 0)
+	latest := make(map[string]*ChartSummary)
+	for _, chart := range charts {
+		summary, ok := latest[chart.Name]
+		if !ok {
+			summary = &ChartSummary{HelmChartRecord: chart}
+			latest[chart.Name] = summary
+			summaries = append(summaries, summary)
+		} else if compareVersions(chart.Version, summary.Version) > 0 {
+			summary.HelmChartRecord = chart
+		}
+		summary.Versions++
+	}
+
+	return c.JSON(http.StatusOK, summaries)
+}
+
+// listChartVersions lists the versions of a chart in a Helm repository, newest first
+func (h *Helm) listChartVersions(c echo.Context) error {
+	endpoint, err := h.getHelmEndpoint(c)
+	if err != nil {
+		return err
+	}
+
+	store, err := h.getStore()
+	if err != nil {
+		return err
+	}
+
+	versions, err := store.ListVersions(endpoint.GUID, c.Param("name"))
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list Helm chart versions",
+			"Unable to list Helm chart versions: %v", err)
+	}
+	if len(versions) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Helm chart not found",
+			"Helm chart not found: %s", c.Param("name"))
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersions(versions[i].Version, versions[j].Version) > 0
+	})
+
+	return c.JSON(http.StatusOK, versions)
+}
+
+// getChartReadme returns the README of a chart version
+func (h *Helm) getChartReadme(c echo.Context) error {
+	return h.getChartFileContent(c, "README.md", "text/markdown; charset=utf-8")
+}
+
+// getChartValues returns the default values of a chart version
+func (h *Helm) getChartValues(c echo.Context) error {
+	return h.getChartFileContent(c, "values.yaml", "application/x-yaml; charset=utf-8")
+}
+
+func (h *Helm) getChartFileContent(c echo.Context, fileName, contentType string) error {
+	endpoint, err := h.getHelmEndpoint(c)
+	if err != nil {
+		return err
+	}
+
+	store, err := h.getStore()
+	if err != nil {
+		return err
+	}
+
+	chart, err := store.Get(endpoint.GUID, c.Param("name"), c.Param("version"))
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to get Helm chart",
+			"Unable to get Helm chart: %v", err)
+	}
+	if chart == nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Helm chart version not found",
+			"Helm chart version not found: %s %s", c.Param("name"), c.Param("version"))
+	}
+
+	content, err := h.getChartFile(endpoint, chart, fileName)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadGateway,
+			"Unable to download Helm chart",
+			"Unable to download Helm chart %s %s: %v", chart.Name, chart.Version, err)
+	}
+	if content == nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Helm chart has no "+fileName,
+			"Helm chart %s %s has no %s", chart.Name, chart.Version, fileName)
+	}
+
+	return c.Blob(http.StatusOK, contentType, content)
+}
+
+// syncCharts syncs the charts of a Helm repository now, rather than waiting for the next periodic sync
+func (h *Helm) syncCharts(c echo.Context) error {
+	endpoint, err := h.getHelmEndpoint(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.syncRepository(&endpoint); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadGateway,
+			"Unable to sync Helm repository",
+			"Unable to sync Helm repository %s: %v", endpoint.Name, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
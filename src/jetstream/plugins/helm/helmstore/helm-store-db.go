@@ -0,0 +1,151 @@
+package helmstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+)
+
+var (
+	listCharts           = `SELECT endpoint_guid, name, version, app_version, description, icon, created, digest, urls, last_synced FROM helm_charts WHERE endpoint_guid = $1 ORDER BY name`
+	listChartVersions    = `SELECT endpoint_guid, name, version, app_version, description, icon, created, digest, urls, last_synced FROM helm_charts WHERE endpoint_guid = $1 AND name = $2`
+	getChart             = `SELECT endpoint_guid, name, version, app_version, description, icon, created, digest, urls, last_synced FROM helm_charts WHERE endpoint_guid = $1 AND name = $2 AND version = $3`
+	saveChart            = `INSERT INTO helm_charts (endpoint_guid, name, version, app_version, description, icon, created, digest, urls, last_synced) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	deleteEndpointCharts = `DELETE FROM helm_charts WHERE endpoint_guid = $1`
+)
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	listCharts = datastore.ModifySQLStatement(listCharts, databaseProvider)
+	listChartVersions = datastore.ModifySQLStatement(listChartVersions, databaseProvider)
+	getChart = datastore.ModifySQLStatement(getChart, databaseProvider)
+	saveChart = datastore.ModifySQLStatement(saveChart, databaseProvider)
+	deleteEndpointCharts = datastore.ModifySQLStatement(deleteEndpointCharts, databaseProvider)
+}
+
+// HelmDBStore is a DB-backed Helm chart repository
+type HelmDBStore struct {
+	db *sql.DB
+}
+
+// NewHelmDBStore will create a new instance of the HelmDBStore
+func NewHelmDBStore(dcp *sql.DB) (HelmStore, error) {
+	return &HelmDBStore{db: dcp}, nil
+}
+
+// List - Returns all versions of all charts of a Helm repository
+func (p *HelmDBStore) List(endpointGUID string) ([]*HelmChartRecord, error) {
+	log.Debug("List")
+	rows, err := p.db.Query(listCharts, endpointGUID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve Helm Chart records: %v", err)
+	}
+	defer rows.Close()
+
+	return scanCharts(rows)
+}
+
+// ListVersions - Returns all versions of a chart of a Helm repository
+func (p *HelmDBStore) ListVersions(endpointGUID string, name string) ([]*HelmChartRecord, error) {
+	log.Debug("ListVersions")
+	rows, err := p.db.Query(listChartVersions, endpointGUID, name)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve Helm Chart records: %v", err)
+	}
+	defer rows.Close()
+
+	return scanCharts(rows)
+}
+
+// Get - Returns a version of a chart, or nil if there is no such chart version
+func (p *HelmDBStore) Get(endpointGUID string, name string, version string) (*HelmChartRecord, error) {
+	log.Debug("Get")
+	rows, err := p.db.Query(getChart, endpointGUID, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve Helm Chart record: %v", err)
+	}
+	defer rows.Close()
+
+	charts, err := scanCharts(rows)
+	if err != nil || len(charts) == 0 {
+		return nil, err
+	}
+	return charts[0], nil
+}
+
+// Sync will replace the charts of a Helm repository
+func (p *HelmDBStore) Sync(endpointGUID string, charts []*HelmChartRecord) error {
+	txn, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to start transaction for Helm Chart records: %v", err)
+	}
+
+	if _, err := txn.Exec(deleteEndpointCharts, endpointGUID); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Unable to delete Helm Chart records: %v", err)
+	}
+
+	lastSynced := time.Now().UTC()
+	for _, chart := range charts {
+		urls, err := json.Marshal(chart.URLs)
+		if err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Unable to marshal Helm Chart URLs: %v", err)
+		}
+		if _, err := txn.Exec(saveChart, endpointGUID, chart.Name, chart.Version, chart.AppVersion, chart.Description, chart.Icon, chart.Created, chart.Digest, string(urls), lastSynced); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Unable to save Helm Chart record: %v", err)
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("Unable to commit Helm Chart records: %v", err)
+	}
+	return nil
+}
+
+// DeleteFromEndpoint will remove all charts for a given endpoint guid
+func (p *HelmDBStore) DeleteFromEndpoint(endpointGUID string) error {
+	if _, err := p.db.Exec(deleteEndpointCharts, endpointGUID); err != nil {
+		return fmt.Errorf("Unable to delete Helm Chart records: %v", err)
+	}
+	return nil
+}
+
+func scanCharts(rows *sql.Rows) ([]*HelmChartRecord, error) {
+	charts := make([]*HelmChartRecord, 0)
+	for rows.Next() {
+		chart := new(HelmChartRecord)
+		var appVersion, description, icon, created, digest, urls sql.NullString
+		err := rows.Scan(&chart.EndpointGUID, &chart.Name, &chart.Version, &appVersion, &description, &icon, &created, &digest, &urls, &chart.LastSynced)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan Helm Chart records: %v", err)
+		}
+
+		chart.AppVersion = appVersion.String
+		chart.Description = description.String
+		chart.Icon = icon.String
+		chart.Created = created.String
+		chart.Digest = digest.String
+		chart.URLs = make([]string, 0)
+		if len(urls.String) > 0 {
+			if err = json.Unmarshal([]byte(urls.String), &chart.URLs); err != nil {
+				return nil, fmt.Errorf("Unable to unmarshal Helm Chart URLs: %v", err)
+			}
+		}
+
+		charts = append(charts, chart)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to List Helm Chart records: %v", err)
+	}
+
+	return charts, nil
+}
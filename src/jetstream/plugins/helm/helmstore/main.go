@@ -0,0 +1,26 @@
+package helmstore
+
+import "time"
+
+// HelmChartRecord is a version of a chart in a Helm repository
+type HelmChartRecord struct {
+	EndpointGUID string    `json:"endpointId"`
+	Name         string    `json:"name"`
+	Version      string    `json:"version"`
+	AppVersion   string    `json:"appVersion,omitempty"`
+	Description  string    `json:"description,omitempty"`
+	Icon         string    `json:"icon,omitempty"`
+	Created      string    `json:"created,omitempty"`
+	Digest       string    `json:"digest,omitempty"`
+	URLs         []string  `json:"urls"`
+	LastSynced   time.Time `json:"lastSynced"`
+}
+
+// HelmStore is the Helm chart repository
+type HelmStore interface {
+	List(endpointGUID string) ([]*HelmChartRecord, error)
+	ListVersions(endpointGUID string, name string) ([]*HelmChartRecord, error)
+	Get(endpointGUID string, name string, version string) (*HelmChartRecord, error)
+	Sync(endpointGUID string, charts []*HelmChartRecord) error
+	DeleteFromEndpoint(endpointGUID string) error
+}
@@ -0,0 +1,311 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/helm/helmstore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Limits on the size of the files downloaded from a Helm repository
+const (
+	maxIndexSize     = 64 * 1024 * 1024
+	maxChartSize     = 16 * 1024 * 1024
+	maxChartFileSize = 4 * 1024 * 1024
+)
+
+// Maximum number of chart files held in the chart file cache
+const maxChartFileCacheEntries = 256
+
+// IndexFile is the index.yaml of a Helm repository
+type IndexFile struct {
+	APIVersion string                         `yaml:"apiVersion"`
+	Entries    map[string][]IndexChartVersion `yaml:"entries"`
+}
+
+// IndexChartVersion is a version of a chart in the index.yaml of a Helm repository
+type IndexChartVersion struct {
+	Name        string   `yaml:"name"`
+	Version     string   `yaml:"version"`
+	AppVersion  string   `yaml:"appVersion"`
+	Description string   `yaml:"description"`
+	Icon        string   `yaml:"icon"`
+	Created     string   `yaml:"created"`
+	Digest      string   `yaml:"digest"`
+	URLs        []string `yaml:"urls"`
+}
+
+// parseIndex parses the index.yaml of a Helm repository into chart records
+func parseIndex(data []byte) ([]*helmstore.HelmChartRecord, error) {
+	index := &IndexFile{}
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("Could not parse the Helm repository index: %v", err)
+	}
+	if len(index.APIVersion) == 0 {
+		return nil, errors.New("The Helm repository index has no API version")
+	}
+
+	charts := make([]*helmstore.HelmChartRecord, 0)
+	for name, versions := range index.Entries {
+		for _, version := range versions {
+			if len(version.Version) == 0 {
+				continue
+			}
+			charts = append(charts, &helmstore.HelmChartRecord{
+				Name:        name,
+				Version:     version.Version,
+				AppVersion:  version.AppVersion,
+				Description: version.Description,
+				Icon:        version.Icon,
+				Created:     version.Created,
+				Digest:      version.Digest,
+				URLs:        version.URLs,
+			})
+		}
+	}
+	return charts, nil
+}
+
+// fetchIndex downloads the index.yaml of a Helm repository
+func (h *Helm) fetchIndex(repoURL string, skipSSLValidation bool) ([]byte, error) {
+	return h.fetch(fmt.Sprintf("%s/index.yaml", strings.TrimRight(repoURL, "/")), skipSSLValidation, maxIndexSize)
+}
+
+func (h *Helm) fetch(fileURL string, skipSSLValidation bool, maxSize int64) ([]byte, error) {
+	httpClient := h.portalProxy.GetHttpClient(skipSSLValidation)
+	res, err := httpClient.Get(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Request for %s failed with status %d", fileURL, res.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", fileURL, maxSize)
+	}
+	return data, nil
+}
+
+// syncRepository downloads the index of a Helm repository and replaces the charts stored for it
+func (h *Helm) syncRepository(endpoint *interfaces.CNSIRecord) error {
+	log.Debugf("Syncing Helm repository: %s", endpoint.APIEndpoint)
+
+	data, err := h.fetchIndex(endpoint.APIEndpoint.String(), endpoint.SkipSSLValidation)
+	if err != nil {
+		return err
+	}
+
+	charts, err := parseIndex(data)
+	if err != nil {
+		return err
+	}
+
+	store, err := helmstore.NewHelmDBStore(h.portalProxy.GetDatabaseConnection())
+	if err != nil {
+		return err
+	}
+	return store.Sync(endpoint.GUID, charts)
+}
+
+// syncRepositories syncs all of the registered Helm repositories
+func (h *Helm) syncRepositories() {
+	endpoints, err := h.portalProxy.ListEndpoints()
+	if err != nil {
+		log.Errorf("Unable to list endpoints to sync Helm repositories: %v", err)
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		if endpoint.CNSIType != EndpointType {
+			continue
+		}
+		if err := h.syncRepository(endpoint); err != nil {
+			log.Warnf("Unable to sync Helm repository %s: %v", endpoint.Name, err)
+		}
+	}
+}
+
+// startSync periodically syncs all of the registered Helm repositories
+func (h *Helm) startSync(interval time.Duration) {
+	log.Infof("Helm repositories will be synced every %s", interval)
+	go func() {
+		for {
+			// The charts are stored in the shared database, so only one instance needs to sync them
+			if h.portalProxy.IsLeader() {
+				h.syncRepositories()
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// getChartFile downloads the archive of a chart version and returns the content of a file at the top level of the chart
+// Files of charts that have a digest are cached, as the digest identifies the content of the archive
+func (h *Helm) getChartFile(endpoint interfaces.CNSIRecord, chart *helmstore.HelmChartRecord, fileName string) ([]byte, error) {
+	if len(chart.URLs) == 0 {
+		return nil, errors.New("Chart has no download URL")
+	}
+
+	chartURL, err := resolveChartURL(endpoint.APIEndpoint, chart.URLs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s:%s:%s", chartURL, chart.Digest, strings.ToLower(fileName))
+	if len(chart.Digest) > 0 {
+		if content, ok := h.chartFiles.get(key); ok {
+			return content, nil
+		}
+	}
+
+	archive, err := h.fetch(chartURL, endpoint.SkipSSLValidation, maxChartSize)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := readChartFile(archive, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chart.Digest) > 0 {
+		h.chartFiles.put(key, content)
+	}
+	return content, nil
+}
+
+// chartFileCache is an in-memory cache of files read from chart archives
+type chartFileCache struct {
+	sync.Mutex
+	maxEntries int
+	entries    map[string]*chartFileCacheEntry
+}
+
+type chartFileCacheEntry struct {
+	Content  []byte
+	LastUsed time.Time
+}
+
+func newChartFileCache(maxEntries int) *chartFileCache {
+	return &chartFileCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*chartFileCacheEntry),
+	}
+}
+
+func (cc *chartFileCache) get(key string) ([]byte, bool) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	entry, ok := cc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry.LastUsed = time.Now()
+	return entry.Content, true
+}
+
+// put stores the file content - if the cache is full, the least recently used entry is removed
+func (cc *chartFileCache) put(key string, content []byte) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	if _, ok := cc.entries[key]; !ok && len(cc.entries) >= cc.maxEntries {
+		var oldestKey string
+		var oldest *chartFileCacheEntry
+		for key, entry := range cc.entries {
+			if oldest == nil || entry.LastUsed.Before(oldest.LastUsed) {
+				oldestKey = key
+				oldest = entry
+			}
+		}
+		delete(cc.entries, oldestKey)
+	}
+	cc.entries[key] = &chartFileCacheEntry{Content: content, LastUsed: time.Now()}
+}
+
+// resolveChartURL resolves a chart URL, which may be relative to the repository URL
+func resolveChartURL(repoURL *url.URL, chartURL string) (string, error) {
+	ref, err := url.Parse(chartURL)
+	if err != nil {
+		return "", fmt.Errorf("Invalid chart URL: %v", err)
+	}
+
+	base := *repoURL
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path = base.Path + "/"
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// readChartFile returns the content of a file at the top level of a chart archive - the file name is not case sensitive
+// Files larger than maxChartFileSize are rejected, whatever size the archive claims they have
+func readChartFile(archive []byte, fileName string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read chart archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not read chart archive: %v", err)
+		}
+
+		// Chart archives contain a single top level folder named after the chart
+		dir, name := path.Split(path.Clean(header.Name))
+		if strings.Count(dir, "/") == 1 && strings.EqualFold(name, fileName) {
+			if header.Size > maxChartFileSize {
+				return nil, fmt.Errorf("%s is larger than %d bytes", header.Name, maxChartFileSize)
+			}
+			content, err := ioutil.ReadAll(io.LimitReader(tr, maxChartFileSize+1))
+			if err != nil {
+				return nil, fmt.Errorf("Could not read chart archive: %v", err)
+			}
+			if int64(len(content)) > maxChartFileSize {
+				return nil, fmt.Errorf("%s is larger than %d bytes", header.Name, maxChartFileSize)
+			}
+			return content, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// compareVersions compares two chart versions, falling back to a string comparison if they are not semantic versions
+func compareVersions(a, b string) int {
+	va, errA := semver.ParseTolerant(a)
+	vb, errB := semver.ParseTolerant(b)
+	if errA == nil && errB == nil {
+		return va.Compare(vb)
+	}
+	return strings.Compare(a, b)
+}
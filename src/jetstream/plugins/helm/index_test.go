@@ -0,0 +1,168 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testIndex = `
+apiVersion: v1
+entries:
+  nginx:
+  - name: nginx
+    version: 1.2.0
+    appVersion: 1.17.6
+    description: NGINX web server
+    urls:
+    - charts/nginx-1.2.0.tgz
+  - name: nginx
+    version: 1.10.0
+    urls:
+    - https://charts.example.com/nginx-1.10.0.tgz
+  redis:
+  - name: redis
+    version: 10.0.1
+  - name: redis
+generated: 2019-11-25T12:00:00Z
+`
+
+func TestParseIndex(t *testing.T) {
+	t.Parallel()
+
+	Convey("Parse Helm repository index", t, func() {
+
+		Convey("should return each chart version", func() {
+			charts, err := parseIndex([]byte(testIndex))
+			So(err, ShouldBeNil)
+			So(len(charts), ShouldEqual, 3)
+
+			for _, chart := range charts {
+				if chart.Version == "1.2.0" {
+					So(chart.Name, ShouldEqual, "nginx")
+					So(chart.AppVersion, ShouldEqual, "1.17.6")
+					So(chart.URLs, ShouldResemble, []string{"charts/nginx-1.2.0.tgz"})
+				}
+			}
+		})
+
+		Convey("should reject files that are not a Helm repository index", func() {
+			_, err := parseIndex([]byte("<html></html>"))
+			So(err, ShouldNotBeNil)
+			_, err = parseIndex([]byte("entries: {}"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestResolveChartURL(t *testing.T) {
+	t.Parallel()
+
+	Convey("Resolve chart URL", t, func() {
+		repoURL, _ := url.Parse("https://example.com/helm")
+
+		chartURL, err := resolveChartURL(repoURL, "charts/nginx-1.2.0.tgz")
+		So(err, ShouldBeNil)
+		So(chartURL, ShouldEqual, "https://example.com/helm/charts/nginx-1.2.0.tgz")
+
+		chartURL, err = resolveChartURL(repoURL, "https://charts.example.com/nginx-1.10.0.tgz")
+		So(err, ShouldBeNil)
+		So(chartURL, ShouldEqual, "https://charts.example.com/nginx-1.10.0.tgz")
+	})
+}
+
+func TestCompareVersions(t *testing.T) {
+	t.Parallel()
+
+	Convey("Compare chart versions", t, func() {
+		So(compareVersions("1.10.0", "1.2.0"), ShouldBeGreaterThan, 0)
+		So(compareVersions("v1.2", "1.2.0"), ShouldEqual, 0)
+		So(compareVersions("1.0.0-rc1", "1.0.0"), ShouldBeLessThan, 0)
+		So(compareVersions("latest", "beta"), ShouldBeGreaterThan, 0)
+	})
+}
+
+func TestReadChartFile(t *testing.T) {
+	t.Parallel()
+
+	Convey("Read file from chart archive", t, func() {
+		archive := createTestChart(map[string]string{
+			"nginx/Chart.yaml":             "name: nginx",
+			"nginx/readme.md":              "# NGINX",
+			"nginx/values.yaml":            "replicas: 1",
+			"nginx/charts/dep/values.yaml": "replicas: 2",
+		})
+
+		content, err := readChartFile(archive, "README.md")
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "# NGINX")
+
+		content, err = readChartFile(archive, "values.yaml")
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "replicas: 1")
+
+		content, err = readChartFile(archive, "NOTES.txt")
+		So(err, ShouldBeNil)
+		So(content, ShouldBeNil)
+
+		_, err = readChartFile([]byte("not an archive"), "README.md")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Reject files that are too large", t, func() {
+		archive := createTestChart(map[string]string{
+			"nginx/values.yaml": strings.Repeat("a", maxChartFileSize+1),
+		})
+
+		_, err := readChartFile(archive, "values.yaml")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestChartFileCache(t *testing.T) {
+	t.Parallel()
+
+	Convey("Chart file cache", t, func() {
+		cache := newChartFileCache(2)
+		cache.put("a", []byte("a"))
+		cache.put("b", []byte("b"))
+
+		Convey("should return cached files", func() {
+			content, ok := cache.get("a")
+			So(ok, ShouldBeTrue)
+			So(string(content), ShouldEqual, "a")
+		})
+
+		Convey("should remove the least recently used file when full", func() {
+			cache.entries["a"].LastUsed = time.Now().Add(time.Minute)
+			cache.put("c", []byte("c"))
+
+			_, ok := cache.get("b")
+			So(ok, ShouldBeFalse)
+			_, ok = cache.get("a")
+			So(ok, ShouldBeTrue)
+			_, ok = cache.get("c")
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
+
+func createTestChart(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		So(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}), ShouldBeNil)
+		_, err := tw.Write([]byte(content))
+		So(err, ShouldBeNil)
+	}
+	So(tw.Close(), ShouldBeNil)
+	So(gz.Close(), ShouldBeNil)
+	return buf.Bytes()
+}
@@ -0,0 +1,159 @@
+package helm
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/helm/helmstore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Helm is a plugin to support Helm chart repository endpoints
+type Helm struct {
+	portalProxy interfaces.PortalProxy
+	chartFiles  *chartFileCache
+}
+
+const (
+	// EndpointType is the endpoint type for Helm chart repositories
+	EndpointType = "helm"
+
+	syncIntervalEnvVar  = "HELM_REPO_SYNC_INTERVAL"
+	defaultSyncInterval = "1h"
+)
+
+// Init creates a new Helm plugin
+func Init(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error) {
+	helmstore.InitRepositoryProvider(portalProxy.GetConfig().DatabaseProviderName)
+	return &Helm{portalProxy: portalProxy, chartFiles: newChartFileCache(maxChartFileCacheEntries)}, nil
+}
+
+// GetMiddlewarePlugin gets the middleware plugin for this plugin
+func (h *Helm) GetMiddlewarePlugin() (interfaces.MiddlewarePlugin, error) {
+	return nil, errors.New("Not implemented")
+}
+
+// GetEndpointPlugin gets the endpoint plugin for this plugin
+func (h *Helm) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	return h, nil
+}
+
+// GetRoutePlugin gets the route plugin for this plugin
+func (h *Helm) GetRoutePlugin() (interfaces.RoutePlugin, error) {
+	return h, nil
+}
+
+// AddAdminGroupRoutes adds the admin routes for this plugin to the Echo server
+func (h *Helm) AddAdminGroupRoutes(echoGroup *echo.Group) {
+	// no-op
+}
+
+// AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
+func (h *Helm) AddSessionGroupRoutes(echoGroup *echo.Group) {
+	echoGroup.POST("/helm/:guid/sync", h.syncCharts, h.portalProxy.RequirePermission(interfaces.PermissionEndpointsAdmin))
+	echoGroup.GET("/helm/:guid/charts", h.listCharts)
+	echoGroup.GET("/helm/:guid/charts/:name", h.listChartVersions)
+	echoGroup.GET("/helm/:guid/charts/:name/:version/readme", h.getChartReadme)
+	echoGroup.GET("/helm/:guid/charts/:name/:version/values", h.getChartValues)
+}
+
+// Init performs plugin initialization
+func (h *Helm) Init() error {
+	interval, err := time.ParseDuration(h.portalProxy.Env().String(syncIntervalEnvVar, defaultSyncInterval))
+	if err != nil || interval <= 0 {
+		log.Warnf("Invalid value for %s - using %s", syncIntervalEnvVar, defaultSyncInterval)
+		interval, _ = time.ParseDuration(defaultSyncInterval)
+	}
+
+	h.startSync(interval)
+	return nil
+}
+
+// OnEndpointNotification syncs the charts of a Helm repository when it is registered or updated and removes them when it is unregistered
+func (h *Helm) OnEndpointNotification(action interfaces.EndpointAction, endpoint *interfaces.CNSIRecord) {
+	if endpoint.CNSIType != EndpointType {
+		return
+	}
+
+	switch action {
+	case interfaces.EndpointRegisterAction, interfaces.EndpointUpdateAction:
+		go func(endpoint interfaces.CNSIRecord) {
+			if err := h.syncRepository(&endpoint); err != nil {
+				log.Warnf("Unable to sync Helm repository %s: %v", endpoint.Name, err)
+			}
+		}(*endpoint)
+	case interfaces.EndpointUnregisterAction:
+		store, err := helmstore.NewHelmDBStore(h.portalProxy.GetDatabaseConnection())
+		if err == nil {
+			err = store.DeleteFromEndpoint(endpoint.GUID)
+		}
+		if err != nil {
+			log.Warnf("Unable to remove charts of Helm repository %s: %v", endpoint.Name, err)
+		}
+	}
+}
+
+func (h *Helm) GetType() string {
+	return EndpointType
+}
+
+func (h *Helm) Register(echoContext echo.Context) error {
+	log.Debug("Helm Register...")
+	return h.portalProxy.RegisterEndpoint(echoContext, h.Info)
+}
+
+// Info checks that the endpoint is a Helm repository by fetching its index
+func (h *Helm) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+	log.Debug("Helm Info")
+	var newCNSI interfaces.CNSIRecord
+
+	newCNSI.CNSIType = EndpointType
+
+	if _, err := url.Parse(apiEndpoint); err != nil {
+		return newCNSI, nil, err
+	}
+
+	data, err := h.fetchIndex(apiEndpoint, skipSSLValidation)
+	if err != nil {
+		return newCNSI, nil, err
+	}
+	if _, err = parseIndex(data); err != nil {
+		return newCNSI, nil, err
+	}
+
+	newCNSI.TokenEndpoint = apiEndpoint
+	newCNSI.AuthorizationEndpoint = apiEndpoint
+
+	return newCNSI, nil, nil
+}
+
+// Connect - Helm repositories are public, so there are no credentials to store
+func (h *Helm) Connect(ec echo.Context, cnsiRecord interfaces.CNSIRecord, userId string) (*interfaces.TokenRecord, bool, error) {
+	log.Debug("Helm Connect...")
+
+	if connectType := ec.FormValue("connect_type"); connectType != interfaces.AuthConnectTypeNone {
+		return nil, false, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Only no authentication is accepted for Helm repositories",
+			"Unsupported connect type for Helm repository: %s", connectType)
+	}
+
+	tr := &interfaces.TokenRecord{
+		AuthType:     interfaces.AuthTypeHttpBasic,
+		AuthToken:    interfaces.AuthConnectTypeNone,
+		RefreshToken: interfaces.AuthConnectTypeNone,
+	}
+	return tr, false, nil
+}
+
+func (h *Helm) Validate(userGUID string, cnsiRecord interfaces.CNSIRecord, tokenRecord interfaces.TokenRecord) error {
+	return nil
+}
+
+func (h *Helm) UpdateMetadata(info *interfaces.Info, userGUID string, echoContext echo.Context) {
+}
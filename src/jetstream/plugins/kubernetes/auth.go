@@ -0,0 +1,216 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// kubeTokenMetadata is stored as the token metadata for bearer token and client certificate tokens
+type kubeTokenMetadata struct {
+	UserName string `json:"user_name"`
+}
+
+func (k *KubernetesSpecification) getTokenRecordFromToken(token string) (*interfaces.TokenRecord, error) {
+	token = strings.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, errors.New("Need a bearer token")
+	}
+
+	// Service account tokens are JWTs - use the subject as the user name if we can
+	userName := ""
+	if u, err := k.portalProxy.GetUserTokenInfo(token); err == nil {
+		userName = u.UserName
+	}
+	if len(userName) == 0 {
+		userName = getJWTSubject(token)
+	}
+
+	tokenRecord := k.portalProxy.InitEndpointTokenRecord(0, token, "", false)
+	tokenRecord.AuthType = AuthTypeKubeToken
+	tokenRecord.Metadata = marshalTokenMetadata(userName)
+	return &tokenRecord, nil
+}
+
+func (k *KubernetesSpecification) getTokenRecordFromCert(cert, certKey string) (*interfaces.TokenRecord, error) {
+	certPEM, err := decodePEM(cert)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid client certificate: %v", err)
+	}
+	keyPEM, err := decodePEM(certKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid client certificate key: %v", err)
+	}
+
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid client certificate: %v", err)
+	}
+
+	userName := ""
+	if x509Cert, err := x509.ParseCertificate(keyPair.Certificate[0]); err == nil {
+		userName = x509Cert.Subject.CommonName
+	}
+
+	// The certificate and key are stored in the token columns so that they are encrypted at rest
+	tokenRecord := k.portalProxy.InitEndpointTokenRecord(0, string(certPEM), string(keyPEM), false)
+	tokenRecord.AuthType = AuthTypeKubeCert
+	tokenRecord.Metadata = marshalTokenMetadata(userName)
+	return &tokenRecord, nil
+}
+
+func (k *KubernetesSpecification) getTokenRecordFromOIDC(config map[string]string) (*interfaces.TokenRecord, error) {
+	idToken := config["id-token"]
+	if len(idToken) == 0 {
+		return nil, errors.New("The kubeconfig OIDC auth provider must have an id-token")
+	}
+
+	u, err := k.portalProxy.GetUserTokenInfo(idToken)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the OIDC id-token: %v", err)
+	}
+
+	metadata := &interfaces.OAuth2Metadata{
+		ClientID:     config["client-id"],
+		ClientSecret: config["client-secret"],
+		IssuerURL:    strings.TrimRight(config["idp-issuer-url"], "/"),
+		UseIDToken:   true,
+	}
+	jsonMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRecord := k.portalProxy.InitEndpointTokenRecord(u.TokenExpiry, idToken, config["refresh-token"], false)
+	tokenRecord.AuthType = interfaces.AuthTypeOIDC
+	tokenRecord.Metadata = string(jsonMetadata)
+	return &tokenRecord, nil
+}
+
+func (k *KubernetesSpecification) doTokenFlowRequest(cnsiRequest *interfaces.CNSIRequest, req *http.Request) (*http.Response, error) {
+	log.Debug("doTokenFlowRequest")
+
+	authHandler := func(tokenRec interfaces.TokenRecord, cnsi interfaces.CNSIRecord) (*http.Response, error) {
+		// Bearer tokens have no refresh or expiry
+		req.Header.Set("Authorization", "Bearer "+tokenRec.AuthToken)
+		client := k.portalProxy.GetHttpClientForRequest(req, cnsi.SkipSSLValidation)
+		return client.Do(req)
+	}
+	return k.portalProxy.DoAuthFlowRequest(cnsiRequest, req, authHandler)
+}
+
+func (k *KubernetesSpecification) doCertFlowRequest(cnsiRequest *interfaces.CNSIRequest, req *http.Request) (*http.Response, error) {
+	log.Debug("doCertFlowRequest")
+
+	authHandler := func(tokenRec interfaces.TokenRecord, cnsi interfaces.CNSIRecord) (*http.Response, error) {
+		tokenRec.Certificate = tokenRec.AuthToken
+		tokenRec.CertificateKey = tokenRec.RefreshToken
+
+		transport, err := getCertTransport(tokenRec, cnsi.SkipSSLValidation)
+		if err != nil {
+			return nil, err
+		}
+
+		// Use the timeout of the shared client for this request
+		client := k.portalProxy.GetHttpClientForRequest(req, cnsi.SkipSSLValidation)
+		certClient := &http.Client{
+			Transport: transport,
+			Timeout:   client.Timeout,
+		}
+		return certClient.Do(req)
+	}
+	return k.portalProxy.DoAuthFlowRequest(cnsiRequest, req, authHandler)
+}
+
+func (k *KubernetesSpecification) getUserFromToken(cnsiGUID string, tokenRec *interfaces.TokenRecord) (*interfaces.ConnectedUser, bool) {
+	metadata := &kubeTokenMetadata{}
+	if len(tokenRec.Metadata) > 0 {
+		if err := json.Unmarshal([]byte(tokenRec.Metadata), metadata); err != nil {
+			log.Warnf("Unable to read Kubernetes token metadata: %v", err)
+		}
+	}
+
+	return &interfaces.ConnectedUser{
+		GUID: metadata.UserName,
+		Name: metadata.UserName,
+	}, true
+}
+
+// getCertTransport creates a transport that presents the client certificate of the token
+// A transport is created for each request, so that certificates are not held once the user has disconnected or reconnected -
+// keep alives are disabled so that the transport does not hold on to connections once the request is done
+func getCertTransport(tokenRec interfaces.TokenRecord, skipSSLValidation bool) (*http.Transport, error) {
+	keyPair, err := tls.X509KeyPair([]byte(tokenRec.Certificate), []byte(tokenRec.CertificateKey))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load the client certificate: %v", err)
+	}
+
+	return &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+		TLSClientConfig: &tls.Config{
+			Certificates:       []tls.Certificate{keyPair},
+			InsecureSkipVerify: skipSSLValidation,
+		},
+	}, nil
+}
+
+// decodePEM accepts PEM data either as-is or base64 encoded (as it is in a kubeconfig file)
+func decodePEM(data string) ([]byte, error) {
+	data = strings.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("no data")
+	}
+
+	if block, _ := pem.Decode([]byte(data)); block != nil {
+		return []byte(data), nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, errors.New("expected PEM or base64 encoded PEM data")
+	}
+	if block, _ := pem.Decode(decoded); block == nil {
+		return nil, errors.New("expected PEM or base64 encoded PEM data")
+	}
+	return decoded, nil
+}
+
+// getJWTSubject returns the subject of a JWT or an empty string if the token is not a JWT
+func getJWTSubject(token string) string {
+	splits := strings.Split(token, ".")
+	if len(splits) < 3 {
+		return ""
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(splits[1], "="))
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(decoded, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+func marshalTokenMetadata(userName string) string {
+	jsonMetadata, err := json.Marshal(&kubeTokenMetadata{UserName: userName})
+	if err != nil {
+		return ""
+	}
+	return string(jsonMetadata)
+}
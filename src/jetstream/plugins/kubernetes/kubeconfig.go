@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// KubeConfigFile is the subset of a kubeconfig file needed to connect to an endpoint
+type KubeConfigFile struct {
+	APIVersion     string            `yaml:"apiVersion"`
+	Kind           string            `yaml:"kind"`
+	Clusters       []KubeConfigNamed `yaml:"clusters"`
+	Contexts       []KubeConfigNamed `yaml:"contexts"`
+	Users          []KubeConfigNamed `yaml:"users"`
+	CurrentContext string            `yaml:"current-context"`
+}
+
+// KubeConfigNamed is a named cluster, context or user entry
+type KubeConfigNamed struct {
+	Name    string            `yaml:"name"`
+	Cluster KubeConfigCluster `yaml:"cluster"`
+	Context KubeConfigContext `yaml:"context"`
+	User    KubeConfigUser    `yaml:"user"`
+}
+
+// KubeConfigCluster is a cluster in a kubeconfig file
+type KubeConfigCluster struct {
+	Server string `yaml:"server"`
+}
+
+// KubeConfigContext is a context in a kubeconfig file
+type KubeConfigContext struct {
+	Cluster string `yaml:"cluster"`
+	User    string `yaml:"user"`
+}
+
+// KubeConfigUser is a user in a kubeconfig file
+type KubeConfigUser struct {
+	Token                 string                  `yaml:"token"`
+	ClientCertificateData string                  `yaml:"client-certificate-data"`
+	ClientKeyData         string                  `yaml:"client-key-data"`
+	AuthProvider          *KubeConfigAuthProvider `yaml:"auth-provider"`
+}
+
+// KubeConfigAuthProvider is an auth provider for a kubeconfig user
+type KubeConfigAuthProvider struct {
+	Name   string            `yaml:"name"`
+	Config map[string]string `yaml:"config"`
+}
+
+// findKubeConfigUser finds the user for the cluster with the given API endpoint
+// If more than one context uses the cluster, the current context is preferred
+func findKubeConfigUser(data []byte, apiEndpoint string) (*KubeConfigUser, error) {
+	kubeConfig := &KubeConfigFile{}
+	if err := yaml.Unmarshal(data, kubeConfig); err != nil {
+		return nil, fmt.Errorf("Could not parse the kubeconfig file: %v", err)
+	}
+
+	apiEndpoint = strings.TrimRight(apiEndpoint, "/")
+
+	clusters := make(map[string]bool)
+	for _, cluster := range kubeConfig.Clusters {
+		if strings.TrimRight(cluster.Cluster.Server, "/") == apiEndpoint {
+			clusters[cluster.Name] = true
+		}
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("The kubeconfig file has no cluster with the server %s", apiEndpoint)
+	}
+
+	var context *KubeConfigContext
+	for i, c := range kubeConfig.Contexts {
+		if !clusters[c.Context.Cluster] {
+			continue
+		}
+		if context == nil || c.Name == kubeConfig.CurrentContext {
+			context = &kubeConfig.Contexts[i].Context
+		}
+	}
+	if context == nil {
+		return nil, errors.New("The kubeconfig file has no context for this endpoint")
+	}
+
+	for i, user := range kubeConfig.Users {
+		if user.Name == context.User {
+			return &kubeConfig.Users[i].User, nil
+		}
+	}
+
+	return nil, fmt.Errorf("The kubeconfig file has no user named %s", context.User)
+}
@@ -0,0 +1,154 @@
+package kubernetes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testKubeConfig = `
+apiVersion: v1
+kind: Config
+current-context: prod-admin
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443/
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev-user
+- name: prod-viewer
+  context:
+    cluster: prod
+    user: prod-viewer
+- name: prod-admin
+  context:
+    cluster: prod
+    user: prod-admin
+users:
+- name: dev-user
+  user:
+    auth-provider:
+      name: oidc
+      config:
+        client-id: kube
+        id-token: abc.def.ghi
+        idp-issuer-url: https://dex.example.com/
+        refresh-token: refresh
+- name: prod-viewer
+  user:
+    token: viewer-token
+- name: prod-admin
+  user:
+    token: admin-token
+`
+
+func TestFindKubeConfigUser(t *testing.T) {
+	t.Parallel()
+
+	Convey("Find kubeconfig user for an endpoint", t, func() {
+
+		Convey("should prefer the current context", func() {
+			user, err := findKubeConfigUser([]byte(testKubeConfig), "https://prod.example.com:6443")
+			So(err, ShouldBeNil)
+			So(user.Token, ShouldEqual, "admin-token")
+		})
+
+		Convey("should find an OIDC auth provider", func() {
+			user, err := findKubeConfigUser([]byte(testKubeConfig), "https://dev.example.com:6443/")
+			So(err, ShouldBeNil)
+			So(user.AuthProvider, ShouldNotBeNil)
+			So(user.AuthProvider.Name, ShouldEqual, "oidc")
+			So(user.AuthProvider.Config["id-token"], ShouldEqual, "abc.def.ghi")
+			So(user.AuthProvider.Config["refresh-token"], ShouldEqual, "refresh")
+		})
+
+		Convey("should fail for an unknown cluster", func() {
+			_, err := findKubeConfigUser([]byte(testKubeConfig), "https://other.example.com")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail for invalid YAML", func() {
+			_, err := findKubeConfigUser([]byte("clusters: ["), "https://dev.example.com:6443")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDecodePEM(t *testing.T) {
+	t.Parallel()
+
+	Convey("Decode PEM data", t, func() {
+		certPEM, keyPEM := generateTestCert()
+
+		Convey("should accept PEM data", func() {
+			data, err := decodePEM(string(certPEM))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, strings.TrimSpace(string(certPEM)))
+		})
+
+		Convey("should accept base64 encoded PEM data", func() {
+			data, err := decodePEM(base64.StdEncoding.EncodeToString(keyPEM))
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, keyPEM)
+
+			_, err = tls.X509KeyPair(certPEM, data)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should reject other data", func() {
+			_, err := decodePEM("")
+			So(err, ShouldNotBeNil)
+			_, err = decodePEM("not a certificate")
+			So(err, ShouldNotBeNil)
+			_, err = decodePEM(base64.StdEncoding.EncodeToString([]byte("not a certificate")))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGetJWTSubject(t *testing.T) {
+	t.Parallel()
+
+	Convey("Get JWT subject", t, func() {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:default:stratos"}`))
+		So(getJWTSubject("header."+payload+".signature"), ShouldEqual, "system:serviceaccount:default:stratos")
+		So(getJWTSubject("opaque-token"), ShouldEqual, "")
+	})
+}
+
+func generateTestCert() ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kube-admin"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
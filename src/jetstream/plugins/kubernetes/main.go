@@ -0,0 +1,211 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// KubernetesSpecification is a plugin to support the Kubernetes endpoint type
+type KubernetesSpecification struct {
+	portalProxy  interfaces.PortalProxy
+	endpointType string
+}
+
+const (
+	// EndpointType is the endpoint type for Kubernetes endpoints
+	EndpointType = "k8s"
+
+	// AuthConnectTypeKubeToken means connect with a bearer token (e.g. a service account token)
+	AuthConnectTypeKubeToken = "kube-token"
+	// AuthConnectTypeKubeCert means connect with a client certificate and key
+	AuthConnectTypeKubeCert = "kube-cert"
+	// AuthConnectTypeKubeConfig means connect with the credentials for the endpoint from an uploaded kubeconfig file
+	AuthConnectTypeKubeConfig = "kubeconfig"
+
+	// AuthTypeKubeToken is the auth type for tokens that are bearer tokens
+	AuthTypeKubeToken = "KubeToken"
+	// AuthTypeKubeCert is the auth type for tokens that are client certificates
+	AuthTypeKubeCert = "KubeCert"
+)
+
+// KubeVersion is the response from the Kubernetes /version API
+type KubeVersion struct {
+	Major      string `json:"major"`
+	Minor      string `json:"minor"`
+	GitVersion string `json:"gitVersion"`
+	Platform   string `json:"platform"`
+}
+
+// Init creates a new KubernetesSpecification
+func Init(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error) {
+	return &KubernetesSpecification{portalProxy: portalProxy, endpointType: EndpointType}, nil
+}
+
+// GetEndpointPlugin gets the endpoint plugin for this plugin
+func (k *KubernetesSpecification) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	return k, nil
+}
+
+// GetRoutePlugin gets the route plugin for this plugin
+func (k *KubernetesSpecification) GetRoutePlugin() (interfaces.RoutePlugin, error) {
+	return nil, errors.New("Not implemented!")
+}
+
+// GetMiddlewarePlugin gets the middleware plugin for this plugin
+func (k *KubernetesSpecification) GetMiddlewarePlugin() (interfaces.MiddlewarePlugin, error) {
+	return nil, errors.New("Not implemented!")
+}
+
+// Init performs plugin initialization
+func (k *KubernetesSpecification) Init() error {
+	k.portalProxy.AddAuthProvider(AuthTypeKubeToken, interfaces.AuthProvider{
+		Handler:  k.doTokenFlowRequest,
+		UserInfo: k.getUserFromToken,
+	})
+	k.portalProxy.AddAuthProvider(AuthTypeKubeCert, interfaces.AuthProvider{
+		Handler:  k.doCertFlowRequest,
+		UserInfo: k.getUserFromToken,
+	})
+	return nil
+}
+
+func (k *KubernetesSpecification) GetType() string {
+	return EndpointType
+}
+
+func (k *KubernetesSpecification) Register(echoContext echo.Context) error {
+	log.Debug("Kubernetes Register...")
+	return k.portalProxy.RegisterEndpoint(echoContext, k.Info)
+}
+
+// Info checks that the endpoint is a Kubernetes API server by probing its version
+func (k *KubernetesSpecification) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+	log.Debug("Kubernetes Info")
+	var newCNSI interfaces.CNSIRecord
+	var version KubeVersion
+
+	newCNSI.CNSIType = EndpointType
+
+	if _, err := url.Parse(apiEndpoint); err != nil {
+		return newCNSI, nil, err
+	}
+
+	var httpClient = k.portalProxy.GetHttpClient(skipSSLValidation)
+	res, err := httpClient.Get(fmt.Sprintf("%s/version", apiEndpoint))
+	if err != nil {
+		return newCNSI, nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return newCNSI, nil, err
+		}
+		if err = json.Unmarshal(body, &version); err != nil || len(version.GitVersion) == 0 {
+			return newCNSI, nil, errors.New("Endpoint does not appear to be a Kubernetes API server")
+		}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// Clusters that do not allow anonymous access will not return the version
+		log.Debug("Kubernetes endpoint does not allow anonymous access to the version API")
+	default:
+		return newCNSI, nil, fmt.Errorf("Unexpected response from the Kubernetes version API: %d", res.StatusCode)
+	}
+
+	newCNSI.TokenEndpoint = apiEndpoint
+	newCNSI.AuthorizationEndpoint = apiEndpoint
+
+	return newCNSI, version, nil
+}
+
+// Connect creates a token record for the credentials provided for the endpoint
+func (k *KubernetesSpecification) Connect(ec echo.Context, cnsiRecord interfaces.CNSIRecord, userId string) (*interfaces.TokenRecord, bool, error) {
+	log.Debug("Kubernetes Connect...")
+
+	var tokenRecord *interfaces.TokenRecord
+	var err error
+
+	switch connectType := ec.FormValue("connect_type"); connectType {
+	case AuthConnectTypeKubeToken:
+		tokenRecord, err = k.getTokenRecordFromToken(ec.FormValue("token"))
+	case AuthConnectTypeKubeCert:
+		tokenRecord, err = k.getTokenRecordFromCert(ec.FormValue("cert"), ec.FormValue("cert_key"))
+	case AuthConnectTypeKubeConfig:
+		tokenRecord, err = k.getTokenRecordFromKubeConfig(ec, cnsiRecord)
+	default:
+		err = fmt.Errorf("Only bearer token, client certificate or kubeconfig authentication is accepted for Kubernetes endpoints")
+	}
+
+	if err != nil {
+		return nil, false, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			err.Error(),
+			"Could not connect to the Kubernetes endpoint: %v", err)
+	}
+
+	return tokenRecord, false, nil
+}
+
+// getTokenRecordFromKubeConfig reads the credentials for the endpoint from the uploaded kubeconfig file
+func (k *KubernetesSpecification) getTokenRecordFromKubeConfig(ec echo.Context, cnsiRecord interfaces.CNSIRecord) (*interfaces.TokenRecord, error) {
+	kubeConfig := []byte(ec.FormValue("kubeconfig"))
+	if len(kubeConfig) == 0 {
+		// Allow the kubeconfig to be uploaded as a file
+		file, err := ec.FormFile("kubeconfig")
+		if err != nil {
+			return nil, errors.New("Need a kubeconfig file")
+		}
+		src, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the kubeconfig file: %v", err)
+		}
+		defer src.Close()
+		if kubeConfig, err = ioutil.ReadAll(src); err != nil {
+			return nil, fmt.Errorf("Could not read the kubeconfig file: %v", err)
+		}
+	}
+
+	user, err := findKubeConfigUser(kubeConfig, cnsiRecord.APIEndpoint.String())
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case user.AuthProvider != nil && user.AuthProvider.Name == "oidc":
+		return k.getTokenRecordFromOIDC(user.AuthProvider.Config)
+	case len(user.Token) > 0:
+		return k.getTokenRecordFromToken(user.Token)
+	case len(user.ClientCertificateData) > 0 && len(user.ClientKeyData) > 0:
+		return k.getTokenRecordFromCert(user.ClientCertificateData, user.ClientKeyData)
+	}
+
+	return nil, errors.New("The kubeconfig user for this endpoint must use a token, client certificate data or OIDC")
+}
+
+// Validate checks that the credentials can be used to access the Kubernetes API
+func (k *KubernetesSpecification) Validate(userGUID string, cnsiRecord interfaces.CNSIRecord, tokenRecord interfaces.TokenRecord) error {
+	res, err := k.portalProxy.DoProxySingleRequest(cnsiRecord.GUID, userGUID, "GET", "/api", nil, nil)
+	if err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kubernetes API request failed with status %d - check the credentials", res.StatusCode)
+	}
+	return nil
+}
+
+func (k *KubernetesSpecification) UpdateMetadata(info *interfaces.Info, userGUID string, echoContext echo.Context) {
+}
@@ -439,6 +439,10 @@ func (m *MetricsSpecification) getMetricsEndpoints(userGUID string, cnsiList []s
 	}
 
 	for _, endpoint := range allUserAccessibleEndpoints {
+		// Skip endpoints that have been restricted to other users
+		if !m.portalProxy.IsEndpointVisible(userGUID, endpoint.GUID) {
+			continue
+		}
 		if stringInSlice(endpoint.GUID, cnsiList) {
 			// Found the Endpoint, so add it to our list
 			endpointsMap[endpoint.GUID] = endpoint
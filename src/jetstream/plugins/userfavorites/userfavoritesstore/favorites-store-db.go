@@ -12,6 +12,7 @@ import (
 
 var (
 	getFavorites           = `SELECT guid, endpoint_type, endpoint_id, entity_type, entity_id, metadata FROM favorites WHERE user_guid = $1`
+	getAllFavorites        = `SELECT guid, user_guid, endpoint_type, endpoint_id, entity_type, entity_id, metadata FROM favorites`
 	deleteFavorite         = `DELETE FROM favorites WHERE user_guid = $1 AND guid = $2`
 	saveFavorite           = `INSERT INTO favorites (guid, user_guid, endpoint_type, endpoint_id, entity_type, entity_id, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7)`
 	setMetadata            = `UPDATE favorites SET metadata = $1 WHERE user_guid = $2 AND guid = $3`
@@ -22,17 +23,18 @@ var (
 func InitRepositoryProvider(databaseProvider string) {
 	// Modify the database statements if needed, for the given database type
 	getFavorites = datastore.ModifySQLStatement(getFavorites, databaseProvider)
+	getAllFavorites = datastore.ModifySQLStatement(getAllFavorites, databaseProvider)
 	deleteFavorite = datastore.ModifySQLStatement(deleteFavorite, databaseProvider)
 	saveFavorite = datastore.ModifySQLStatement(saveFavorite, databaseProvider)
 }
 
 // FavoritesDBStore is a DB-backed User Favorites repository
 type FavoritesDBStore struct {
-	db *sql.DB
+	db datastore.Connection
 }
 
 // NewFavoritesDBStore will create a new instance of the FavoritesDBStore
-func NewFavoritesDBStore(dcp *sql.DB) (FavoritesStore, error) {
+func NewFavoritesDBStore(dcp datastore.Connection) (FavoritesStore, error) {
 	return &FavoritesDBStore{db: dcp}, nil
 }
 
@@ -73,6 +75,40 @@ func (p *FavoritesDBStore) List(userGUID string) ([]*UserFavoriteRecord, error)
 	return favoritesList, nil
 }
 
+// ListAll - Returns the favorites of all users
+func (p *FavoritesDBStore) ListAll() ([]*UserFavoriteRecord, error) {
+	log.Debug("ListAll")
+	rows, err := p.db.Query(getAllFavorites)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve User Favorite records: %v", err)
+	}
+	defer rows.Close()
+
+	favoritesList := make([]*UserFavoriteRecord, 0)
+	for rows.Next() {
+		favorite := new(UserFavoriteRecord)
+		var metaString sql.NullString
+		err := rows.Scan(&favorite.GUID, &favorite.UserGUID, &favorite.EndpointType, &favorite.EndpointID, &favorite.EntityType, &favorite.EntityID, &metaString)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan User Favorite records: %v", err)
+		}
+
+		if metaString.Valid && len(metaString.String) > 0 {
+			if err = json.Unmarshal([]byte(metaString.String), &favorite.Metadata); err != nil {
+				return nil, fmt.Errorf("Unable to Marshal User Favorite metadata: %v", err)
+			}
+		}
+
+		favoritesList = append(favoritesList, favorite)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to List User Favorite records: %v", err)
+	}
+
+	return favoritesList, nil
+}
+
 // Delete will delete a User Favorite from the datastore
 func (p *FavoritesDBStore) Delete(userGUID string, guid string) error {
 	if _, err := p.db.Exec(deleteFavorite, userGUID, guid); err != nil {
@@ -13,6 +13,7 @@ type UserFavoriteRecord struct {
 // FavoritesStore is the user favorites repository
 type FavoritesStore interface {
 	List(userGUID string) ([]*UserFavoriteRecord, error)
+	ListAll() ([]*UserFavoriteRecord, error)
 	Delete(userGUID string, guid string) error
 	Save(favoriteRecord UserFavoriteRecord) (*UserFavoriteRecord, error)
 	SetMetadata(userGUID string, guid string, metadata string) error
@@ -3,6 +3,7 @@ package userinfo
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -46,9 +47,11 @@ func (userInfo *LocalUserInfo) GetUserInfo(id string) (int, []byte, error) {
 	uaaUser.Name.GivenName = user.GivenName
 	uaaUser.Name.FamilyName = user.FamilyName
 
-	groups := make([]uaaUserGroup, 2)
-	groups[0] = uaaUserGroup{Display: user.Scope}
-	groups[1] = uaaUserGroup{Display: "password.write"}
+	groups := make([]uaaUserGroup, 0)
+	for _, scope := range strings.Fields(user.Scope) {
+		groups = append(groups, uaaUserGroup{Display: scope})
+	}
+	groups = append(groups, uaaUserGroup{Display: "password.write"})
 	uaaUser.Groups = groups
 
 	uaaUser.Meta.Version = 0
@@ -131,18 +134,32 @@ func (userInfo *LocalUserInfo) UpdatePassword(id string, passwordInfo *passwordC
 		)
 	}
 
+	// New password must meet the password policy
+	policy := localusers.NewPasswordPolicy(userInfo.portalProxy.GetConfig())
+	if err = policy.Check(localUsersRepo, id, passwordInfo.NewPassword); err != nil {
+		if _, ok := err.(localusers.PasswordPolicyError); ok {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				err.Error(),
+				"New password does not meet the password policy: %v", err,
+			)
+		}
+		return err
+	}
+
 	passwordHash, err := HashPassword(passwordInfo.NewPassword)
 	if err != nil {
 		return err
 	}
 
 	user.PasswordHash = passwordHash
+	user.PasswordChangeRequired = false
 
 	err = localUsersRepo.UpdateLocalUser(user)
 	if err != nil {
 		return err
 	}
-	return nil
+	return policy.Remember(localUsersRepo, id, passwordHash)
 }
 
 //HashPassword accepts a plaintext password string and generates a salted hash
@@ -54,16 +54,18 @@ func (userinvite *UserInvite) GetRoutePlugin() (interfaces.RoutePlugin, error) {
 
 // AddAdminGroupRoutes adds the admin routes for this plugin to the Echo server
 func (userinvite *UserInvite) AddAdminGroupRoutes(echoGroup *echo.Group) {
-	echoGroup.GET("/invite/:id", userinvite.status)
-	echoGroup.POST("/invite/:id", userinvite.configure)
-	echoGroup.DELETE("/invite/:id", userinvite.remove)
+	// no-op
 }
 
 // AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
 func (userinvite *UserInvite) AddSessionGroupRoutes(echoGroup *echo.Group) {
+	requireUserManager := userinvite.portalProxy.RequirePermission(interfaces.PermissionUsersManage)
+	echoGroup.GET("/invite/:id", userinvite.status, requireUserManager)
+	echoGroup.POST("/invite/:id", userinvite.configure, requireUserManager, userinvite.portalProxy.AuditMiddleware(interfaces.AuditUserInviteConfigure))
+	echoGroup.DELETE("/invite/:id", userinvite.remove, requireUserManager, userinvite.portalProxy.AuditMiddleware(interfaces.AuditUserInviteRemove))
 
 	// User Info
-	echoGroup.POST("/invite/send/:id", userinvite.invite)
+	echoGroup.POST("/invite/send/:id", userinvite.invite, userinvite.portalProxy.AuditMiddleware(interfaces.AuditUserInvite))
 }
 
 // Init performs plugin initialization
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/secrets"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/govau/cf-common/env"
 )
@@ -13,16 +15,27 @@ import (
 type portalProxy struct {
 	Config                 interfaces.PortalConfig
 	DatabaseConnectionPool *sql.DB
+	SecretStore            secrets.Store
 	SessionStore           interfaces.SessionStorer
 	SessionStoreOptions    *sessions.Options
 	Plugins                map[string]interfaces.StratosPlugin
 	PluginsStatus          map[string]bool
 	Diagnostics            *interfaces.Diagnostics
 	SessionCookieName      string
+	SessionCodecs          []securecookie.Codec
 	EmptyCookieMatcher     *regexp.Regexp // Used to detect and remove empty Cookies sent by certain browsers
 	AuthProviders          map[string]interfaces.AuthProvider
 	env                    *env.VarSet
 	StratosAuthService     interfaces.StratosAuth
+	ProxyCache             *proxyCache
+	CircuitBreakers        *circuitBreakers
+	InstanceID             string
+	Leader                 *leaderElection
+	RoleMappings           *roleMappings
+	KeyRotation            *keyRotation
+	LoginThrottle          *loginThrottle
+	TrustedProxies         trustedProxies
+	APITokenRoutes         map[string]string
 }
 
 // HttpSessionStore - Interface for a store that can manage HTTP Sessions
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Default maximum number of pages that will be fetched from an endpoint for a single proxy-all request
+const defaultProxyAllMaxPages = 50
+
+// Maximum number of pages fetched concurrently from a single endpoint
+const proxyAllPageConcurrency = 10
+
+// cfListPagination is the pagination block of a CF v3 list response
+type cfListPagination struct {
+	TotalPages int `json:"total_pages"`
+	Next       *struct {
+		Href string `json:"href"`
+	} `json:"next"`
+}
+
+// cfListPage is a page of a CF v2 or v3 list response
+type cfListPage struct {
+	// v2
+	TotalPages *int    `json:"total_pages"`
+	NextURL    *string `json:"next_url"`
+	// v3
+	Pagination *cfListPagination `json:"pagination"`
+
+	Resources []json.RawMessage `json:"resources"`
+}
+
+func parseCFListPage(data []byte) (*cfListPage, error) {
+	page := &cfListPage{}
+	if err := json.Unmarshal(data, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// isList indicates if the response looks like a paginated CF list response
+func (page *cfListPage) isList() bool {
+	return page.Resources != nil && (page.TotalPages != nil || page.NextURL != nil || page.Pagination != nil)
+}
+
+func (page *cfListPage) totalPages() int {
+	if page.TotalPages != nil {
+		return *page.TotalPages
+	}
+	if page.Pagination != nil {
+		return page.Pagination.TotalPages
+	}
+	return 0
+}
+
+func (page *cfListPage) nextURL() string {
+	if page.NextURL != nil {
+		return *page.NextURL
+	}
+	if page.Pagination != nil && page.Pagination.Next != nil {
+		return page.Pagination.Next.Href
+	}
+	return ""
+}
+
+// pageURL returns the next page URL with the page number changed to the given page
+func pageURL(nextURL string, page int) (string, error) {
+	u, err := url.Parse(nextURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// mergeCFListPages replaces the resources in the first page with the resources from all pages
+// The next link is updated to point to the first page that was not fetched (if any)
+func mergeCFListPages(firstPage []byte, resources []json.RawMessage, next string) ([]byte, error) {
+	var merged map[string]*json.RawMessage
+	if err := json.Unmarshal(firstPage, &merged); err != nil {
+		return nil, err
+	}
+
+	var nextJSON *json.RawMessage
+	if len(next) > 0 {
+		nextJSON = rawJSON(next)
+	}
+
+	if resourcesJSON, err := json.Marshal(resources); err == nil {
+		merged["resources"] = (*json.RawMessage)(&resourcesJSON)
+	} else {
+		return nil, err
+	}
+
+	if _, ok := merged["next_url"]; ok {
+		merged["next_url"] = nextJSON
+	}
+
+	if paginationJSON, ok := merged["pagination"]; ok && paginationJSON != nil {
+		var pagination map[string]*json.RawMessage
+		if err := json.Unmarshal(*paginationJSON, &pagination); err != nil {
+			return nil, err
+		}
+		if nextJSON != nil {
+			pagination["next"] = rawJSON(map[string]string{"href": next})
+		} else {
+			pagination["next"] = nil
+		}
+		merged["pagination"] = rawJSON(pagination)
+	}
+
+	return json.Marshal(merged)
+}
+
+func rawJSON(value interface{}) *json.RawMessage {
+	data, _ := json.Marshal(value)
+	return (*json.RawMessage)(&data)
+}
+
+// copyErrorResponse copies a failed page response into the endpoint's response
+func copyErrorResponse(res *interfaces.CNSIRequest, pageRes *interfaces.CNSIRequest) {
+	if pageRes == nil {
+		res.StatusCode = http.StatusInternalServerError
+		res.Status = "Failed to fetch all pages"
+		res.Response = nil
+		res.Error = fmt.Errorf("Failed to fetch all pages")
+		return
+	}
+	res.StatusCode = pageRes.StatusCode
+	res.Status = pageRes.Status
+	res.Response = pageRes.Response
+	res.Error = pageRes.Error
+}
+
+func isPageOK(pageRes *interfaces.CNSIRequest) bool {
+	return pageRes != nil && pageRes.Error == nil && pageRes.StatusCode == http.StatusOK
+}
+
+// proxyAll proxies a CF list API request, following the pagination links to fetch all of the pages from each endpoint
+func (p *portalProxy) proxyAll(c echo.Context) error {
+	log.Debug("proxyAll")
+	responses, err := p.ProxyRequest(c, makeRequestURI(c))
+	if err != nil {
+		return err
+	}
+
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	header := getEchoHeaders(c)
+	header.Del("Cookie")
+
+	// Later pages must come from the same API host as the first page
+	apiHost := c.Request().Header.Get("x-cap-api-host")
+
+	maxPages := int(p.Config.ProxyAllMaxPages)
+	if maxPages <= 0 {
+		maxPages = defaultProxyAllMaxPages
+	}
+
+	var wg sync.WaitGroup
+	for _, res := range responses {
+		wg.Add(1)
+		go func(res *interfaces.CNSIRequest) {
+			defer wg.Done()
+			p.fetchAllPages(res, userGUID, header, apiHost, maxPages)
+		}(res)
+	}
+	wg.Wait()
+
+	return p.SendProxiedResponse(c, responses)
+}
+
+// fetchAllPages fetches the remaining pages of the list response from the endpoint and merges them into the response
+func (p *portalProxy) fetchAllPages(res *interfaces.CNSIRequest, userGUID string, header http.Header, apiHost string, maxPages int) {
+	if res.Error != nil || res.StatusCode != http.StatusOK {
+		return
+	}
+
+	firstPage, err := parseCFListPage(res.Response)
+	if err != nil || !firstPage.isList() {
+		// Not a list response, so leave it as is
+		return
+	}
+
+	resources := firstPage.Resources
+	next := firstPage.nextURL()
+	totalPages := firstPage.totalPages()
+
+	if totalPages > 1 && len(next) > 0 {
+		// We know how many pages there are, so fetch them concurrently
+		lastPage := totalPages
+		if lastPage > maxPages {
+			lastPage = maxPages
+		}
+		for start := 2; start <= lastPage; start += proxyAllPageConcurrency {
+			end := start + proxyAllPageConcurrency - 1
+			if end > lastPage {
+				end = lastPage
+			}
+			pages, ok := p.fetchPages(res, userGUID, header, apiHost, next, start, end)
+			if !ok {
+				return
+			}
+			for _, page := range pages {
+				resources = append(resources, page.Resources...)
+			}
+		}
+
+		if lastPage < totalPages {
+			next, err = pageURL(next, lastPage+1)
+			if err != nil {
+				copyErrorResponse(res, nil)
+				return
+			}
+		} else {
+			next = ""
+		}
+	} else {
+		// Follow the next links one at a time
+		for fetched := 1; len(next) > 0 && fetched < maxPages; fetched++ {
+			pages, ok := p.fetchPages(res, userGUID, header, apiHost, next, 0, 0)
+			if !ok {
+				return
+			}
+			resources = append(resources, pages[0].Resources...)
+			next = pages[0].nextURL()
+		}
+	}
+
+	merged, err := mergeCFListPages(res.Response, resources, next)
+	if err != nil {
+		log.Warnf("Unable to merge pages for endpoint %s: %v", res.GUID, err)
+		copyErrorResponse(res, nil)
+		return
+	}
+	res.Response = merged
+}
+
+// fetchPages fetches the pages from start to end (inclusive) of the next URL - if start is 0, the next URL is fetched as is
+// If any page fails, the failure is copied into the endpoint's response
+func (p *portalProxy) fetchPages(res *interfaces.CNSIRequest, userGUID string, header http.Header, apiHost, next string, start, end int) ([]*cfListPage, bool) {
+	var requests []interfaces.ProxyRequestInfo
+	for page := start; page <= end; page++ {
+		pageNext := next
+		if page > 0 {
+			var err error
+			if pageNext, err = pageURL(next, page); err != nil {
+				copyErrorResponse(res, nil)
+				return nil, false
+			}
+		}
+		uri, err := url.Parse(pageNext)
+		if err != nil {
+			copyErrorResponse(res, nil)
+			return nil, false
+		}
+		requests = append(requests, interfaces.ProxyRequestInfo{
+			EndpointGUID: res.GUID,
+			URI:          &url.URL{Path: uri.Path, RawQuery: uri.RawQuery},
+			UserGUID:     userGUID,
+			ResultGUID:   fmt.Sprintf("%s-%d", res.GUID, page),
+			Headers:      header,
+			Method:       http.MethodGet,
+			APIHost:      apiHost,
+		})
+	}
+
+	responses, err := p.DoProxyRequest(requests)
+	if err != nil {
+		copyErrorResponse(res, nil)
+		return nil, false
+	}
+
+	pages := make([]*cfListPage, 0, len(requests))
+	for _, request := range requests {
+		pageRes := responses[request.ResultGUID]
+		if !isPageOK(pageRes) {
+			copyErrorResponse(res, pageRes)
+			return nil, false
+		}
+		page, err := parseCFListPage(pageRes.Response)
+		if err != nil {
+			copyErrorResponse(res, nil)
+			return nil, false
+		}
+		pages = append(pages, page)
+	}
+	return pages, true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const mockV2ListPage = `{"total_results":3,"total_pages":3,"prev_url":null,"next_url":"/v2/apps?order-direction=asc&page=2&results-per-page=1","resources":[{"metadata":{"guid":"app-1"}}]}`
+
+const mockV3ListPage = `{"pagination":{"total_results":3,"total_pages":3,"first":{"href":"https://api.example.com/v3/apps?page=1&per_page=1"},"next":{"href":"https://api.example.com/v3/apps?page=2&per_page=1"},"previous":null},"resources":[{"guid":"app-1"}]}`
+
+func TestProxyAllParsePages(t *testing.T) {
+	t.Parallel()
+
+	Convey("Parsing CF list pages", t, func() {
+
+		Convey("should parse a v2 list page", func() {
+			page, err := parseCFListPage([]byte(mockV2ListPage))
+			So(err, ShouldBeNil)
+			So(page.isList(), ShouldBeTrue)
+			So(page.totalPages(), ShouldEqual, 3)
+			So(page.nextURL(), ShouldEqual, "/v2/apps?order-direction=asc&page=2&results-per-page=1")
+			So(len(page.Resources), ShouldEqual, 1)
+		})
+
+		Convey("should parse a v3 list page", func() {
+			page, err := parseCFListPage([]byte(mockV3ListPage))
+			So(err, ShouldBeNil)
+			So(page.isList(), ShouldBeTrue)
+			So(page.totalPages(), ShouldEqual, 3)
+			So(page.nextURL(), ShouldEqual, "https://api.example.com/v3/apps?page=2&per_page=1")
+		})
+
+		Convey("should not treat other responses as lists", func() {
+			page, err := parseCFListPage([]byte(jsonMust(mockV2InfoResponse)))
+			So(err, ShouldBeNil)
+			So(page.isList(), ShouldBeFalse)
+		})
+
+		Convey("should change the page of a next URL", func() {
+			next, err := pageURL("/v2/apps?order-direction=asc&page=2&results-per-page=1", 3)
+			So(err, ShouldBeNil)
+			So(next, ShouldEqual, "/v2/apps?order-direction=asc&page=3&results-per-page=1")
+
+			next, err = pageURL("https://api.example.com/v3/apps?page=2&per_page=1", 3)
+			So(err, ShouldBeNil)
+			So(next, ShouldEqual, "https://api.example.com/v3/apps?page=3&per_page=1")
+		})
+	})
+}
+
+func TestProxyAllMergePages(t *testing.T) {
+	t.Parallel()
+
+	resources := []json.RawMessage{
+		json.RawMessage(`{"guid":"app-1"}`),
+		json.RawMessage(`{"guid":"app-2"}`),
+		json.RawMessage(`{"guid":"app-3"}`),
+	}
+
+	Convey("Merging CF list pages", t, func() {
+
+		Convey("should merge v2 pages", func() {
+			merged, err := mergeCFListPages([]byte(mockV2ListPage), resources, "")
+			So(err, ShouldBeNil)
+			page, err := parseCFListPage(merged)
+			So(err, ShouldBeNil)
+			So(len(page.Resources), ShouldEqual, 3)
+			So(page.NextURL, ShouldBeNil)
+			So(page.totalPages(), ShouldEqual, 3)
+		})
+
+		Convey("should merge v3 pages", func() {
+			merged, err := mergeCFListPages([]byte(mockV3ListPage), resources, "")
+			So(err, ShouldBeNil)
+			page, err := parseCFListPage(merged)
+			So(err, ShouldBeNil)
+			So(len(page.Resources), ShouldEqual, 3)
+			So(page.nextURL(), ShouldBeEmpty)
+		})
+
+		Convey("should keep the next link when not all pages were fetched", func() {
+			merged, err := mergeCFListPages([]byte(mockV3ListPage), resources[:2], "https://api.example.com/v3/apps?page=3&per_page=1")
+			So(err, ShouldBeNil)
+			page, err := parseCFListPage(merged)
+			So(err, ShouldBeNil)
+			So(len(page.Resources), ShouldEqual, 2)
+			So(page.nextURL(), ShouldEqual, "https://api.example.com/v3/apps?page=3&per_page=1")
+		})
+	})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+// Default maximum number of responses held in the proxy cache
+const defaultProxyCacheMaxEntries = 1000
+
+// proxyCacheTTL is the cache lifetime for requests whose path matches the pattern
+type proxyCacheTTL struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// proxyCacheEntry is a cached response from an endpoint
+type proxyCacheEntry struct {
+	EndpointGUID string
+	StatusCode   int
+	Status       string
+	Response     []byte
+	ETag         string
+	LastModified string
+	Expires      time.Time
+}
+
+func (e *proxyCacheEntry) isFresh() bool {
+	return time.Now().Before(e.Expires)
+}
+
+// canRevalidate indicates if we can make a conditional request to check if a stale entry is still valid
+func (e *proxyCacheEntry) canRevalidate() bool {
+	return len(e.ETag) > 0 || len(e.LastModified) > 0
+}
+
+func (e *proxyCacheEntry) apply(cnsiRequest *interfaces.CNSIRequest) {
+	cnsiRequest.StatusCode = e.StatusCode
+	cnsiRequest.Status = e.Status
+	cnsiRequest.Response = e.Response
+	cnsiRequest.Error = nil
+}
+
+// proxyCache is an in-memory cache of responses to idempotent proxy requests
+type proxyCache struct {
+	sync.Mutex
+	ttls          []proxyCacheTTL
+	maxEntries    int
+	entries       map[string]*proxyCacheEntry
+	generations   map[string]int64
+	hits          int64
+	misses        int64
+	revalidations int64
+	invalidations int64
+}
+
+// newProxyCache creates the proxy cache - returns nil if no cache TTLs have been configured
+// TTLs are configured as a list of path pattern and seconds, e.g. /v2/info=300,/v2/organizations/*=30
+func newProxyCache(ttlConfig []string, maxEntries int64) *proxyCache {
+	var ttls []proxyCacheTTL
+	for _, item := range ttlConfig {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			log.Warnf("Ignoring invalid proxy cache TTL: %s", item)
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || seconds <= 0 {
+			log.Warnf("Ignoring invalid proxy cache TTL: %s", item)
+			continue
+		}
+		pattern := strings.TrimSpace(parts[0])
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Warnf("Ignoring invalid proxy cache path pattern: %s", pattern)
+			continue
+		}
+		ttls = append(ttls, proxyCacheTTL{
+			Pattern: pattern,
+			TTL:     time.Duration(seconds) * time.Second,
+		})
+	}
+
+	if len(ttls) == 0 {
+		return nil
+	}
+
+	if maxEntries <= 0 {
+		maxEntries = defaultProxyCacheMaxEntries
+	}
+
+	return &proxyCache{
+		ttls:        ttls,
+		maxEntries:  int(maxEntries),
+		entries:     make(map[string]*proxyCacheEntry),
+		generations: make(map[string]int64),
+	}
+}
+
+// getTTL returns the TTL for the first pattern that matches the given path, or 0 if none match
+func (pc *proxyCache) getTTL(requestPath string) time.Duration {
+	for _, ttl := range pc.ttls {
+		if matched, _ := path.Match(ttl.Pattern, requestPath); matched {
+			return ttl.TTL
+		}
+	}
+	return 0
+}
+
+func (pc *proxyCache) get(key string) *proxyCacheEntry {
+	pc.Lock()
+	defer pc.Unlock()
+	return pc.entries[key]
+}
+
+func (pc *proxyCache) put(key string, entry *proxyCacheEntry) {
+	pc.Lock()
+	defer pc.Unlock()
+	pc.store(key, entry)
+}
+
+// generation returns the number of times that the cached responses for the endpoint have been invalidated
+func (pc *proxyCache) generation(endpointGUID string) int64 {
+	pc.Lock()
+	defer pc.Unlock()
+	return pc.generations[endpointGUID]
+}
+
+// putIfCurrent stores the entry unless the cached responses for its endpoint have been invalidated since the given generation
+// This stops a response to a request that was in flight during a mutating request from being cached after the invalidation
+func (pc *proxyCache) putIfCurrent(key string, entry *proxyCacheEntry, generation int64) bool {
+	pc.Lock()
+	defer pc.Unlock()
+
+	if pc.generations[entry.EndpointGUID] != generation {
+		return false
+	}
+	pc.store(key, entry)
+	return true
+}
+
+// remove deletes the cached response for the key
+func (pc *proxyCache) remove(key string) {
+	pc.Lock()
+	defer pc.Unlock()
+	delete(pc.entries, key)
+}
+
+// Caller must hold the lock
+func (pc *proxyCache) store(key string, entry *proxyCacheEntry) {
+	if _, ok := pc.entries[key]; !ok && len(pc.entries) >= pc.maxEntries {
+		pc.evict()
+	}
+	pc.entries[key] = entry
+}
+
+// evict removes expired entries that can not be revalidated - if the cache is still full, the entry closest to expiry is removed
+// Caller must hold the lock
+func (pc *proxyCache) evict() {
+	var oldestKey string
+	var oldest *proxyCacheEntry
+	for key, entry := range pc.entries {
+		if !entry.isFresh() && !entry.canRevalidate() {
+			delete(pc.entries, key)
+			continue
+		}
+		if oldest == nil || entry.Expires.Before(oldest.Expires) {
+			oldestKey = key
+			oldest = entry
+		}
+	}
+
+	if len(pc.entries) >= pc.maxEntries && oldest != nil {
+		delete(pc.entries, oldestKey)
+	}
+}
+
+// invalidate removes all of the cached responses for the given endpoint
+func (pc *proxyCache) invalidate(endpointGUID string) {
+	pc.Lock()
+	defer pc.Unlock()
+
+	for key, entry := range pc.entries {
+		if entry.EndpointGUID == endpointGUID {
+			delete(pc.entries, key)
+		}
+	}
+	pc.generations[endpointGUID]++
+	pc.invalidations++
+}
+
+func (pc *proxyCache) recordHit() {
+	pc.Lock()
+	pc.hits++
+	pc.Unlock()
+}
+
+func (pc *proxyCache) recordMiss() {
+	pc.Lock()
+	pc.misses++
+	pc.Unlock()
+}
+
+func (pc *proxyCache) recordRevalidation() {
+	pc.Lock()
+	pc.revalidations++
+	pc.Unlock()
+}
+
+// Stats returns the current cache counters
+func (pc *proxyCache) Stats() *interfaces.ProxyCacheStats {
+	pc.Lock()
+	defer pc.Unlock()
+	return &interfaces.ProxyCacheStats{
+		Entries:       len(pc.entries),
+		Hits:          pc.hits,
+		Misses:        pc.misses,
+		Revalidations: pc.revalidations,
+		Invalidations: pc.invalidations,
+	}
+}
+
+// parseCacheControl returns whether a response can be stored and the max-age (or -1 if not specified)
+func parseCacheControl(header http.Header) (bool, time.Duration) {
+	maxAge := time.Duration(-1)
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return false, 0
+		case directive == "no-cache":
+			maxAge = 0
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && maxAge != 0 {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return true, maxAge
+}
+
+// isConditionalRequest indicates if the client has made its own conditional request
+func isConditionalRequest(header http.Header) bool {
+	return len(header.Get("If-None-Match")) > 0 || len(header.Get("If-Modified-Since")) > 0 ||
+		strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-cache")
+}
+
+func isMutatingMethod(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// getProxyCacheKey returns the cache key and TTL for the request - an empty key means the request can not be cached
+func (p *portalProxy) getProxyCacheKey(cnsiRequest *interfaces.CNSIRequest) (string, time.Duration) {
+	if p.ProxyCache == nil || cnsiRequest.Method != http.MethodGet || isConditionalRequest(cnsiRequest.Header) {
+		return "", 0
+	}
+
+	ttl := p.ProxyCache.getTTL(cnsiRequest.URL.Path)
+	if ttl == 0 {
+		return "", 0
+	}
+
+	// Responses made with a system shared token are the same for all users
+	tokenRec, ok := p.GetCNSITokenRecord(cnsiRequest.GUID, cnsiRequest.UserGUID)
+	if !ok {
+		return "", 0
+	}
+	userGUID := cnsiRequest.UserGUID
+	if tokenRec.SystemShared {
+		userGUID = tokens.SystemSharedUserGuid
+	}
+
+	return fmt.Sprintf("%s|%s|%s|%s", cnsiRequest.GUID, userGUID, cnsiRequest.Method, cnsiRequest.URL.String()), ttl
+}
+
+// doCachedRequest serves the request from the proxy cache if possible, otherwise makes the request and caches the response
+func (p *portalProxy) doCachedRequest(cnsiRequest *interfaces.CNSIRequest, cacheKey string, ttl time.Duration) {
+	generation := p.ProxyCache.generation(cnsiRequest.GUID)
+	cached := p.ProxyCache.get(cacheKey)
+	if cached != nil && cached.isFresh() {
+		cached.apply(cnsiRequest)
+		p.ProxyCache.recordHit()
+		return
+	}
+	p.ProxyCache.recordMiss()
+
+	// Make a conditional request if we have a stale entry - the header is shared between requests, so copy it
+	if cached != nil && cached.canRevalidate() {
+		header := make(http.Header)
+		for k, v := range cnsiRequest.Header {
+			header[k] = v
+		}
+		if len(cached.ETag) > 0 {
+			header.Set("If-None-Match", cached.ETag)
+		}
+		if len(cached.LastModified) > 0 {
+			header.Set("If-Modified-Since", cached.LastModified)
+		}
+		cnsiRequest.Header = header
+	}
+
+	res := p.readUpstreamResponse(cnsiRequest)
+	if cnsiRequest.Error != nil || res == nil {
+		return
+	}
+
+	// The conditional request was ours, not the client's, so the client always gets the stored response
+	notModified := cnsiRequest.StatusCode == http.StatusNotModified && cached != nil
+	if notModified {
+		p.ProxyCache.recordRevalidation()
+		cached.apply(cnsiRequest)
+	}
+
+	cacheable, maxAge := parseCacheControl(res.Header)
+	if !cacheable {
+		if notModified {
+			p.ProxyCache.remove(cacheKey)
+		}
+		return
+	}
+	if maxAge >= 0 && maxAge < ttl {
+		ttl = maxAge
+	}
+
+	switch {
+	case notModified:
+		p.ProxyCache.putIfCurrent(cacheKey, &proxyCacheEntry{
+			EndpointGUID: cached.EndpointGUID,
+			StatusCode:   cached.StatusCode,
+			Status:       cached.Status,
+			Response:     cached.Response,
+			ETag:         cached.ETag,
+			LastModified: cached.LastModified,
+			Expires:      time.Now().Add(ttl),
+		}, generation)
+	case cnsiRequest.StatusCode == http.StatusOK:
+		p.ProxyCache.putIfCurrent(cacheKey, &proxyCacheEntry{
+			EndpointGUID: cnsiRequest.GUID,
+			StatusCode:   cnsiRequest.StatusCode,
+			Status:       cnsiRequest.Status,
+			Response:     cnsiRequest.Response,
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+			Expires:      time.Now().Add(ttl),
+		}, generation)
+	}
+}
+
+// invalidateProxyCache removes cached responses for the endpoint of a mutating request
+func (p *portalProxy) invalidateProxyCache(cnsiRequest *interfaces.CNSIRequest) {
+	if p.ProxyCache != nil && isMutatingMethod(cnsiRequest.Method) {
+		p.ProxyCache.invalidate(cnsiRequest.GUID)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestProxyCacheConfig(t *testing.T) {
+	t.Parallel()
+
+	Convey("Proxy cache configuration", t, func() {
+
+		Convey("should be disabled when no TTLs are configured", func() {
+			So(newProxyCache(nil, 0), ShouldBeNil)
+			So(newProxyCache([]string{""}, 0), ShouldBeNil)
+		})
+
+		Convey("should ignore invalid TTLs", func() {
+			So(newProxyCache([]string{"/v2/info", "/v2/info=abc", "/v2/info=-1", "[=10"}, 0), ShouldBeNil)
+		})
+
+		Convey("should match TTLs by path pattern", func() {
+			pc := newProxyCache([]string{"/v2/info=300", " /v2/organizations/*=30 "}, 0)
+			So(pc, ShouldNotBeNil)
+			So(pc.maxEntries, ShouldEqual, defaultProxyCacheMaxEntries)
+			So(pc.getTTL("/v2/info"), ShouldEqual, 300*time.Second)
+			So(pc.getTTL("/v2/organizations/abc"), ShouldEqual, 30*time.Second)
+			So(pc.getTTL("/v2/organizations/abc/spaces"), ShouldEqual, 0)
+			So(pc.getTTL("/v2/apps"), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestProxyCacheEntries(t *testing.T) {
+	t.Parallel()
+
+	Convey("Proxy cache entries", t, func() {
+		pc := newProxyCache([]string{"/v2/info=300"}, 2)
+
+		fresh := &proxyCacheEntry{EndpointGUID: "cf-1", StatusCode: 200, Response: []byte("a"), Expires: time.Now().Add(time.Minute)}
+		stale := &proxyCacheEntry{EndpointGUID: "cf-2", StatusCode: 200, Response: []byte("b"), Expires: time.Now().Add(-time.Minute)}
+		pc.put("a", fresh)
+		pc.put("b", stale)
+
+		Convey("should return stored entries", func() {
+			So(pc.get("a"), ShouldEqual, fresh)
+			So(pc.get("a").isFresh(), ShouldBeTrue)
+			So(pc.get("b").isFresh(), ShouldBeFalse)
+			So(pc.get("c"), ShouldBeNil)
+		})
+
+		Convey("should evict expired entries when full", func() {
+			pc.put("c", fresh)
+			So(pc.get("b"), ShouldBeNil)
+			So(pc.Stats().Entries, ShouldEqual, 2)
+		})
+
+		Convey("should invalidate all entries for an endpoint", func() {
+			pc.put("c", &proxyCacheEntry{EndpointGUID: "cf-1", Expires: time.Now().Add(time.Minute)})
+			pc.invalidate("cf-1")
+			So(pc.get("a"), ShouldBeNil)
+			So(pc.get("c"), ShouldBeNil)
+			So(pc.Stats().Invalidations, ShouldEqual, 1)
+		})
+
+		Convey("should not store a response from before an invalidation", func() {
+			generation := pc.generation("cf-1")
+			pc.invalidate("cf-1")
+			So(pc.putIfCurrent("c", &proxyCacheEntry{EndpointGUID: "cf-1", Expires: time.Now().Add(time.Minute)}, generation), ShouldBeFalse)
+			So(pc.get("c"), ShouldBeNil)
+
+			So(pc.putIfCurrent("c", &proxyCacheEntry{EndpointGUID: "cf-1", Expires: time.Now().Add(time.Minute)}, pc.generation("cf-1")), ShouldBeTrue)
+			So(pc.get("c"), ShouldNotBeNil)
+		})
+
+		Convey("should apply a cached response to a request", func() {
+			cnsiRequest := &interfaces.CNSIRequest{}
+			fresh.apply(cnsiRequest)
+			So(cnsiRequest.StatusCode, ShouldEqual, 200)
+			So(string(cnsiRequest.Response), ShouldEqual, "a")
+		})
+	})
+}
+
+func TestProxyCacheControl(t *testing.T) {
+	t.Parallel()
+
+	Convey("Cache-Control parsing", t, func() {
+		header := make(http.Header)
+
+		cacheable, maxAge := parseCacheControl(header)
+		So(cacheable, ShouldBeTrue)
+		So(maxAge, ShouldEqual, -1)
+
+		header.Set("Cache-Control", "private, max-age=10")
+		cacheable, maxAge = parseCacheControl(header)
+		So(cacheable, ShouldBeTrue)
+		So(maxAge, ShouldEqual, 10*time.Second)
+
+		header.Set("Cache-Control", "no-cache, max-age=10")
+		cacheable, maxAge = parseCacheControl(header)
+		So(cacheable, ShouldBeTrue)
+		So(maxAge, ShouldEqual, 0)
+
+		header.Set("Cache-Control", "no-store")
+		cacheable, _ = parseCacheControl(header)
+		So(cacheable, ShouldBeFalse)
+
+		So(isConditionalRequest(http.Header{"If-None-Match": []string{"abc"}}), ShouldBeTrue)
+		So(isMutatingMethod("GET"), ShouldBeFalse)
+		So(isMutatingMethod("DELETE"), ShouldBeTrue)
+	})
+}
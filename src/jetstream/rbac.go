@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	// Every user is granted these roles unless RBAC_DEFAULT_ROLES is set
+	defaultRBACRoles = interfaces.RoleEndpointViewer
+	// Set RBAC_DEFAULT_ROLES to this to grant no roles by default
+	noRBACRoles = "none"
+)
+
+// roleMappings maps the scopes and groups of a user to roles
+type roleMappings struct {
+	scopes       map[string][]string
+	defaultRoles []string
+}
+
+// newRoleMappings parses role mappings of the form "role=scope1,group1;role2=scope2" and a comma-separated list of default roles
+func newRoleMappings(mappings string, defaultRoles string) *roleMappings {
+	r := &roleMappings{
+		scopes:       make(map[string][]string),
+		defaultRoles: make([]string, 0),
+	}
+
+	for _, mapping := range strings.Split(mappings, ";") {
+		if len(strings.TrimSpace(mapping)) == 0 {
+			continue
+		}
+		parts := strings.SplitN(mapping, "=", 2)
+		role := strings.TrimSpace(parts[0])
+		if _, ok := interfaces.RolePermissions[role]; !ok || len(parts) != 2 {
+			log.Warnf("Ignoring invalid role mapping: %s", mapping)
+			continue
+		}
+		for _, scope := range strings.Split(parts[1], ",") {
+			if scope = strings.TrimSpace(scope); len(scope) > 0 {
+				r.scopes[scope] = append(r.scopes[scope], role)
+			}
+		}
+	}
+
+	if len(defaultRoles) == 0 {
+		defaultRoles = defaultRBACRoles
+	}
+	for _, role := range strings.Split(defaultRoles, ",") {
+		role = strings.TrimSpace(role)
+		if len(role) == 0 || role == noRBACRoles {
+			continue
+		}
+		if _, ok := interfaces.RolePermissions[role]; !ok {
+			log.Warnf("Ignoring unknown default role: %s", role)
+			continue
+		}
+		r.defaultRoles = append(r.defaultRoles, role)
+	}
+
+	return r
+}
+
+// getPermissions returns the roles of the user and the permissions that they grant
+// Console admins always have the admin role
+func (r *roleMappings) getPermissions(user *interfaces.ConnectedUser) *interfaces.UserPermissions {
+	roles := make(map[string]bool)
+	if user.Admin {
+		roles[interfaces.RoleAdmin] = true
+	}
+	for _, role := range r.defaultRoles {
+		roles[role] = true
+	}
+	for _, scope := range user.Scopes {
+		for _, role := range r.scopes[scope] {
+			roles[role] = true
+		}
+	}
+	for _, group := range user.Groups {
+		for _, role := range r.scopes[group] {
+			roles[role] = true
+		}
+	}
+
+	permissions := make(map[string]bool)
+	for role := range roles {
+		for _, permission := range interfaces.RolePermissions[role] {
+			permissions[permission] = true
+		}
+	}
+
+	return &interfaces.UserPermissions{
+		Roles:       sortedKeys(roles),
+		Permissions: sortedKeys(permissions),
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetUserPermissions returns the roles and permissions of the user
+func (p *portalProxy) GetUserPermissions(userGUID string) (*interfaces.UserPermissions, error) {
+	user, err := p.StratosAuthService.GetUser(userGUID)
+	if err != nil {
+		return nil, err
+	}
+	return p.RoleMappings.getPermissions(user), nil
+}
+
+// RequirePermission only passes requests from users that have been granted the permission
+func (p *portalProxy) RequirePermission(permission string) echo.MiddlewareFunc {
+	return func(h echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, err := p.GetSessionStringValue(c, "user_id")
+			if err != nil {
+				return handleSessionError(p.Config, c, errors.New("Unauthorized"), false, "You must be logged in to access this API")
+			}
+
+			permissions, err := p.GetUserPermissions(userID)
+			if err != nil {
+				return c.NoContent(http.StatusUnauthorized)
+			}
+			if !permissions.Has(permission) {
+				return interfaces.NewHTTPShadowError(
+					http.StatusForbidden,
+					"You do not have permission to access this API",
+					"User %s does not have permission %s", userID, permission)
+			}
+			// Tokens that can make requests to endpoints can also see the endpoints they make requests to
+			if token := getRequestAPIToken(c); token != nil && !token.HasScope(permission) &&
+				!(permission == interfaces.PermissionEndpointsView && token.HasScope(interfaces.APITokenScopeProxy)) {
+				return interfaces.NewHTTPShadowError(
+					http.StatusForbidden,
+					"The API token does not have the scope needed for this API",
+					"API token %s does not have scope %s", token.GUID, permission)
+			}
+			return h(c)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestRoleMappings(t *testing.T) {
+	t.Parallel()
+
+	Convey("Role mappings", t, func() {
+
+		Convey("should grant the default roles to every user", func() {
+			permissions := newRoleMappings("", "").getPermissions(&interfaces.ConnectedUser{})
+			So(permissions.Roles, ShouldResemble, []string{interfaces.RoleEndpointViewer})
+			So(permissions.Permissions, ShouldResemble, []string{interfaces.PermissionEndpointsView})
+
+			permissions = newRoleMappings("", noRBACRoles).getPermissions(&interfaces.ConnectedUser{})
+			So(permissions.Roles, ShouldBeEmpty)
+			So(permissions.Has(interfaces.PermissionEndpointsView), ShouldBeFalse)
+		})
+
+		Convey("should grant every permission to console admins", func() {
+			permissions := newRoleMappings("", noRBACRoles).getPermissions(&interfaces.ConnectedUser{Admin: true})
+			So(permissions.Roles, ShouldResemble, []string{interfaces.RoleAdmin})
+			So(permissions.Permissions, ShouldHaveLength, len(interfaces.RolePermissions[interfaces.RoleAdmin]))
+		})
+
+		Convey("should map scopes to roles", func() {
+			mappings := newRoleMappings(" endpoint-admin = stratos.endpoints ; audit-reader=stratos.audit,stratos.endpoints;unknown=stratos.unknown;user-manager", "")
+
+			permissions := mappings.getPermissions(&interfaces.ConnectedUser{Scopes: []string{"openid", "stratos.endpoints"}})
+			So(permissions.Roles, ShouldResemble, []string{interfaces.RoleAuditReader, interfaces.RoleEndpointAdmin, interfaces.RoleEndpointViewer})
+			So(permissions.Permissions, ShouldResemble, []string{interfaces.PermissionAuditRead, interfaces.PermissionEndpointsAdmin, interfaces.PermissionEndpointsView})
+			So(permissions.Has(interfaces.PermissionConsoleAdmin), ShouldBeFalse)
+
+			permissions = mappings.getPermissions(&interfaces.ConnectedUser{Scopes: []string{"stratos.unknown"}})
+			So(permissions.Roles, ShouldResemble, []string{interfaces.RoleEndpointViewer})
+		})
+
+		Convey("should map groups to roles", func() {
+			mappings := newRoleMappings("endpoint-admin=stratos-endpoint-admins", noRBACRoles)
+
+			permissions := mappings.getPermissions(&interfaces.ConnectedUser{Groups: []string{"developers", "stratos-endpoint-admins"}})
+			So(permissions.Roles, ShouldResemble, []string{interfaces.RoleEndpointAdmin})
+
+			permissions = mappings.getPermissions(&interfaces.ConnectedUser{Groups: []string{"developers"}})
+			So(permissions.Roles, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestProxyRoutesRequireEndpointsView(t *testing.T) {
+	t.Parallel()
+
+	Convey("Requests to endpoints should only be passed through for users that can see endpoints", t, func() {
+		_, _, _, pp, db, _ := setupHTTPTest(setupMockReq("GET", "", nil))
+		defer db.Close()
+
+		pp.StratosAuthService = &mockStratosAuth{user: &interfaces.ConnectedUser{GUID: mockUserGUID}}
+		pp.RoleMappings = newRoleMappings("", noRBACRoles)
+
+		e := echo.New()
+		pp.addProxyRoutes(e.Group("/pp/v1"))
+
+		for _, path := range []string{"/pp/v1/proxy/v2/apps", "/pp/v1/proxy-all/v2/apps"} {
+			req := setupMockReq("GET", "http://127.0.0.1"+path, nil)
+			ctx := e.NewContext(req, httptest.NewRecorder())
+			session, _ := pp.GetSession(ctx)
+			session.Values["user_id"] = mockUserGUID
+
+			e.Router().Find(http.MethodGet, path, ctx)
+			err := ctx.Handler()(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusForbidden)
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package apitokens
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Repository is an application of the repository pattern for storing the personal API tokens of users
+type Repository interface {
+	Save(token interfaces.APIToken, tokenHash string) error
+	// FindByHash returns the token with the hash, or nil if there is no such token
+	FindByHash(tokenHash string) (*interfaces.APIToken, error)
+	ListByUser(userGUID string) ([]*interfaces.APIToken, error)
+	UpdateLastUsed(guid string, lastUsed time.Time) error
+	// Delete removes a token of the user - returns false if the user has no such token
+	Delete(userGUID string, guid string) (bool, error)
+	DeleteByUser(userGUID string) (int64, error)
+}
@@ -0,0 +1,160 @@
+package apitokens
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var saveAPIToken = `INSERT INTO api_tokens (guid, user_guid, name, token_hash, scopes, created, expires)
+							VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+var findAPIToken = `SELECT guid, user_guid, name, scopes, created, expires, last_used
+							FROM api_tokens
+							WHERE token_hash = $1`
+
+var listAPITokens = `SELECT guid, user_guid, name, scopes, created, expires, last_used
+							FROM api_tokens
+							WHERE user_guid = $1
+							ORDER BY created`
+
+var updateAPITokenLastUsed = `UPDATE api_tokens SET last_used = $1 WHERE guid = $2`
+
+var deleteAPIToken = `DELETE FROM api_tokens WHERE user_guid = $1 AND guid = $2`
+
+var deleteAPITokens = `DELETE FROM api_tokens WHERE user_guid = $1`
+
+// PgsqlAPITokensRepository is a PostgreSQL-backed API token repository
+type PgsqlAPITokensRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlAPITokensRepository will create a new instance of the PgsqlAPITokensRepository
+func NewPgsqlAPITokensRepository(dcp *sql.DB) (Repository, error) {
+	return &PgsqlAPITokensRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	saveAPIToken = datastore.ModifySQLStatement(saveAPIToken, databaseProvider)
+	findAPIToken = datastore.ModifySQLStatement(findAPIToken, databaseProvider)
+	listAPITokens = datastore.ModifySQLStatement(listAPITokens, databaseProvider)
+	updateAPITokenLastUsed = datastore.ModifySQLStatement(updateAPITokenLastUsed, databaseProvider)
+	deleteAPIToken = datastore.ModifySQLStatement(deleteAPIToken, databaseProvider)
+	deleteAPITokens = datastore.ModifySQLStatement(deleteAPITokens, databaseProvider)
+}
+
+// Save will persist the API token with the hash of its value
+func (p *PgsqlAPITokensRepository) Save(token interfaces.APIToken, tokenHash string) error {
+	log.Debug("Save API Token")
+	_, err := p.db.Exec(saveAPIToken, token.GUID, token.UserGUID, token.Name, tokenHash,
+		strings.Join(token.Scopes, ","), token.Created, token.Expires)
+	if err != nil {
+		return fmt.Errorf("Unable to save API token: %v", err)
+	}
+	return nil
+}
+
+// FindByHash returns the API token with the hash, or nil if there is no such token
+func (p *PgsqlAPITokensRepository) FindByHash(tokenHash string) (*interfaces.APIToken, error) {
+	log.Debug("Find API Token")
+	token, err := scanAPIToken(p.db.QueryRow(findAPIToken, tokenHash))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("Unable to find API token: %v", err)
+	}
+	return token, nil
+}
+
+// ListByUser returns the API tokens of the user, oldest first
+func (p *PgsqlAPITokensRepository) ListByUser(userGUID string) ([]*interfaces.APIToken, error) {
+	log.Debug("List API Tokens")
+	rows, err := p.db.Query(listAPITokens, userGUID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve API tokens: %v", err)
+	}
+	defer rows.Close()
+
+	list := make([]*interfaces.APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan API tokens: %v", err)
+		}
+		list = append(list, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list API tokens: %v", err)
+	}
+
+	return list, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIToken(row rowScanner) (*interfaces.APIToken, error) {
+	var scopes string
+	token := new(interfaces.APIToken)
+	if err := row.Scan(&token.GUID, &token.UserGUID, &token.Name, &scopes, &token.Created, &token.Expires, &token.LastUsed); err != nil {
+		return nil, err
+	}
+
+	token.Scopes = make([]string, 0)
+	for _, scope := range strings.Split(scopes, ",") {
+		if len(scope) > 0 {
+			token.Scopes = append(token.Scopes, scope)
+		}
+	}
+	return token, nil
+}
+
+// UpdateLastUsed records when the API token was last used
+func (p *PgsqlAPITokensRepository) UpdateLastUsed(guid string, lastUsed time.Time) error {
+	log.Debug("Update API Token Last Used")
+	if _, err := p.db.Exec(updateAPITokenLastUsed, lastUsed, guid); err != nil {
+		return fmt.Errorf("Unable to update API token: %v", err)
+	}
+	return nil
+}
+
+// Delete removes an API token of the user, returning false if the user has no such token
+func (p *PgsqlAPITokensRepository) Delete(userGUID string, guid string) (bool, error) {
+	log.Debug("Delete API Token")
+	result, err := p.db.Exec(deleteAPIToken, userGUID, guid)
+	if err != nil {
+		return false, fmt.Errorf("Unable to delete API token: %v", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Unable to determine number of deleted API tokens: %v", err)
+	}
+	return count > 0, nil
+}
+
+// DeleteByUser removes all of the API tokens of the user, returning the number removed
+func (p *PgsqlAPITokensRepository) DeleteByUser(userGUID string) (int64, error) {
+	log.Debug("Delete API Tokens")
+	result, err := p.db.Exec(deleteAPITokens, userGUID)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to delete API tokens: %v", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to determine number of deleted API tokens: %v", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,137 @@
+package apitokens
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPgSQLAPITokens(t *testing.T) {
+
+	var (
+		mockTokenGUID  = "mock-api-token-guid"
+		mockUserGUID   = "mock-user-guid"
+		mockTokenHash  = "mock-token-hash"
+		unknownDBError = "Unknown Database Error"
+
+		insertIntoAPITokens   = `INSERT INTO api_tokens`
+		selectByHash          = `SELECT (.+) FROM api_tokens WHERE token_hash = (.+)`
+		selectByUser          = `SELECT (.+) FROM api_tokens WHERE user_guid = (.+) ORDER BY created`
+		deleteFromAPITokens   = `DELETE FROM api_tokens WHERE user_guid = (.+) AND guid = (.+)`
+		rowFieldsForAPITokens = []string{"guid", "user_guid", "name", "scopes", "created", "expires", "last_used"}
+		mockTime              = time.Date(2019, 12, 2, 9, 0, 0, 0, time.UTC)
+	)
+
+	Convey("Given a request to save an API token", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		token := interfaces.APIToken{
+			GUID:     mockTokenGUID,
+			UserGUID: mockUserGUID,
+			Name:     "ci",
+			Scopes:   []string{"endpoints.view", "endpoints.proxy"},
+			Created:  mockTime,
+			Expires:  mockTime.Add(time.Hour),
+		}
+
+		Convey("the token should be inserted with its hash and scopes", func() {
+			mock.ExpectExec(insertIntoAPITokens).
+				WithArgs(mockTokenGUID, mockUserGUID, "ci", mockTokenHash, "endpoints.view,endpoints.proxy", mockTime, mockTime.Add(time.Hour)).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			repository, _ := NewPgsqlAPITokensRepository(db)
+			So(repository.Save(token, mockTokenHash), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectExec(insertIntoAPITokens).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlAPITokensRepository(db)
+			So(repository.Save(token, mockTokenHash), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a request to find an API token", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the token with the hash should be returned", func() {
+			mock.ExpectQuery(selectByHash).
+				WithArgs(mockTokenHash).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens).
+					AddRow(mockTokenGUID, mockUserGUID, "ci", "endpoints.view,endpoints.proxy", mockTime, mockTime.Add(time.Hour), mockTime))
+
+			repository, _ := NewPgsqlAPITokensRepository(db)
+			token, err := repository.FindByHash(mockTokenHash)
+			So(err, ShouldBeNil)
+			So(token.GUID, ShouldEqual, mockTokenGUID)
+			So(token.Scopes, ShouldResemble, []string{"endpoints.view", "endpoints.proxy"})
+			So(*token.LastUsed, ShouldEqual, mockTime)
+		})
+
+		Convey("nil should be returned for an unknown token", func() {
+			mock.ExpectQuery(selectByHash).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens))
+
+			repository, _ := NewPgsqlAPITokensRepository(db)
+			token, err := repository.FindByHash(mockTokenHash)
+			So(err, ShouldBeNil)
+			So(token, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to list the API tokens of a user", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("tokens that have never been used should have no last used time", func() {
+			mock.ExpectQuery(selectByUser).
+				WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAPITokens).
+					AddRow(mockTokenGUID, mockUserGUID, "ci", "endpoints.view", mockTime, mockTime.Add(time.Hour), nil))
+
+			repository, _ := NewPgsqlAPITokensRepository(db)
+			list, err := repository.ListByUser(mockUserGUID)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].LastUsed, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to delete an API token", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("only a token of the user should be deleted", func() {
+			mock.ExpectExec(deleteFromAPITokens).
+				WithArgs(mockUserGUID, mockTokenGUID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			repository, _ := NewPgsqlAPITokensRepository(db)
+			deleted, err := repository.Delete(mockUserGUID, mockTokenGUID)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldBeFalse)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
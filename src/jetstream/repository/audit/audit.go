@@ -0,0 +1,22 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Filter restricts the audit events returned by List
+type Filter struct {
+	From     time.Time
+	To       time.Time
+	UserGUID string
+	Limit    int
+}
+
+// Repository is an application of the repository pattern for storing audit events
+type Repository interface {
+	Save(event interfaces.AuditEvent) error
+	List(filter Filter) ([]*interfaces.AuditEvent, error)
+	DeleteOlderThan(cutoff time.Time) (int64, error)
+}
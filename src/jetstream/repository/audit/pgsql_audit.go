@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Default maximum number of audit events returned by List
+const defaultListLimit = 1000
+
+var saveAuditEvent = `INSERT INTO audit_log (guid, event_time, user_guid, user_name, action, endpoint_guid, remote_ip, result, status_code, details)
+							VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+var listAuditEvents = `SELECT guid, event_time, user_guid, user_name, action, endpoint_guid, remote_ip, result, status_code, details
+							FROM audit_log
+							WHERE event_time >= $1 AND event_time <= $2
+							ORDER BY event_time DESC LIMIT $3`
+
+var listAuditEventsByUser = `SELECT guid, event_time, user_guid, user_name, action, endpoint_guid, remote_ip, result, status_code, details
+							FROM audit_log
+							WHERE event_time >= $1 AND event_time <= $2 AND user_guid = $3
+							ORDER BY event_time DESC LIMIT $4`
+
+var deleteAuditEvents = `DELETE FROM audit_log WHERE event_time < $1`
+
+// PgsqlAuditRepository is a PostgreSQL-backed audit log repository
+type PgsqlAuditRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlAuditRepository will create a new instance of the PgsqlAuditRepository
+func NewPgsqlAuditRepository(dcp *sql.DB) (Repository, error) {
+	return &PgsqlAuditRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	saveAuditEvent = datastore.ModifySQLStatement(saveAuditEvent, databaseProvider)
+	listAuditEvents = datastore.ModifySQLStatement(listAuditEvents, databaseProvider)
+	listAuditEventsByUser = datastore.ModifySQLStatement(listAuditEventsByUser, databaseProvider)
+	deleteAuditEvents = datastore.ModifySQLStatement(deleteAuditEvents, databaseProvider)
+}
+
+// Save will persist the audit event
+func (p *PgsqlAuditRepository) Save(event interfaces.AuditEvent) error {
+	log.Debug("Save Audit Event")
+	_, err := p.db.Exec(saveAuditEvent, event.GUID, event.Timestamp, event.UserGUID, event.UserName, event.Action,
+		event.EndpointGUID, event.RemoteIP, event.Result, event.StatusCode, event.Details)
+	if err != nil {
+		return fmt.Errorf("Unable to save audit event: %v", err)
+	}
+	return nil
+}
+
+// List returns the audit events matching the filter, most recent first
+func (p *PgsqlAuditRepository) List(filter Filter) ([]*interfaces.AuditEvent, error) {
+	log.Debug("List Audit Events")
+
+	// Timestamps are stored in UTC
+	from := filter.From.UTC()
+	to := filter.To.UTC()
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	var rows *sql.Rows
+	var err error
+	if len(filter.UserGUID) > 0 {
+		rows, err = p.db.Query(listAuditEventsByUser, from, to, filter.UserGUID, limit)
+	} else {
+		rows, err = p.db.Query(listAuditEvents, from, to, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve audit events: %v", err)
+	}
+	defer rows.Close()
+
+	events := make([]*interfaces.AuditEvent, 0)
+	for rows.Next() {
+		var (
+			userGUID     sql.NullString
+			userName     sql.NullString
+			endpointGUID sql.NullString
+			remoteIP     sql.NullString
+			statusCode   sql.NullInt64
+			details      sql.NullString
+		)
+
+		event := new(interfaces.AuditEvent)
+		err := rows.Scan(&event.GUID, &event.Timestamp, &userGUID, &userName, &event.Action, &endpointGUID, &remoteIP, &event.Result, &statusCode, &details)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan audit events: %v", err)
+		}
+
+		event.UserGUID = userGUID.String
+		event.UserName = userName.String
+		event.EndpointGUID = endpointGUID.String
+		event.RemoteIP = remoteIP.String
+		event.StatusCode = int(statusCode.Int64)
+		event.Details = details.String
+
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list audit events: %v", err)
+	}
+
+	return events, nil
+}
+
+// DeleteOlderThan removes audit events recorded before the cutoff, returning the number of events removed
+func (p *PgsqlAuditRepository) DeleteOlderThan(cutoff time.Time) (int64, error) {
+	log.Debug("Delete Audit Events")
+	result, err := p.db.Exec(deleteAuditEvents, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to delete audit events: %v", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to determine number of deleted audit events: %v", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPgSQLAudit(t *testing.T) {
+
+	var (
+		mockUserGUID     = "some-user-guid-1234"
+		mockEndpointGUID = "some-cf-guid-1234"
+		unknownDBError   = "Unknown Database Error"
+
+		insertIntoAuditLog       = `INSERT INTO audit_log`
+		selectFromAuditLog       = `SELECT (.+) FROM audit_log WHERE event_time >= (.+) AND event_time <= (.+) ORDER BY`
+		selectFromAuditLogByUser = `SELECT (.+) FROM audit_log WHERE (.+) AND user_guid = (.+) ORDER BY`
+		deleteFromAuditLog       = `DELETE FROM audit_log WHERE event_time < (.+)`
+		rowFieldsForAuditLog     = []string{"guid", "event_time", "user_guid", "user_name", "action", "endpoint_guid", "remote_ip", "result", "status_code", "details"}
+		mockTime                 = time.Date(2019, 11, 20, 10, 15, 0, 0, time.UTC)
+	)
+
+	Convey("Given a request to save an audit event", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		event := interfaces.AuditEvent{
+			GUID:         "event-1",
+			Timestamp:    mockTime,
+			UserGUID:     mockUserGUID,
+			UserName:     "admin",
+			Action:       interfaces.AuditEndpointConnect,
+			EndpointGUID: mockEndpointGUID,
+			RemoteIP:     "127.0.0.1",
+			Result:       interfaces.AuditResultSuccess,
+			StatusCode:   200,
+		}
+
+		Convey("the event should be inserted", func() {
+			mock.ExpectExec(insertIntoAuditLog).
+				WithArgs("event-1", mockTime, mockUserGUID, "admin", interfaces.AuditEndpointConnect, mockEndpointGUID, "127.0.0.1", interfaces.AuditResultSuccess, 200, "").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			repository, _ := NewPgsqlAuditRepository(db)
+			err := repository.Save(event)
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectExec(insertIntoAuditLog).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlAuditRepository(db)
+			err := repository.Save(event)
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request for a list of audit events", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if no events exist in the database", func() {
+			mock.ExpectQuery(selectFromAuditLog).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAuditLog))
+
+			repository, _ := NewPgsqlAuditRepository(db)
+			results, err := repository.List(Filter{})
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 0)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("if filtering by a time in another time zone", func() {
+			mock.ExpectQuery(selectFromAuditLog).
+				WithArgs(mockTime.Add(-time.Hour), mockTime, defaultListLimit).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAuditLog))
+
+			repository, _ := NewPgsqlAuditRepository(db)
+			zone := time.FixedZone("UTC+1", 60*60)
+			_, err := repository.List(Filter{From: mockTime.Add(-time.Hour).In(zone), To: mockTime.In(zone)})
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("if filtering by user", func() {
+			rows := sqlmock.NewRows(rowFieldsForAuditLog).
+				AddRow("event-1", mockTime, mockUserGUID, "admin", interfaces.AuditEndpointRegister, mockEndpointGUID, "127.0.0.1", interfaces.AuditResultSuccess, 201, "name=cf").
+				AddRow("event-2", mockTime, mockUserGUID, nil, interfaces.AuditSetupSave, nil, nil, interfaces.AuditResultFailure, 500, nil)
+			mock.ExpectQuery(selectFromAuditLogByUser).
+				WithArgs(time.Time{}, mockTime, mockUserGUID, 10).
+				WillReturnRows(rows)
+
+			repository, _ := NewPgsqlAuditRepository(db)
+			results, err := repository.List(Filter{To: mockTime, UserGUID: mockUserGUID, Limit: 10})
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 2)
+			So(results[0].EndpointGUID, ShouldEqual, mockEndpointGUID)
+			So(results[0].StatusCode, ShouldEqual, 201)
+			So(results[0].Details, ShouldEqual, "name=cf")
+			So(results[1].UserName, ShouldEqual, "")
+			So(results[1].Result, ShouldEqual, interfaces.AuditResultFailure)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectQuery(selectFromAuditLog).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlAuditRepository(db)
+			_, err := repository.List(Filter{})
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to delete old audit events", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the number of deleted events should be returned", func() {
+			mock.ExpectExec(deleteFromAuditLog).
+				WithArgs(mockTime).
+				WillReturnResult(sqlmock.NewResult(0, 3))
+
+			repository, _ := NewPgsqlAuditRepository(db)
+			count, err := repository.DeleteOlderThan(mockTime)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -14,6 +14,21 @@ type Repository interface {
 	Save(guid string, cnsiRecord interfaces.CNSIRecord, encryptionKey []byte) error
 	Update(guid string, ssoAllowed bool) error
 	UpdateMetadata(guid string, metadata string) error
+	Overwrite(guid string, cnsiRecord interfaces.CNSIRecord, encryptionKey []byte) error
+	ListVisibility() (map[string][]interfaces.EndpointVisibility, error)
+	FindVisibility(guid string) ([]interfaces.EndpointVisibility, error)
+	SetVisibility(guid string, visibility []interfaces.EndpointVisibility) error
+
+	// Re-encryption of client secrets with a new encryption key
+	CountClientSecrets() (int, error)
+	ListClientSecrets(afterGUID string, limit int) ([]EncryptedClientSecret, error)
+	UpdateClientSecret(guid string, clientSecret []byte, previous []byte) (bool, error)
+}
+
+// EncryptedClientSecret is the stored ciphertext of an endpoint's client secret
+type EncryptedClientSecret struct {
+	GUID         string
+	ClientSecret []byte
 }
 
 type Endpoint interface {
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"net/url"
 
-	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/secrets"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -32,20 +32,43 @@ var saveCNSI = `INSERT INTO cnsis (guid, name, cnsi_type, api_endpoint, auth_end
 
 var deleteCNSI = `DELETE FROM cnsis WHERE guid = $1`
 
+var findClientSecret = `SELECT client_secret FROM cnsis WHERE guid = $1`
+
 // Just update the SSO Allowed state for now
 var updateCNSI = `UPDATE cnsis SET sso_allowed = $1 WHERE guid = $2`
 
+// Update everything apart from the guid and type
+var overwriteCNSI = `UPDATE cnsis SET name = $1, api_endpoint = $2, auth_endpoint = $3, token_endpoint = $4, doppler_logging_endpoint = $5, skip_ssl_validation = $6,
+						client_id = $7, client_secret = $8, sso_allowed = $9, sub_type = $10, meta_data = $11
+						WHERE guid = $12`
+
 // Update the metadata
 var updateCNSIMetadata = `UPDATE cnsis SET meta_data = $1 WHERE guid = $2`
 
+var listVisibility = `SELECT endpoint_guid, principal_type, principal FROM endpoint_visibility`
+
+var findVisibility = `SELECT endpoint_guid, principal_type, principal FROM endpoint_visibility WHERE endpoint_guid = $1`
+
+var saveVisibility = `INSERT INTO endpoint_visibility (endpoint_guid, principal_type, principal) VALUES ($1, $2, $3)`
+
+var deleteVisibility = `DELETE FROM endpoint_visibility WHERE endpoint_guid = $1`
+
+var countClientSecrets = `SELECT COUNT(*) FROM cnsis WHERE client_secret IS NOT NULL`
+
+var listClientSecrets = `SELECT guid, client_secret FROM cnsis WHERE client_secret IS NOT NULL AND guid > $1 ORDER BY guid LIMIT $2`
+
+var updateClientSecret = `UPDATE cnsis SET client_secret = $1 WHERE guid = $2 AND client_secret = $3`
+
 // PostgresCNSIRepository is a PostgreSQL-backed CNSI repository
 type PostgresCNSIRepository struct {
-	db *sql.DB
+	db          datastore.Connection
+	secretStore secrets.Store
 }
 
 // NewPostgresCNSIRepository will create a new instance of the PostgresCNSIRepository
-func NewPostgresCNSIRepository(dcp *sql.DB) (Repository, error) {
-	return &PostgresCNSIRepository{db: dcp}, nil
+// Client secrets are kept in the secret store if one is given, otherwise they are encrypted in the database
+func NewPostgresCNSIRepository(dcp datastore.Connection, secretStore secrets.Store) (Repository, error) {
+	return &PostgresCNSIRepository{db: dcp, secretStore: secretStore}, nil
 }
 
 // InitRepositoryProvider - One time init for the given DB Provider
@@ -57,8 +80,17 @@ func InitRepositoryProvider(databaseProvider string) {
 	findCNSIByAPIEndpoint = datastore.ModifySQLStatement(findCNSIByAPIEndpoint, databaseProvider)
 	saveCNSI = datastore.ModifySQLStatement(saveCNSI, databaseProvider)
 	deleteCNSI = datastore.ModifySQLStatement(deleteCNSI, databaseProvider)
+	findClientSecret = datastore.ModifySQLStatement(findClientSecret, databaseProvider)
 	updateCNSI = datastore.ModifySQLStatement(updateCNSI, databaseProvider)
 	updateCNSIMetadata = datastore.ModifySQLStatement(updateCNSIMetadata, databaseProvider)
+	overwriteCNSI = datastore.ModifySQLStatement(overwriteCNSI, databaseProvider)
+	listVisibility = datastore.ModifySQLStatement(listVisibility, databaseProvider)
+	findVisibility = datastore.ModifySQLStatement(findVisibility, databaseProvider)
+	saveVisibility = datastore.ModifySQLStatement(saveVisibility, databaseProvider)
+	deleteVisibility = datastore.ModifySQLStatement(deleteVisibility, databaseProvider)
+	countClientSecrets = datastore.ModifySQLStatement(countClientSecrets, databaseProvider)
+	listClientSecrets = datastore.ModifySQLStatement(listClientSecrets, databaseProvider)
+	updateClientSecret = datastore.ModifySQLStatement(updateClientSecret, databaseProvider)
 }
 
 // List - Returns a list of CNSI Records
@@ -104,7 +136,7 @@ func (p *PostgresCNSIRepository) List(encryptionKey []byte) ([]*interfaces.CNSIR
 		}
 
 		if len(cipherTextClientSecret) > 0 {
-			plaintextClientSecret, err := crypto.DecryptToken(encryptionKey, cipherTextClientSecret)
+			plaintextClientSecret, err := secrets.Open(p.secretStore, cipherTextClientSecret, encryptionKey)
 			if err != nil {
 				return nil, err
 			}
@@ -231,7 +263,7 @@ func (p *PostgresCNSIRepository) findBy(query, match string, encryptionKey []byt
 	}
 
 	if len(cipherTextClientSecret) > 0 {
-		plaintextClientSecret, err := crypto.DecryptToken(encryptionKey, cipherTextClientSecret)
+		plaintextClientSecret, err := secrets.Open(p.secretStore, cipherTextClientSecret, encryptionKey)
 		if err != nil {
 			return interfaces.CNSIRecord{}, err
 		}
@@ -247,13 +279,17 @@ func (p *PostgresCNSIRepository) findBy(query, match string, encryptionKey []byt
 // Save will persist a CNSI Record to a datastore
 func (p *PostgresCNSIRepository) Save(guid string, cnsi interfaces.CNSIRecord, encryptionKey []byte) error {
 	log.Debug("Save")
-	cipherTextClientSecret, err := crypto.EncryptToken(encryptionKey, cnsi.ClientSecret)
+	cipherTextClientSecret, err := secrets.Seal(p.secretStore, clientSecretPath(guid), cnsi.ClientSecret, encryptionKey)
 	if err != nil {
 		return err
 	}
 	if _, err := p.db.Exec(saveCNSI, guid, cnsi.Name, fmt.Sprintf("%s", cnsi.CNSIType),
 		fmt.Sprintf("%s", cnsi.APIEndpoint), cnsi.AuthorizationEndpoint, cnsi.TokenEndpoint, cnsi.DopplerLoggingEndpoint, cnsi.SkipSSLValidation,
 		cnsi.ClientId, cipherTextClientSecret, cnsi.SSOAllowed, cnsi.SubType, cnsi.Metadata); err != nil {
+		// Don't leave the client secret of an endpoint that was not saved in the secret store
+		if err := secrets.Remove(p.secretStore, cipherTextClientSecret); err != nil {
+			log.Warnf("Unable to remove client secret from the secret store: %v", err)
+		}
 		return fmt.Errorf("Unable to Save CNSI record: %v", err)
 	}
 
@@ -263,10 +299,23 @@ func (p *PostgresCNSIRepository) Save(guid string, cnsi interfaces.CNSIRecord, e
 // Delete will delete a CNSI Record from the datastore
 func (p *PostgresCNSIRepository) Delete(guid string) error {
 	log.Debug("Delete")
+
+	// The client secret is removed from the secret store once the endpoint has been deleted
+	var cipherTextClientSecret []byte
+	if p.secretStore != nil {
+		if err := p.db.QueryRow(findClientSecret, guid).Scan(&cipherTextClientSecret); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("Unable to Delete CNSI record: %v", err)
+		}
+	}
+
 	if _, err := p.db.Exec(deleteCNSI, guid); err != nil {
 		return fmt.Errorf("Unable to Delete CNSI record: %v", err)
 	}
 
+	if err := secrets.Remove(p.secretStore, cipherTextClientSecret); err != nil {
+		log.Warnf("Unable to remove client secret from the secret store: %v", err)
+	}
+
 	return nil
 }
 
@@ -307,6 +356,41 @@ func (p *PostgresCNSIRepository) Update(guid string, ssoAllowed bool) error {
 	return nil
 }
 
+// Overwrite - Update all of the details of an endpoint, apart from its guid and type
+func (p *PostgresCNSIRepository) Overwrite(guid string, cnsi interfaces.CNSIRecord, encryptionKey []byte) error {
+	log.Debug("Overwrite")
+
+	if guid == "" {
+		msg := "Unable to update Endpoint without a valid guid."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	cipherTextClientSecret, err := secrets.Seal(p.secretStore, clientSecretPath(guid), cnsi.ClientSecret, encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	result, err := p.db.Exec(overwriteCNSI, cnsi.Name, fmt.Sprintf("%s", cnsi.APIEndpoint), cnsi.AuthorizationEndpoint, cnsi.TokenEndpoint,
+		cnsi.DopplerLoggingEndpoint, cnsi.SkipSSLValidation, cnsi.ClientId, cipherTextClientSecret, cnsi.SSOAllowed, cnsi.SubType, cnsi.Metadata, guid)
+	if err != nil {
+		msg := "Unable to UPDATE endpoint: %v"
+		log.Debugf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("Unable to UPDATE endpoint: could not determine number of rows that were updated")
+	}
+
+	if rowsUpdates < 1 {
+		return errors.New("Unable to UPDATE endpoint: no rows were updated")
+	}
+
+	return nil
+}
+
 // UpdateMetadata - Update an endpoint's metadata
 func (p *PostgresCNSIRepository) UpdateMetadata(guid string, metadata string) error {
 	log.Debug("UpdateMetadata")
@@ -343,3 +427,129 @@ func (p *PostgresCNSIRepository) UpdateMetadata(guid string, metadata string) er
 
 	return nil
 }
+
+// ListVisibility - Returns the visibility restrictions of all restricted endpoints, keyed by endpoint guid
+func (p *PostgresCNSIRepository) ListVisibility() (map[string][]interfaces.EndpointVisibility, error) {
+	log.Debug("ListVisibility")
+	rows, err := p.db.Query(listVisibility)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve endpoint visibility: %v", err)
+	}
+	defer rows.Close()
+
+	return scanVisibility(rows)
+}
+
+// FindVisibility - Returns the visibility restrictions of an endpoint - an endpoint without restrictions is visible to all users
+func (p *PostgresCNSIRepository) FindVisibility(guid string) ([]interfaces.EndpointVisibility, error) {
+	log.Debug("FindVisibility")
+	rows, err := p.db.Query(findVisibility, guid)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve endpoint visibility: %v", err)
+	}
+	defer rows.Close()
+
+	visibility, err := scanVisibility(rows)
+	if err != nil {
+		return nil, err
+	}
+	if restrictions, ok := visibility[guid]; ok {
+		return restrictions, nil
+	}
+	return make([]interfaces.EndpointVisibility, 0), nil
+}
+
+// SetVisibility - Replaces the visibility restrictions of an endpoint - no restrictions makes the endpoint visible to all users
+func (p *PostgresCNSIRepository) SetVisibility(guid string, visibility []interfaces.EndpointVisibility) error {
+	log.Debug("SetVisibility")
+	return datastore.RunInTransaction(p.db, func(txn datastore.Connection) error {
+		if _, err := txn.Exec(deleteVisibility, guid); err != nil {
+			return fmt.Errorf("Unable to delete endpoint visibility: %v", err)
+		}
+
+		for _, restriction := range visibility {
+			if _, err := txn.Exec(saveVisibility, guid, restriction.Type, restriction.Principal); err != nil {
+				return fmt.Errorf("Unable to save endpoint visibility: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func scanVisibility(rows *sql.Rows) (map[string][]interfaces.EndpointVisibility, error) {
+	visibility := make(map[string][]interfaces.EndpointVisibility)
+	for rows.Next() {
+		var guid string
+		var restriction interfaces.EndpointVisibility
+		if err := rows.Scan(&guid, &restriction.Type, &restriction.Principal); err != nil {
+			return nil, fmt.Errorf("Unable to scan endpoint visibility: %v", err)
+		}
+		visibility[guid] = append(visibility[guid], restriction)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list endpoint visibility: %v", err)
+	}
+
+	return visibility, nil
+}
+
+// CountClientSecrets - Returns the number of endpoints with a client secret
+func (p *PostgresCNSIRepository) CountClientSecrets() (int, error) {
+	log.Debug("CountClientSecrets")
+
+	var count int
+	if err := p.db.QueryRow(countClientSecrets).Scan(&count); err != nil {
+		return 0, fmt.Errorf("Unable to count client secrets: %v", err)
+	}
+	return count, nil
+}
+
+// ListClientSecrets - Returns a batch of encrypted client secrets, ordered by endpoint guid, starting after the given guid
+func (p *PostgresCNSIRepository) ListClientSecrets(afterGUID string, limit int) ([]EncryptedClientSecret, error) {
+	log.Debug("ListClientSecrets")
+
+	rows, err := p.db.Query(listClientSecrets, afterGUID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list client secrets: %v", err)
+	}
+	defer rows.Close()
+
+	secrets := make([]EncryptedClientSecret, 0)
+	for rows.Next() {
+		var secret EncryptedClientSecret
+		if err := rows.Scan(&secret.GUID, &secret.ClientSecret); err != nil {
+			return nil, fmt.Errorf("Unable to scan client secrets: %v", err)
+		}
+		secrets = append(secrets, secret)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list client secrets: %v", err)
+	}
+
+	return secrets, nil
+}
+
+// UpdateClientSecret - Replace the encrypted client secret of an endpoint, as long as it has not changed since it was listed
+// Returns false if the client secret has changed
+func (p *PostgresCNSIRepository) UpdateClientSecret(guid string, clientSecret []byte, previous []byte) (bool, error) {
+	log.Debug("UpdateClientSecret")
+
+	result, err := p.db.Exec(updateClientSecret, clientSecret, guid, previous)
+	if err != nil {
+		return false, fmt.Errorf("Unable to update client secret: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Unable to update client secret: %v", err)
+	}
+
+	return rowsUpdates == 1, nil
+}
+
+// clientSecretPath returns where the client secret of an endpoint is kept in the secret store
+func clientSecretPath(guid string) string {
+	return fmt.Sprintf("endpoints/%s/client_secret", guid)
+}
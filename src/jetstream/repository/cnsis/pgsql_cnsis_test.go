@@ -3,7 +3,9 @@ package cnsis
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
 	"testing"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/secrets"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -45,7 +48,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 		defer db.Close()
 
 		Convey("A valid CNSI Repository should be returned without error.", func() {
-			repository, err := NewPostgresCNSIRepository(db)
+			repository, err := NewPostgresCNSIRepository(db, nil)
 			So(repository, ShouldHaveSameTypeAs, &PostgresCNSIRepository{})
 			So(err, ShouldBeNil)
 		})
@@ -75,7 +78,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnRows(rs)
 
 			Convey("No CNSIs should be returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.List(mockEncryptionKey)
 				So(len(results), ShouldEqual, 0)
 
@@ -84,7 +87,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("the list of returned CNSIs should be empty", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.List(mockEncryptionKey)
 				So(results, ShouldResemble, expectedList)
 
@@ -93,7 +96,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be no error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.List(mockEncryptionKey)
 				So(err, ShouldBeNil)
 
@@ -123,7 +126,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 
 				// Expectations
 			Convey("2 CNSIs should be returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.List(mockEncryptionKey)
 				So(len(results), ShouldEqual, 2)
 
@@ -132,7 +135,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("the list of returned CNSIs should match the expected list of CNSIs", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.List(mockEncryptionKey)
 				So(results, ShouldResemble, expectedList)
 
@@ -141,7 +144,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be no error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.List(mockEncryptionKey)
 				So(err, ShouldBeNil)
 
@@ -158,7 +161,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnError(errors.New(unknownDBError))
 
 			Convey("the returned value should be nil", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.List(mockEncryptionKey)
 				So(results, ShouldBeNil)
 
@@ -167,7 +170,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be a 'not found' error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.List(mockEncryptionKey)
 				So(err, ShouldResemble, errors.New(expectedErrorMessage))
 
@@ -203,7 +206,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 
 				// Expectations
 			Convey("No CNSIs should be returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.ListByUser(mockAccount)
 				So(len(results), ShouldEqual, 0)
 
@@ -212,7 +215,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("the list of returned CNSIs should be empty", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.ListByUser(mockAccount)
 				So(results, ShouldResemble, expectedList)
 
@@ -221,7 +224,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be no error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.ListByUser(mockAccount)
 				So(err, ShouldBeNil)
 
@@ -251,7 +254,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 
 				// Expectations
 			Convey("2 CNSIs should be returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.ListByUser(mockAccount)
 				So(len(results), ShouldEqual, 2)
 
@@ -260,7 +263,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("the cluster list returned should match the expected cluster list", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.ListByUser(mockAccount)
 				So(results, ShouldResemble, expectedList)
 
@@ -269,7 +272,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be no error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.ListByUser(mockAccount)
 				So(err, ShouldBeNil)
 
@@ -286,7 +289,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnError(errors.New(unknownDBError))
 
 			Convey("the returned value should be nil", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				results, _ := repository.ListByUser(mockAccount)
 				So(results, ShouldBeNil)
 
@@ -295,7 +298,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be a 'not found' error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.ListByUser(mockAccount)
 				So(err, ShouldResemble, errors.New(expectedErrorMessage))
 
@@ -326,7 +329,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 
 			// Expectations
 			Convey("the returned CNSI should match the expected CNSI", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				cnsi, _ := repository.Find(mockCFGUID, mockEncryptionKey)
 				So(cnsi, ShouldResemble, expectedCNSIRecord)
 
@@ -335,7 +338,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be no error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.Find(mockCFGUID, mockEncryptionKey)
 				So(err, ShouldBeNil)
 
@@ -355,7 +358,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 
 				// Expectations
 			Convey("the returned CNSI record should be empty", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				cnsi, _ := repository.Find(mockCFGUID, mockEncryptionKey)
 				So(cnsi, ShouldResemble, expectedCNSIRecord)
 
@@ -364,7 +367,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be a 'not found' error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.Find(mockCFGUID, mockEncryptionKey)
 				So(err, ShouldResemble, errors.New("No match for that Endpoint"))
 
@@ -383,7 +386,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnError(errors.New(unknownDBError))
 
 			Convey("the returned CNSI record should be empty", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				cnsi, _ := repository.Find(mockCFGUID, mockEncryptionKey)
 				So(cnsi, ShouldResemble, expectedCNSIRecord)
 
@@ -392,7 +395,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be a 'not found' error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.Find(mockCFGUID, mockEncryptionKey)
 				So(err, ShouldResemble, errors.New(expectedErrorMessage))
 
@@ -423,7 +426,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 
 			// Expectations
 			Convey("the returned CNSI should match the expected CNSI", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				cnsi, _ := repository.FindByAPIEndpoint(mockAPIEndpoint, mockEncryptionKey)
 				So(cnsi, ShouldResemble, expectedCNSIRecord)
 
@@ -432,7 +435,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be no error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.FindByAPIEndpoint(mockAPIEndpoint, mockEncryptionKey)
 				So(err, ShouldBeNil)
 
@@ -453,7 +456,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 
 			// Expectations
 			Convey("the returned CNSI record should be empty", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				cnsi, _ := repository.FindByAPIEndpoint(mockAPIEndpoint, mockEncryptionKey)
 				So(cnsi, ShouldResemble, expectedCNSIRecord)
 
@@ -462,7 +465,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be a 'not found' error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.FindByAPIEndpoint(mockAPIEndpoint, mockEncryptionKey)
 				So(err, ShouldResemble, errors.New("No match for that Endpoint"))
 
@@ -481,7 +484,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnError(errors.New(unknownDBError))
 
 			Convey("the returned CNSI record should be empty", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				cnsi, _ := repository.FindByAPIEndpoint(mockAPIEndpoint, mockEncryptionKey)
 				So(cnsi, ShouldResemble, expectedCNSIRecord)
 
@@ -490,7 +493,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 			})
 
 			Convey("there should be a 'not found' error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				_, err := repository.FindByAPIEndpoint(mockAPIEndpoint, mockEncryptionKey)
 				So(err, ShouldResemble, errors.New(expectedErrorMessage))
 
@@ -519,7 +522,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnResult(sqlmock.NewResult(1, 1))
 
 			Convey("there should be no error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				err := repository.Save(mockCFGUID, cnsi, mockEncryptionKey)
 				So(err, ShouldBeNil)
 
@@ -540,7 +543,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnError(errors.New(unknownDBError))
 
 			Convey("there should be an error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				err := repository.Save(mockCFGUID, cnsi, mockEncryptionKey)
 				So(err, ShouldResemble, errors.New(expectedErrorMessage))
 
@@ -566,7 +569,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnResult(sqlmock.NewResult(1, 1))
 
 			Convey("there should be no error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				err := repository.Delete(mockCFGUID)
 				So(err, ShouldBeNil)
 
@@ -584,7 +587,7 @@ func TestPgSQLCNSIs(t *testing.T) {
 				WillReturnError(errors.New(unknownDBError))
 
 			Convey("there should be an error returned", func() {
-				repository, _ := NewPostgresCNSIRepository(db)
+				repository, _ := NewPostgresCNSIRepository(db, nil)
 				err := repository.Delete(mockCFGUID)
 				So(err, ShouldResemble, errors.New(expectedErrorMessage))
 
@@ -594,4 +597,135 @@ func TestPgSQLCNSIs(t *testing.T) {
 		})
 	})
 
+	Convey("Given a request to restrict the visibility of a CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		visibility := []interfaces.EndpointVisibility{
+			{Type: interfaces.EndpointVisibilityUser, Principal: "some-user-guid"},
+			{Type: interfaces.EndpointVisibilityScope, Principal: "stratos.team-a"},
+		}
+
+		Convey("the existing restrictions should be replaced", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(`DELETE FROM endpoint_visibility WHERE (.+)`).
+				WithArgs(mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(`INSERT INTO endpoint_visibility`).
+				WithArgs(mockCFGUID, interfaces.EndpointVisibilityUser, "some-user-guid").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(`INSERT INTO endpoint_visibility`).
+				WithArgs(mockCFGUID, interfaces.EndpointVisibilityScope, "stratos.team-a").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			repository, _ := NewPostgresCNSIRepository(db, nil)
+			So(repository.SetVisibility(mockCFGUID, visibility), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("the restrictions should be returned by endpoint", func() {
+			rows := sqlmock.NewRows([]string{"endpoint_guid", "principal_type", "principal"}).
+				AddRow(mockCFGUID, interfaces.EndpointVisibilityUser, "some-user-guid").
+				AddRow(mockCFGUID, interfaces.EndpointVisibilityScope, "stratos.team-a").
+				AddRow(mockCEGUID, interfaces.EndpointVisibilityUser, "some-user-guid")
+			mock.ExpectQuery(`SELECT (.+) FROM endpoint_visibility`).
+				WillReturnRows(rows)
+
+			repository, _ := NewPostgresCNSIRepository(db, nil)
+			restrictions, err := repository.ListVisibility()
+			So(err, ShouldBeNil)
+			So(restrictions[mockCFGUID], ShouldResemble, visibility)
+			So(restrictions[mockCEGUID], ShouldHaveLength, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("an endpoint without restrictions should have none", func() {
+			mock.ExpectQuery(`SELECT (.+) FROM endpoint_visibility WHERE (.+)`).
+				WithArgs(mockCFGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"endpoint_guid", "principal_type", "principal"}))
+
+			repository, _ := NewPostgresCNSIRepository(db, nil)
+			restrictions, err := repository.FindVisibility(mockCFGUID)
+			So(err, ShouldBeNil)
+			So(restrictions, ShouldBeEmpty)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+}
+
+func TestCNSIClientSecretStore(t *testing.T) {
+
+	Convey("Given an external secret store", t, func() {
+
+		var (
+			mockCFGUID       = "some-cf-guid-1234"
+			mockClientSecret = "stratos_secret"
+			secretPath       = "endpoints/some-cf-guid-1234/client_secret"
+		)
+
+		dir, _ := ioutil.TempDir("", "secrets")
+		store, err := secrets.NewFileStore(dir)
+		So(err, ShouldBeNil)
+
+		db, mock, err := sqlmock.New()
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			db.Close()
+			os.RemoveAll(dir)
+		})
+
+		repository, _ := NewPostgresCNSIRepository(db, store)
+
+		Convey("saving an endpoint should keep its client secret in the store", func() {
+			u, _ := url.Parse("https://api.127.0.0.1")
+			cnsi := interfaces.CNSIRecord{GUID: mockCFGUID, Name: "Some fancy CF Cluster", CNSIType: "cf", APIEndpoint: u, ClientSecret: mockClientSecret}
+
+			mock.ExpectExec(`INSERT INTO cnsis`).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			So(repository.Save(mockCFGUID, cnsi, make([]byte, 32)), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+			value, err := store.Get(secretPath)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, mockClientSecret)
+
+			// The database keeps a reference to the secret in place of its ciphertext
+			stored, _ := secrets.Seal(store, secretPath, mockClientSecret, nil)
+
+			Convey("deleting the endpoint should remove its client secret from the store", func() {
+				mock.ExpectQuery(`SELECT client_secret FROM cnsis WHERE (.+)`).
+					WithArgs(mockCFGUID).
+					WillReturnRows(sqlmock.NewRows([]string{"client_secret"}).AddRow(stored))
+				mock.ExpectExec(`DELETE FROM cnsis WHERE (.+)`).
+					WithArgs(mockCFGUID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				So(repository.Delete(mockCFGUID), ShouldBeNil)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+				_, err := store.Get(secretPath)
+				So(err, ShouldEqual, secrets.ErrSecretNotFound)
+			})
+		})
+
+		Convey("failing to save an endpoint should not leave its client secret in the store", func() {
+			u, _ := url.Parse("https://api.127.0.0.1")
+			cnsi := interfaces.CNSIRecord{GUID: mockCFGUID, Name: "Some fancy CF Cluster", CNSIType: "cf", APIEndpoint: u, ClientSecret: mockClientSecret}
+
+			mock.ExpectExec(`INSERT INTO cnsis`).
+				WillReturnError(errors.New("Unknown Database Error"))
+			So(repository.Save(mockCFGUID, cnsi, make([]byte, 32)), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+			_, err := store.Get(secretPath)
+			So(err, ShouldEqual, secrets.ErrSecretNotFound)
+		})
+	})
 }
@@ -31,11 +31,11 @@ var getAllConfigValues = `SELECT name, value, last_updated FROM config WHERE gro
 
 // PostgresCNSIRepository is a PostgreSQL-backed ConsoleConfig repository
 type ConsoleConfigRepository struct {
-	db *sql.DB
+	db datastore.Connection
 }
 
 // NewPostgresConsoleConfigRepository will create a new instance of the PostgresConsoleConfigRepository
-func NewPostgresConsoleConfigRepository(dcp *sql.DB) (Repository, error) {
+func NewPostgresConsoleConfigRepository(dcp datastore.Connection) (Repository, error) {
 	return &ConsoleConfigRepository{db: dcp}, nil
 }
 
@@ -0,0 +1,15 @@
+package health
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Repository is an application of the repository pattern for storing the results of endpoint health checks
+type Repository interface {
+	Save(check interfaces.EndpointHealthCheck) error
+	ListSince(since time.Time) ([]*interfaces.EndpointHealthCheck, error)
+	ListLatest() ([]*interfaces.EndpointHealthCheck, error)
+	DeleteOlderThan(cutoff time.Time) (int64, error)
+}
@@ -0,0 +1,112 @@
+package health
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var saveHealthCheck = `INSERT INTO endpoint_health (endpoint_guid, checked, healthy, latency_ms, error)
+							VALUES ($1, $2, $3, $4, $5)`
+
+var listHealthChecks = `SELECT endpoint_guid, checked, healthy, latency_ms, error
+							FROM endpoint_health
+							WHERE checked >= $1
+							ORDER BY checked DESC`
+
+var listLatestHealthChecks = `SELECT h.endpoint_guid, h.checked, h.healthy, h.latency_ms, h.error
+							FROM endpoint_health h
+							INNER JOIN (SELECT endpoint_guid, MAX(checked) AS checked FROM endpoint_health GROUP BY endpoint_guid) latest
+							ON h.endpoint_guid = latest.endpoint_guid AND h.checked = latest.checked`
+
+var deleteHealthChecks = `DELETE FROM endpoint_health WHERE checked < $1`
+
+// PgsqlHealthRepository is a PostgreSQL-backed endpoint health repository
+type PgsqlHealthRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlHealthRepository will create a new instance of the PgsqlHealthRepository
+func NewPgsqlHealthRepository(dcp *sql.DB) (Repository, error) {
+	return &PgsqlHealthRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	saveHealthCheck = datastore.ModifySQLStatement(saveHealthCheck, databaseProvider)
+	listHealthChecks = datastore.ModifySQLStatement(listHealthChecks, databaseProvider)
+	listLatestHealthChecks = datastore.ModifySQLStatement(listLatestHealthChecks, databaseProvider)
+	deleteHealthChecks = datastore.ModifySQLStatement(deleteHealthChecks, databaseProvider)
+}
+
+// Save will persist the result of a health check
+func (p *PgsqlHealthRepository) Save(check interfaces.EndpointHealthCheck) error {
+	log.Debug("Save Endpoint Health Check")
+	_, err := p.db.Exec(saveHealthCheck, check.EndpointGUID, check.Timestamp, check.Healthy, check.LatencyMs, check.Error)
+	if err != nil {
+		return fmt.Errorf("Unable to save endpoint health check: %v", err)
+	}
+	return nil
+}
+
+// ListSince returns the results of the health checks of all endpoints since the given time, most recent first
+func (p *PgsqlHealthRepository) ListSince(since time.Time) ([]*interfaces.EndpointHealthCheck, error) {
+	log.Debug("List Endpoint Health Checks")
+	rows, err := p.db.Query(listHealthChecks, since)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve endpoint health checks: %v", err)
+	}
+	return scanHealthChecks(rows)
+}
+
+// ListLatest returns the result of the most recent health check of each endpoint
+func (p *PgsqlHealthRepository) ListLatest() ([]*interfaces.EndpointHealthCheck, error) {
+	log.Debug("List Latest Endpoint Health Checks")
+	rows, err := p.db.Query(listLatestHealthChecks)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve endpoint health checks: %v", err)
+	}
+	return scanHealthChecks(rows)
+}
+
+func scanHealthChecks(rows *sql.Rows) ([]*interfaces.EndpointHealthCheck, error) {
+	defer rows.Close()
+
+	checks := make([]*interfaces.EndpointHealthCheck, 0)
+	for rows.Next() {
+		var checkErr sql.NullString
+		check := new(interfaces.EndpointHealthCheck)
+		if err := rows.Scan(&check.EndpointGUID, &check.Timestamp, &check.Healthy, &check.LatencyMs, &checkErr); err != nil {
+			return nil, fmt.Errorf("Unable to scan endpoint health checks: %v", err)
+		}
+		check.Error = checkErr.String
+		checks = append(checks, check)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list endpoint health checks: %v", err)
+	}
+
+	return checks, nil
+}
+
+// DeleteOlderThan removes the results of health checks made before the cutoff, returning the number removed
+func (p *PgsqlHealthRepository) DeleteOlderThan(cutoff time.Time) (int64, error) {
+	log.Debug("Delete Endpoint Health Checks")
+	result, err := p.db.Exec(deleteHealthChecks, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to delete endpoint health checks: %v", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to determine number of deleted endpoint health checks: %v", err)
+	}
+	return count, nil
+}
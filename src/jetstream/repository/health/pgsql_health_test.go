@@ -0,0 +1,155 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPgSQLHealth(t *testing.T) {
+
+	var (
+		mockEndpointGUID = "some-cf-guid-1234"
+		unknownDBError   = "Unknown Database Error"
+
+		insertIntoEndpointHealth   = `INSERT INTO endpoint_health`
+		selectFromEndpointHealth   = `SELECT (.+) FROM endpoint_health WHERE checked >= (.+) ORDER BY checked DESC`
+		selectLatestEndpointHealth = `SELECT (.+) FROM endpoint_health h INNER JOIN (.+) latest`
+		deleteFromEndpointHealth   = `DELETE FROM endpoint_health WHERE checked < (.+)`
+		rowFieldsForEndpointHealth = []string{"endpoint_guid", "checked", "healthy", "latency_ms", "error"}
+		mockTime                   = time.Date(2019, 11, 29, 9, 0, 0, 0, time.UTC)
+	)
+
+	Convey("Given a request to save a health check", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		check := interfaces.EndpointHealthCheck{
+			EndpointGUID: mockEndpointGUID,
+			Timestamp:    mockTime,
+			Healthy:      false,
+			LatencyMs:    250,
+			Error:        "connection refused",
+		}
+
+		Convey("the check should be inserted", func() {
+			mock.ExpectExec(insertIntoEndpointHealth).
+				WithArgs(mockEndpointGUID, mockTime, false, 250, "connection refused").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			repository, _ := NewPgsqlHealthRepository(db)
+			So(repository.Save(check), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectExec(insertIntoEndpointHealth).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlHealthRepository(db)
+			So(repository.Save(check), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to list health checks", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the checks since the given time should be returned", func() {
+			rows := sqlmock.NewRows(rowFieldsForEndpointHealth).
+				AddRow(mockEndpointGUID, mockTime, false, 250, "connection refused").
+				AddRow(mockEndpointGUID, mockTime.Add(-time.Minute), true, 40, nil)
+			mock.ExpectQuery(selectFromEndpointHealth).
+				WithArgs(mockTime.Add(-time.Hour)).
+				WillReturnRows(rows)
+
+			repository, _ := NewPgsqlHealthRepository(db)
+			checks, err := repository.ListSince(mockTime.Add(-time.Hour))
+			So(err, ShouldBeNil)
+			So(checks, ShouldHaveLength, 2)
+			So(checks[0].Healthy, ShouldBeFalse)
+			So(checks[0].Error, ShouldEqual, "connection refused")
+			So(checks[1].Healthy, ShouldBeTrue)
+			So(checks[1].LatencyMs, ShouldEqual, 40)
+			So(checks[1].Error, ShouldBeEmpty)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectQuery(selectFromEndpointHealth).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlHealthRepository(db)
+			_, err := repository.ListSince(mockTime)
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to list the latest health checks", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the most recent check of each endpoint should be returned", func() {
+			rows := sqlmock.NewRows(rowFieldsForEndpointHealth).
+				AddRow(mockEndpointGUID, mockTime, false, 250, "connection refused").
+				AddRow("some-cf-guid-5678", mockTime, true, 40, nil)
+			mock.ExpectQuery(selectLatestEndpointHealth).
+				WillReturnRows(rows)
+
+			repository, _ := NewPgsqlHealthRepository(db)
+			checks, err := repository.ListLatest()
+			So(err, ShouldBeNil)
+			So(checks, ShouldHaveLength, 2)
+			So(checks[0].EndpointGUID, ShouldEqual, mockEndpointGUID)
+			So(checks[0].Healthy, ShouldBeFalse)
+			So(checks[1].Healthy, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectQuery(selectLatestEndpointHealth).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlHealthRepository(db)
+			_, err := repository.ListLatest()
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to delete old health checks", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the number of deleted checks should be returned", func() {
+			mock.ExpectExec(deleteFromEndpointHealth).
+				WithArgs(mockTime).
+				WillReturnResult(sqlmock.NewResult(0, 12))
+
+			repository, _ := NewPgsqlHealthRepository(db)
+			count, err := repository.DeleteOlderThan(mockTime)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 12)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
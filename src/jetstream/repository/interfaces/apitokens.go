@@ -0,0 +1,31 @@
+package interfaces
+
+import (
+	"time"
+)
+
+// APITokenScopeProxy is the scope that API tokens need for requests that are passed through to endpoints
+// The other scopes of API tokens are the permissions of users
+const APITokenScopeProxy = "endpoints.proxy"
+
+// APIToken is a personal access token that a user has created for non-browser access to Jetstream
+// Only a hash of the token is stored
+type APIToken struct {
+	GUID     string     `json:"guid"`
+	UserGUID string     `json:"user_guid"`
+	Name     string     `json:"name"`
+	Scopes   []string   `json:"scopes"`
+	Created  time.Time  `json:"created"`
+	Expires  time.Time  `json:"expires"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// HasScope returns true if the token has been granted the scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package interfaces
+
+import "time"
+
+// Audit actions
+const (
+	AuditEndpointRegister    = "endpoint.register"
+	AuditEndpointUnregister  = "endpoint.unregister"
+	AuditEndpointUpdate      = "endpoint.update"
+	AuditEndpointConnect     = "endpoint.connect"
+	AuditEndpointDisconnect  = "endpoint.disconnect"
+	AuditEndpointVisibility  = "endpoint.visibility"
+	AuditSetupSave           = "setup.save"
+	AuditUserInvite          = "user.invite"
+	AuditUserInviteConfigure = "user.invite.configure"
+	AuditUserInviteRemove    = "user.invite.remove"
+	AuditProxyRequest        = "proxy.request"
+	AuditBackupCreate        = "backup.create"
+	AuditBackupRestore       = "backup.restore"
+	AuditEncryptionKeyRotate = "encryption.rotate"
+	AuditSessionRevoke       = "session.revoke"
+	AuditAPITokenCreate      = "apitoken.create"
+	AuditAPITokenRevoke      = "apitoken.revoke"
+	AuditAPITokenStatus      = "apitoken.status"
+	AuditLocalUserCreate     = "localuser.create"
+	AuditLocalUserUpdate     = "localuser.update"
+	AuditLocalUserPassword   = "localuser.password"
+	AuditLocalUserDelete     = "localuser.delete"
+	AuditLocalUserLockout    = "localuser.lockout"
+	AuditLoginThrottle       = "login.throttle"
+	AuditMFAEnable           = "mfa.enable"
+	AuditMFADisable          = "mfa.disable"
+	AuditMFARecoveryCodes    = "mfa.recovery_codes"
+	AuditMFAReset            = "mfa.reset"
+	AuditMFASettings         = "mfa.settings"
+)
+
+// Audit results
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// AuditEvent is a record of an administrative or mutating action performed by a user
+type AuditEvent struct {
+	GUID         string    `json:"guid"`
+	Timestamp    time.Time `json:"timestamp"`
+	UserGUID     string    `json:"user_guid"`
+	UserName     string    `json:"user_name"`
+	Action       string    `json:"action"`
+	EndpointGUID string    `json:"endpoint_guid,omitempty"`
+	RemoteIP     string    `json:"remote_ip"`
+	Result       string    `json:"result"`
+	StatusCode   int       `json:"status_code"`
+	Details      string    `json:"details,omitempty"`
+}
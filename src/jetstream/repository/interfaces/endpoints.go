@@ -13,6 +13,16 @@ type EndpointPlugin interface {
 	UpdateMetadata(info *Info, userGUID string, echoContext echo.Context)
 }
 
+// EndpointHealthCheckPlugin can be implemented by endpoint plugins to check the health of their endpoints
+// Endpoints of plugins that don't implement it are checked by fetching their Info
+type EndpointHealthCheckPlugin interface {
+	HealthCheck(cnsiRecord CNSIRecord) error
+}
+
+// RoutePlugin adds routes to the Echo server
+// Session routes that need more than a session should declare the permission they need with PortalProxy.RequirePermission
+// API tokens can not be used for plugin routes
+// Admin routes are only available to users with the console admin permission
 type RoutePlugin interface {
 	AddSessionGroupRoutes(echoContext *echo.Group)
 	AddAdminGroupRoutes(echoContext *echo.Group)
@@ -23,4 +33,9 @@ type EndpointAction int
 const (
 	EndpointRegisterAction EndpointAction = iota
 	EndpointUnregisterAction
+	EndpointUpdateAction
+	// The health checker found that an endpoint stopped responding
+	EndpointDownAction
+	// The health checker found that an endpoint is responding again
+	EndpointUpAction
 )
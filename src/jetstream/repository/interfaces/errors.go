@@ -20,6 +20,12 @@ type ErrHTTPRequest struct {
 	Response   string
 }
 
+// ErrTokenRefresh is returned when an endpoint token could not be refreshed
+type ErrTokenRefresh struct {
+	Status     int
+	InnerError error
+}
+
 func (e ErrHTTPShadow) Error() string {
 	return fmt.Sprintf("HTTP Error: %v\nLog Message: %s", e.HTTPError, e.LogMessage)
 }
@@ -70,3 +76,21 @@ func LogHTTPError(r *http.Response, innerErr error) error {
 	}
 
 }
+
+func (e ErrTokenRefresh) Error() string {
+	return fmt.Sprintf("Token refresh request failed: %v", e.InnerError)
+}
+
+// Rejected returns true if the token endpoint rejected the refresh token, so retrying the refresh will not help
+func (e ErrTokenRefresh) Rejected() bool {
+	return e.Status == http.StatusBadRequest || e.Status == http.StatusUnauthorized
+}
+
+// NewTokenRefreshError wraps the error from a token refresh request
+func NewTokenRefreshError(err error) error {
+	refreshErr := ErrTokenRefresh{InnerError: err}
+	if httpErr, ok := err.(ErrHTTPRequest); ok {
+		refreshErr.Status = httpErr.Status
+	}
+	return refreshErr
+}
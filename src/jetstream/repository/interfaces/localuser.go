@@ -1,12 +1,32 @@
 package interfaces
 
+import (
+	"time"
+)
+
 // LocalUser - Used for local user auth and management
+// Scope holds the scopes of the user, separated by spaces
 type LocalUser struct {
-	UserGUID     string `json:"user_guid"`
-	PasswordHash []byte `json:"password_hash"`
-	Username     string `json:"username"`
-	Email        string `json:"email"`
-	Scope        string `json:"scope"`
-	GivenName    string `json:"given_name"`
-	FamilyName   string `json:"family_name"`
+	UserGUID               string     `json:"user_guid"`
+	PasswordHash           []byte     `json:"password_hash"`
+	Username               string     `json:"username"`
+	Email                  string     `json:"email"`
+	Scope                  string     `json:"scope"`
+	GivenName              string     `json:"given_name"`
+	FamilyName             string     `json:"family_name"`
+	Disabled               bool       `json:"disabled"`
+	PasswordChangeRequired bool       `json:"password_change_required"`
+	LastLogin              *time.Time `json:"last_login,omitempty"`
+	FailedLoginAttempts    int        `json:"failed_login_attempts"`
+	LockedUntil            *time.Time `json:"locked_until,omitempty"`
+}
+
+// LocalUserMFA is the TOTP second factor of a local user
+// Enrolment is pending until the user has verified a code, when Enabled is set
+type LocalUserMFA struct {
+	UserGUID     string    `json:"user_guid"`
+	Secret       string    `json:"-"`
+	Enabled      bool      `json:"enabled"`
+	LastUsedStep int64     `json:"-"`
+	Created      time.Time `json:"created"`
 }
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/govau/cf-common/env"
@@ -73,6 +74,22 @@ type PortalProxy interface {
 	// Plugins
 	GetPlugin(name string) interface{}
 
+	// AuditMiddleware records an audit event for the given action once a request has been handled
+	AuditMiddleware(action string) echo.MiddlewareFunc
+
+	// Role-based access control
+	GetUserPermissions(userGUID string) (*UserPermissions, error)
+	// RequirePermission only passes requests from users that have been granted the permission
+	RequirePermission(permission string) echo.MiddlewareFunc
+	// IsEndpointVisible returns false if the endpoint has been restricted to other users
+	IsEndpointVisible(userGUID string, cnsiGUID string) bool
+
+	// Locks shared by all Jetstream instances using the same database
+	AcquireLock(name string, ttl time.Duration) (bool, error)
+	ReleaseLock(name string) error
+	// IsLeader returns true if this Jetstream instance is the leader - use this for background jobs that should only run once
+	IsLeader() bool
+
 	// SetCanPerformMigrations updates the state that records if we can perform Database migrations
 	SetCanPerformMigrations(bool)
 
@@ -0,0 +1,50 @@
+package interfaces
+
+// Permissions that roles grant to users
+const (
+	PermissionConsoleAdmin   = "console.admin"
+	PermissionEndpointsView  = "endpoints.view"
+	PermissionEndpointsAdmin = "endpoints.admin"
+	PermissionUsersManage    = "users.manage"
+	PermissionAuditRead      = "audit.read"
+)
+
+// Roles that can be mapped to UAA scopes, local user scopes and groups
+const (
+	RoleAdmin          = "admin"
+	RoleEndpointAdmin  = "endpoint-admin"
+	RoleEndpointViewer = "endpoint-viewer"
+	RoleUserManager    = "user-manager"
+	RoleAuditReader    = "audit-reader"
+)
+
+// RolePermissions are the permissions granted by each role
+var RolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermissionConsoleAdmin,
+		PermissionEndpointsView,
+		PermissionEndpointsAdmin,
+		PermissionUsersManage,
+		PermissionAuditRead,
+	},
+	RoleEndpointAdmin:  {PermissionEndpointsView, PermissionEndpointsAdmin},
+	RoleEndpointViewer: {PermissionEndpointsView},
+	RoleUserManager:    {PermissionUsersManage},
+	RoleAuditReader:    {PermissionAuditRead},
+}
+
+// UserPermissions are the roles of a user and the permissions that they grant
+type UserPermissions struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// Has returns true if the user has been granted the permission
+func (u *UserPermissions) Has(permission string) bool {
+	for _, p := range u.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package interfaces
 import (
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo"
@@ -48,6 +49,20 @@ type CNSIRecord struct {
 	Metadata               string   `json:"metadata"`
 }
 
+// Types of principal that can be granted visibility of a restricted endpoint
+const (
+	EndpointVisibilityUser  = "user"
+	EndpointVisibilityScope = "scope"
+	EndpointVisibilityGroup = "group"
+)
+
+// EndpointVisibility grants a user, or the users with a scope or in a group, visibility of a restricted endpoint
+// UAA groups are granted to users as scopes, so restrict an endpoint to a UAA group with its scope
+type EndpointVisibility struct {
+	Type      string `json:"type"`
+	Principal string `json:"principal"`
+}
+
 // ConnectedEndpoint
 type ConnectedEndpoint struct {
 	GUID                   string   `json:"guid"`
@@ -117,6 +132,8 @@ type OAuth2Metadata struct {
 	ClientID     string
 	ClientSecret string
 	IssuerURL    string
+	// UseIDToken is set for endpoints that authenticate with the ID token rather than the access token, e.g. Kubernetes
+	UseIDToken bool
 }
 
 type VCapApplicationData struct {
@@ -126,11 +143,14 @@ type VCapApplicationData struct {
 }
 
 type LoginRes struct {
-	Account     string         `json:"account"`
-	TokenExpiry int64          `json:"token_expiry"`
-	APIEndpoint *url.URL       `json:"api_endpoint"`
-	Admin       bool           `json:"admin"`
-	User        *ConnectedUser `json:"user"`
+	Account                string         `json:"account"`
+	TokenExpiry            int64          `json:"token_expiry"`
+	APIEndpoint            *url.URL       `json:"api_endpoint"`
+	Admin                  bool           `json:"admin"`
+	User                   *ConnectedUser `json:"user"`
+	PasswordChangeRequired bool           `json:"password_change_required,omitempty"`
+	MFARequired            bool           `json:"mfa_required,omitempty"`
+	MFAEnrolmentRequired   bool           `json:"mfa_enrolment_required,omitempty"`
 }
 
 type LocalLoginRes struct {
@@ -151,6 +171,8 @@ type ProxyRequestInfo struct {
 	Headers      http.Header
 	Body         []byte
 	Method       string
+	// APIHost overrides the host of the endpoint's API URL, as with the x-cap-api-host header
+	APIHost string
 }
 
 type SessionStorer interface {
@@ -164,6 +186,7 @@ type ConnectedUser struct {
 	Name   string   `json:"name"`
 	Admin  bool     `json:"admin"`
 	Scopes []string `json:"scopes"`
+	Groups []string `json:"groups,omitempty"`
 }
 
 type JWTUserTokenInfo struct {
@@ -195,14 +218,18 @@ type GooseDBVersionRecord struct {
 
 // Info - this represents user specific info
 type Info struct {
-	Versions      *Versions                             `json:"version"`
-	User          *ConnectedUser                        `json:"user"`
-	Endpoints     map[string]map[string]*EndpointDetail `json:"endpoints"`
-	CloudFoundry  *CFInfo                               `json:"cloud-foundry,omitempty"`
-	Plugins       map[string]bool                       `json:"plugins"`
-	PluginConfig  map[string]string                     `json:"plugin-config,omitempty"`
-	Diagnostics   *Diagnostics                          `json:"diagnostics,omitempty"`
-	Configuration struct {
+	Versions        *Versions                                `json:"version"`
+	User            *ConnectedUser                           `json:"user"`
+	Endpoints       map[string]map[string]*EndpointDetail    `json:"endpoints"`
+	CloudFoundry    *CFInfo                                  `json:"cloud-foundry,omitempty"`
+	Plugins         map[string]bool                          `json:"plugins"`
+	PluginConfig    map[string]string                        `json:"plugin-config,omitempty"`
+	Diagnostics     *Diagnostics                             `json:"diagnostics,omitempty"`
+	ProxyCache      *ProxyCacheStats                         `json:"proxyCache,omitempty"`
+	CircuitBreakers map[string]*EndpointCircuitBreakerStatus `json:"circuitBreakers,omitempty"`
+	Roles           []string                                 `json:"roles"`
+	Permissions     []string                                 `json:"permissions"`
+	Configuration   struct {
 		TechPreview bool `json:"enableTechPreview"`
 	} `json:"config"`
 }
@@ -215,6 +242,26 @@ type EndpointDetail struct {
 	Metadata          map[string]string `json:"metadata,omitempty"`
 	TokenMetadata     string            `json:"-"`
 	SystemSharedToken bool              `json:"system_shared_token"`
+	Health            *EndpointHealth   `json:"health,omitempty"`
+}
+
+// EndpointHealthCheck is the result of checking the health of an endpoint
+type EndpointHealthCheck struct {
+	EndpointGUID string    `json:"-"`
+	Timestamp    time.Time `json:"timestamp"`
+	Healthy      bool      `json:"healthy"`
+	LatencyMs    int64     `json:"latency_ms"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// EndpointHealth is the current health of an endpoint and the results of recent health checks, most recent first
+// The history is only included by the endpoint health route
+type EndpointHealth struct {
+	Healthy     bool                   `json:"healthy"`
+	LastChecked time.Time              `json:"last_checked"`
+	LatencyMs   int64                  `json:"latency_ms"`
+	Error       string                 `json:"error,omitempty"`
+	History     []*EndpointHealthCheck `json:"history,omitempty"`
 }
 
 // Versions - response returned to caller from a getVersions action
@@ -231,6 +278,10 @@ const (
 	Remote AuthEndpointType = "remote"
 	//Local - String representation of remote auth endpoint type
 	Local AuthEndpointType = "local"
+	//OIDC - String representation of OpenID Connect auth endpoint type
+	OIDC AuthEndpointType = "oidc"
+	//LDAP - String representation of LDAP auth endpoint type
+	LDAP AuthEndpointType = "ldap"
 )
 
 //AuthEndpointTypes - Allows lookup of internal string representation by the
@@ -238,6 +289,8 @@ const (
 var AuthEndpointTypes = map[string]AuthEndpointType{
 	"remote": Remote,
 	"local":  Local,
+	"oidc":   OIDC,
+	"ldap":   LDAP,
 }
 
 // ConsoleConfig is essential configuration settings
@@ -289,6 +342,16 @@ func (consoleConfig *ConsoleConfig) IsSetupComplete() bool {
 		return true
 	}
 
+	// OpenID Connect - the provider is configured with the OIDC settings, which are checked when the auth service starts
+	if AuthEndpointTypes[consoleConfig.AuthEndpointType] == OIDC {
+		return true
+	}
+
+	// LDAP - the directory is configured with the LDAP settings, which are checked when the auth service starts
+	if AuthEndpointTypes[consoleConfig.AuthEndpointType] == LDAP {
+		return true
+	}
+
 	// UAA - check setup complete for UAA
 	if consoleConfig.UAAEndpoint == nil {
 		return false
@@ -330,9 +393,23 @@ type PortalConfig struct {
 	CFClientSecret                     string   `configName:"CF_CLIENT_SECRET"`
 	AllowedOrigins                     []string `configName:"ALLOWED_ORIGINS"`
 	SessionStoreSecret                 string   `configName:"SESSION_STORE_SECRET"`
+	SessionIdleTimeoutInSecs           int64    `configName:"SESSION_IDLE_TIMEOUT_IN_SECS"`
+	SessionMaxLifetimeInSecs           int64    `configName:"SESSION_MAX_LIFETIME_IN_SECS"`
+	SessionMaxPerUser                  int64    `configName:"SESSION_MAX_PER_USER"`
+	APITokenMaxLifetimeInDays          int64    `configName:"API_TOKEN_MAX_LIFETIME_IN_DAYS"`
+	LocalUserPasswordMinLength         int64    `configName:"LOCAL_USER_PASSWORD_MIN_LENGTH"`
+	LocalUserPasswordCharacterClasses  int64    `configName:"LOCAL_USER_PASSWORD_CHARACTER_CLASSES"`
+	LocalUserPasswordHistory           int64    `configName:"LOCAL_USER_PASSWORD_HISTORY"`
+	LocalUserMaxFailedLogins           int64    `configName:"LOCAL_USER_MAX_FAILED_LOGINS"`
+	LocalUserLockoutInSecs             int64    `configName:"LOCAL_USER_LOCKOUT_IN_SECS"`
+	LoginThrottleMaxPerIP              int64    `configName:"LOGIN_THROTTLE_MAX_PER_IP"`
+	LoginThrottleMaxPerUser            int64    `configName:"LOGIN_THROTTLE_MAX_PER_USER"`
+	LoginThrottleWindowInSecs          int64    `configName:"LOGIN_THROTTLE_WINDOW_IN_SECS"`
+	TrustedProxies                     []string `configName:"TRUSTED_PROXIES"`
 	EncryptionKeyVolume                string   `configName:"ENCRYPTION_KEY_VOLUME"`
 	EncryptionKeyFilename              string   `configName:"ENCRYPTION_KEY_FILENAME"`
 	EncryptionKey                      string   `configName:"ENCRYPTION_KEY"`
+	EncryptionKeyPrevious              string   `configName:"ENCRYPTION_KEY_PREVIOUS"`
 	AutoRegisterCFUrl                  string   `configName:"AUTO_REG_CF_URL"`
 	AutoRegisterCFName                 string   `configName:"AUTO_REG_CF_NAME"`
 	SSOLogin                           bool     `configName:"SSO_LOGIN"`
@@ -352,5 +429,74 @@ type PortalConfig struct {
 	ConsoleConfig                      *ConsoleConfig
 	PluginConfig                       map[string]string
 	DatabaseProviderName               string
-	EnableTechPreview                  bool `configName:"ENABLE_TECH_PREVIEW"`
+	EnableTechPreview                  bool     `configName:"ENABLE_TECH_PREVIEW"`
+	ProxyCacheTTLs                     []string `configName:"PROXY_CACHE_TTLS"`
+	ProxyCacheMaxEntries               int64    `configName:"PROXY_CACHE_MAX_ENTRIES"`
+	CircuitBreakerFailureThreshold     int64    `configName:"CIRCUIT_BREAKER_FAILURE_THRESHOLD"`
+	CircuitBreakerResetTimeoutInSecs   int64    `configName:"CIRCUIT_BREAKER_RESET_TIMEOUT_IN_SECS"`
+	EndpointMaxConcurrentRequests      int64    `configName:"ENDPOINT_MAX_CONCURRENT_REQUESTS"`
+	ProxyAllMaxPages                   int64    `configName:"PROXY_ALL_MAX_PAGES"`
+	AuditLogRetentionDays              int64    `configName:"AUDIT_LOG_RETENTION_DAYS"`
+	MetricsAuthToken                   string   `configName:"METRICS_AUTH_TOKEN"`
+	TokenRefreshIntervalInSecs         int64    `configName:"TOKEN_REFRESH_INTERVAL_IN_SECS"`
+	TokenRefreshWindowInSecs           int64    `configName:"TOKEN_REFRESH_WINDOW_IN_SECS"`
+	RBACRoleMappings                   string   `configName:"RBAC_ROLE_MAPPINGS"`
+	RBACDefaultRoles                   string   `configName:"RBAC_DEFAULT_ROLES"`
+	EndpointHealthCheckIntervalInSecs  int64    `configName:"ENDPOINT_HEALTH_CHECK_INTERVAL_IN_SECS"`
+	EndpointHealthHistoryInHours       int64    `configName:"ENDPOINT_HEALTH_HISTORY_IN_HOURS"`
+	EndpointsBootstrap                 string   `configName:"ENDPOINTS_BOOTSTRAP"`
+	EndpointsBootstrapFile             string   `configName:"ENDPOINTS_BOOTSTRAP_FILE"`
+	EndpointsBootstrapPrune            bool     `configName:"ENDPOINTS_BOOTSTRAP_PRUNE"`
+	SecretsBackend                     string   `configName:"SECRETS_BACKEND"`
+	SecretsFileDir                     string   `configName:"SECRETS_FILE_DIR"`
+	SecretsVaultAddress                string   `configName:"SECRETS_VAULT_ADDRESS"`
+	SecretsVaultToken                  string   `configName:"SECRETS_VAULT_TOKEN"`
+	SecretsVaultMount                  string   `configName:"SECRETS_VAULT_MOUNT"`
+	SecretsVaultPrefix                 string   `configName:"SECRETS_VAULT_PREFIX"`
+	SecretsVaultSkipSSLValidation      bool     `configName:"SECRETS_VAULT_SKIP_SSL_VALIDATION"`
+	OIDCIssuerURL                      string   `configName:"OIDC_ISSUER_URL"`
+	OIDCClientID                       string   `configName:"OIDC_CLIENT_ID"`
+	OIDCClientSecret                   string   `configName:"OIDC_CLIENT_SECRET"`
+	OIDCScopes                         string   `configName:"OIDC_SCOPES"`
+	OIDCRedirectURL                    string   `configName:"OIDC_REDIRECT_URL"`
+	OIDCSkipSSLValidation              bool     `configName:"OIDC_SKIP_SSL_VALIDATION"`
+	OIDCUserIDClaim                    string   `configName:"OIDC_USER_ID_CLAIM"`
+	OIDCUserNameClaim                  string   `configName:"OIDC_USER_NAME_CLAIM"`
+	OIDCGroupsClaim                    string   `configName:"OIDC_GROUPS_CLAIM"`
+	OIDCAdminGroup                     string   `configName:"OIDC_ADMIN_GROUP"`
+	LDAPURL                            string   `configName:"LDAP_URL"`
+	LDAPStartTLS                       bool     `configName:"LDAP_START_TLS"`
+	LDAPSkipSSLValidation              bool     `configName:"LDAP_SKIP_SSL_VALIDATION"`
+	LDAPCACertFile                     string   `configName:"LDAP_CA_CERT_FILE"`
+	LDAPBindDN                         string   `configName:"LDAP_BIND_DN"`
+	LDAPBindPassword                   string   `configName:"LDAP_BIND_PASSWORD"`
+	LDAPUserBaseDN                     string   `configName:"LDAP_USER_BASE_DN"`
+	LDAPUserFilter                     string   `configName:"LDAP_USER_FILTER"`
+	LDAPUserIDAttribute                string   `configName:"LDAP_USER_ID_ATTRIBUTE"`
+	LDAPUserNameAttribute              string   `configName:"LDAP_USER_NAME_ATTRIBUTE"`
+	LDAPUserEmailAttribute             string   `configName:"LDAP_USER_EMAIL_ATTRIBUTE"`
+	LDAPGroupBaseDN                    string   `configName:"LDAP_GROUP_BASE_DN"`
+	LDAPGroupFilter                    string   `configName:"LDAP_GROUP_FILTER"`
+	LDAPGroupNameAttribute             string   `configName:"LDAP_GROUP_NAME_ATTRIBUTE"`
+	LDAPAdminGroup                     string   `configName:"LDAP_ADMIN_GROUP"`
+	LDAPPoolSize                       int64    `configName:"LDAP_POOL_SIZE"`
+	LDAPCacheTTLInSecs                 int64    `configName:"LDAP_CACHE_TTL_IN_SECS"`
+	LDAPTimeoutInSecs                  int64    `configName:"LDAP_TIMEOUT_IN_SECS"`
+}
+
+// EndpointCircuitBreakerStatus - state of the circuit breaker for an endpoint
+type EndpointCircuitBreakerStatus struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	InFlight            int    `json:"inFlight"`
+	OpenedAt            int64  `json:"openedAt,omitempty"`
+}
+
+// ProxyCacheStats - counters for the proxy response cache
+type ProxyCacheStats struct {
+	Entries       int   `json:"entries"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Revalidations int64 `json:"revalidations"`
+	Invalidations int64 `json:"invalidations"`
 }
@@ -1,11 +1,15 @@
 package localusers
 
 import (
+	"errors"
 	"time"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 )
 
+// ErrUserNotFound is returned when there is no local user with the given GUID
+var ErrUserNotFound = errors.New("Local user not found")
+
 // Repository is an application of the repository pattern for storing local users
 type Repository interface {
 	AddLocalUser(user interfaces.LocalUser) error
@@ -16,4 +20,10 @@ type Repository interface {
 	FindUser(userGUID string) (interfaces.LocalUser, error)
 	UpdateLastLoginTime(userGUID string, loginTime time.Time) error
 	FindLastLoginTime(userGUID string) (time.Time, error)
+	ListLocalUsers() ([]interfaces.LocalUser, error)
+	DeleteLocalUser(userGUID string) error
+	UpdateFailedLogins(userGUID string, attempts int, lockedUntil *time.Time) error
+	IncrementFailedLogins(userGUID string) (int, error)
+	AddPasswordHistory(userGUID string, passwordHash []byte, keep int) error
+	FindPasswordHistory(userGUID string, limit int) ([][]byte, error)
 }
@@ -0,0 +1,117 @@
+package localusers
+
+import (
+	"fmt"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// PasswordPolicyError describes why a password does not meet the password policy
+type PasswordPolicyError struct {
+	Message string
+}
+
+func (e PasswordPolicyError) Error() string {
+	return e.Message
+}
+
+// ErrPasswordReused is returned when a password is one of the user's recent passwords
+var ErrPasswordReused = PasswordPolicyError{"Password must not be the same as a recently used password"}
+
+// PasswordPolicy describes the passwords that local users can choose
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+	// CharacterClasses is how many of lower case letters, upper case letters, digits and symbols must be used
+	CharacterClasses int
+	// History is the number of previous passwords that can not be used again
+	History int
+}
+
+// NewPasswordPolicy returns the password policy configured for the console
+func NewPasswordPolicy(config *interfaces.PortalConfig) PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:        int(config.LocalUserPasswordMinLength),
+		CharacterClasses: int(config.LocalUserPasswordCharacterClasses),
+		History:          int(config.LocalUserPasswordHistory),
+	}
+}
+
+// Check checks that the password meets the policy - returns a PasswordPolicyError if it does not
+// The password history is only checked for existing users
+func (policy PasswordPolicy) Check(repo Repository, userGUID, password string) error {
+	if err := policy.Validate(password); err != nil {
+		return err
+	}
+	if len(userGUID) == 0 {
+		return nil
+	}
+	return policy.CheckHistory(repo, userGUID, password)
+}
+
+// Validate checks that the password meets the length and character class rules of the policy
+func (policy PasswordPolicy) Validate(password string) error {
+	if len([]rune(password)) < policy.MinLength {
+		return PasswordPolicyError{fmt.Sprintf("Password must be at least %d characters long", policy.MinLength)}
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, used := range []bool{lower, upper, digit, symbol} {
+		if used {
+			classes++
+		}
+	}
+	if classes < policy.CharacterClasses {
+		return PasswordPolicyError{fmt.Sprintf("Password must use at least %d of lower case letters, upper case letters, digits and symbols", policy.CharacterClasses)}
+	}
+	return nil
+}
+
+// CheckHistory returns ErrPasswordReused if the password is the user's current password or one of their recent passwords
+func (policy PasswordPolicy) CheckHistory(repo Repository, userGUID, password string) error {
+	if policy.History <= 0 {
+		return nil
+	}
+
+	hashes, err := repo.FindPasswordHistory(userGUID, policy.History)
+	if err != nil {
+		return err
+	}
+
+	// Users created before the policy was configured won't have their current password in the history
+	if current, err := repo.FindPasswordHash(userGUID); err == nil {
+		hashes = append(hashes, current)
+	}
+
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+// Remember adds the hash of a new password of the user to their password history
+func (policy PasswordPolicy) Remember(repo Repository, userGUID string, passwordHash []byte) error {
+	if policy.History <= 0 {
+		return nil
+	}
+	return repo.AddPasswordHistory(userGUID, passwordHash, policy.History)
+}
@@ -0,0 +1,110 @@
+package localusers
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+
+	var (
+		mockUserGUID        = "mock-user-guid"
+		selectHistory       = `SELECT password_hash, created FROM local_users_password_history WHERE user_guid = (.+) ORDER BY created DESC`
+		selectPasswordHash  = `SELECT password_hash FROM local_users WHERE user_guid = (.+)`
+		insertHistory       = `INSERT INTO local_users_password_history`
+		pruneHistory        = `DELETE FROM local_users_password_history WHERE user_guid = (.+) AND created < (.+)`
+		rowFieldsForHistory = []string{"password_hash", "created"}
+		mockTime            = time.Date(2019, 12, 4, 9, 0, 0, 0, time.UTC)
+	)
+
+	hash := func(password string) []byte {
+		hashed, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		return hashed
+	}
+
+	Convey("Given a password policy for length and character classes", t, func() {
+		policy := PasswordPolicy{MinLength: 10, CharacterClasses: 3}
+
+		Convey("short passwords should be refused", func() {
+			err := policy.Validate("Sh0rt!")
+			So(err, ShouldHaveSameTypeAs, PasswordPolicyError{})
+			So(err.Error(), ShouldContainSubstring, "at least 10 characters")
+		})
+
+		Convey("passwords with too few character classes should be refused", func() {
+			err := policy.Validate("alllowercase1")
+			So(err, ShouldHaveSameTypeAs, PasswordPolicyError{})
+			So(err.Error(), ShouldContainSubstring, "at least 3 of")
+		})
+
+		Convey("passwords that meet the policy should be accepted", func() {
+			So(policy.Validate("Lower-and-UPPER"), ShouldBeNil)
+			So(policy.Validate("lowercase-1234"), ShouldBeNil)
+		})
+
+		Convey("an empty policy should accept any password", func() {
+			So(PasswordPolicy{}.Validate(""), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a password policy that stops passwords from being reused", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		repository, _ := NewPgsqlLocalUsersRepository(db)
+		policy := PasswordPolicy{History: 2}
+
+		Convey("a recent password should be refused", func() {
+			mock.ExpectQuery(selectHistory).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForHistory).AddRow(hash("previous"), mockTime))
+			mock.ExpectQuery(selectPasswordHash).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(hash("current")))
+
+			So(policy.Check(repository, mockUserGUID, "previous"), ShouldResemble, ErrPasswordReused)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("the current password should be refused", func() {
+			mock.ExpectQuery(selectHistory).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForHistory))
+			mock.ExpectQuery(selectPasswordHash).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(hash("current")))
+
+			So(policy.Check(repository, mockUserGUID, "current"), ShouldResemble, ErrPasswordReused)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a new password should be accepted", func() {
+			mock.ExpectQuery(selectHistory).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForHistory).AddRow(hash("previous"), mockTime))
+			mock.ExpectQuery(selectPasswordHash).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(hash("current")))
+
+			So(policy.Check(repository, mockUserGUID, "something-new"), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("only the most recent passwords should be remembered", func() {
+			mock.ExpectExec(insertHistory).WithArgs(mockUserGUID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(selectHistory).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForHistory).
+					AddRow(hash("newest"), mockTime).
+					AddRow(hash("previous"), mockTime.Add(-time.Hour)).
+					AddRow(hash("oldest"), mockTime.Add(-2*time.Hour)))
+			mock.ExpectExec(pruneHistory).WithArgs(mockUserGUID, mockTime.Add(-time.Hour)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(policy.Remember(repository, mockUserGUID, hash("newest")), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -16,20 +16,29 @@ var findPasswordHash = `SELECT password_hash
 									WHERE user_guid = $1`
 var findUserGUID = `SELECT user_guid FROM local_users WHERE user_name = $1`
 var findUserScope = `SELECT user_scope FROM local_users WHERE user_guid = $1`
-var insertLocalUser = `INSERT INTO local_users (user_guid, password_hash, user_name, user_email, user_scope, given_name, family_name) VALUES ($1, $2, $3, $4, $5, $6, $7)`
-var updateLocalUser = `UPDATE local_users SET password_hash=$1, user_name=$2, user_email=$3, user_scope=$4, given_name=$5, family_name=$6, last_updated=CURRENT_TIMESTAMP WHERE user_guid=$7`
+var insertLocalUser = `INSERT INTO local_users (user_guid, password_hash, user_name, user_email, user_scope, given_name, family_name, disabled, password_change_required) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+var updateLocalUser = `UPDATE local_users SET password_hash=$1, user_name=$2, user_email=$3, user_scope=$4, given_name=$5, family_name=$6, disabled=$7, password_change_required=$8, last_updated=CURRENT_TIMESTAMP WHERE user_guid=$9`
+var deleteLocalUser = `DELETE FROM local_users WHERE user_guid = $1`
+var updateFailedLogins = `UPDATE local_users SET failed_login_attempts=$1, locked_until=$2 WHERE user_guid = $3`
+var incrementFailedLogins = `UPDATE local_users SET failed_login_attempts = failed_login_attempts + 1 WHERE user_guid = $1`
+var findFailedLogins = `SELECT failed_login_attempts FROM local_users WHERE user_guid = $1`
+var insertPasswordHistory = `INSERT INTO local_users_password_history (user_guid, password_hash, created) VALUES ($1, $2, $3)`
+var findPasswordHistory = `SELECT password_hash, created FROM local_users_password_history WHERE user_guid = $1 ORDER BY created DESC`
+var prunePasswordHistory = `DELETE FROM local_users_password_history WHERE user_guid = $1 AND created < $2`
+var deletePasswordHistory = `DELETE FROM local_users_password_history WHERE user_guid = $1`
 var updateLastLoginTime = `UPDATE local_users SET last_login=$1 WHERE user_guid = $2`
 var findLastLoginTime = `SELECT last_login FROM local_users WHERE user_guid = $1`
 var getTableCount = `SELECT count(user_guid) FROM local_users`
-var findUser = `SELECT user_name, user_email, user_scope, given_name, family_name FROM local_users WHERE user_guid = $1`
+var findUser = `SELECT user_name, user_email, user_scope, given_name, family_name, disabled, password_change_required, last_login, failed_login_attempts, locked_until FROM local_users WHERE user_guid = $1`
+var listLocalUsers = `SELECT user_guid, password_hash, user_name, user_email, user_scope, given_name, family_name, disabled, password_change_required, last_login, failed_login_attempts, locked_until FROM local_users`
 
 // PgsqlLocalUsersRepository is a PostgreSQL-backed local users repository
 type PgsqlLocalUsersRepository struct {
-	db *sql.DB
+	db datastore.Connection
 }
 
 // NewPgsqlLocalUsersRepository - get a reference to the local users data source
-func NewPgsqlLocalUsersRepository(dcp *sql.DB) (Repository, error) {
+func NewPgsqlLocalUsersRepository(dcp datastore.Connection) (Repository, error) {
 	log.Debug("NewPgsqlLocalUsersRepository")
 	return &PgsqlLocalUsersRepository{db: dcp}, nil
 }
@@ -46,6 +55,15 @@ func InitRepositoryProvider(databaseProvider string) {
 	getTableCount = datastore.ModifySQLStatement(getTableCount, databaseProvider)
 	updateLastLoginTime = datastore.ModifySQLStatement(updateLastLoginTime, databaseProvider)
 	findLastLoginTime = datastore.ModifySQLStatement(findLastLoginTime, databaseProvider)
+	listLocalUsers = datastore.ModifySQLStatement(listLocalUsers, databaseProvider)
+	deleteLocalUser = datastore.ModifySQLStatement(deleteLocalUser, databaseProvider)
+	updateFailedLogins = datastore.ModifySQLStatement(updateFailedLogins, databaseProvider)
+	incrementFailedLogins = datastore.ModifySQLStatement(incrementFailedLogins, databaseProvider)
+	findFailedLogins = datastore.ModifySQLStatement(findFailedLogins, databaseProvider)
+	insertPasswordHistory = datastore.ModifySQLStatement(insertPasswordHistory, databaseProvider)
+	findPasswordHistory = datastore.ModifySQLStatement(findPasswordHistory, databaseProvider)
+	prunePasswordHistory = datastore.ModifySQLStatement(prunePasswordHistory, databaseProvider)
+	deletePasswordHistory = datastore.ModifySQLStatement(deletePasswordHistory, databaseProvider)
 }
 
 // FindPasswordHash returns the password hash from the datastore, for the given user
@@ -119,8 +137,10 @@ func (p *PgsqlLocalUsersRepository) FindUser(userGUID string) (interfaces.LocalU
 	)
 
 	// Look for the user
-	err := p.db.QueryRow(findUser, userGUID).Scan(&user.Username, &email, &scope, &givenName, &familyName)
-	if err != nil {
+	err := p.db.QueryRow(findUser, userGUID).Scan(&user.Username, &email, &scope, &givenName, &familyName, &user.Disabled, &user.PasswordChangeRequired, &user.LastLogin, &user.FailedLoginAttempts, &user.LockedUntil)
+	if err == sql.ErrNoRows {
+		return user, ErrUserNotFound
+	} else if err != nil {
 		msg := "Unable to find user: %v"
 		log.Debugf(msg, err)
 		return user, fmt.Errorf(msg, err)
@@ -145,6 +165,42 @@ func (p *PgsqlLocalUsersRepository) FindUser(userGUID string) (interfaces.LocalU
 	return user, nil
 }
 
+// ListLocalUsers returns all local users, including their password hashes
+func (p *PgsqlLocalUsersRepository) ListLocalUsers() ([]interfaces.LocalUser, error) {
+	log.Debug("ListLocalUsers")
+
+	rows, err := p.db.Query(listLocalUsers)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list local users: %v", err)
+	}
+	defer rows.Close()
+
+	users := make([]interfaces.LocalUser, 0)
+	for rows.Next() {
+		var (
+			user       interfaces.LocalUser
+			email      sql.NullString
+			scope      sql.NullString
+			givenName  sql.NullString
+			familyName sql.NullString
+		)
+		if err := rows.Scan(&user.UserGUID, &user.PasswordHash, &user.Username, &email, &scope, &givenName, &familyName, &user.Disabled, &user.PasswordChangeRequired, &user.LastLogin, &user.FailedLoginAttempts, &user.LockedUntil); err != nil {
+			return nil, fmt.Errorf("Unable to scan local users: %v", err)
+		}
+		user.Email = email.String
+		user.Scope = scope.String
+		user.GivenName = givenName.String
+		user.FamilyName = familyName.String
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list local users: %v", err)
+	}
+
+	return users, nil
+}
+
 //FindUserScope selects the user_scope field from the local_users table in the db, for the given user.
 func (p *PgsqlLocalUsersRepository) FindUserScope(userGUID string) (string, error) {
 	log.Debug("FindUserScope")
@@ -255,7 +311,7 @@ func (p *PgsqlLocalUsersRepository) AddLocalUser(user interfaces.LocalUser) erro
 
 	// Add the new local user to the DB
 	var result sql.Result
-	if result, err = p.db.Exec(insertLocalUser, user.UserGUID, user.PasswordHash, user.Username, user.Email, user.Scope, user.GivenName, user.FamilyName); err != nil {
+	if result, err = p.db.Exec(insertLocalUser, user.UserGUID, user.PasswordHash, user.Username, user.Email, user.Scope, user.GivenName, user.FamilyName, user.Disabled, user.PasswordChangeRequired); err != nil {
 		msg := "unable to INSERT local user: %v"
 		log.Debugf(msg)
 		err = fmt.Errorf(msg, err)
@@ -304,7 +360,7 @@ func (p *PgsqlLocalUsersRepository) UpdateLocalUser(user interfaces.LocalUser) e
 
 	// Update the local user to the DB
 	var result sql.Result
-	if result, err = p.db.Exec(updateLocalUser, user.PasswordHash, user.Username, user.Email, user.Scope, user.GivenName, user.FamilyName, user.UserGUID); err != nil {
+	if result, err = p.db.Exec(updateLocalUser, user.PasswordHash, user.Username, user.Email, user.Scope, user.GivenName, user.FamilyName, user.Disabled, user.PasswordChangeRequired, user.UserGUID); err != nil {
 		msg := "unable to UPDATE local user: %v"
 		log.Debugf(msg)
 		err = fmt.Errorf(msg, err)
@@ -324,3 +380,141 @@ func (p *PgsqlLocalUsersRepository) UpdateLocalUser(user interfaces.LocalUser) e
 
 	return err
 }
+
+// DeleteLocalUser removes the local user from the datastore
+func (p *PgsqlLocalUsersRepository) DeleteLocalUser(userGUID string) error {
+	log.Debug("DeleteLocalUser")
+	if userGUID == "" {
+		return errors.New("unable to delete local user without a valid User GUID")
+	}
+
+	result, err := p.db.Exec(deleteLocalUser, userGUID)
+	if err != nil {
+		return fmt.Errorf("unable to DELETE local user: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("unable to DELETE local user: could not determine number of rows that were updated")
+	} else if rowsUpdates < 1 {
+		return ErrUserNotFound
+	}
+
+	if _, err = p.db.Exec(deletePasswordHistory, userGUID); err != nil {
+		return fmt.Errorf("unable to DELETE password history of local user: %v", err)
+	}
+	return nil
+}
+
+// UpdateFailedLogins records the number of failed logins of the user since their last successful login
+// and the time until which the user is locked out - nil if they are not locked out
+func (p *PgsqlLocalUsersRepository) UpdateFailedLogins(userGUID string, attempts int, lockedUntil *time.Time) error {
+	log.Debug("UpdateFailedLogins")
+	if userGUID == "" {
+		return errors.New("unable to update failed logins without a valid User GUID")
+	}
+
+	result, err := p.db.Exec(updateFailedLogins, attempts, lockedUntil, userGUID)
+	if err != nil {
+		return fmt.Errorf("unable to update failed logins of local user: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("unable to update failed logins of local user: could not determine the number of rows updated")
+	} else if rowsUpdates < 1 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// IncrementFailedLogins counts a failed login of the user and returns the number of failed logins since their last successful login
+// The count is incremented in the database, so that failed logins made at the same time are all counted
+func (p *PgsqlLocalUsersRepository) IncrementFailedLogins(userGUID string) (int, error) {
+	log.Debug("IncrementFailedLogins")
+	if userGUID == "" {
+		return 0, errors.New("unable to update failed logins without a valid User GUID")
+	}
+
+	result, err := p.db.Exec(incrementFailedLogins, userGUID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to update failed logins of local user: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.New("unable to update failed logins of local user: could not determine the number of rows updated")
+	} else if rowsUpdates < 1 {
+		return 0, ErrUserNotFound
+	}
+
+	var attempts int
+	if err := p.db.QueryRow(findFailedLogins, userGUID).Scan(&attempts); err != nil {
+		return 0, fmt.Errorf("unable to find failed logins of local user: %v", err)
+	}
+	return attempts, nil
+}
+
+// AddPasswordHistory remembers a password hash of the user, keeping only the given number of the most recent hashes
+func (p *PgsqlLocalUsersRepository) AddPasswordHistory(userGUID string, passwordHash []byte, keep int) error {
+	log.Debug("AddPasswordHistory")
+	if userGUID == "" || len(passwordHash) == 0 {
+		return errors.New("unable to add password history without a valid User GUID and password hash")
+	}
+
+	if _, err := p.db.Exec(insertPasswordHistory, userGUID, passwordHash, time.Now().UTC()); err != nil {
+		return fmt.Errorf("unable to INSERT password history: %v", err)
+	}
+
+	rows, err := p.db.Query(findPasswordHistory, userGUID)
+	if err != nil {
+		return fmt.Errorf("unable to find password history: %v", err)
+	}
+	defer rows.Close()
+
+	var oldest time.Time
+	count := 0
+	for rows.Next() && count < keep {
+		var hash []byte
+		if err := rows.Scan(&hash, &oldest); err != nil {
+			return fmt.Errorf("unable to scan password history: %v", err)
+		}
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to find password history: %v", err)
+	}
+	rows.Close()
+
+	if count == keep {
+		if _, err := p.db.Exec(prunePasswordHistory, userGUID, oldest); err != nil {
+			return fmt.Errorf("unable to DELETE password history: %v", err)
+		}
+	}
+	return nil
+}
+
+// FindPasswordHistory returns up to the given number of the most recent password hashes of the user, newest first
+func (p *PgsqlLocalUsersRepository) FindPasswordHistory(userGUID string, limit int) ([][]byte, error) {
+	log.Debug("FindPasswordHistory")
+
+	rows, err := p.db.Query(findPasswordHistory, userGUID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find password history: %v", err)
+	}
+	defer rows.Close()
+
+	hashes := make([][]byte, 0)
+	for rows.Next() && len(hashes) < limit {
+		var hash []byte
+		var created time.Time
+		if err := rows.Scan(&hash, &created); err != nil {
+			return nil, fmt.Errorf("unable to scan password history: %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to find password history: %v", err)
+	}
+	return hashes, nil
+}
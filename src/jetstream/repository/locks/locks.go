@@ -0,0 +1,11 @@
+package locks
+
+import "time"
+
+// Repository is an application of the repository pattern for named locks shared by all Jetstream instances
+type Repository interface {
+	// Acquire takes or renews the named lock for the holder - returns false if another holder has the lock
+	Acquire(name string, holder string, expires time.Time) (bool, error)
+	// Release gives up the named lock, if it is held by the holder
+	Release(name string, holder string) error
+}
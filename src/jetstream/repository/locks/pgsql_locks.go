@@ -0,0 +1,79 @@
+package locks
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+)
+
+var updateLock = `UPDATE locks SET holder = $1, expires = $2
+							WHERE name = $3 AND (holder = $4 OR expires < $5)`
+
+var insertLock = `INSERT INTO locks (name, holder, expires) VALUES ($1, $2, $3)`
+
+var getLockHolder = `SELECT holder FROM locks WHERE name = $1`
+
+var deleteLock = `DELETE FROM locks WHERE name = $1 AND holder = $2`
+
+// PgsqlLockRepository is a database-backed lock repository
+type PgsqlLockRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlLockRepository will create a new instance of the PgsqlLockRepository
+func NewPgsqlLockRepository(dcp *sql.DB) (Repository, error) {
+	return &PgsqlLockRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	updateLock = datastore.ModifySQLStatement(updateLock, databaseProvider)
+	insertLock = datastore.ModifySQLStatement(insertLock, databaseProvider)
+	getLockHolder = datastore.ModifySQLStatement(getLockHolder, databaseProvider)
+	deleteLock = datastore.ModifySQLStatement(deleteLock, databaseProvider)
+}
+
+// Acquire takes or renews the named lock for the holder
+// The lock is taken over if it has expired, so a holder that stops renewing the lock loses it
+func (p *PgsqlLockRepository) Acquire(name string, holder string, expires time.Time) (bool, error) {
+	log.Debugf("Acquire lock %s", name)
+
+	// Renew the lock, or take over an expired lock
+	result, err := p.db.Exec(updateLock, holder, expires.Unix(), name, holder, time.Now().Unix())
+	if err != nil {
+		return false, fmt.Errorf("Unable to acquire lock %s: %v", name, err)
+	}
+	if rowsUpdates, err := result.RowsAffected(); err == nil && rowsUpdates == 1 {
+		return true, nil
+	}
+
+	// Nobody has taken the lock yet - inserting will fail if another holder has just taken it
+	if _, err = p.db.Exec(insertLock, name, holder, expires.Unix()); err == nil {
+		return true, nil
+	}
+
+	// MySQL does not count a renewal that does not change the expiry as an update, so check who holds the lock
+	var currentHolder string
+	if err = p.db.QueryRow(getLockHolder, name).Scan(&currentHolder); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("Unable to find holder of lock %s: %v", name, err)
+	}
+
+	return currentHolder == holder, nil
+}
+
+// Release gives up the named lock, if it is held by the holder
+func (p *PgsqlLockRepository) Release(name string, holder string) error {
+	log.Debugf("Release lock %s", name)
+	if _, err := p.db.Exec(deleteLock, name, holder); err != nil {
+		return fmt.Errorf("Unable to release lock %s: %v", name, err)
+	}
+	return nil
+}
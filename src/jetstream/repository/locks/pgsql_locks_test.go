@@ -0,0 +1,121 @@
+package locks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPgSQLLocks(t *testing.T) {
+
+	var (
+		mockLockName   = "leader"
+		mockHolder     = "instance-1"
+		mockOther      = "instance-2"
+		unknownDBError = "Unknown Database Error"
+
+		updateLockSQL     = `UPDATE locks SET holder = (.+) WHERE name = (.+) AND \(holder = (.+) OR expires < (.+)\)`
+		insertLockSQL     = `INSERT INTO locks`
+		selectHolderSQL   = `SELECT holder FROM locks WHERE name = (.+)`
+		deleteLockSQL     = `DELETE FROM locks WHERE name = (.+) AND holder = (.+)`
+		mockExpires       = time.Now().Add(time.Minute)
+		duplicateKeyError = errors.New("duplicate key value violates unique constraint")
+	)
+
+	Convey("Given a request to acquire a lock", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		repository, _ := NewPgsqlLockRepository(db)
+
+		Convey("a lock held by the holder or expired should be taken", func() {
+			mock.ExpectExec(updateLockSQL).
+				WithArgs(mockHolder, mockExpires.Unix(), mockLockName, mockHolder, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			acquired, err := repository.Acquire(mockLockName, mockHolder, mockExpires)
+			So(err, ShouldBeNil)
+			So(acquired, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a lock that nobody holds should be inserted", func() {
+			mock.ExpectExec(updateLockSQL).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(insertLockSQL).
+				WithArgs(mockLockName, mockHolder, mockExpires.Unix()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			acquired, err := repository.Acquire(mockLockName, mockHolder, mockExpires)
+			So(err, ShouldBeNil)
+			So(acquired, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a lock held by another holder should not be taken", func() {
+			mock.ExpectExec(updateLockSQL).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(insertLockSQL).
+				WillReturnError(duplicateKeyError)
+			mock.ExpectQuery(selectHolderSQL).
+				WithArgs(mockLockName).
+				WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow(mockOther))
+
+			acquired, err := repository.Acquire(mockLockName, mockHolder, mockExpires)
+			So(err, ShouldBeNil)
+			So(acquired, ShouldBeFalse)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a renewal that did not change the lock should keep it", func() {
+			mock.ExpectExec(updateLockSQL).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(insertLockSQL).
+				WillReturnError(duplicateKeyError)
+			mock.ExpectQuery(selectHolderSQL).
+				WithArgs(mockLockName).
+				WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow(mockHolder))
+
+			acquired, err := repository.Acquire(mockLockName, mockHolder, mockExpires)
+			So(err, ShouldBeNil)
+			So(acquired, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectExec(updateLockSQL).
+				WillReturnError(errors.New(unknownDBError))
+
+			acquired, err := repository.Acquire(mockLockName, mockHolder, mockExpires)
+			So(err, ShouldNotBeNil)
+			So(acquired, ShouldBeFalse)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to release a lock", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		repository, _ := NewPgsqlLockRepository(db)
+
+		Convey("only the lock held by the holder should be deleted", func() {
+			mock.ExpectExec(deleteLockSQL).
+				WithArgs(mockLockName, mockHolder).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(repository.Release(mockLockName, mockHolder), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,39 @@
+package mfa
+
+import (
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// EncryptedSecret is the stored ciphertext of a user's TOTP secret
+type EncryptedSecret struct {
+	UserGUID string
+	Secret   []byte
+}
+
+// Repository is an application of the repository pattern for storing the TOTP second factor of local users
+type Repository interface {
+	// Find returns the second factor of the user, or nil if they have not started to enrol
+	Find(userGUID string, encryptionKey []byte) (*interfaces.LocalUserMFA, error)
+	// Save starts enrolment of the user, replacing any second factor and recovery codes that they had
+	Save(userGUID string, secret string, encryptionKey []byte) error
+	// Enable completes enrolment of the user with the hashes of their recovery codes
+	Enable(userGUID string, recoveryCodeHashes []string) error
+	SetRecoveryCodes(userGUID string, recoveryCodeHashes []string) error
+	// UseRecoveryCode removes the recovery code - returns false if the user has no such code
+	UseRecoveryCode(userGUID string, codeHash string) (bool, error)
+	CountRecoveryCodes(userGUID string) (int, error)
+	// UpdateLastUsedStep records the time step of a code that has been used - returns false if a code from that step, or a later one, has already been used
+	UpdateLastUsedStep(userGUID string, step int64) (bool, error)
+	Delete(userGUID string) error
+
+	// Used to back up and restore the second factor of local users
+	List(encryptionKey []byte) ([]*interfaces.LocalUserMFA, error)
+	// ListRecoveryCodes returns the hashes of the recovery codes of all users, keyed by user guid
+	ListRecoveryCodes() (map[string][]string, error)
+	Restore(mfa *interfaces.LocalUserMFA, recoveryCodeHashes []string, encryptionKey []byte) error
+
+	// Used to re-encrypt secrets when the encryption key is rotated
+	CountSecrets() (int, error)
+	ListSecrets(afterUserGUID string, limit int) ([]EncryptedSecret, error)
+	UpdateSecret(userGUID string, secret []byte, previous []byte) (bool, error)
+}
@@ -0,0 +1,308 @@
+package mfa
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var findMFA = `SELECT user_guid, secret, enabled, last_used_step, created
+							FROM local_users_mfa
+							WHERE user_guid = $1`
+
+var listMFA = `SELECT user_guid, secret, enabled, last_used_step, created
+							FROM local_users_mfa`
+
+var insertMFA = `INSERT INTO local_users_mfa (user_guid, secret, enabled, last_used_step, created)
+							VALUES ($1, $2, $3, $4, $5)`
+
+var enableMFA = `UPDATE local_users_mfa SET enabled = $1 WHERE user_guid = $2`
+
+var updateMFALastUsedStep = `UPDATE local_users_mfa SET last_used_step = $1 WHERE user_guid = $2 AND last_used_step < $3`
+
+var deleteMFA = `DELETE FROM local_users_mfa WHERE user_guid = $1`
+
+var insertRecoveryCode = `INSERT INTO local_users_recovery_codes (user_guid, code_hash) VALUES ($1, $2)`
+
+var deleteRecoveryCode = `DELETE FROM local_users_recovery_codes WHERE user_guid = $1 AND code_hash = $2`
+
+var deleteRecoveryCodes = `DELETE FROM local_users_recovery_codes WHERE user_guid = $1`
+
+var countRecoveryCodes = `SELECT COUNT(*) FROM local_users_recovery_codes WHERE user_guid = $1`
+
+var listRecoveryCodes = `SELECT user_guid, code_hash FROM local_users_recovery_codes`
+
+var countMFASecrets = `SELECT COUNT(*) FROM local_users_mfa`
+
+var listMFASecrets = `SELECT user_guid, secret FROM local_users_mfa WHERE user_guid > $1 ORDER BY user_guid LIMIT $2`
+
+var updateMFASecret = `UPDATE local_users_mfa SET secret = $1 WHERE user_guid = $2 AND secret = $3`
+
+// PgsqlMFARepository is a PostgreSQL-backed repository for the second factor of local users
+type PgsqlMFARepository struct {
+	db datastore.Connection
+}
+
+// NewPgsqlMFARepository will create a new instance of the PgsqlMFARepository
+func NewPgsqlMFARepository(dcp datastore.Connection) (Repository, error) {
+	return &PgsqlMFARepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	findMFA = datastore.ModifySQLStatement(findMFA, databaseProvider)
+	listMFA = datastore.ModifySQLStatement(listMFA, databaseProvider)
+	insertMFA = datastore.ModifySQLStatement(insertMFA, databaseProvider)
+	enableMFA = datastore.ModifySQLStatement(enableMFA, databaseProvider)
+	updateMFALastUsedStep = datastore.ModifySQLStatement(updateMFALastUsedStep, databaseProvider)
+	deleteMFA = datastore.ModifySQLStatement(deleteMFA, databaseProvider)
+	insertRecoveryCode = datastore.ModifySQLStatement(insertRecoveryCode, databaseProvider)
+	deleteRecoveryCode = datastore.ModifySQLStatement(deleteRecoveryCode, databaseProvider)
+	deleteRecoveryCodes = datastore.ModifySQLStatement(deleteRecoveryCodes, databaseProvider)
+	countRecoveryCodes = datastore.ModifySQLStatement(countRecoveryCodes, databaseProvider)
+	listRecoveryCodes = datastore.ModifySQLStatement(listRecoveryCodes, databaseProvider)
+	countMFASecrets = datastore.ModifySQLStatement(countMFASecrets, databaseProvider)
+	listMFASecrets = datastore.ModifySQLStatement(listMFASecrets, databaseProvider)
+	updateMFASecret = datastore.ModifySQLStatement(updateMFASecret, databaseProvider)
+}
+
+// Find returns the second factor of the user with the decrypted secret, or nil if they have not started to enrol
+func (p *PgsqlMFARepository) Find(userGUID string, encryptionKey []byte) (*interfaces.LocalUserMFA, error) {
+	log.Debug("Find MFA")
+	var secret []byte
+	mfa := new(interfaces.LocalUserMFA)
+	err := p.db.QueryRow(findMFA, userGUID).Scan(&mfa.UserGUID, &secret, &mfa.Enabled, &mfa.LastUsedStep, &mfa.Created)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("Unable to find MFA of user: %v", err)
+	}
+
+	if mfa.Secret, err = crypto.DecryptToken(encryptionKey, secret); err != nil {
+		return nil, fmt.Errorf("Unable to decrypt MFA secret: %v", err)
+	}
+	return mfa, nil
+}
+
+// Save starts enrolment of the user, replacing any second factor and recovery codes that they had
+func (p *PgsqlMFARepository) Save(userGUID string, secret string, encryptionKey []byte) error {
+	log.Debug("Save MFA")
+	ciphertext, err := crypto.EncryptToken(encryptionKey, secret)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt MFA secret: %v", err)
+	}
+
+	if err = p.Delete(userGUID); err != nil {
+		return err
+	}
+
+	if _, err = p.db.Exec(insertMFA, userGUID, ciphertext, false, 0, time.Now().UTC()); err != nil {
+		return fmt.Errorf("Unable to save MFA of user: %v", err)
+	}
+	return nil
+}
+
+// Enable completes enrolment of the user with the hashes of their recovery codes
+func (p *PgsqlMFARepository) Enable(userGUID string, recoveryCodeHashes []string) error {
+	log.Debug("Enable MFA")
+	if err := p.SetRecoveryCodes(userGUID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if _, err := p.db.Exec(enableMFA, true, userGUID); err != nil {
+		return fmt.Errorf("Unable to enable MFA of user: %v", err)
+	}
+	return nil
+}
+
+// SetRecoveryCodes replaces the recovery codes of the user
+func (p *PgsqlMFARepository) SetRecoveryCodes(userGUID string, recoveryCodeHashes []string) error {
+	log.Debug("Set MFA Recovery Codes")
+	if _, err := p.db.Exec(deleteRecoveryCodes, userGUID); err != nil {
+		return fmt.Errorf("Unable to delete recovery codes: %v", err)
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		if _, err := p.db.Exec(insertRecoveryCode, userGUID, codeHash); err != nil {
+			return fmt.Errorf("Unable to save recovery code: %v", err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode removes the recovery code, returning false if the user has no such code
+func (p *PgsqlMFARepository) UseRecoveryCode(userGUID string, codeHash string) (bool, error) {
+	log.Debug("Use MFA Recovery Code")
+	result, err := p.db.Exec(deleteRecoveryCode, userGUID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("Unable to use recovery code: %v", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Unable to determine number of used recovery codes: %v", err)
+	}
+	return count > 0, nil
+}
+
+// CountRecoveryCodes returns the number of recovery codes that the user has left
+func (p *PgsqlMFARepository) CountRecoveryCodes(userGUID string) (int, error) {
+	log.Debug("Count MFA Recovery Codes")
+	var count int
+	if err := p.db.QueryRow(countRecoveryCodes, userGUID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("Unable to count recovery codes: %v", err)
+	}
+	return count, nil
+}
+
+// UpdateLastUsedStep records the time step of a code that has been used
+// Returns false if a code from the step, or a later one, has already been used - so that codes can't be replayed
+func (p *PgsqlMFARepository) UpdateLastUsedStep(userGUID string, step int64) (bool, error) {
+	log.Debug("Update MFA Last Used Step")
+	result, err := p.db.Exec(updateMFALastUsedStep, step, userGUID, step)
+	if err != nil {
+		return false, fmt.Errorf("Unable to update MFA of user: %v", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Unable to update MFA of user: %v", err)
+	}
+	return count == 1, nil
+}
+
+// Delete removes the second factor and recovery codes of the user
+func (p *PgsqlMFARepository) Delete(userGUID string) error {
+	log.Debug("Delete MFA")
+	if _, err := p.db.Exec(deleteRecoveryCodes, userGUID); err != nil {
+		return fmt.Errorf("Unable to delete recovery codes: %v", err)
+	}
+	if _, err := p.db.Exec(deleteMFA, userGUID); err != nil {
+		return fmt.Errorf("Unable to delete MFA of user: %v", err)
+	}
+	return nil
+}
+
+// List returns the second factor of all users with the decrypted secrets
+func (p *PgsqlMFARepository) List(encryptionKey []byte) ([]*interfaces.LocalUserMFA, error) {
+	log.Debug("List MFA")
+	rows, err := p.db.Query(listMFA)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list MFA of users: %v", err)
+	}
+	defer rows.Close()
+
+	list := make([]*interfaces.LocalUserMFA, 0)
+	for rows.Next() {
+		var secret []byte
+		mfa := new(interfaces.LocalUserMFA)
+		if err := rows.Scan(&mfa.UserGUID, &secret, &mfa.Enabled, &mfa.LastUsedStep, &mfa.Created); err != nil {
+			return nil, fmt.Errorf("Unable to scan MFA of users: %v", err)
+		}
+		if mfa.Secret, err = crypto.DecryptToken(encryptionKey, secret); err != nil {
+			return nil, fmt.Errorf("Unable to decrypt MFA secret: %v", err)
+		}
+		list = append(list, mfa)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list MFA of users: %v", err)
+	}
+	return list, nil
+}
+
+// ListRecoveryCodes returns the hashes of the recovery codes of all users, keyed by user guid
+func (p *PgsqlMFARepository) ListRecoveryCodes() (map[string][]string, error) {
+	log.Debug("List MFA Recovery Codes")
+	rows, err := p.db.Query(listRecoveryCodes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list recovery codes: %v", err)
+	}
+	defer rows.Close()
+
+	codes := make(map[string][]string)
+	for rows.Next() {
+		var userGUID, codeHash string
+		if err := rows.Scan(&userGUID, &codeHash); err != nil {
+			return nil, fmt.Errorf("Unable to scan recovery codes: %v", err)
+		}
+		codes[userGUID] = append(codes[userGUID], codeHash)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list recovery codes: %v", err)
+	}
+	return codes, nil
+}
+
+// Restore saves the second factor and recovery codes of a user from a backup, encrypting the secret with the given key
+func (p *PgsqlMFARepository) Restore(mfa *interfaces.LocalUserMFA, recoveryCodeHashes []string, encryptionKey []byte) error {
+	log.Debug("Restore MFA")
+	ciphertext, err := crypto.EncryptToken(encryptionKey, mfa.Secret)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt MFA secret: %v", err)
+	}
+
+	if _, err = p.db.Exec(insertMFA, mfa.UserGUID, ciphertext, mfa.Enabled, mfa.LastUsedStep, mfa.Created); err != nil {
+		return fmt.Errorf("Unable to save MFA of user: %v", err)
+	}
+	return p.SetRecoveryCodes(mfa.UserGUID, recoveryCodeHashes)
+}
+
+// CountSecrets returns the number of users with a TOTP secret
+func (p *PgsqlMFARepository) CountSecrets() (int, error) {
+	log.Debug("Count MFA Secrets")
+	var count int
+	if err := p.db.QueryRow(countMFASecrets).Scan(&count); err != nil {
+		return 0, fmt.Errorf("Unable to count MFA secrets: %v", err)
+	}
+	return count, nil
+}
+
+// ListSecrets returns a batch of encrypted TOTP secrets, ordered by user guid, starting after the given guid
+func (p *PgsqlMFARepository) ListSecrets(afterUserGUID string, limit int) ([]EncryptedSecret, error) {
+	log.Debug("List MFA Secrets")
+	rows, err := p.db.Query(listMFASecrets, afterUserGUID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list MFA secrets: %v", err)
+	}
+	defer rows.Close()
+
+	secrets := make([]EncryptedSecret, 0)
+	for rows.Next() {
+		var secret EncryptedSecret
+		if err := rows.Scan(&secret.UserGUID, &secret.Secret); err != nil {
+			return nil, fmt.Errorf("Unable to scan MFA secrets: %v", err)
+		}
+		secrets = append(secrets, secret)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list MFA secrets: %v", err)
+	}
+	return secrets, nil
+}
+
+// UpdateSecret replaces the encrypted TOTP secret of a user, as long as it has not changed since it was listed
+// Returns false if the secret has changed
+func (p *PgsqlMFARepository) UpdateSecret(userGUID string, secret []byte, previous []byte) (bool, error) {
+	log.Debug("Update MFA Secret")
+	result, err := p.db.Exec(updateMFASecret, secret, userGUID, previous)
+	if err != nil {
+		return false, fmt.Errorf("Unable to update MFA secret: %v", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Unable to update MFA secret: %v", err)
+	}
+	return count == 1, nil
+}
@@ -0,0 +1,228 @@
+package mfa
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPgSQLMFA(t *testing.T) {
+
+	var (
+		mockUserGUID   = "mock-user-guid"
+		mockSecret     = "JBSWY3DPEHPK3PXP"
+		mockCodeHash   = "mock-code-hash"
+		mockKey        = []byte("0123456789abcdef0123456789abcdef")
+		unknownDBError = "Unknown Database Error"
+
+		selectMFA            = `SELECT (.+) FROM local_users_mfa WHERE user_guid = (.+)`
+		insertIntoMFA        = `INSERT INTO local_users_mfa`
+		updateEnabled        = `UPDATE local_users_mfa SET enabled = (.+) WHERE user_guid = (.+)`
+		updateLastUsedStep   = `UPDATE local_users_mfa SET last_used_step = (.+) WHERE user_guid = (.+) AND last_used_step < (.+)`
+		deleteFromMFA        = `DELETE FROM local_users_mfa WHERE user_guid = (.+)`
+		insertIntoCodes      = `INSERT INTO local_users_recovery_codes`
+		deleteCode           = `DELETE FROM local_users_recovery_codes WHERE user_guid = (.+) AND code_hash = (.+)`
+		deleteCodes          = `DELETE FROM local_users_recovery_codes WHERE user_guid = (.+)`
+		selectAllMFA         = `SELECT (.+) FROM local_users_mfa`
+		selectAllCodes       = `SELECT user_guid, code_hash FROM local_users_recovery_codes`
+		rowFieldsForMFA      = []string{"user_guid", "secret", "enabled", "last_used_step", "created"}
+		mockTime             = time.Date(2019, 12, 5, 9, 0, 0, 0, time.UTC)
+		encryptedSecret, _   = crypto.EncryptToken(mockKey, mockSecret)
+		recoveryCodeHashes   = []string{"hash-1", "hash-2"}
+		expectedRecoveryArgs = [][]interface{}{{mockUserGUID, "hash-1"}, {mockUserGUID, "hash-2"}}
+	)
+
+	Convey("Given a request to find the MFA of a user", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the MFA should be returned with the decrypted secret", func() {
+			mock.ExpectQuery(selectMFA).
+				WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForMFA).AddRow(mockUserGUID, encryptedSecret, true, 42, mockTime))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			mfa, err := repository.Find(mockUserGUID, mockKey)
+			So(err, ShouldBeNil)
+			So(mfa.Secret, ShouldEqual, mockSecret)
+			So(mfa.Enabled, ShouldBeTrue)
+			So(mfa.LastUsedStep, ShouldEqual, 42)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("nil should be returned if the user has not enrolled", func() {
+			mock.ExpectQuery(selectMFA).
+				WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForMFA))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			mfa, err := repository.Find(mockUserGUID, mockKey)
+			So(err, ShouldBeNil)
+			So(mfa, ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectQuery(selectMFA).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			_, err := repository.Find(mockUserGUID, mockKey)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a request to save the MFA of a user", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("any previous MFA should be replaced with a disabled one", func() {
+			mock.ExpectExec(deleteCodes).WithArgs(mockUserGUID).WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(deleteFromMFA).WithArgs(mockUserGUID).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(insertIntoMFA).
+				WithArgs(mockUserGUID, sqlmock.AnyArg(), false, 0, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			So(repository.Save(mockUserGUID, mockSecret, mockKey), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to enable the MFA of a user", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the recovery codes should be saved and the MFA enabled", func() {
+			mock.ExpectExec(deleteCodes).WithArgs(mockUserGUID).WillReturnResult(sqlmock.NewResult(0, 0))
+			for _, args := range expectedRecoveryArgs {
+				mock.ExpectExec(insertIntoCodes).WithArgs(args[0], args[1]).WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+			mock.ExpectExec(updateEnabled).WithArgs(true, mockUserGUID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			So(repository.Enable(mockUserGUID, recoveryCodeHashes), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to use a recovery code", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("a code that the user has should be used up", func() {
+			mock.ExpectExec(deleteCode).WithArgs(mockUserGUID, mockCodeHash).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			ok, err := repository.UseRecoveryCode(mockUserGUID, mockCodeHash)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("a code that the user does not have should not be accepted", func() {
+			mock.ExpectExec(deleteCode).WithArgs(mockUserGUID, mockCodeHash).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			ok, err := repository.UseRecoveryCode(mockUserGUID, mockCodeHash)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a request to record a used code", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("a code from a later step should be accepted", func() {
+			mock.ExpectExec(updateLastUsedStep).WithArgs(43, mockUserGUID, 43).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			ok, err := repository.UpdateLastUsedStep(mockUserGUID, 43)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("a code that has already been used should not be accepted", func() {
+			mock.ExpectExec(updateLastUsedStep).WithArgs(42, mockUserGUID, 42).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			ok, err := repository.UpdateLastUsedStep(mockUserGUID, 42)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+		})
+	})
+	Convey("Given a request to back up the MFA of all users", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the MFA should be returned with the decrypted secrets", func() {
+			mock.ExpectQuery(selectAllMFA).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForMFA).AddRow(mockUserGUID, encryptedSecret, true, 42, mockTime))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			list, err := repository.List(mockKey)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].Secret, ShouldEqual, mockSecret)
+			So(list[0].LastUsedStep, ShouldEqual, 42)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("the recovery codes should be returned by user", func() {
+			mock.ExpectQuery(selectAllCodes).
+				WillReturnRows(sqlmock.NewRows([]string{"user_guid", "code_hash"}).AddRow(mockUserGUID, "hash-1").AddRow(mockUserGUID, "hash-2"))
+
+			repository, _ := NewPgsqlMFARepository(db)
+			codes, err := repository.ListRecoveryCodes()
+			So(err, ShouldBeNil)
+			So(codes, ShouldResemble, map[string][]string{mockUserGUID: recoveryCodeHashes})
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to restore the MFA of a user", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the MFA and recovery codes should be saved as they were", func() {
+			mock.ExpectExec(insertIntoMFA).
+				WithArgs(mockUserGUID, sqlmock.AnyArg(), true, 42, mockTime).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(deleteCodes).WithArgs(mockUserGUID).WillReturnResult(sqlmock.NewResult(0, 0))
+			for _, args := range expectedRecoveryArgs {
+				mock.ExpectExec(insertIntoCodes).WithArgs(args[0], args[1]).WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			repository, _ := NewPgsqlMFARepository(db)
+			mfa := &interfaces.LocalUserMFA{UserGUID: mockUserGUID, Secret: mockSecret, Enabled: true, LastUsedStep: 42, Created: mockTime}
+			So(repository.Restore(mfa, recoveryCodeHashes, mockKey), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
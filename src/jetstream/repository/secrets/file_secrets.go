@@ -0,0 +1,79 @@
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps each secret in its own file under a directory
+// Secrets are not encrypted, so this is intended for development and testing
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store that keeps secrets in files under the directory, creating it if needed
+func NewFileStore(dir string) (Store, error) {
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("A directory is needed for the file secret store")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create secret store directory: %v", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) filename(path string) (string, error) {
+	for _, part := range strings.Split(path, "/") {
+		if len(part) == 0 || part == "." || part == ".." {
+			return "", fmt.Errorf("Invalid secret path: %s", path)
+		}
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(path)), nil
+}
+
+// Get reads a secret
+func (f *FileStore) Get(path string) (string, error) {
+	filename, err := f.filename(path)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return "", ErrSecretNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// Put writes a secret, replacing any previous value
+func (f *FileStore) Put(path string, value string) error {
+	filename, err := f.filename(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, []byte(value), 0600)
+}
+
+// Delete removes a secret
+func (f *FileStore) Delete(path string) error {
+	filename, err := f.filename(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filename); os.IsNotExist(err) {
+		return ErrSecretNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+)
+
+// ErrSecretNotFound is returned by a Store for a secret that it does not hold
+var ErrSecretNotFound = errors.New("Secret not found")
+
+// Store is an external store for endpoint client secrets and tokens
+// Secrets are identified by a slash-separated path, e.g. endpoints/<guid>/client_secret
+type Store interface {
+	Get(path string) (string, error)
+	Put(path string, value string) error
+	Delete(path string) error
+}
+
+// References to secrets in the external store are kept in the database in place of the encrypted secret
+var referencePrefix = []byte{0x00, 'S', 'R', '1'}
+
+// IsReference returns true if the stored value refers to a secret in the external store
+func IsReference(stored []byte) bool {
+	return bytes.HasPrefix(stored, referencePrefix)
+}
+
+// Seal saves the secret and returns the value to keep in the database
+// This is a reference to the secret if an external store is given, otherwise the secret encrypted with the encryption key
+func Seal(s Store, path string, value string, encryptionKey []byte) ([]byte, error) {
+	if s == nil {
+		return crypto.EncryptToken(encryptionKey, value)
+	}
+
+	if err := s.Put(path, value); err != nil {
+		return nil, fmt.Errorf("Unable to save secret %s: %v", path, err)
+	}
+	return append(append([]byte{}, referencePrefix...), path...), nil
+}
+
+// Open returns the secret for a value kept in the database by Seal
+// Secrets that were encrypted in the database can still be read once an external store is used
+func Open(s Store, stored []byte, encryptionKey []byte) (string, error) {
+	if !IsReference(stored) {
+		return crypto.DecryptToken(encryptionKey, stored)
+	}
+
+	path := string(stored[len(referencePrefix):])
+	if s == nil {
+		return "", fmt.Errorf("Unable to read secret %s: no secret store has been configured", path)
+	}
+
+	value, err := s.Get(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read secret %s: %v", path, err)
+	}
+	return value, nil
+}
+
+// Remove deletes the secret that a value kept in the database refers to - values that are not references are ignored
+func Remove(s Store, stored []byte) error {
+	if !IsReference(stored) {
+		return nil
+	}
+
+	path := string(stored[len(referencePrefix):])
+	if s == nil {
+		return fmt.Errorf("Unable to delete secret %s: no secret store has been configured", path)
+	}
+
+	if err := s.Delete(path); err != nil && err != ErrSecretNotFound {
+		return fmt.Errorf("Unable to delete secret %s: %v", path, err)
+	}
+	return nil
+}
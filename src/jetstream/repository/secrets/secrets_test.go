@@ -0,0 +1,181 @@
+package secrets
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	mockVaultToken = "mock-vault-token"
+	mockSecretPath = "endpoints/mock-endpoint-guid/client_secret"
+	mockSecret     = "big_secret"
+)
+
+var mockEncryptionKey = make([]byte, 32)
+
+// newMockVault returns a stand-in for the HTTP API of a Vault KV version 2 secrets engine mounted at secret/
+func newMockVault() *httptest.Server {
+	var lock sync.Mutex
+	secrets := make(map[string]map[string]string)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != mockVaultToken {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+			path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+			switch r.Method {
+			case http.MethodGet:
+				data, ok := secrets[path]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"errors":[]}`))
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+			case http.MethodPost, http.MethodPut:
+				var body struct {
+					Data map[string]string `json:"data"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				secrets[path] = body.Data
+				w.Write([]byte(`{"data":{"version":1}}`))
+			}
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") && r.Method == http.MethodDelete:
+			delete(secrets, strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestFileStore(t *testing.T) {
+
+	Convey("Given a file secret store", t, func() {
+		dir, err := ioutil.TempDir("", "secrets")
+		So(err, ShouldBeNil)
+		store, err := NewFileStore(dir)
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+
+		Convey("a secret that has been saved can be read and deleted", func() {
+			So(store.Put(mockSecretPath, mockSecret), ShouldBeNil)
+
+			value, err := store.Get(mockSecretPath)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, mockSecret)
+
+			So(store.Delete(mockSecretPath), ShouldBeNil)
+			_, err = store.Get(mockSecretPath)
+			So(err, ShouldEqual, ErrSecretNotFound)
+		})
+
+		Convey("paths outside of the directory should be rejected", func() {
+			So(store.Put("../outside", mockSecret), ShouldNotBeNil)
+			_, err := store.Get("endpoints//client_secret")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestVaultStore(t *testing.T) {
+
+	Convey("Given a Vault secret store", t, func() {
+		vault := newMockVault()
+		store, err := NewVaultStore(http.DefaultClient, vault.URL, mockVaultToken, "", "")
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			vault.Close()
+		})
+
+		Convey("a secret that has been saved can be read and deleted", func() {
+			So(store.Put(mockSecretPath, mockSecret), ShouldBeNil)
+
+			value, err := store.Get(mockSecretPath)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, mockSecret)
+
+			So(store.Delete(mockSecretPath), ShouldBeNil)
+			_, err = store.Get(mockSecretPath)
+			So(err, ShouldEqual, ErrSecretNotFound)
+		})
+
+		Convey("errors reported by Vault should be returned", func() {
+			store, _ := NewVaultStore(http.DefaultClient, vault.URL, "wrong-token", "", "")
+			err := store.Put(mockSecretPath, mockSecret)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "permission denied")
+		})
+	})
+
+	Convey("A Vault secret store needs an address and a token", t, func() {
+		_, err := NewVaultStore(http.DefaultClient, "", mockVaultToken, "", "")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSealAndOpen(t *testing.T) {
+
+	Convey("Given secrets kept in the database", t, func() {
+
+		Convey("without an external store the secret should be encrypted", func() {
+			stored, err := Seal(nil, mockSecretPath, mockSecret, mockEncryptionKey)
+			So(err, ShouldBeNil)
+			So(IsReference(stored), ShouldBeFalse)
+
+			value, err := Open(nil, stored, mockEncryptionKey)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, mockSecret)
+		})
+
+		Convey("with an external store only a reference should be kept", func() {
+			vault := newMockVault()
+			defer vault.Close()
+			store, _ := NewVaultStore(http.DefaultClient, vault.URL, mockVaultToken, "", "")
+
+			encrypted, err := Seal(nil, mockSecretPath, mockSecret, mockEncryptionKey)
+			So(err, ShouldBeNil)
+
+			stored, err := Seal(store, mockSecretPath, mockSecret, mockEncryptionKey)
+			So(err, ShouldBeNil)
+			So(IsReference(stored), ShouldBeTrue)
+			So(string(stored), ShouldNotContainSubstring, mockSecret)
+
+			value, err := Open(store, stored, mockEncryptionKey)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, mockSecret)
+
+			Convey("secrets encrypted before the store was set can still be read", func() {
+				value, err := Open(store, encrypted, mockEncryptionKey)
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, mockSecret)
+			})
+
+			Convey("removing the secret should delete it from the store", func() {
+				So(Remove(store, stored), ShouldBeNil)
+				_, err := store.Get(mockSecretPath)
+				So(err, ShouldEqual, ErrSecretNotFound)
+			})
+		})
+	})
+}
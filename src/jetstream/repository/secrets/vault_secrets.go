@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultVaultMount  = "secret"
+	defaultVaultPrefix = "stratos"
+)
+
+// VaultStore keeps secrets in a Vault KV version 2 secrets engine, using its HTTP API
+type VaultStore struct {
+	client  *http.Client
+	address string
+	token   string
+	mount   string
+	prefix  string
+}
+
+type vaultSecret struct {
+	Data struct {
+		Data map[string]string `json:"data"`
+	} `json:"data"`
+}
+
+// NewVaultStore returns a store that keeps secrets under the prefix of the KV secrets engine at the mount
+func NewVaultStore(client *http.Client, address string, token string, mount string, prefix string) (Store, error) {
+	if len(address) == 0 || len(token) == 0 {
+		return nil, fmt.Errorf("An address and a token are needed for the Vault secret store")
+	}
+	if len(mount) == 0 {
+		mount = defaultVaultMount
+	}
+	if len(prefix) == 0 {
+		prefix = defaultVaultPrefix
+	}
+	return &VaultStore{
+		client:  client,
+		address: strings.TrimRight(address, "/"),
+		token:   token,
+		mount:   strings.Trim(mount, "/"),
+		prefix:  strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (v *VaultStore) url(api string, path string) string {
+	return fmt.Sprintf("%s/v1/%s/%s/%s/%s", v.address, v.mount, api, v.prefix, path)
+}
+
+func (v *VaultStore) do(method string, url string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return v.client.Do(req)
+}
+
+// vaultError returns an error for an unexpected response, including the errors reported by Vault
+func vaultError(res *http.Response) error {
+	var reply struct {
+		Errors []string `json:"errors"`
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if json.Unmarshal(body, &reply) == nil && len(reply.Errors) > 0 {
+		return fmt.Errorf("Vault returned %d: %s", res.StatusCode, strings.Join(reply.Errors, ", "))
+	}
+	return fmt.Errorf("Vault returned %d", res.StatusCode)
+}
+
+// Get reads the latest version of a secret
+func (v *VaultStore) Get(path string) (string, error) {
+	res, err := v.do(http.MethodGet, v.url("data", path), nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", ErrSecretNotFound
+	default:
+		return "", vaultError(res)
+	}
+
+	secret := &vaultSecret{}
+	if err := json.NewDecoder(res.Body).Decode(secret); err != nil {
+		return "", fmt.Errorf("Unable to parse secret: %v", err)
+	}
+	value, ok := secret.Data.Data["value"]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// Put writes a new version of a secret
+func (v *VaultStore) Put(path string, value string) error {
+	body := map[string]interface{}{
+		"data": map[string]string{"value": value},
+	}
+	res, err := v.do(http.MethodPost, v.url("data", path), body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return vaultError(res)
+	}
+	return nil
+}
+
+// Delete removes all versions of a secret
+func (v *VaultStore) Delete(path string) error {
+	res, err := v.do(http.MethodDelete, v.url("metadata", path), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return ErrSecretNotFound
+	default:
+		return vaultError(res)
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/secrets"
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 )
@@ -16,6 +16,10 @@ var findAuthToken = `SELECT token_guid, auth_token, refresh_token, token_expiry,
 									FROM tokens
 									WHERE token_type = 'uaa' AND cnsi_guid = 'STRATOS' AND user_guid = $1`
 
+var findAuthTokenGUID = `SELECT token_guid
+										FROM tokens
+										WHERE token_type = 'uaa' AND cnsi_guid = 'STRATOS' AND user_guid = $1`
+
 var countAuthTokens = `SELECT COUNT(*)
 										FROM tokens
 										WHERE token_type = 'uaa' AND cnsi_guid = 'STRATOS' AND user_guid = $1 `
@@ -43,6 +47,10 @@ var findCNSITokenConnected = `SELECT token_guid, auth_token, refresh_token, toke
 										FROM tokens
 										WHERE cnsi_guid = $1 AND (user_guid = $2 OR user_guid = $3) AND token_type = 'cnsi' AND disconnected = '0'`
 
+var findCNSITokenGUID = `SELECT token_guid
+											FROM tokens
+											WHERE cnsi_guid = $1 AND user_guid = $2 AND token_type = 'cnsi'`
+
 var countCNSITokens = `SELECT COUNT(*)
 											FROM tokens
 											WHERE cnsi_guid=$1 AND user_guid = $2 AND token_type = 'cnsi'`
@@ -53,6 +61,12 @@ var insertCNSIToken = `INSERT INTO tokens (token_guid, cnsi_guid, user_guid, tok
 var updateCNSIToken = `UPDATE tokens
 										SET auth_token = $1, refresh_token = $2, token_expiry = $3, disconnected = $4, meta_data = $5, linked_token = $6
 										WHERE cnsi_guid = $7 AND user_guid = $8 AND token_type = $9 AND auth_type = $10`
+var listCNSITokenSecrets = `SELECT auth_token, refresh_token
+										FROM tokens
+										WHERE token_type = 'cnsi' AND cnsi_guid = $1 AND user_guid = $2`
+var listCNSITokensSecrets = `SELECT auth_token, refresh_token
+										FROM tokens
+										WHERE token_type = 'cnsi' AND cnsi_guid = $1`
 var deleteCNSIToken = `DELETE FROM tokens
 										WHERE token_type = 'cnsi' AND cnsi_guid = $1 AND user_guid = $2`
 var deleteCNSITokens = `DELETE FROM tokens
@@ -62,32 +76,72 @@ var updateToken = `UPDATE tokens
 										SET auth_token = $1, refresh_token = $2, token_expiry = $3
 										WHERE token_guid = $4 AND user_guid = $5`
 
+var listExpiringCNSITokens = `SELECT token_guid, cnsi_guid, user_guid, auth_type, token_expiry
+										FROM tokens
+										WHERE token_type = 'cnsi' AND disconnected = '0' AND linked_token IS NULL AND token_expiry > 0 AND token_expiry < $1`
+
+var listCNSITokens = `SELECT token_guid, cnsi_guid, user_guid, auth_token, refresh_token, token_expiry, disconnected, auth_type, meta_data
+										FROM tokens
+										WHERE token_type = 'cnsi' AND linked_token IS NULL`
+
+var claimTokenRefresh = `UPDATE tokens
+										SET refresh_lease = $1
+										WHERE token_guid = $2 AND user_guid = $3 AND (refresh_lease IS NULL OR refresh_lease < $4)`
+
+var disconnectToken = `UPDATE tokens
+										SET disconnected = $1
+										WHERE token_guid = $2 AND user_guid = $3`
+
+var countTokens = `SELECT COUNT(*) FROM tokens`
+
+var listEncryptedTokens = `SELECT user_guid, cnsi_guid, token_guid, auth_token, refresh_token
+										FROM tokens
+										WHERE user_guid > $1 OR (user_guid = $2 AND cnsi_guid > $3) OR (user_guid = $4 AND cnsi_guid = $5 AND token_guid > $6)
+										ORDER BY user_guid, cnsi_guid, token_guid LIMIT $7`
+
+var updateEncryptedToken = `UPDATE tokens
+										SET auth_token = $1, refresh_token = $2
+										WHERE user_guid = $3 AND cnsi_guid = $4 AND token_guid = $5 AND auth_token = $6`
+
 // PgsqlTokenRepository is a PostgreSQL-backed token repository
 type PgsqlTokenRepository struct {
-	db *sql.DB
+	db          datastore.Connection
+	secretStore secrets.Store
 }
 
 // NewPgsqlTokenRepository - get a reference to the token data source
-func NewPgsqlTokenRepository(dcp *sql.DB) (Repository, error) {
+// Tokens are kept in the secret store if one is given, otherwise they are encrypted in the database
+func NewPgsqlTokenRepository(dcp datastore.Connection, secretStore secrets.Store) (Repository, error) {
 	log.Debug("NewPgsqlTokenRepository")
-	return &PgsqlTokenRepository{db: dcp}, nil
+	return &PgsqlTokenRepository{db: dcp, secretStore: secretStore}, nil
 }
 
 // InitRepositoryProvider - One time init for the given DB Provider
 func InitRepositoryProvider(databaseProvider string) {
 	// Modify the database statements if needed, for the given database type
 	findAuthToken = datastore.ModifySQLStatement(findAuthToken, databaseProvider)
+	findAuthTokenGUID = datastore.ModifySQLStatement(findAuthTokenGUID, databaseProvider)
 	countAuthTokens = datastore.ModifySQLStatement(countAuthTokens, databaseProvider)
 	insertAuthToken = datastore.ModifySQLStatement(insertAuthToken, databaseProvider)
 	updateAuthToken = datastore.ModifySQLStatement(updateAuthToken, databaseProvider)
 	findCNSIToken = datastore.ModifySQLStatement(findCNSIToken, databaseProvider)
 	findCNSITokenConnected = datastore.ModifySQLStatement(findCNSITokenConnected, databaseProvider)
+	findCNSITokenGUID = datastore.ModifySQLStatement(findCNSITokenGUID, databaseProvider)
 	countCNSITokens = datastore.ModifySQLStatement(countCNSITokens, databaseProvider)
+	listCNSITokenSecrets = datastore.ModifySQLStatement(listCNSITokenSecrets, databaseProvider)
+	listCNSITokensSecrets = datastore.ModifySQLStatement(listCNSITokensSecrets, databaseProvider)
 	insertCNSIToken = datastore.ModifySQLStatement(insertCNSIToken, databaseProvider)
 	updateCNSIToken = datastore.ModifySQLStatement(updateCNSIToken, databaseProvider)
 	deleteCNSIToken = datastore.ModifySQLStatement(deleteCNSIToken, databaseProvider)
 	deleteCNSITokens = datastore.ModifySQLStatement(deleteCNSITokens, databaseProvider)
 	updateToken = datastore.ModifySQLStatement(updateToken, databaseProvider)
+	listExpiringCNSITokens = datastore.ModifySQLStatement(listExpiringCNSITokens, databaseProvider)
+	listCNSITokens = datastore.ModifySQLStatement(listCNSITokens, databaseProvider)
+	claimTokenRefresh = datastore.ModifySQLStatement(claimTokenRefresh, databaseProvider)
+	disconnectToken = datastore.ModifySQLStatement(disconnectToken, databaseProvider)
+	countTokens = datastore.ModifySQLStatement(countTokens, databaseProvider)
+	listEncryptedTokens = datastore.ModifySQLStatement(listEncryptedTokens, databaseProvider)
+	updateEncryptedToken = datastore.ModifySQLStatement(updateEncryptedToken, databaseProvider)
 }
 
 // saveAuthToken - Save the Auth token to the datastore
@@ -105,34 +159,34 @@ func (p *PgsqlTokenRepository) SaveAuthToken(userGUID string, tr interfaces.Toke
 		return errors.New(msg)
 	}
 
-	log.Debug("Encrypting Auth Token")
-	ciphertextAuthToken, err := crypto.EncryptToken(encryptionKey, tr.AuthToken)
+	// Is there an existing token?
+	var count int
+	err := p.db.QueryRow(countAuthTokens, userGUID).Scan(&count)
 	if err != nil {
-		return err
+		log.Errorf("Unknown error attempting to find UAA token: %v", err)
 	}
-	var ciphertextRefreshToken []byte
-	if tr.RefreshToken != "" {
-		log.Debug("Encrypting Refresh Token")
-		ciphertextRefreshToken, err = crypto.EncryptToken(encryptionKey, tr.RefreshToken)
-		if err != nil {
-			return err
+
+	// The secrets of an existing token are kept at the same place in the secret store
+	tokenGUID := uuid.NewV4().String()
+	if count > 0 && p.secretStore != nil {
+		if err := p.db.QueryRow(findAuthTokenGUID, userGUID).Scan(&tokenGUID); err != nil {
+			return fmt.Errorf("Unable to find UAA token: %v", err)
 		}
 	}
 
-	// Is there an existing token?
-	var count int
-	err = p.db.QueryRow(countAuthTokens, userGUID).Scan(&count)
+	ciphertextAuthToken, ciphertextRefreshToken, err := p.sealTokens(userGUID, tokenGUID, tr, encryptionKey)
 	if err != nil {
-		log.Errorf("Unknown error attempting to find UAA token: %v", err)
+		return err
 	}
 
 	switch count {
 	case 0:
 
 		log.Debug("Performing INSERT of encrypted tokens")
-		tokenGUID := uuid.NewV4().String()
 		if _, err := p.db.Exec(insertAuthToken, tokenGUID, userGUID, "uaa", ciphertextAuthToken,
 			ciphertextRefreshToken, tr.TokenExpiry); err != nil {
+			// Don't leave the secrets of a token that was not saved in the secret store
+			p.removeTokenSecrets([][]byte{ciphertextAuthToken, ciphertextRefreshToken})
 			msg := "Unable to INSERT UAA token: %v"
 			log.Debugf(msg, err)
 			return fmt.Errorf(msg, err)
@@ -184,13 +238,13 @@ func (p *PgsqlTokenRepository) FindAuthToken(userGUID string, encryptionKey []by
 	}
 
 	log.Debug("Decrypting Auth Token")
-	plaintextAuthToken, err := crypto.DecryptToken(encryptionKey, ciphertextAuthToken)
+	plaintextAuthToken, err := secrets.Open(p.secretStore, ciphertextAuthToken, encryptionKey)
 	if err != nil {
 		return interfaces.TokenRecord{}, err
 	}
 
 	log.Debug("Decrypting Refresh Token")
-	plaintextRefreshToken, err := crypto.DecryptToken(encryptionKey, ciphertextRefreshToken)
+	plaintextRefreshToken, err := secrets.Open(p.secretStore, ciphertextRefreshToken, encryptionKey)
 	if err != nil {
 		return interfaces.TokenRecord{}, err
 	}
@@ -250,31 +304,32 @@ func (p *PgsqlTokenRepository) SaveCNSIToken(cnsiGUID string, userGUID string, t
 		}
 	}
 
-	log.Debug("Encrypting Auth Token")
-	ciphertextAuthToken, err = crypto.EncryptToken(encryptionKey, tr.AuthToken)
+	// Is there an existing token?
+	var count int
+	err = p.db.QueryRow(countCNSITokens, cnsiGUID, userGUID).Scan(&count)
 	if err != nil {
-		return err
+		log.Errorf("Unknown error attempting to find CNSI token: %v", err)
 	}
-	if tr.RefreshToken != "" {
-		log.Debug("Encrypting Refresh Token")
-		ciphertextRefreshToken, err = crypto.EncryptToken(encryptionKey, tr.RefreshToken)
-		if err != nil {
-			return err
+
+	// The secrets of an existing token are kept at the same place in the secret store
+	tokenGUID := uuid.NewV4().String()
+	if count > 0 && p.secretStore != nil {
+		if err := p.db.QueryRow(findCNSITokenGUID, cnsiGUID, userGUID).Scan(&tokenGUID); err != nil {
+			return fmt.Errorf("Unable to find CNSI token: %v", err)
 		}
 	}
 
-	// Is there an existing token?
-	var count int
-	err = p.db.QueryRow(countCNSITokens, cnsiGUID, userGUID).Scan(&count)
+	ciphertextAuthToken, ciphertextRefreshToken, err = p.sealTokens(userGUID, tokenGUID, tr, encryptionKey)
 	if err != nil {
-		log.Errorf("Unknown error attempting to find CNSI token: %v", err)
+		return err
 	}
 
 	switch count {
 	case 0:
-		tokenGUID := uuid.NewV4().String()
 		if _, insertErr := p.db.Exec(insertCNSIToken, tokenGUID, cnsiGUID, userGUID, "cnsi", ciphertextAuthToken,
 			ciphertextRefreshToken, tr.TokenExpiry, tr.Disconnected, tr.AuthType, tr.Metadata, linkedToken); insertErr != nil {
+			// Don't leave the secrets of a token that was not saved in the secret store
+			p.removeTokenSecrets([][]byte{ciphertextAuthToken, ciphertextRefreshToken})
 
 			msg := "Unable to INSERT CNSI token: %v"
 			log.Debugf(msg, insertErr)
@@ -388,13 +443,13 @@ func (p *PgsqlTokenRepository) findCNSIToken(cnsiGUID string, userGUID string, e
 	}
 
 	log.Debug("Decrypting Auth Token")
-	plaintextAuthToken, err := crypto.DecryptToken(encryptionKey, ciphertextAuthToken)
+	plaintextAuthToken, err := secrets.Open(p.secretStore, ciphertextAuthToken, encryptionKey)
 	if err != nil {
 		return interfaces.TokenRecord{}, err
 	}
 
 	log.Debug("Decrypting Refresh Token")
-	plaintextRefreshToken, err := crypto.DecryptToken(encryptionKey, ciphertextRefreshToken)
+	plaintextRefreshToken, err := secrets.Open(p.secretStore, ciphertextRefreshToken, encryptionKey)
 	if err != nil {
 		return interfaces.TokenRecord{}, err
 	}
@@ -439,13 +494,19 @@ func (p *PgsqlTokenRepository) DeleteCNSIToken(cnsiGUID string, userGUID string)
 		return errors.New(msg)
 	}
 
-	_, err := p.db.Exec(deleteCNSIToken, cnsiGUID, userGUID)
+	stored, err := p.listTokenSecrets(listCNSITokenSecrets, cnsiGUID, userGUID)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.db.Exec(deleteCNSIToken, cnsiGUID, userGUID)
 	if err != nil {
 		msg := "Unable to Delete CNSI token: %v"
 		log.Debugf(msg, err)
 		return fmt.Errorf(msg, err)
 	}
 
+	p.removeTokenSecrets(stored)
 	return nil
 }
 
@@ -457,13 +518,19 @@ func (p *PgsqlTokenRepository) DeleteCNSITokens(cnsiGUID string) error {
 		return errors.New(msg)
 	}
 
-	_, err := p.db.Exec(deleteCNSITokens, cnsiGUID)
+	stored, err := p.listTokenSecrets(listCNSITokensSecrets, cnsiGUID)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.db.Exec(deleteCNSITokens, cnsiGUID)
 	if err != nil {
 		msg := "Unable to Delete CNSI token: %v"
 		log.Debugf(msg, err)
 		return fmt.Errorf(msg, err)
 	}
 
+	p.removeTokenSecrets(stored)
 	return nil
 }
 
@@ -508,18 +575,10 @@ func (p *PgsqlTokenRepository) UpdateTokenAuth(userGUID string, tr interfaces.To
 
 	log.Infof("Updating token %s", tokenGUID)
 
-	log.Debug("Encrypting Auth Token")
-	ciphertextAuthToken, err = crypto.EncryptToken(encryptionKey, tr.AuthToken)
+	ciphertextAuthToken, ciphertextRefreshToken, err = p.sealTokens(userGUID, tokenGUID, tr, encryptionKey)
 	if err != nil {
 		return err
 	}
-	if tr.RefreshToken != "" {
-		log.Debug("Encrypting Refresh Token")
-		ciphertextRefreshToken, err = crypto.EncryptToken(encryptionKey, tr.RefreshToken)
-		if err != nil {
-			return err
-		}
-	}
 
 	result, err := p.db.Exec(updateToken, ciphertextAuthToken, ciphertextRefreshToken, tr.TokenExpiry, tokenGUID, userGUID)
 	if err != nil {
@@ -545,3 +604,218 @@ func (p *PgsqlTokenRepository) UpdateTokenAuth(userGUID string, tr interfaces.To
 
 	return nil
 }
+
+// ListExpiringCNSITokens - list the connected endpoint tokens that expire before the given time
+// Linked tokens are not included, since the token they link to is listed instead
+func (p *PgsqlTokenRepository) ListExpiringCNSITokens(expiresBefore int64) ([]ExpiringToken, error) {
+	log.Debug("ListExpiringCNSITokens")
+
+	rows, err := p.db.Query(listExpiringCNSITokens, expiresBefore)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list expiring tokens: %v", err)
+	}
+	defer rows.Close()
+
+	expiring := make([]ExpiringToken, 0)
+	for rows.Next() {
+		var token ExpiringToken
+		if err := rows.Scan(&token.TokenGUID, &token.CNSIGUID, &token.UserGUID, &token.AuthType, &token.TokenExpiry); err != nil {
+			return nil, fmt.Errorf("Unable to scan expiring tokens: %v", err)
+		}
+		expiring = append(expiring, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list expiring tokens: %v", err)
+	}
+
+	return expiring, nil
+}
+
+// ListCNSITokens - list the endpoint tokens of all users, decrypted
+// Linked tokens are not included, since they refer to a token of the user's console session
+func (p *PgsqlTokenRepository) ListCNSITokens(encryptionKey []byte) ([]Token, error) {
+	log.Debug("ListCNSITokens")
+
+	rows, err := p.db.Query(listCNSITokens)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list endpoint tokens: %v", err)
+	}
+	defer rows.Close()
+
+	list := make([]Token, 0)
+	for rows.Next() {
+		var (
+			token                  Token
+			ciphertextAuthToken    []byte
+			ciphertextRefreshToken []byte
+			tokenExpiry            sql.NullInt64
+			metadata               sql.NullString
+		)
+		err := rows.Scan(&token.Record.TokenGUID, &token.CNSIGUID, &token.UserGUID, &ciphertextAuthToken, &ciphertextRefreshToken,
+			&tokenExpiry, &token.Record.Disconnected, &token.Record.AuthType, &metadata)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan endpoint tokens: %v", err)
+		}
+
+		if token.Record.AuthToken, err = secrets.Open(p.secretStore, ciphertextAuthToken, encryptionKey); err != nil {
+			return nil, err
+		}
+		if token.Record.RefreshToken, err = secrets.Open(p.secretStore, ciphertextRefreshToken, encryptionKey); err != nil {
+			return nil, err
+		}
+		token.TokenType = "cnsi"
+		token.Record.TokenExpiry = tokenExpiry.Int64
+		token.Record.Metadata = metadata.String
+		token.Record.SystemShared = token.UserGUID == SystemSharedUserGuid
+		list = append(list, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list endpoint tokens: %v", err)
+	}
+
+	return list, nil
+}
+
+// ClaimTokenRefresh - claim a token for a background refresh until the lease expires
+// Returns false if the token is already claimed by another Jetstream instance
+func (p *PgsqlTokenRepository) ClaimTokenRefresh(tokenGUID string, userGUID string, now int64, leaseExpiry int64) (bool, error) {
+	log.Debug("ClaimTokenRefresh")
+
+	result, err := p.db.Exec(claimTokenRefresh, leaseExpiry, tokenGUID, userGUID, now)
+	if err != nil {
+		return false, fmt.Errorf("Unable to claim token for refresh: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Unable to claim token for refresh: %v", err)
+	}
+
+	return rowsUpdates == 1, nil
+}
+
+// DisconnectToken - mark a token as disconnected, e.g. when it can no longer be refreshed
+func (p *PgsqlTokenRepository) DisconnectToken(tokenGUID string, userGUID string) error {
+	log.Debug("DisconnectToken")
+
+	if _, err := p.db.Exec(disconnectToken, true, tokenGUID, userGUID); err != nil {
+		return fmt.Errorf("Unable to disconnect token: %v", err)
+	}
+
+	return nil
+}
+
+// CountTokens - count the tokens of all users, both console and endpoint tokens
+func (p *PgsqlTokenRepository) CountTokens() (int, error) {
+	log.Debug("CountTokens")
+
+	var count int
+	if err := p.db.QueryRow(countTokens).Scan(&count); err != nil {
+		return 0, fmt.Errorf("Unable to count tokens: %v", err)
+	}
+	return count, nil
+}
+
+// ListEncryptedTokens - list a batch of tokens without decrypting them, ordered by primary key, starting after the given token
+func (p *PgsqlTokenRepository) ListEncryptedTokens(after EncryptedToken, limit int) ([]EncryptedToken, error) {
+	log.Debug("ListEncryptedTokens")
+
+	rows, err := p.db.Query(listEncryptedTokens, after.UserGUID, after.UserGUID, after.CNSIGUID, after.UserGUID, after.CNSIGUID, after.TokenGUID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list tokens: %v", err)
+	}
+	defer rows.Close()
+
+	list := make([]EncryptedToken, 0)
+	for rows.Next() {
+		var token EncryptedToken
+		if err := rows.Scan(&token.UserGUID, &token.CNSIGUID, &token.TokenGUID, &token.AuthToken, &token.RefreshToken); err != nil {
+			return nil, fmt.Errorf("Unable to scan tokens: %v", err)
+		}
+		list = append(list, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list tokens: %v", err)
+	}
+
+	return list, nil
+}
+
+// UpdateEncryptedToken - replace the ciphertexts of a token, as long as its auth token has not changed since it was listed
+// Returns false if the token has been changed, e.g. by a refresh
+func (p *PgsqlTokenRepository) UpdateEncryptedToken(token EncryptedToken, previousAuthToken []byte) (bool, error) {
+	log.Debug("UpdateEncryptedToken")
+
+	result, err := p.db.Exec(updateEncryptedToken, token.AuthToken, token.RefreshToken, token.UserGUID, token.CNSIGUID, token.TokenGUID, previousAuthToken)
+	if err != nil {
+		return false, fmt.Errorf("Unable to update token: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Unable to update token: %v", err)
+	}
+
+	return rowsUpdates == 1, nil
+}
+
+// tokenSecretPath returns where a secret of a token is kept in the secret store
+func tokenSecretPath(userGUID string, tokenGUID string, name string) string {
+	return fmt.Sprintf("tokens/%s/%s/%s", userGUID, tokenGUID, name)
+}
+
+// sealTokens returns the auth and refresh tokens to keep in the database - either encrypted or references to the secret store
+func (p *PgsqlTokenRepository) sealTokens(userGUID string, tokenGUID string, tr interfaces.TokenRecord, encryptionKey []byte) ([]byte, []byte, error) {
+	log.Debug("Encrypting Auth Token")
+	ciphertextAuthToken, err := secrets.Seal(p.secretStore, tokenSecretPath(userGUID, tokenGUID, "auth_token"), tr.AuthToken, encryptionKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ciphertextRefreshToken []byte
+	if tr.RefreshToken != "" {
+		log.Debug("Encrypting Refresh Token")
+		ciphertextRefreshToken, err = secrets.Seal(p.secretStore, tokenSecretPath(userGUID, tokenGUID, "refresh_token"), tr.RefreshToken, encryptionKey)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return ciphertextAuthToken, ciphertextRefreshToken, nil
+}
+
+// listTokenSecrets returns the stored auth and refresh tokens of the tokens that are about to be deleted
+// These are only needed to remove the tokens from the secret store
+func (p *PgsqlTokenRepository) listTokenSecrets(query string, args ...interface{}) ([][]byte, error) {
+	if p.secretStore == nil {
+		return nil, nil
+	}
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list tokens to delete: %v", err)
+	}
+	defer rows.Close()
+
+	stored := make([][]byte, 0)
+	for rows.Next() {
+		var authToken, refreshToken []byte
+		if err := rows.Scan(&authToken, &refreshToken); err != nil {
+			return nil, fmt.Errorf("Unable to scan tokens to delete: %v", err)
+		}
+		stored = append(stored, authToken, refreshToken)
+	}
+
+	return stored, rows.Err()
+}
+
+func (p *PgsqlTokenRepository) removeTokenSecrets(stored [][]byte) {
+	for _, value := range stored {
+		if err := secrets.Remove(p.secretStore, value); err != nil {
+			log.Warnf("Unable to remove token from the secret store: %v", err)
+		}
+	}
+}
@@ -39,7 +39,7 @@ func initialiseRepo(t *testing.T) (*sql.DB, sqlmock.Sqlmock, Repository) {
 	if err != nil {
 		t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	repository, _ := NewPgsqlTokenRepository(db)
+	repository, _ := NewPgsqlTokenRepository(db, nil)
 	return db, mock, repository
 }
 
@@ -460,3 +460,43 @@ func TestDeleteCNSITokens(t *testing.T) {
 	})
 
 }
+
+func TestReEncryptTokens(t *testing.T) {
+
+	Convey("Re-encrypting tokens", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+
+		Convey("should list the next batch of tokens after the last one", func() {
+			after := EncryptedToken{UserGUID: mockUserGuid, CNSIGUID: mockCNSIGuid, TokenGUID: mockTokenGUID}
+			rows := sqlmock.NewRows([]string{"user_guid", "cnsi_guid", "token_guid", "auth_token", "refresh_token"}).
+				AddRow(mockUserGuid, "other-cnsi", "other-token", []byte("auth"), nil)
+			mock.ExpectQuery(`SELECT user_guid, cnsi_guid, token_guid, auth_token, refresh_token FROM tokens`).
+				WithArgs(mockUserGuid, mockUserGuid, mockCNSIGuid, mockUserGuid, mockCNSIGuid, mockTokenGUID, 10).
+				WillReturnRows(rows)
+
+			list, err := repository.ListEncryptedTokens(after, 10)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].TokenGUID, ShouldEqual, "other-token")
+			So(list[0].RefreshToken, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not update a token that has changed since it was listed", func() {
+			token := EncryptedToken{UserGUID: mockUserGuid, CNSIGUID: mockCNSIGuid, TokenGUID: mockTokenGUID, AuthToken: []byte("new")}
+			mock.ExpectExec(updateUAATokenSql).
+				WithArgs(token.AuthToken, token.RefreshToken, mockUserGuid, mockCNSIGuid, mockTokenGUID, []byte("old")).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			updated, err := repository.UpdateEncryptedToken(token, []byte("old"))
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeFalse)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
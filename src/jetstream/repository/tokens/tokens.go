@@ -4,11 +4,30 @@ import "github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/inter
 
 // Token -
 type Token struct {
+	CNSIGUID  string
 	UserGUID  string
 	TokenType string
 	Record    interfaces.TokenRecord
 }
 
+// ExpiringToken identifies an endpoint token that is due to expire
+type ExpiringToken struct {
+	TokenGUID   string
+	CNSIGUID    string
+	UserGUID    string
+	AuthType    string
+	TokenExpiry int64
+}
+
+// EncryptedToken holds the stored ciphertexts of a token, identified by its primary key
+type EncryptedToken struct {
+	UserGUID     string
+	CNSIGUID     string
+	TokenGUID    string
+	AuthToken    []byte
+	RefreshToken []byte
+}
+
 const SystemSharedUserGuid = "00000000-1111-2222-3333-444444444444" // User ID for the system shared user for endpoints
 
 // Repository is an application of the repository pattern for storing tokens
@@ -24,4 +43,15 @@ type Repository interface {
 
 	// Update a token's auth data
 	UpdateTokenAuth(userGUID string, tokenRecord interfaces.TokenRecord, encryptionKey []byte) error
+
+	// Background token refresh
+	ListExpiringCNSITokens(expiresBefore int64) ([]ExpiringToken, error)
+	ListCNSITokens(encryptionKey []byte) ([]Token, error)
+	ClaimTokenRefresh(tokenGUID string, userGUID string, now int64, leaseExpiry int64) (bool, error)
+	DisconnectToken(tokenGUID string, userGUID string) error
+
+	// Re-encryption of tokens with a new encryption key
+	CountTokens() (int, error)
+	ListEncryptedTokens(after EncryptedToken, limit int) ([]EncryptedToken, error)
+	UpdateEncryptedToken(token EncryptedToken, previousAuthToken []byte) (bool, error)
 }
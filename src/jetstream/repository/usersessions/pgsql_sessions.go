@@ -0,0 +1,118 @@
+package usersessions
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+)
+
+// The Postgres session store keeps sessions in its own table, keyed by a random string
+// The MySQL and SQLite session stores key sessions by an auto-incremented ID
+// The user of each session is kept in the session_users table, so that only the sessions of the user are read
+var listActiveUserSessions = `SELECT s.key, s.data, s.created_on, s.expires_on
+							FROM http_sessions s, session_users u
+							WHERE u.user_guid = $1 AND u.session_id = s.key AND s.expires_on > $2
+							ORDER BY s.created_on`
+
+var deleteSession = `DELETE FROM http_sessions WHERE key = $1`
+
+var insertSessionUser = `INSERT INTO session_users (session_id, user_guid) VALUES ($1, $2)`
+
+var deleteSessionUser = `DELETE FROM session_users WHERE session_id = $1`
+
+var deleteStaleSessionUsers = `DELETE FROM session_users
+							WHERE user_guid = $1 AND NOT EXISTS (SELECT 1 FROM http_sessions s WHERE s.key = session_users.session_id)`
+
+// PgsqlSessionsRepository is a session repository over the tables of the pgstore, mysqlstore and sqlitestore session stores
+type PgsqlSessionsRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlSessionsRepository will create a new instance of the PgsqlSessionsRepository
+func NewPgsqlSessionsRepository(dcp *sql.DB) (Repository, error) {
+	return &PgsqlSessionsRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	if databaseProvider != datastore.PGSQL {
+		listActiveUserSessions = `SELECT s.id, s.session_data, s.created_on, s.expires_on
+							FROM sessions s, session_users u
+							WHERE u.user_guid = $1 AND u.session_id = s.id AND s.expires_on > $2
+							ORDER BY s.created_on`
+		deleteSession = `DELETE FROM sessions WHERE id = $1`
+		deleteStaleSessionUsers = `DELETE FROM session_users
+							WHERE user_guid = $1 AND NOT EXISTS (SELECT 1 FROM sessions s WHERE s.id = session_users.session_id)`
+	}
+
+	// Modify the database statements if needed, for the given database type
+	listActiveUserSessions = datastore.ModifySQLStatement(listActiveUserSessions, databaseProvider)
+	deleteSession = datastore.ModifySQLStatement(deleteSession, databaseProvider)
+	insertSessionUser = datastore.ModifySQLStatement(insertSessionUser, databaseProvider)
+	deleteSessionUser = datastore.ModifySQLStatement(deleteSessionUser, databaseProvider)
+	deleteStaleSessionUsers = datastore.ModifySQLStatement(deleteStaleSessionUsers, databaseProvider)
+}
+
+// ListActiveForUser returns the sessions of the user that have not expired, oldest first
+func (p *PgsqlSessionsRepository) ListActiveForUser(userGUID string, now time.Time) ([]*Session, error) {
+	log.Debug("List Active Sessions For User")
+	rows, err := p.db.Query(listActiveUserSessions, userGUID, now)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve sessions: %v", err)
+	}
+	defer rows.Close()
+
+	list := make([]*Session, 0)
+	for rows.Next() {
+		var id, data []byte
+		session := new(Session)
+		if err := rows.Scan(&id, &data, &session.CreatedOn, &session.ExpiresOn); err != nil {
+			return nil, fmt.Errorf("Unable to scan sessions: %v", err)
+		}
+		session.ID = string(id)
+		session.Data = string(data)
+		list = append(list, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list sessions: %v", err)
+	}
+
+	return list, nil
+}
+
+// SetUser records the user of a new session
+// The records of the user's sessions that no longer exist are removed at the same time
+func (p *PgsqlSessionsRepository) SetUser(id, userGUID string) error {
+	log.Debug("Set Session User")
+	if _, err := p.db.Exec(deleteStaleSessionUsers, userGUID); err != nil {
+		return fmt.Errorf("Unable to remove the users of old sessions: %v", err)
+	}
+	if _, err := p.db.Exec(insertSessionUser, id, userGUID); err != nil {
+		return fmt.Errorf("Unable to save session user: %v", err)
+	}
+	return nil
+}
+
+// Delete removes a session, returning false if there was no session with the ID
+func (p *PgsqlSessionsRepository) Delete(id string) (bool, error) {
+	log.Debug("Delete Session")
+	result, err := p.db.Exec(deleteSession, id)
+	if err != nil {
+		return false, fmt.Errorf("Unable to delete session: %v", err)
+	}
+
+	if _, err := p.db.Exec(deleteSessionUser, id); err != nil {
+		return false, fmt.Errorf("Unable to delete session user: %v", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Unable to determine number of deleted sessions: %v", err)
+	}
+	return count > 0, nil
+}
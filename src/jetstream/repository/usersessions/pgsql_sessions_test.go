@@ -0,0 +1,131 @@
+package usersessions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPgSQLSessions(t *testing.T) {
+
+	var (
+		mockSessionKey = "MOCKSESSIONKEY"
+		unknownDBError = "Unknown Database Error"
+
+		mockUserGUID = "mock-user-guid"
+
+		selectFromSessions      = `SELECT s.key, s.data, s.created_on, s.expires_on FROM http_sessions s, session_users u WHERE u.user_guid = (.+) AND u.session_id = s.key AND s.expires_on > (.+) ORDER BY s.created_on`
+		deleteFromSessions      = `DELETE FROM http_sessions WHERE key = (.+)`
+		insertIntoSessionUsers  = `INSERT INTO session_users \(session_id, user_guid\) VALUES \((.+)\)`
+		deleteFromSessionUsers  = `DELETE FROM session_users WHERE session_id = (.+)`
+		deleteStaleSessionUsers = `DELETE FROM session_users WHERE user_guid = (.+) AND NOT EXISTS \(SELECT 1 FROM http_sessions s WHERE s.key = session_users.session_id\)`
+		rowFieldsForSessions    = []string{"key", "data", "created_on", "expires_on"}
+		mockTime                = time.Date(2019, 11, 29, 9, 0, 0, 0, time.UTC)
+	)
+
+	Convey("Given a request to list the active sessions of a user", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the sessions that have not expired should be returned", func() {
+			rows := sqlmock.NewRows(rowFieldsForSessions).
+				AddRow([]byte(mockSessionKey), []byte("encoded"), mockTime.Add(-time.Hour), mockTime.Add(time.Minute))
+			mock.ExpectQuery(selectFromSessions).
+				WithArgs(mockUserGUID, mockTime).
+				WillReturnRows(rows)
+
+			repository, _ := NewPgsqlSessionsRepository(db)
+			list, err := repository.ListActiveForUser(mockUserGUID, mockTime)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].ID, ShouldEqual, mockSessionKey)
+			So(list[0].Data, ShouldEqual, "encoded")
+			So(list[0].ExpiresOn, ShouldEqual, mockTime.Add(time.Minute))
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectQuery(selectFromSessions).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlSessionsRepository(db)
+			_, err := repository.ListActiveForUser(mockUserGUID, mockTime)
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to delete a session", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the session should be deleted", func() {
+			mock.ExpectExec(deleteFromSessions).
+				WithArgs(mockSessionKey).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(deleteFromSessionUsers).
+				WithArgs(mockSessionKey).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			repository, _ := NewPgsqlSessionsRepository(db)
+			deleted, err := repository.Delete(mockSessionKey)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a session that does not exist should not be reported as deleted", func() {
+			mock.ExpectExec(deleteFromSessions).
+				WithArgs(mockSessionKey).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(deleteFromSessionUsers).
+				WithArgs(mockSessionKey).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			repository, _ := NewPgsqlSessionsRepository(db)
+			deleted, err := repository.Delete(mockSessionKey)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a request to record the user of a session", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("the user should be recorded and the user's sessions that no longer exist removed", func() {
+			mock.ExpectExec(deleteStaleSessionUsers).
+				WithArgs(mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(insertIntoSessionUsers).
+				WithArgs(mockSessionKey, mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			repository, _ := NewPgsqlSessionsRepository(db)
+			So(repository.SetUser(mockSessionKey, mockUserGUID), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a database error should be returned", func() {
+			mock.ExpectExec(deleteStaleSessionUsers).
+				WillReturnError(errors.New(unknownDBError))
+
+			repository, _ := NewPgsqlSessionsRepository(db)
+			So(repository.SetUser(mockSessionKey, mockUserGUID), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,21 @@
+package usersessions
+
+import (
+	"time"
+)
+
+// Session is a row of the table that the session store keeps the HTTP sessions of users in
+// The data is the session values, encoded by the session store
+type Session struct {
+	ID        string
+	Data      string
+	CreatedOn time.Time
+	ExpiresOn time.Time
+}
+
+// Repository is an application of the repository pattern for reading and deleting the sessions kept by the session store
+type Repository interface {
+	ListActiveForUser(userGUID string, now time.Time) ([]*Session, error)
+	SetUser(id, userGUID string) error
+	Delete(id string) (bool, error)
+}
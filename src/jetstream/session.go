@@ -100,7 +100,11 @@ func (p *portalProxy) SaveSession(c echo.Context, session *sessions.Session) err
 	// Update the cached session and mark that it has been updated
 
 	// We're not calling the real session save, so we need to set the session expiry ourselves
+	// Sessions can not be extended beyond their maximum lifetime
 	expiresOn := time.Now().Add(time.Second * time.Duration(session.Options.MaxAge))
+	if end := p.getSessionLifetimeEnd(session.Values); !end.IsZero() && end.Before(expiresOn) {
+		expiresOn = end
+	}
 	session.Values["expires_on"] = expiresOn
 
 	// If this is the first time we have updated the session, register the session writer hook
@@ -117,12 +121,21 @@ func (p *portalProxy) SaveSession(c echo.Context, session *sessions.Session) err
 // This is called only once per request to avoid duplication
 func (p *portalProxy) writeSessionHook(c echo.Context) func() {
 	return func() {
+		// Sessions of requests authenticated with an API token are never saved
+		if getRequestAPIToken(c) != nil {
+			return
+		}
+
 		// Has the session been modified and need saving?
 		sessionModifed := c.Get(jetStreamSessionContextUpdatedKey)
 		sessionIntf := c.Get(jetStreamSessionContextKey)
 		if sessionModifed != nil && sessionIntf != nil {
 			if session, ok := sessionIntf.(*sessions.Session); ok {
-				p.SessionStore.Save(c.Request(), c.Response().Writer, session)
+				if err := p.SessionStore.Save(c.Request(), c.Response().Writer, session); err != nil {
+					log.Errorf("Unable to save session: %v", err)
+					return
+				}
+				p.recordSessionUser(c, session)
 			}
 		}
 	}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/telemetry"
+)
+
+// initTelemetry registers the database and session store metrics
+func initTelemetry(db *sql.DB, databaseProvider string) {
+	telemetry.RegisterDatabaseStats(db)
+
+	// The Postgres session store uses its own table name
+	sessionsTable := "sessions"
+	if databaseProvider == datastore.PGSQL {
+		sessionsTable = "http_sessions"
+	}
+	countSessions := fmt.Sprintf("SELECT COUNT(*) FROM %s", sessionsTable)
+
+	telemetry.RegisterSessionStoreSize(func() (float64, error) {
+		var count int64
+		if err := db.QueryRow(countSessions).Scan(&count); err != nil {
+			log.Warnf("Unable to count sessions for metrics: %v", err)
+			return 0, err
+		}
+		return float64(count), nil
+	})
+}
+
+// metrics serves the Jetstream metrics in the Prometheus exposition format
+// If a metrics token is configured, it must be provided as a bearer token
+func (p *portalProxy) metrics(c echo.Context) error {
+	if len(p.Config.MetricsAuthToken) > 0 {
+		token := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(p.Config.MetricsAuthToken)) != 1 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid metrics token")
+		}
+	}
+
+	telemetry.Handler().ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -0,0 +1,129 @@
+package telemetry
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "jetstream"
+
+// WebSocket session types
+const (
+	WebSocketFirehose  = "firehose"
+	WebSocketAppStream = "app_stream"
+	WebSocketAppSSH    = "app_ssh"
+	WebSocketAppPush   = "app_push"
+)
+
+// Token refresh types
+const (
+	TokenRefreshOAuth = "oauth"
+	TokenRefreshOidc  = "oidc"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	proxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_requests_total",
+		Help:      "Number of requests proxied to endpoints, by endpoint type and response status class.",
+	}, []string{"endpoint_type", "status_class"})
+
+	proxyRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxy_request_duration_seconds",
+		Help:      "Time taken for endpoints to respond to proxied requests, by endpoint type and response status class.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint_type", "status_class"})
+
+	tokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Number of endpoint token refreshes, by token type.",
+	}, []string{"type"})
+
+	tokenRefreshFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refresh_failures_total",
+		Help:      "Number of endpoint token refreshes that failed, by token type.",
+	}, []string{"type"})
+
+	webSocketSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_sessions",
+		Help:      "Number of active WebSocket sessions, by session type.",
+	}, []string{"type"})
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		proxyRequests,
+		proxyRequestDuration,
+		tokenRefreshes,
+		tokenRefreshFailures,
+		webSocketSessions,
+	)
+}
+
+// statusClass returns the class of the status code, e.g. 2xx - a status code of 0 means the request failed without a response
+func statusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
+
+// ObserveProxyRequest records a request proxied to an endpoint
+func ObserveProxyRequest(endpointType string, statusCode int, duration time.Duration) {
+	class := statusClass(statusCode)
+	proxyRequests.WithLabelValues(endpointType, class).Inc()
+	proxyRequestDuration.WithLabelValues(endpointType, class).Observe(duration.Seconds())
+}
+
+// ObserveTokenRefresh records a token refresh and whether it failed
+func ObserveTokenRefresh(tokenType string, err error) {
+	tokenRefreshes.WithLabelValues(tokenType).Inc()
+	if err != nil {
+		tokenRefreshFailures.WithLabelValues(tokenType).Inc()
+	}
+}
+
+// TrackWebSocketSession records a WebSocket session as active - call the returned function when the session ends
+func TrackWebSocketSession(sessionType string) func() {
+	gauge := webSocketSessions.WithLabelValues(sessionType)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// RegisterDatabaseStats exposes the connection pool statistics of the database
+func RegisterDatabaseStats(db *sql.DB) {
+	registry.MustRegister(newDBStatsCollector(db))
+}
+
+// RegisterSessionStoreSize exposes the number of sessions in the session store, as returned by the given function
+func RegisterSessionStoreSize(size func() (float64, error)) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "session_store_sessions",
+		Help:      "Number of sessions in the session store.",
+	}, func() float64 {
+		value, err := size()
+		if err != nil {
+			return -1
+		}
+		return value
+	}))
+}
+
+// Handler returns the HTTP handler that serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,66 @@
+package telemetry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func scrape() string {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	Handler().ServeHTTP(res, req)
+	return res.Body.String()
+}
+
+func TestStatusClass(t *testing.T) {
+	Convey("Status codes should be grouped by class", t, func() {
+		So(statusClass(200), ShouldEqual, "2xx")
+		So(statusClass(404), ShouldEqual, "4xx")
+		So(statusClass(503), ShouldEqual, "5xx")
+		So(statusClass(0), ShouldEqual, "error")
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	Convey("Metrics should be exposed in the Prometheus format", t, func() {
+		ObserveProxyRequest("cf", 201, 50*time.Millisecond)
+		ObserveTokenRefresh(TokenRefreshOAuth, nil)
+		ObserveTokenRefresh(TokenRefreshOidc, errors.New("refresh failed"))
+		done := TrackWebSocketSession(WebSocketAppSSH)
+		TrackWebSocketSession(WebSocketFirehose)()
+
+		body := scrape()
+		So(body, ShouldContainSubstring, `jetstream_proxy_requests_total{endpoint_type="cf",status_class="2xx"} 1`)
+		So(body, ShouldContainSubstring, `jetstream_proxy_request_duration_seconds_count{endpoint_type="cf",status_class="2xx"} 1`)
+		So(body, ShouldContainSubstring, `jetstream_token_refreshes_total{type="oauth"} 1`)
+		So(body, ShouldContainSubstring, `jetstream_token_refresh_failures_total{type="oidc"} 1`)
+		So(body, ShouldContainSubstring, `jetstream_websocket_sessions{type="app_ssh"} 1`)
+		So(body, ShouldContainSubstring, `jetstream_websocket_sessions{type="firehose"} 0`)
+
+		done()
+		So(scrape(), ShouldContainSubstring, `jetstream_websocket_sessions{type="app_ssh"} 0`)
+	})
+
+	Convey("Database and session store metrics should be exposed", t, func() {
+		db, _, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		RegisterDatabaseStats(db)
+		RegisterSessionStoreSize(func() (float64, error) {
+			return 5, nil
+		})
+
+		body := scrape()
+		So(body, ShouldContainSubstring, "jetstream_db_pool_open_connections")
+		So(body, ShouldContainSubstring, "jetstream_session_store_sessions 5")
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	t.Parallel()
+
+	Convey("Metrics should be served without a token when none is configured", t, func() {
+		req := setupMockReq("GET", "http://127.0.0.1/metrics", nil)
+		res, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		So(pp.metrics(ctx), ShouldBeNil)
+		So(res.Code, ShouldEqual, http.StatusOK)
+		So(res.Body.String(), ShouldContainSubstring, "go_goroutines")
+	})
+
+	Convey("Metrics should require the configured token", t, func() {
+		req := setupMockReq("GET", "http://127.0.0.1/metrics", nil)
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.MetricsAuthToken = "secret"
+
+		err := pp.metrics(ctx)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "401")
+
+		Convey("and serve the metrics when the token is provided", func() {
+			req := setupMockReq("GET", "http://127.0.0.1/metrics", nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			res, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+			pp.Config.MetricsAuthToken = "secret"
+
+			So(pp.metrics(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+const (
+	defaultTokenRefreshInterval = 60 * time.Second
+	defaultTokenRefreshWindow   = 5 * time.Minute
+
+	// How long a claim on a token lasts - this also limits how often a failing refresh is retried
+	tokenRefreshLease = 2 * time.Minute
+)
+
+// getRefreshTokenFunc returns the function used to refresh tokens of the given auth type, or nil if they can not be refreshed
+func (p *portalProxy) getRefreshTokenFunc(authType string) interfaces.RefreshOAuthTokenFunc {
+	switch authType {
+	case interfaces.AuthTypeOAuth2:
+		return p.RefreshOAuthToken
+	case interfaces.AuthTypeOIDC:
+		return p.RefreshOidcToken
+	}
+	return nil
+}
+
+// startTokenRefresh periodically refreshes endpoint tokens before they expire,
+// so that requests after a period of inactivity don't need to wait for a refresh
+func (p *portalProxy) startTokenRefresh() {
+	if p.Config.TokenRefreshIntervalInSecs < 0 {
+		log.Info("Background refresh of endpoint tokens is disabled")
+		return
+	}
+
+	interval := defaultTokenRefreshInterval
+	if p.Config.TokenRefreshIntervalInSecs > 0 {
+		interval = time.Duration(p.Config.TokenRefreshIntervalInSecs) * time.Second
+	}
+	window := defaultTokenRefreshWindow
+	if p.Config.TokenRefreshWindowInSecs > 0 {
+		window = time.Duration(p.Config.TokenRefreshWindowInSecs) * time.Second
+	}
+
+	log.Infof("Endpoint tokens that expire within %s will be refreshed every %s", window, interval)
+
+	go func() {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for {
+			// Jitter the interval so that multiple Jetstream instances don't scan at the same time
+			time.Sleep(interval + time.Duration(random.Int63n(int64(interval/2)+1)))
+			p.refreshExpiringTokens(window, random)
+		}
+	}()
+}
+
+// refreshExpiringTokens refreshes the endpoint tokens that expire within the window
+func (p *portalProxy) refreshExpiringTokens(window time.Duration, random *rand.Rand) {
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return
+	}
+
+	expiring, err := tokenRepo.ListExpiringCNSITokens(time.Now().Add(window).Unix())
+	if err != nil {
+		log.Errorf("Unable to find endpoint tokens to refresh: %v", err)
+		return
+	}
+
+	// Visit the tokens in a random order, so that instances scanning at the same time spread the work
+	for _, i := range random.Perm(len(expiring)) {
+		p.refreshExpiringToken(tokenRepo, expiring[i])
+	}
+}
+
+func (p *portalProxy) refreshExpiringToken(tokenRepo tokens.Repository, token tokens.ExpiringToken) {
+	refreshFunc := p.getRefreshTokenFunc(token.AuthType)
+	if refreshFunc == nil {
+		return
+	}
+
+	// Claim the token, so that other Jetstream instances don't refresh it at the same time
+	now := time.Now()
+	claimed, err := tokenRepo.ClaimTokenRefresh(token.TokenGUID, token.UserGUID, now.Unix(), now.Add(tokenRefreshLease).Unix())
+	if err != nil {
+		log.Warnf("Unable to claim endpoint token for refresh: %v", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	cnsiRecord, err := p.GetCNSIRecord(token.CNSIGUID)
+	if err != nil {
+		log.Warnf("Unable to find endpoint %s to refresh token: %v", token.CNSIGUID, err)
+		return
+	}
+
+	log.Debugf("Refreshing token for endpoint %s and user %s", token.CNSIGUID, token.UserGUID)
+	_, err = refreshFunc(cnsiRecord.SkipSSLValidation, token.CNSIGUID, token.UserGUID, cnsiRecord.ClientId, cnsiRecord.ClientSecret, cnsiRecord.TokenEndpoint)
+	if err == nil {
+		return
+	}
+
+	if refreshErr, ok := err.(interfaces.ErrTokenRefresh); ok && refreshErr.Rejected() {
+		// The refresh token is no longer valid - the user will need to connect again
+		log.Infof("Refresh token for endpoint %s and user %s was rejected - marking the token as disconnected", token.CNSIGUID, token.UserGUID)
+		if err := tokenRepo.DisconnectToken(token.TokenGUID, token.UserGUID); err != nil {
+			log.Warnf("Unable to disconnect endpoint token: %v", err)
+		}
+		return
+	}
+
+	// Leave the token to be retried once the claim has expired
+	log.Warnf("Unable to refresh token for endpoint %s and user %s: %v", token.CNSIGUID, token.UserGUID, err)
+}
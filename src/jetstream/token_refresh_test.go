@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const (
+	claimTokenRefreshSQL = `UPDATE tokens SET refresh_lease = (.+)`
+	disconnectTokenSQL   = `UPDATE tokens SET disconnected = (.+)`
+)
+
+func TestBackgroundTokenRefresh(t *testing.T) {
+	t.Parallel()
+
+	Convey("Background token refresh", t, func() {
+
+		db, mock, dberr := sqlmock.New()
+		if dberr != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", dberr)
+		}
+		defer db.Close()
+
+		pp := setupPortalProxy(db)
+		pp.DatabaseConnectionPool = db
+		tokenRepo, _ := tokens.NewPgsqlTokenRepository(db, nil)
+
+		expiring := tokens.ExpiringToken{
+			TokenGUID:   mockTokenGUID,
+			CNSIGUID:    mockCNSIGUID,
+			UserGUID:    mockUserGUID,
+			AuthType:    interfaces.AuthTypeOAuth2,
+			TokenExpiry: time.Now().Add(time.Minute).Unix(),
+		}
+
+		Convey("should skip tokens that can not be refreshed", func() {
+			expiring.AuthType = interfaces.AuthTypeHttpBasic
+			pp.refreshExpiringToken(tokenRepo, expiring)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should skip tokens claimed by another instance", func() {
+			mock.ExpectExec(claimTokenRefreshSQL).
+				WithArgs(sqlmock.AnyArg(), mockTokenGUID, mockUserGUID, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			pp.refreshExpiringToken(tokenRepo, expiring)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should disconnect tokens whose refresh token is rejected", func() {
+			mockUAA := setupMockServer(t,
+				msRoute("/oauth/token"),
+				msMethod("POST"),
+				msStatus(http.StatusUnauthorized),
+				msBody(`{"error":"invalid_token"}`))
+			defer mockUAA.Close()
+
+			mock.ExpectExec(claimTokenRefreshSQL).
+				WithArgs(sqlmock.AnyArg(), mockTokenGUID, mockUserGUID, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			cnsiRow := sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data"}).
+				AddRow(mockCNSIGUID, "mockCF", "cf", mockAPIEndpoint, mockUAA.URL, mockUAA.URL, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "")
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WithArgs(mockCNSIGUID).
+				WillReturnRows(cnsiRow)
+
+			encryptedToken, _ := crypto.EncryptToken(pp.Config.EncryptionKeyInBytes, mockUAAToken)
+			tokenRow := sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "disconnected", "auth_type", "meta_data", "user_guid", "linked_token"}).
+				AddRow(mockTokenGUID, encryptedToken, encryptedToken, expiring.TokenExpiry, false, "OAuth2", "", mockUserGUID, nil)
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCNSIGUID, mockUserGUID, mockAdminGUID).
+				WillReturnRows(tokenRow)
+
+			mock.ExpectExec(disconnectTokenSQL).
+				WithArgs(true, mockTokenGUID, mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			pp.refreshExpiringToken(tokenRepo, expiring)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should list the tokens that are about to expire", func() {
+			rows := sqlmock.NewRows([]string{"token_guid", "cnsi_guid", "user_guid", "auth_type", "token_expiry"}).
+				AddRow(mockTokenGUID, mockCNSIGUID, mockUserGUID, interfaces.AuthTypeHttpBasic, expiring.TokenExpiry)
+			mock.ExpectQuery(`SELECT (.+) FROM tokens WHERE token_type = 'cnsi' AND (.+) token_expiry < (.+)`).
+				WillReturnRows(rows)
+
+			pp.refreshExpiringTokens(5*time.Minute, rand.New(rand.NewSource(1)))
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+func TestTokenRefreshError(t *testing.T) {
+	t.Parallel()
+
+	Convey("Token refresh errors", t, func() {
+		rejected := interfaces.NewTokenRefreshError(interfaces.ErrHTTPRequest{Status: http.StatusUnauthorized})
+		So(rejected.(interfaces.ErrTokenRefresh).Rejected(), ShouldBeTrue)
+
+		unavailable := interfaces.NewTokenRefreshError(interfaces.ErrHTTPRequest{Status: http.StatusServiceUnavailable})
+		So(unavailable.(interfaces.ErrTokenRefresh).Rejected(), ShouldBeFalse)
+
+		noResponse := interfaces.NewTokenRefreshError(http.ErrHandlerTimeout)
+		So(noResponse.(interfaces.ErrTokenRefresh).Rejected(), ShouldBeFalse)
+	})
+}
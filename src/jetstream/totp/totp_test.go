@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTOTP(t *testing.T) {
+	t.Parallel()
+
+	// Secret of the RFC 6238 SHA1 test vectors - the codes are the last six digits of the eight digit codes in the RFC
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	Convey("Codes should match the RFC 6238 test vectors", t, func() {
+		vectors := map[int64]string{
+			59:          "287082",
+			1111111109:  "081804",
+			1111111111:  "050471",
+			1234567890:  "005924",
+			2000000000:  "279037",
+			20000000000: "353130",
+		}
+		for seconds, expected := range vectors {
+			code, err := Code(secret, Step(time.Unix(seconds, 0)))
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, expected)
+		}
+	})
+
+	Convey("Given a code for the current time", t, func() {
+		now := time.Unix(1234567890, 0)
+		code, _ := Code(secret, Step(now))
+
+		Convey("it should be valid now and within the allowed clock drift", func() {
+			step, ok := Validate(secret, code, now)
+			So(ok, ShouldBeTrue)
+			So(step, ShouldEqual, Step(now))
+
+			_, ok = Validate(secret, code, now.Add(Period*time.Second))
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("it should not be valid later on", func() {
+			_, ok := Validate(secret, code, now.Add(3*Period*time.Second))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("codes of the wrong length should not be valid", func() {
+			_, ok := Validate(secret, code[1:], now)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Generated secrets should be usable", t, func() {
+		generated, err := GenerateSecret()
+		So(err, ShouldBeNil)
+		So(len(generated), ShouldEqual, 32)
+
+		code, err := Code(generated, Step(time.Now()))
+		So(err, ShouldBeNil)
+		_, ok := Validate(generated, code, time.Now())
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("The provisioning URI should describe the secret", t, func() {
+		uri := ProvisioningURI("Stratos", "admin user", "JBSWY3DPEHPK3PXP")
+		So(strings.HasPrefix(uri, "otpauth://totp/Stratos:admin%20user?"), ShouldBeTrue)
+		So(uri, ShouldContainSubstring, "secret=JBSWY3DPEHPK3PXP")
+		So(uri, ShouldContainSubstring, "issuer=Stratos")
+	})
+}
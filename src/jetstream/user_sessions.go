@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/usersessions"
+)
+
+const (
+	// Sessions expire after 20 minutes without a request unless SESSION_IDLE_TIMEOUT_IN_SECS is set
+	defaultSessionIdleTimeout = 20 * 60
+
+	// Session values recorded at login, used to enforce the session lifetime and to describe sessions to users
+	sessionLoginTimeKey = "login_time"
+	sessionUserAgentKey = "user_agent"
+	sessionAddressKey   = "remote_address"
+
+	// Echo context key of the user that the session of the request has been created for by logging in
+	sessionLoginUserContextKey = "jetstream-session-login-user"
+)
+
+var errSessionLifetimeExceeded = errors.New("Session has reached its maximum lifetime")
+
+// userSession describes an active session of a user
+// The ID is derived from the key of the session in the session store, which is never returned
+type userSession struct {
+	ID        string    `json:"id"`
+	UserGUID  string    `json:"user_id"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	Current   bool      `json:"current"`
+	key       string
+}
+
+// getSessionIdleTimeout returns how many seconds a session lasts without a request
+func getSessionIdleTimeout(pc interfaces.PortalConfig) int {
+	if pc.SessionIdleTimeoutInSecs > 0 {
+		return int(pc.SessionIdleTimeoutInSecs)
+	}
+	return defaultSessionIdleTimeout
+}
+
+// newSessionCodecs returns codecs that decode the session values kept by the session store
+// Expiry is checked against the session store table rather than the age of the encoded values
+func newSessionCodecs(secret string) []securecookie.Codec {
+	codecs := securecookie.CodecsFromPairs([]byte(secret))
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(0)
+		}
+	}
+	return codecs
+}
+
+// sessionRef returns the ID of a session that is shown to users in place of its key
+func sessionRef(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:16])
+}
+
+// newLoginSessionValues returns the values of the session of a user that has just logged in
+// The user of the session is recorded once the session has been saved, see recordSessionUser
+func (p *portalProxy) newLoginSessionValues(c echo.Context, userGUID string, expiry int64) map[string]interface{} {
+	c.Set(sessionLoginUserContextKey, userGUID)
+	return map[string]interface{}{
+		"user_id":           userGUID,
+		"exp":               expiry,
+		sessionLoginTimeKey: time.Now().Unix(),
+		sessionUserAgentKey: c.Request().UserAgent(),
+		sessionAddressKey:   p.clientIP(c),
+	}
+}
+
+// getSessionLifetimeEnd returns when a session must end, regardless of activity
+// The zero time is returned if the session lifetime is not limited
+func (p *portalProxy) getSessionLifetimeEnd(values map[interface{}]interface{}) time.Time {
+	if p.Config.SessionMaxLifetimeInSecs <= 0 {
+		return time.Time{}
+	}
+
+	// Sessions created before the lifetime was limited end straight away
+	loginTime, ok := values[sessionLoginTimeKey].(int64)
+	if !ok {
+		return time.Unix(0, 0)
+	}
+	return time.Unix(loginTime+p.Config.SessionMaxLifetimeInSecs, 0)
+}
+
+// checkSessionLifetime clears the session of the request if it has reached its maximum lifetime
+func (p *portalProxy) checkSessionLifetime(c echo.Context) error {
+	session, err := p.GetSession(c)
+	if err != nil {
+		return err
+	}
+
+	end := p.getSessionLifetimeEnd(session.Values)
+	if end.IsZero() || time.Now().Before(end) {
+		return nil
+	}
+
+	if err := p.clearSession(c); err != nil {
+		log.Warnf("Unable to clear session: %v", err)
+	}
+	return errSessionLifetimeExceeded
+}
+
+// recordSessionUser records the user of a session that has been created by logging in
+// Called once the session has been saved, as the session store only assigns the ID of a new session when it is saved
+func (p *portalProxy) recordSessionUser(c echo.Context, session *sessions.Session) {
+	userGUID, ok := c.Get(sessionLoginUserContextKey).(string)
+	if !ok || len(session.ID) == 0 || session.Options.MaxAge < 0 {
+		return
+	}
+
+	sessionsRepo, err := usersessions.NewPgsqlSessionsRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return
+	}
+	if err := sessionsRepo.SetUser(session.ID, userGUID); err != nil {
+		log.Errorf("Unable to record the user of session: %v", err)
+	}
+}
+
+// listUserSessions returns the active sessions of the user, oldest first
+// The session of the request is marked as the current session
+func (p *portalProxy) listUserSessions(c echo.Context, userGUID string) ([]*userSession, error) {
+	sessionsRepo, err := usersessions.NewPgsqlSessionsRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+
+	now := time.Now()
+	rows, err := sessionsRepo.ListActiveForUser(userGUID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	currentKey := ""
+	if current, err := p.GetSession(c); err == nil {
+		currentKey = current.ID
+	}
+
+	list := make([]*userSession, 0)
+	for _, row := range rows {
+		values := make(map[interface{}]interface{})
+		if err := securecookie.DecodeMulti(p.SessionCookieName, row.Data, &values, p.SessionCodecs...); err != nil {
+			log.Debugf("Unable to decode session: %v", err)
+			continue
+		}
+		if sessionUser, ok := values["user_id"].(string); !ok || sessionUser != userGUID {
+			continue
+		}
+
+		expires := row.ExpiresOn
+		if end := p.getSessionLifetimeEnd(values); !end.IsZero() {
+			if !now.Before(end) {
+				continue
+			}
+			if end.Before(expires) {
+				expires = end
+			}
+		}
+
+		userAgent, _ := values[sessionUserAgentKey].(string)
+		address, _ := values[sessionAddressKey].(string)
+		list = append(list, &userSession{
+			ID:        sessionRef(row.ID),
+			UserGUID:  userGUID,
+			Created:   row.CreatedOn,
+			Expires:   expires,
+			UserAgent: userAgent,
+			Address:   address,
+			Current:   len(currentKey) > 0 && row.ID == currentKey,
+			key:       row.ID,
+		})
+	}
+
+	return list, nil
+}
+
+// deleteUserSessions removes the sessions from the session store
+func (p *portalProxy) deleteUserSessions(list []*userSession) error {
+	sessionsRepo, err := usersessions.NewPgsqlSessionsRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	for _, session := range list {
+		if _, err := sessionsRepo.Delete(session.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enforceSessionLimit revokes the oldest sessions of a user that is logging in, so that the new session
+// does not take the user over the maximum number of sessions
+func (p *portalProxy) enforceSessionLimit(c echo.Context, userGUID string) error {
+	if p.Config.SessionMaxPerUser <= 0 {
+		return nil
+	}
+
+	list, err := p.listUserSessions(c, userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check the sessions of the user",
+			"Unable to list sessions: %v", err,
+		)
+	}
+
+	existing := make([]*userSession, 0, len(list))
+	for _, session := range list {
+		if !session.Current {
+			existing = append(existing, session)
+		}
+	}
+
+	excess := len(existing) - int(p.Config.SessionMaxPerUser) + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	log.Infof("Revoking %d oldest session(s) of user %s, who has reached the maximum number of sessions", excess, userGUID)
+	if err := p.deleteUserSessions(existing[:excess]); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to revoke the oldest sessions of the user",
+			"Unable to revoke sessions: %v", err,
+		)
+	}
+	return nil
+}
+
+// revokeUserSession removes a session of the user, clearing the session cookie if it is the session of the request
+func (p *portalProxy) revokeUserSession(c echo.Context, userGUID string, id string) error {
+	setAuditDetails(c, fmt.Sprintf("user=%s session=%s", userGUID, id))
+
+	list, err := p.listUserSessions(c, userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list sessions",
+			"Unable to list sessions: %v", err,
+		)
+	}
+
+	for _, session := range list {
+		if session.ID != id {
+			continue
+		}
+
+		if err := p.deleteUserSessions([]*userSession{session}); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusInternalServerError,
+				"Unable to revoke session",
+				"Unable to revoke session: %v", err,
+			)
+		}
+		if session.Current {
+			if err := p.clearSession(c); err != nil {
+				log.Warnf("Unable to clear session: %v", err)
+			}
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return interfaces.NewHTTPShadowError(
+		http.StatusNotFound,
+		"Session not found",
+		"Session %s of user %s not found", id, userGUID,
+	)
+}
+
+// listSessions returns the active sessions of the current user
+func (p *portalProxy) listSessions(c echo.Context) error {
+	log.Debug("listSessions")
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	list, err := p.listUserSessions(c, userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list sessions",
+			"Unable to list sessions: %v", err,
+		)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// revokeSession removes one of the sessions of the current user
+func (p *portalProxy) revokeSession(c echo.Context) error {
+	log.Debug("revokeSession")
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	return p.revokeUserSession(c, userGUID, c.Param("session"))
+}
+
+// listSessionsOfUser returns the active sessions of any user
+func (p *portalProxy) listSessionsOfUser(c echo.Context) error {
+	log.Debug("listSessionsOfUser")
+	list, err := p.listUserSessions(c, c.Param("user"))
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list sessions",
+			"Unable to list sessions: %v", err,
+		)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// revokeSessionOfUser removes a session of any user
+func (p *portalProxy) revokeSessionOfUser(c echo.Context) error {
+	log.Debug("revokeSessionOfUser")
+	return p.revokeUserSession(c, c.Param("user"), c.Param("session"))
+}
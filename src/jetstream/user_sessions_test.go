@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const (
+	selectActiveSessions    = `SELECT s.key, s.data, s.created_on, s.expires_on FROM http_sessions s, session_users u WHERE u.user_guid = (.+) AND (.+) ORDER BY s.created_on`
+	deleteSessionByKey      = `DELETE FROM http_sessions WHERE key = (.+)`
+	insertSessionUser       = `INSERT INTO session_users`
+	deleteSessionUserByKey  = `DELETE FROM session_users WHERE session_id = (.+)`
+	deleteStaleSessionUsers = `DELETE FROM session_users WHERE user_guid = (.+) AND NOT EXISTS`
+)
+
+var rowFieldsForSessions = []string{"key", "data", "created_on", "expires_on"}
+
+// encodeMockSession encodes session values the way that the session store does
+func encodeMockSession(pp *portalProxy, userGUID string, loginTime time.Time) []byte {
+	values := map[interface{}]interface{}{
+		"user_id":           userGUID,
+		sessionLoginTimeKey: loginTime.Unix(),
+		sessionUserAgentKey: "mock-browser",
+	}
+	encoded, err := securecookie.EncodeMulti(pp.SessionCookieName, values, pp.SessionCodecs...)
+	if err != nil {
+		panic(err)
+	}
+	return []byte(encoded)
+}
+
+func TestSessionLifetime(t *testing.T) {
+	t.Parallel()
+
+	Convey("Checking the maximum lifetime of a session", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		session, _ := pp.GetSession(ctx)
+
+		Convey("sessions should not end if the lifetime is not limited", func() {
+			So(pp.checkSessionLifetime(ctx), ShouldBeNil)
+		})
+
+		Convey("with a limited lifetime", func() {
+			pp.Config.SessionMaxLifetimeInSecs = 3600
+
+			Convey("a recent session should not end", func() {
+				session.Values[sessionLoginTimeKey] = time.Now().Add(-time.Minute).Unix()
+				So(pp.checkSessionLifetime(ctx), ShouldBeNil)
+			})
+
+			Convey("an old session should be cleared", func() {
+				session.Values[sessionLoginTimeKey] = time.Now().Add(-2 * time.Hour).Unix()
+				So(pp.checkSessionLifetime(ctx), ShouldEqual, errSessionLifetimeExceeded)
+				So(session.Options.MaxAge, ShouldEqual, -1)
+			})
+
+			Convey("a session without a login time should be cleared", func() {
+				So(pp.checkSessionLifetime(ctx), ShouldEqual, errSessionLifetimeExceeded)
+			})
+
+			Convey("a session should not be extended beyond its lifetime", func() {
+				loginTime := time.Now().Add(-59 * time.Minute)
+				session.Values[sessionLoginTimeKey] = loginTime.Unix()
+				session.Options.MaxAge = 20 * 60
+				So(pp.SaveSession(ctx, session), ShouldBeNil)
+				So(session.Values["expires_on"], ShouldEqual, time.Unix(loginTime.Unix()+3600, 0))
+			})
+		})
+	})
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	t.Parallel()
+
+	Convey("Given a user with two sessions", t, func() {
+		req := setupMockReq("GET", "", nil)
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		now := time.Now()
+		rows := sqlmock.NewRows(rowFieldsForSessions).
+			AddRow([]byte("key-1"), encodeMockSession(pp, mockUserGUID, now.Add(-time.Hour)), now.Add(-time.Hour), now.Add(time.Minute)).
+			AddRow([]byte("key-2"), encodeMockSession(pp, "another-user", now), now.Add(-time.Minute), now.Add(time.Minute)).
+			AddRow([]byte("key-3"), encodeMockSession(pp, mockUserGUID, now), now, now.Add(time.Minute)).
+			AddRow([]byte("key-4"), []byte("not-a-session"), now, now.Add(time.Minute))
+		mock.ExpectQuery(selectActiveSessions).WithArgs(mockUserGUID, sqlmock.AnyArg()).WillReturnRows(rows)
+
+		ctx.Set("user_id", mockUserGUID)
+		session, _ := pp.GetSession(ctx)
+		session.ID = "key-3"
+
+		Convey("only the sessions of the user should be listed", func() {
+			So(pp.listSessions(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+
+			var list []userSession
+			So(json.Unmarshal(res.Body.Bytes(), &list), ShouldBeNil)
+			So(list, ShouldHaveLength, 2)
+			So(list[0].ID, ShouldEqual, sessionRef("key-1"))
+			So(list[0].Current, ShouldBeFalse)
+			So(list[0].UserAgent, ShouldEqual, "mock-browser")
+			So(list[1].ID, ShouldEqual, sessionRef("key-3"))
+			So(list[1].Current, ShouldBeTrue)
+			So(res.Body.String(), ShouldNotContainSubstring, "key-1")
+		})
+
+		Convey("a session of the user can be revoked", func() {
+			mock.ExpectExec(deleteSessionByKey).
+				WithArgs("key-1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(deleteSessionUserByKey).
+				WithArgs("key-1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			ctx.SetParamNames("session")
+			ctx.SetParamValues(sessionRef("key-1"))
+			So(pp.revokeSession(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusNoContent)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a session of another user can not be revoked", func() {
+			ctx.SetParamNames("session")
+			ctx.SetParamValues(sessionRef("key-2"))
+			err := pp.revokeSession(ctx)
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("the oldest session should be revoked when the user reaches the maximum number of sessions", func() {
+			session.ID = ""
+			pp.Config.SessionMaxPerUser = 2
+			mock.ExpectExec(deleteSessionByKey).
+				WithArgs("key-1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(deleteSessionUserByKey).
+				WithArgs("key-1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(pp.enforceSessionLimit(ctx, mockUserGUID), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+func TestRecordSessionUser(t *testing.T) {
+	t.Parallel()
+
+	Convey("Recording the user of a session", t, func() {
+		req := setupMockReq("POST", "", nil)
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		session, _ := pp.GetSession(ctx)
+		session.ID = "key-5"
+
+		Convey("the user of a session created by logging in should be recorded", func() {
+			pp.newLoginSessionValues(ctx, mockUserGUID, 0)
+			mock.ExpectExec(deleteStaleSessionUsers).
+				WithArgs(mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(insertSessionUser).
+				WithArgs("key-5", mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			pp.recordSessionUser(ctx, session)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("sessions that have not been created by logging in should not be recorded", func() {
+			pp.recordSessionUser(ctx, session)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// endpointVisibility decides which endpoints a user can see
+// Endpoints without restrictions are visible to all users, as are all endpoints to endpoint admins
+type endpointVisibility struct {
+	restrictions map[string][]interfaces.EndpointVisibility
+	user         *interfaces.ConnectedUser
+	unrestricted bool
+}
+
+// getEndpointVisibility returns the visibility of the restricted endpoints for the user
+func (p *portalProxy) getEndpointVisibility(userGUID string, restrictions map[string][]interfaces.EndpointVisibility) (*endpointVisibility, error) {
+	visibility := &endpointVisibility{restrictions: restrictions}
+
+	// There's no need to look up the user if nothing has been restricted
+	if len(restrictions) == 0 {
+		return visibility, nil
+	}
+
+	user, err := p.StratosAuthService.GetUser(userGUID)
+	if err != nil {
+		return nil, err
+	}
+	visibility.user = user
+	visibility.unrestricted = p.RoleMappings.getPermissions(user).Has(interfaces.PermissionEndpointsAdmin)
+	return visibility, nil
+}
+
+func (v *endpointVisibility) isVisible(cnsiGUID string) bool {
+	restrictions, ok := v.restrictions[cnsiGUID]
+	if !ok || len(restrictions) == 0 || v.unrestricted {
+		return true
+	}
+
+	for _, restriction := range restrictions {
+		switch restriction.Type {
+		case interfaces.EndpointVisibilityUser:
+			if restriction.Principal == v.user.GUID {
+				return true
+			}
+		case interfaces.EndpointVisibilityScope:
+			for _, scope := range v.user.Scopes {
+				if restriction.Principal == scope {
+					return true
+				}
+			}
+		case interfaces.EndpointVisibilityGroup:
+			for _, group := range v.user.Groups {
+				if restriction.Principal == group {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// getUserEndpointVisibility returns the visibility of all restricted endpoints for the user
+func (p *portalProxy) getUserEndpointVisibility(userGUID string) (*endpointVisibility, error) {
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+
+	restrictions, err := cnsiRepo.ListVisibility()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.getEndpointVisibility(userGUID, restrictions)
+}
+
+// listVisibleEndpoints returns the endpoints that the user can see
+func (p *portalProxy) listVisibleEndpoints(userGUID string) ([]*interfaces.CNSIRecord, error) {
+	cnsiList, err := p.ListEndpoints()
+	if err != nil {
+		return cnsiList, err
+	}
+
+	visibility, err := p.getUserEndpointVisibility(userGUID)
+	if err != nil {
+		return cnsiList, err
+	}
+
+	visible := make([]*interfaces.CNSIRecord, 0, len(cnsiList))
+	for _, cnsi := range cnsiList {
+		if visibility.isVisible(cnsi.GUID) {
+			visible = append(visible, cnsi)
+		}
+	}
+	return visible, nil
+}
+
+// IsEndpointVisible returns false if the endpoint has been restricted to other users
+func (p *portalProxy) IsEndpointVisible(userGUID string, cnsiGUID string) bool {
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return false
+	}
+
+	restrictions, err := cnsiRepo.FindVisibility(cnsiGUID)
+	if err != nil {
+		log.Errorf("Unable to check visibility of endpoint %s: %v", cnsiGUID, err)
+		return false
+	}
+	if len(restrictions) == 0 {
+		return true
+	}
+
+	visibility, err := p.getEndpointVisibility(userGUID, map[string][]interfaces.EndpointVisibility{cnsiGUID: restrictions})
+	if err != nil {
+		log.Errorf("Unable to check visibility of endpoint %s: %v", cnsiGUID, err)
+		return false
+	}
+	return visibility.isVisible(cnsiGUID)
+}
+
+// endpointVisibilityMiddleware rejects requests for endpoints that have been restricted to other users
+// Endpoints are identified by the cnsiGuid route parameter used by plugins or the x-cap-cnsi-list header used by the proxy
+func (p *portalProxy) endpointVisibilityMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		endpoints := make([]string, 0)
+		if cnsiGUID := c.Param("cnsiGuid"); len(cnsiGUID) > 0 {
+			endpoints = append(endpoints, cnsiGUID)
+		}
+		if cnsiList := c.Request().Header.Get("x-cap-cnsi-list"); len(cnsiList) > 0 {
+			for _, cnsiGUID := range strings.Split(cnsiList, ",") {
+				endpoints = append(endpoints, strings.TrimSpace(cnsiGUID))
+			}
+		}
+		if len(endpoints) == 0 {
+			return h(c)
+		}
+
+		userGUID, err := p.GetSessionStringValue(c, "user_id")
+		if err != nil {
+			return handleSessionError(p.Config, c, errors.New("Unauthorized"), false, "You must be logged in to access this API")
+		}
+
+		for _, cnsiGUID := range endpoints {
+			if !p.IsEndpointVisible(userGUID, cnsiGUID) {
+				return interfaces.NewHTTPShadowError(
+					http.StatusNotFound,
+					"Requested endpoint not registered",
+					"Endpoint %s is not visible to user %s", cnsiGUID, userGUID)
+			}
+		}
+		return h(c)
+	}
+}
+
+// listEndpointVisibility returns the users, scopes and groups that an endpoint has been restricted to
+func (p *portalProxy) listEndpointVisibility(c echo.Context) error {
+	cnsiGUID := c.Param("id")
+	if _, err := p.GetCNSIRecord(cnsiGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested endpoint not registered",
+			"No Endpoint registered with GUID %s: %s", cnsiGUID, err)
+	}
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	visibility, err := cnsiRepo.FindVisibility(cnsiGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to retrieve endpoint visibility",
+			"Unable to retrieve endpoint visibility: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, visibility)
+}
+
+// setEndpointVisibility restricts an endpoint to the users, scopes and groups in the request body
+// An empty list makes the endpoint visible to all users
+func (p *portalProxy) setEndpointVisibility(c echo.Context) error {
+	cnsiGUID := c.Param("id")
+	if _, err := p.GetCNSIRecord(cnsiGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested endpoint not registered",
+			"No Endpoint registered with GUID %s: %s", cnsiGUID, err)
+	}
+
+	visibility := make([]interfaces.EndpointVisibility, 0)
+	if err := json.NewDecoder(c.Request().Body).Decode(&visibility); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid endpoint visibility",
+			"Unable to parse endpoint visibility: %v", err)
+	}
+
+	for _, restriction := range visibility {
+		switch restriction.Type {
+		case interfaces.EndpointVisibilityUser, interfaces.EndpointVisibilityScope, interfaces.EndpointVisibilityGroup:
+		default:
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Endpoint visibility must be granted to a user, a scope or a group",
+				"Invalid endpoint visibility type: %s", restriction.Type)
+		}
+		if len(restriction.Principal) == 0 {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Endpoint visibility must name a user, a scope or a group",
+				"Endpoint visibility is missing a principal")
+		}
+	}
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool, p.SecretStore)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	if err := cnsiRepo.SetVisibility(cnsiGUID, visibility); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update endpoint visibility",
+			"Unable to update endpoint visibility: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, visibility)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const selectAnyFromEndpointVisibility = `SELECT (.+) FROM endpoint_visibility WHERE endpoint_guid = (.+)`
+
+var rowFieldsForEndpointVisibility = []string{"endpoint_guid", "principal_type", "principal"}
+
+func TestEndpointVisibility(t *testing.T) {
+	t.Parallel()
+
+	Convey("Endpoint visibility", t, func() {
+		restrictions := map[string][]interfaces.EndpointVisibility{
+			"restricted-by-user":  {{Type: interfaces.EndpointVisibilityUser, Principal: mockUserGUID}},
+			"restricted-by-scope": {{Type: interfaces.EndpointVisibilityScope, Principal: "stratos.team-a"}},
+			"restricted-by-group": {{Type: interfaces.EndpointVisibilityGroup, Principal: "team-b"}},
+		}
+		visibility := &endpointVisibility{
+			restrictions: restrictions,
+			user:         &interfaces.ConnectedUser{GUID: "another-user", Scopes: []string{"openid", "stratos.team-a"}},
+		}
+
+		So(visibility.isVisible("unrestricted"), ShouldBeTrue)
+		So(visibility.isVisible("restricted-by-user"), ShouldBeFalse)
+		So(visibility.isVisible("restricted-by-scope"), ShouldBeTrue)
+		So(visibility.isVisible("restricted-by-group"), ShouldBeFalse)
+
+		visibility.user = &interfaces.ConnectedUser{GUID: mockUserGUID}
+		So(visibility.isVisible("restricted-by-user"), ShouldBeTrue)
+		So(visibility.isVisible("restricted-by-scope"), ShouldBeFalse)
+
+		visibility.user = &interfaces.ConnectedUser{GUID: "another-user", Groups: []string{"team-a", "team-b"}}
+		So(visibility.isVisible("restricted-by-group"), ShouldBeTrue)
+		So(visibility.isVisible("restricted-by-scope"), ShouldBeFalse)
+
+		visibility.unrestricted = true
+		So(visibility.isVisible("restricted-by-scope"), ShouldBeTrue)
+	})
+}
+
+func TestEndpointVisibilityMiddleware(t *testing.T) {
+	t.Parallel()
+
+	Convey("Endpoint visibility middleware", t, func() {
+
+		req := setupMockReq("GET", "", nil)
+		req.Header.Set("x-cap-cnsi-list", mockCNSIGUID)
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		if e := pp.InitStratosAuthService(interfaces.Remote); e != nil {
+			t.Fatalf("Could not initialise auth service: %v", e)
+		}
+
+		sessionValues := make(map[string]interface{})
+		sessionValues["user_id"] = mockUserGUID
+		sessionValues["exp"] = time.Now().Add(time.Hour).Unix()
+		if errSession := pp.setSessionValues(ctx, sessionValues); errSession != nil {
+			t.Error(errors.New("unable to mock/stub user in session object"))
+		}
+
+		handler := pp.endpointVisibilityMiddleware(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+
+		expectUser := func() {
+			encryptedUAAToken, _ := crypto.EncryptToken(pp.Config.EncryptionKeyInBytes, mockUAAToken)
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "auth_type", "meta_data"}).
+					AddRow(mockTokenGUID, encryptedUAAToken, encryptedUAAToken, mockTokenExpiry, "oauth", ""))
+		}
+
+		Convey("should pass requests for endpoints without restrictions", func() {
+			mock.ExpectQuery(selectAnyFromEndpointVisibility).
+				WithArgs(mockCNSIGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointVisibility))
+
+			So(handler(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should pass requests from users with a scope the endpoint is restricted to", func() {
+			mock.ExpectQuery(selectAnyFromEndpointVisibility).
+				WithArgs(mockCNSIGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointVisibility).
+					AddRow(mockCNSIGUID, interfaces.EndpointVisibilityScope, "cloud_controller.admin"))
+			expectUser()
+
+			So(handler(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should reject requests for endpoints restricted to other users", func() {
+			mock.ExpectQuery(selectAnyFromEndpointVisibility).
+				WithArgs(mockCNSIGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointVisibility).
+					AddRow(mockCNSIGUID, interfaces.EndpointVisibilityUser, "another-user"))
+			expectUser()
+
+			err := handler(ctx)
+			So(err, ShouldHaveSameTypeAs, interfaces.ErrHTTPShadow{})
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusNotFound)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}